@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/aws/ebs/pkg/volume"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner          = flag.String("provisioner", "example.com/aws-ebs", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master               = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig           = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+	zone                 = flag.String("aws-zone", os.Getenv("AWS_ZONE"), "Availability zone to create volumes in when their class doesn't say. Defaults to the AWS_ZONE environment variable, else the zone of the instance the provisioner runs on.")
+	failedRetryThreshold = flag.Int("failed-retry-threshold", 10, "If the number of retries on provisioning failure need to be limited to a set number of attempts. Default 10")
+)
+
+const (
+	resyncPeriod              = 15 * time.Second
+	exponentialBackOffOnError = true
+	leasePeriod               = leaderelection.DefaultLeaseDuration
+	retryPeriod               = leaderelection.DefaultRetryPeriod
+	renewDeadline             = leaderelection.DefaultRenewDeadline
+	termLimit                 = leaderelection.DefaultTermLimit
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Authenticate to AWS with the SDK's default credential chain: the AWS_*
+	// environment variables, the shared credentials file or the instance
+	// profile
+	sess, err := session.NewSession()
+	if err != nil {
+		glog.Fatalf("Failed to create AWS session: %v", err)
+	}
+	region, defaultZone := getLocation(sess, os.Getenv("AWS_REGION"), *zone)
+	svc := ec2.New(sess, &aws.Config{Region: aws.String(region)})
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	ebsProvisioner := volume.NewEBSProvisioner(svc, region, defaultZone)
+
+	// Start the provision controller which will dynamically provision EBS
+	// PVs
+	pc := controller.NewProvisionController(clientset, resyncPeriod, *provisioner, ebsProvisioner, serverVersion.GitVersion, exponentialBackOffOnError, *failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit)
+	pc.Run(wait.NeverStop)
+}
+
+// getLocation returns the region of the EC2 API to use and the default zone
+// to create volumes in. Whichever isn't given is taken from the instance
+// metadata: the zone is the instance's and the region the zone's.
+func getLocation(sess *session.Session, region, zone string) (string, string) {
+	if zone == "" {
+		metadata := ec2metadata.New(sess)
+		var err error
+		zone, err = metadata.GetMetadata("placement/availability-zone")
+		if err != nil {
+			glog.Fatalf("AWS_ZONE is not set and the zone of the instance could not be got from the instance metadata: %v", err)
+		}
+	}
+	if region == "" {
+		// Zones are named after their region plus a letter
+		region = strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+	}
+	return region, zone
+}
@@ -0,0 +1,402 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	volumeTypeGp2      = "gp2"
+	volumeTypeGp3      = "gp3"
+	volumeTypeIo1      = "io1"
+	volumeTypeIo2      = "io2"
+	volumeTypeSc1      = "sc1"
+	volumeTypeSt1      = "st1"
+	volumeTypeStandard = "standard"
+
+	// EC2's limits on the tags of a resource
+	maxTags           = 50
+	maxTagKeyLength   = 127
+	maxTagValueLength = 255
+	reservedTagPrefix = "aws:"
+
+	gib = 1024 * 1024 * 1024
+)
+
+// ebsParameters are the options parsed from a StorageClass
+type ebsParameters struct {
+	volumeType string
+	// iops is the provisioned IOPS of the volume, iopsPerGB the IOPS per GiB
+	// of its size. At most one is set.
+	iops      int64
+	iopsPerGB int64
+	// throughput is the throughput of gp3 volumes in MiB/s
+	throughput int64
+	encrypted  bool
+	kmsKeyID   string
+	// zones are the availability zones to create volumes in. Each claim gets
+	// one of them by the hash of its name.
+	zones  []string
+	fsType string
+	// tags are added to every volume, after the claim's labels
+	tags map[string]string
+}
+
+type ebsProvisioner struct {
+	// EC2 API
+	volumes volumeService
+	// region is the region of the EC2 API and zone the availability zone to
+	// create volumes in when the class doesn't say
+	region string
+	zone   string
+}
+
+// NewEBSProvisioner creates a Provisioner that provisions AWS EBS volumes
+// using the EC2 API client svc. Volumes are created in zone unless their
+// class names other zones.
+func NewEBSProvisioner(svc *ec2.EC2, region, zone string) controller.Provisioner {
+	return newEBSProvisionerInternal(&ec2VolumeService{svc: svc}, region, zone)
+}
+
+func newEBSProvisionerInternal(volumes volumeService, region, zone string) *ebsProvisioner {
+	return &ebsProvisioner{
+		volumes: volumes,
+		region:  region,
+		zone:    zone,
+	}
+}
+
+var _ controller.Provisioner = &ebsProvisioner{}
+
+// Provision creates a storage asset and returns a PV object representing it.
+func (p *ebsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	for _, mode := range options.PVC.Spec.AccessModes {
+		if mode != v1.ReadWriteOnce {
+			return nil, fmt.Errorf("invalid AccessModes %v: only AccessMode %v is supported", options.PVC.Spec.AccessModes, v1.ReadWriteOnce)
+		}
+	}
+	params, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// EBS sizes volumes in GiB, round up
+	sizeGiB := (capacity.Value() + gib - 1) / gib
+	if sizeGiB < 1 {
+		sizeGiB = 1
+	}
+
+	tags, err := volumeTags(options, params.tags)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := p.zone
+	if len(params.zones) > 0 {
+		zone = chooseZone(params.zones, options.PVC.Name)
+	}
+	if zone == "" {
+		return nil, errors.New("no zone to create the volume in: the provisioner has no default zone and the class has no zone parameter")
+	}
+
+	input := &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(zone),
+		Size:             aws.Int64(sizeGiB),
+		VolumeType:       aws.String(params.volumeType),
+	}
+	iops := params.iops
+	if params.iopsPerGB > 0 {
+		iops = params.iopsPerGB * sizeGiB
+	}
+	if iops > 0 {
+		input.Iops = aws.Int64(iops)
+	}
+	if params.encrypted {
+		input.Encrypted = aws.Bool(true)
+	}
+	if params.kmsKeyID != "" {
+		input.KmsKeyId = aws.String(params.kmsKeyID)
+	}
+
+	volume, err := p.volumes.create(input, params.throughput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %v", err)
+	}
+	volumeID := aws.StringValue(volume.VolumeId)
+
+	if err := p.setupVolume(volumeID, tags); err != nil {
+		if deleteErr := p.volumes.delete(volumeID); deleteErr != nil {
+			glog.Errorf("failed to delete volume %s after failing to set it up: %v", volumeID, deleteErr)
+		}
+		return nil, err
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: options.PVName,
+			Labels: map[string]string{
+				unversioned.LabelZoneFailureDomain: zone,
+				unversioned.LabelZoneRegion:        p.region,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", sizeGiB)),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{
+					VolumeID: "aws://" + zone + "/" + volumeID,
+					FSType:   params.fsType,
+				},
+			},
+		},
+	}
+
+	glog.Infof("successfully created EBS %s volume %s for claim %s/%s", params.volumeType, volumeID, options.PVC.Namespace, options.PVC.Name)
+
+	return pv, nil
+}
+
+// setupVolume waits for the volume to become available and tags it
+func (p *ebsProvisioner) setupVolume(volumeID string, tags map[string]string) error {
+	if err := p.volumes.waitUntilAvailable(volumeID); err != nil {
+		return fmt.Errorf("error waiting for volume %s to become available: %v", volumeID, err)
+	}
+	if err := p.volumes.tag(volumeID, tags); err != nil {
+		return fmt.Errorf("failed to tag volume %s: %v", volumeID, err)
+	}
+	return nil
+}
+
+// volumeTags returns the tags of the volume provisioned for the claim: the
+// claim's labels, then the class' tags, then tags naming the claim and PV.
+// Later ones override earlier ones. Labels that can't be tags are skipped.
+func volumeTags(options controller.VolumeOptions, classTags map[string]string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for k, v := range options.PVC.Labels {
+		if err := validateTag(k, v); err != nil {
+			glog.Warningf("not adding label %q of claim %s/%s to the tags of its volume: %v", k, options.PVC.Namespace, options.PVC.Name, err)
+			continue
+		}
+		tags[k] = v
+	}
+	for k, v := range classTags {
+		tags[k] = v
+	}
+	tags["kubernetes.io/created-for/pv/name"] = options.PVName
+	tags["kubernetes.io/created-for/pvc/namespace"] = options.PVC.Namespace
+	tags["kubernetes.io/created-for/pvc/name"] = options.PVC.Name
+
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("volume would have %d tags, more than the %d EC2 allows: remove labels from the claim or tags from the class", len(tags), maxTags)
+	}
+	return tags, nil
+}
+
+func validateTag(key, value string) error {
+	if key == "" {
+		return errors.New("tag key is empty")
+	}
+	if len(key) > maxTagKeyLength {
+		return fmt.Errorf("tag key is longer than %d characters", maxTagKeyLength)
+	}
+	if len(value) > maxTagValueLength {
+		return fmt.Errorf("tag value is longer than %d characters", maxTagValueLength)
+	}
+	if strings.HasPrefix(strings.ToLower(key), reservedTagPrefix) {
+		return fmt.Errorf("tag key has the reserved prefix %q", reservedTagPrefix)
+	}
+	return nil
+}
+
+// chooseZone returns one of zones by the hash of the claim's name, so that
+// the volumes of a class are spread over the zones
+func chooseZone(zones []string, claimName string) string {
+	h := fnv.New32a()
+	h.Write([]byte(claimName))
+	return zones[h.Sum32()%uint32(len(zones))]
+}
+
+// Delete removes the storage asset that was created by Provision represented
+// by the given PV.
+func (p *ebsProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ebs := volume.Spec.PersistentVolumeSource.AWSElasticBlockStore
+	if ebs == nil {
+		return errors.New("PV is not an AWS EBS volume")
+	}
+	volumeID, err := parseVolumeID(ebs.VolumeID)
+	if err != nil {
+		return err
+	}
+
+	if err := p.volumes.delete(volumeID); err != nil {
+		return fmt.Errorf("failed to delete volume %s: %v", volumeID, err)
+	}
+
+	return nil
+}
+
+// parseVolumeID returns the EC2 ID of the volume from the volume ID of an
+// AWSElasticBlockStore source, which is either "aws://<zone>/<ID>" or "<ID>"
+func parseVolumeID(volumeID string) (string, error) {
+	id := volumeID
+	if strings.HasPrefix(id, "aws://") {
+		id = id[strings.LastIndex(id, "/")+1:]
+	}
+	if !strings.HasPrefix(id, "vol-") {
+		return "", fmt.Errorf("invalid volume ID %q", volumeID)
+	}
+	return id, nil
+}
+
+func parseParameters(parameters map[string]string) (*ebsParameters, error) {
+	params := &ebsParameters{
+		volumeType: volumeTypeGp2,
+		fsType:     "ext4",
+		tags:       map[string]string{},
+	}
+	encrypted := ""
+
+	var err error
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "type":
+			params.volumeType = strings.ToLower(v)
+		case "iops":
+			params.iops, err = parsePositiveInt(v)
+		case "iopspergb":
+			params.iopsPerGB, err = parsePositiveInt(v)
+		case "throughput":
+			params.throughput, err = parsePositiveInt(v)
+		case "encrypted":
+			encrypted = v
+			params.encrypted, err = strconv.ParseBool(v)
+		case "kmskeyid":
+			params.kmsKeyID = v
+		case "zone", "zones":
+			if len(params.zones) > 0 {
+				return nil, errors.New("only one of zone and zones may be set")
+			}
+			params.zones = parseZones(v)
+		case "fstype":
+			params.fsType = v
+		case "tags":
+			params.tags, err = parseTags(v)
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for option %q: %v", v, k, err)
+		}
+	}
+
+	switch params.volumeType {
+	case volumeTypeIo1, volumeTypeIo2:
+		if params.iops == 0 && params.iopsPerGB == 0 {
+			return nil, fmt.Errorf("volume type %s needs iops or iopsPerGB", params.volumeType)
+		}
+	case volumeTypeGp3:
+		if params.iopsPerGB > 0 {
+			return nil, fmt.Errorf("iopsPerGB is only supported for volume types %s and %s", volumeTypeIo1, volumeTypeIo2)
+		}
+	case volumeTypeGp2, volumeTypeSc1, volumeTypeSt1, volumeTypeStandard:
+		if params.iops > 0 || params.iopsPerGB > 0 {
+			return nil, fmt.Errorf("volume type %s does not support provisioned IOPS", params.volumeType)
+		}
+	default:
+		return nil, fmt.Errorf("invalid volume type %q", params.volumeType)
+	}
+	if params.iops > 0 && params.iopsPerGB > 0 {
+		return nil, errors.New("only one of iops and iopsPerGB may be set")
+	}
+	if params.throughput > 0 && params.volumeType != volumeTypeGp3 {
+		return nil, fmt.Errorf("throughput is only supported for volume type %s", volumeTypeGp3)
+	}
+	if params.kmsKeyID != "" {
+		if encrypted != "" && !params.encrypted {
+			return nil, errors.New("kmsKeyId is set but encrypted is false")
+		}
+		params.encrypted = true
+	}
+	return params, nil
+}
+
+func parsePositiveInt(value string) (int64, error) {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if i <= 0 {
+		return 0, errors.New("must be positive")
+	}
+	return i, nil
+}
+
+// parseZones parses a comma-separated list of zones, sorted so that the same
+// zones always map claims the same way
+func parseZones(list string) []string {
+	var zones []string
+	for _, zone := range strings.Split(list, ",") {
+		zone = strings.TrimSpace(zone)
+		if zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// parseTags parses a comma-separated list of key=value pairs
+func parseTags(list string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if err := validateTag(key, value); err != nil {
+			return nil, fmt.Errorf("invalid tag %q: %v", pair, err)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
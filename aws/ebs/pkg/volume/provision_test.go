@@ -0,0 +1,347 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name               string
+		parameters         map[string]string
+		capacity           string
+		labels             map[string]string
+		accessModes        []v1.PersistentVolumeAccessMode
+		createErr          error
+		waitErr            error
+		tagErr             error
+		expectedInput      *ec2.CreateVolumeInput
+		expectedThroughput int64
+		expectedTags       map[string]string
+		expectedSource     v1.PersistentVolumeSource
+		expectedCapacity   string
+		expectDelete       bool
+		expectError        bool
+	}{
+		{
+			name:     "succeed with defaults, rounding up capacity",
+			capacity: "1500Mi",
+			expectedInput: &ec2.CreateVolumeInput{
+				AvailabilityZone: aws.String("us-east-1a"),
+				Size:             aws.Int64(2),
+				VolumeType:       aws.String("gp2"),
+			},
+			expectedTags:     createdForTags,
+			expectedSource:   v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/vol-1", FSType: "ext4"}},
+			expectedCapacity: "2Gi",
+		},
+		{
+			name:       "succeed with gp3, iops, throughput and kms key",
+			parameters: map[string]string{"type": "gp3", "iops": "4000", "throughput": "250", "kmsKeyId": "arn:aws:kms:us-east-1:012345678910:key/abcd", "zone": "us-east-1b", "fsType": "xfs"},
+			expectedInput: &ec2.CreateVolumeInput{
+				AvailabilityZone: aws.String("us-east-1b"),
+				Size:             aws.Int64(1),
+				VolumeType:       aws.String("gp3"),
+				Iops:             aws.Int64(4000),
+				Encrypted:        aws.Bool(true),
+				KmsKeyId:         aws.String("arn:aws:kms:us-east-1:012345678910:key/abcd"),
+			},
+			expectedThroughput: 250,
+			expectedTags:       createdForTags,
+			expectedSource:     v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1b/vol-1", FSType: "xfs"}},
+			expectedCapacity:   "1Gi",
+		},
+		{
+			name:       "succeed with io2 and iopsPerGB",
+			parameters: map[string]string{"type": "io2", "iopsPerGB": "50", "encrypted": "true"},
+			capacity:   "10Gi",
+			expectedInput: &ec2.CreateVolumeInput{
+				AvailabilityZone: aws.String("us-east-1a"),
+				Size:             aws.Int64(10),
+				VolumeType:       aws.String("io2"),
+				Iops:             aws.Int64(500),
+				Encrypted:        aws.Bool(true),
+			},
+			expectedTags:     createdForTags,
+			expectedSource:   v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/vol-1", FSType: "ext4"}},
+			expectedCapacity: "10Gi",
+		},
+		{
+			name:       "succeed with claim labels and class tags, skipping reserved labels",
+			parameters: map[string]string{"tags": "team=storage, cost-center=42"},
+			labels:     map[string]string{"app": "db", "team": "web", "aws:foo": "bar"},
+			expectedInput: &ec2.CreateVolumeInput{
+				AvailabilityZone: aws.String("us-east-1a"),
+				Size:             aws.Int64(1),
+				VolumeType:       aws.String("gp2"),
+			},
+			expectedTags: map[string]string{
+				"app":                               "db",
+				"team":                              "storage",
+				"cost-center":                       "42",
+				"kubernetes.io/created-for/pv/name": "pvc-1",
+				"kubernetes.io/created-for/pvc/namespace": v1.NamespaceDefault,
+				"kubernetes.io/created-for/pvc/name":      "claim-1",
+			},
+			expectedSource:   v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/vol-1", FSType: "ext4"}},
+			expectedCapacity: "1Gi",
+		},
+		{
+			name:        "bad parameter",
+			parameters:  map[string]string{"foo": "bar"},
+			expectError: true,
+		},
+		{
+			name:        "io1 without iops",
+			parameters:  map[string]string{"type": "io1"},
+			expectError: true,
+		},
+		{
+			name:        "throughput for gp2",
+			parameters:  map[string]string{"throughput": "250"},
+			expectError: true,
+		},
+		{
+			name:        "kms key without encryption",
+			parameters:  map[string]string{"kmsKeyId": "abcd", "encrypted": "false"},
+			expectError: true,
+		},
+		{
+			name:        "read-write-many claim",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			expectError: true,
+		},
+		{
+			name:        "create fails",
+			createErr:   errors.New("fake error"),
+			expectError: true,
+		},
+		{
+			name:         "volume errors",
+			waitErr:      errors.New("fake error"),
+			expectDelete: true,
+			expectError:  true,
+		},
+		{
+			name:         "tagging fails",
+			tagErr:       errors.New("fake error"),
+			expectDelete: true,
+			expectError:  true,
+		},
+	}
+	for _, test := range tests {
+		s := &testVolumeService{createErr: test.createErr, waitErr: test.waitErr, tagErr: test.tagErr}
+		p := newEBSProvisionerInternal(s, "us-east-1", "us-east-1a")
+
+		capacity := resource.MustParse("1Gi")
+		if test.capacity != "" {
+			capacity = resource.MustParse(test.capacity)
+		}
+		options := newOptions("pvc-1", capacity)
+		options.Parameters = test.parameters
+		options.PVC.Labels = test.labels
+		if test.accessModes != nil {
+			options.PVC.Spec.AccessModes = test.accessModes
+		}
+		pv, err := p.Provision(options)
+
+		evaluate(t, test.name, false, nil, test.expectDelete, len(s.deleted) == 1, "cleanup delete")
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, pv == nil, "nil pv")
+			continue
+		}
+		evaluate(t, test.name, false, err, test.expectedInput, s.input, "create input")
+		evaluate(t, test.name, false, err, test.expectedThroughput, s.throughput, "throughput")
+		evaluate(t, test.name, false, err, test.expectedTags, s.tags, "tags")
+		evaluate(t, test.name, false, err, "pvc-1", pv.Name, "pv name")
+		evaluate(t, test.name, false, err, test.expectedSource, pv.Spec.PersistentVolumeSource, "volume source")
+		pvCapacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		evaluate(t, test.name, false, err, test.expectedCapacity, pvCapacity.String(), "capacity")
+		evaluate(t, test.name, false, err, "us-east-1", pv.Labels[unversioned.LabelZoneRegion], "region label")
+	}
+}
+
+func TestVolumeTagsLimit(t *testing.T) {
+	labels := make(map[string]string)
+	for i := 0; i < maxTags; i++ {
+		labels[string(rune('a'+i%26))+string(rune('a'+i/26))] = "x"
+	}
+	options := newOptions("pvc-1", resource.MustParse("1Gi"))
+	options.PVC.Labels = labels
+
+	tags, err := volumeTags(options, nil)
+	evaluate(t, "too many tags", true, err, true, tags == nil, "nil tags")
+}
+
+func TestChooseZone(t *testing.T) {
+	zones := parseZones("us-east-1c, us-east-1a,us-east-1b")
+	evaluate(t, "sorted zones", false, nil, []string{"us-east-1a", "us-east-1b", "us-east-1c"}, zones, "zones")
+
+	chosen := make(map[string]bool)
+	for _, claim := range []string{"claim-1", "claim-2", "claim-3", "claim-4", "claim-5", "claim-6"} {
+		zone := chooseZone(zones, claim)
+		evaluate(t, claim, false, nil, zone, chooseZone(zones, claim), "zone chosen again")
+		chosen[zone] = true
+	}
+	if len(chosen) < 2 {
+		t.Errorf("expected claims to be spread over zones but all got %v", chosen)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      v1.PersistentVolumeSource
+		deleteErr   error
+		expectedID  string
+		expectError bool
+	}{
+		{
+			name:       "succeed",
+			source:     v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/vol-1"}},
+			expectedID: "vol-1",
+		},
+		{
+			name:       "succeed with bare volume id",
+			source:     v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "vol-1"}},
+			expectedID: "vol-1",
+		},
+		{
+			name:        "not an ebs volume",
+			source:      v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "foo", Path: "/"}},
+			expectError: true,
+		},
+		{
+			name:        "bad volume id",
+			source:      v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/"}},
+			expectError: true,
+		},
+		{
+			name:        "delete fails",
+			source:      v1.PersistentVolumeSource{AWSElasticBlockStore: &v1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/vol-1"}},
+			deleteErr:   errors.New("fake error"),
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		s := &testVolumeService{deleteErr: test.deleteErr}
+		p := newEBSProvisionerInternal(s, "us-east-1", "us-east-1a")
+
+		err := p.Delete(&v1.PersistentVolume{
+			ObjectMeta: v1.ObjectMeta{Name: "pvc-1"},
+			Spec:       v1.PersistentVolumeSpec{PersistentVolumeSource: test.source},
+		})
+
+		if test.expectError {
+			evaluate(t, test.name, true, err, 0, len(s.deleted), "deleted volumes")
+			continue
+		}
+		evaluate(t, test.name, false, err, []string{test.expectedID}, s.deleted, "deleted volumes")
+	}
+}
+
+var createdForTags = map[string]string{
+	"kubernetes.io/created-for/pv/name":       "pvc-1",
+	"kubernetes.io/created-for/pvc/namespace": v1.NamespaceDefault,
+	"kubernetes.io/created-for/pvc/name":      "claim-1",
+}
+
+func newOptions(pvName string, capacity resource.Quantity) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{Name: "claim-1", Namespace: v1.NamespaceDefault},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): capacity,
+					},
+				},
+			},
+		},
+		Parameters: map[string]string{},
+	}
+}
+
+// testVolumeService is a volumeService whose volumes are all "vol-1"
+type testVolumeService struct {
+	createErr error
+	waitErr   error
+	tagErr    error
+	deleteErr error
+
+	input      *ec2.CreateVolumeInput
+	throughput int64
+	tags       map[string]string
+	deleted    []string
+}
+
+var _ volumeService = &testVolumeService{}
+
+func (s *testVolumeService) create(input *ec2.CreateVolumeInput, throughput int64) (*ec2.Volume, error) {
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	s.input = input
+	s.throughput = throughput
+	return &ec2.Volume{VolumeId: aws.String("vol-1"), AvailabilityZone: input.AvailabilityZone, State: aws.String("creating")}, nil
+}
+
+func (s *testVolumeService) waitUntilAvailable(id string) error {
+	return s.waitErr
+}
+
+func (s *testVolumeService) tag(id string, tags map[string]string) error {
+	if s.tagErr != nil {
+		return s.tagErr
+	}
+	s.tags = tags
+	return nil
+}
+
+func (s *testVolumeService) delete(id string) error {
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+func evaluate(t *testing.T, name string, expectError bool, err error, expected interface{}, got interface{}, output string) {
+	if !expectError && err != nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("unexpected error getting %s: %v", output, err)
+	} else if expectError && err == nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected error but got %s: %v", output, got)
+	} else if !reflect.DeepEqual(expected, got) {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestAddQueryParameter(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	req, _ := ec2.New(sess).CreateVolumeRequest(&ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String("us-east-1a"),
+		Size:             aws.Int64(1),
+		VolumeType:       aws.String("gp3"),
+	})
+	req.Handlers.Build.PushBack(addQueryParameter("Throughput", "250"))
+
+	if err := req.Build(); err != nil {
+		t.Fatalf("error building request: %v", err)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("error reading request body: %v", err)
+	}
+	values, err := url.ParseQuery(string(body))
+	evaluate(t, "throughput", false, err, "250", values.Get("Throughput"), "throughput")
+	evaluate(t, "volume type", false, err, "gp3", values.Get("VolumeType"), "volume type")
+	evaluate(t, "action", false, err, "CreateVolume", values.Get("Action"), "action")
+}
@@ -17,21 +17,15 @@ limitations under the License.
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"flag"
-	"fmt"
-	"os/exec"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/cephfs/pkg/volume"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/pkg/api/v1"
-	storage "k8s.io/client-go/pkg/apis/storage/v1beta1"
-	"k8s.io/client-go/pkg/types"
-	"k8s.io/client-go/pkg/util/uuid"
 	"k8s.io/client-go/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -42,250 +36,44 @@ const (
 	provisionerName           = "kubernetes.io/cephfs"
 	exponentialBackOffOnError = false
 	failedRetryThreshold      = 5
-	provisionCmd              = "/usr/local/bin/cephfs_provisioner"
-	provisionerIDAnn          = "cephFSProvisionerIdentity"
-	cephShareAnn              = "cephShare"
 )
 
-type provisionOutput struct {
-	Path   string `json:"path"`
-	User   string `json:"user"`
-	Secret string `json:"auth"`
-}
-
-type cephFSProvisioner struct {
-	// Kubernetes Client. Use to retrieve Ceph admin secret
-	client kubernetes.Interface
-	// Identity of this cephFSProvisioner, generated. Used to identify "this"
-	// provisioner's PVs.
-	identity types.UID
-}
-
-func newCephFSProvisioner(client kubernetes.Interface) controller.Provisioner {
-	return &cephFSProvisioner{
-		client:   client,
-		identity: uuid.NewUUID(),
-	}
-}
-
-var _ controller.Provisioner = &cephFSProvisioner{}
-
-// Provision creates a storage asset and returns a PV object representing it.
-func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
-	if options.PVC.Spec.Selector != nil {
-		return nil, fmt.Errorf("claim Selector is not supported")
-	}
-	cluster, adminID, adminSecret, mon, err := p.parseParameters(options.Parameters)
-	if err != nil {
-		return nil, err
-	}
-	// create random share name
-	share := fmt.Sprintf("kubernetes-dynamic-pvc-%s", uuid.NewUUID())
-	// create random user id
-	user := fmt.Sprintf("kubernetes-dynamic-user-%s", uuid.NewUUID())
-	// provision share
-	// create cmd
-	cmd := exec.Command(provisionCmd, "-n", share, "-u", user)
-	// set env
-	cmd.Env = []string{
-		"CEPH_CLUSTER_NAME=" + cluster,
-		"CEPH_MON=" + strings.Join(mon[:], ","),
-		"CEPH_AUTH_ID=" + adminID,
-		"CEPH_AUTH_KEY=" + adminSecret}
-
-	output, cmdErr := cmd.CombinedOutput()
-	if cmdErr != nil {
-		glog.Errorf("failed to provision share %q for %q, err: %v, output: %v", share, user, cmdErr, string(output))
-		return nil, cmdErr
-	}
-	// validate output
-	res := &provisionOutput{}
-	json.Unmarshal([]byte(output), &res)
-	if res.User == "" || res.Secret == "" || res.Path == "" {
-		return nil, fmt.Errorf("invalid provisioner output")
-	}
-	// create secret in PVC's namespace
-	nameSpace := options.PVC.Namespace
-	secretName := "ceph-" + user + "-secret"
-	secret := &v1.Secret{
-		ObjectMeta: v1.ObjectMeta{
-			Namespace: nameSpace,
-			Name:      secretName,
-		},
-		Data: map[string][]byte{
-			"key": []byte(res.Secret),
-		},
-		Type: "Opaque",
-	}
-
-	_, err = p.client.Core().Secrets(nameSpace).Create(secret)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create secret")
-	}
-
-	if err != nil {
-		glog.Errorf("Cephfs Provisioner: create volume failed, err: %v", err)
-		return nil, err
-	}
-
-	pv := &v1.PersistentVolume{
-		ObjectMeta: v1.ObjectMeta{
-			Name: options.PVName,
-			Annotations: map[string]string{
-				provisionerIDAnn: string(p.identity),
-				cephShareAnn:     share,
-			},
-		},
-		Spec: v1.PersistentVolumeSpec{
-			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
-			AccessModes: []v1.PersistentVolumeAccessMode{
-				v1.ReadWriteOnce,
-				v1.ReadOnlyMany,
-				v1.ReadWriteMany,
-			},
-			Capacity: v1.ResourceList{ //FIXME: kernel cephfs doesn't enforce quota, capacity is not meaningless here.
-				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
-			},
-			PersistentVolumeSource: v1.PersistentVolumeSource{
-				CephFS: &v1.CephFSVolumeSource{
-					Monitors: mon,
-					Path:     res.Path[strings.Index(res.Path, "/"):],
-					SecretRef: &v1.LocalObjectReference{
-						Name: secretName,
-					},
-					User: user,
-				},
-			},
-		},
-	}
-
-	glog.Infof("successfully created CephFS share %+v", pv.Spec.PersistentVolumeSource.CephFS)
-
-	return pv, nil
-}
-
-// Delete removes the storage asset that was created by Provision represented
-// by the given PV.
-func (p *cephFSProvisioner) Delete(volume *v1.PersistentVolume) error {
-	ann, ok := volume.Annotations[provisionerIDAnn]
-	if !ok {
-		return errors.New("identity annotation not found on PV")
-	}
-	if ann != string(p.identity) {
-		return &controller.IgnoredError{"identity annotation on PV does not match ours"}
-	}
-	share, ok := volume.Annotations[cephShareAnn]
-	if !ok {
-		return errors.New("ceph share annotation not found on PV")
-	}
-	// delete CephFS
-	class, err := p.getClassForVolume(volume)
-	if err != nil {
-		return err
-	}
-	cluster, adminID, adminSecret, mon, err := p.parseParameters(class.Parameters)
-	if err != nil {
-		return err
-	}
-	user := volume.Spec.PersistentVolumeSource.CephFS.User
-	// create cmd
-	cmd := exec.Command(provisionCmd, "-r", "-n", share, "-u", user)
-	// set env
-	cmd.Env = []string{
-		"CEPH_CLUSTER_NAME=" + cluster,
-		"CEPH_MON=" + strings.Join(mon[:], ","),
-		"CEPH_AUTH_ID=" + adminID,
-		"CEPH_AUTH_KEY=" + adminSecret}
-
-	output, cmdErr := cmd.CombinedOutput()
-	if cmdErr != nil {
-		glog.Errorf("failed to delete share %q for %q, err: %v, output: %v", share, user, cmdErr, string(output))
-		return cmdErr
-	}
-
-	return nil
-}
-
-func (p *cephFSProvisioner) parseParameters(parameters map[string]string) (string, string, string, []string, error) {
-	var (
-		err                                                                  error
-		mon                                                                  []string
-		cluster, adminID, adminSecretName, adminSecretNamespace, adminSecret string
-	)
-
-	adminSecretNamespace = "default"
-	adminID = "admin"
-	cluster = "ceph"
-
-	for k, v := range parameters {
-		switch strings.ToLower(k) {
-		case "cluster":
-			cluster = v
-		case "monitors":
-			arr := strings.Split(v, ",")
-			for _, m := range arr {
-				mon = append(mon, m)
-			}
-		case "adminid":
-			adminID = v
-		case "adminsecretname":
-			adminSecretName = v
-		case "adminsecretnamespace":
-			adminSecretNamespace = v
-		default:
-			return "", "", "", nil, fmt.Errorf("invalid option %q", k)
-		}
-	}
-	// sanity check
-	if adminSecretName == "" {
-		return "", "", "", nil, fmt.Errorf("missing Ceph admin secret name")
-	}
-	if adminSecret, err = p.parsePVSecret(adminSecretNamespace, adminSecretName); err != nil {
-		return "", "", "", nil, fmt.Errorf("failed to get admin secret from [%q/%q]: %v", adminSecretNamespace, adminSecretName, err)
-	}
-	if len(mon) < 1 {
-		return "", "", "", nil, fmt.Errorf("missing Ceph monitors")
-	}
-	return cluster, adminID, adminSecret, mon, nil
-}
-
-func (p *cephFSProvisioner) parsePVSecret(namespace, secretName string) (string, error) {
-	if p.client == nil {
-		return "", fmt.Errorf("Cannot get kube client")
-	}
-	secrets, err := p.client.Core().Secrets(namespace).Get(secretName)
-	if err != nil {
-		return "", err
-	}
-	for _, data := range secrets.Data {
-		return string(data), nil
-	}
-
-	// If not found, the last secret in the map wins as done before
-	return "", fmt.Errorf("no secret found")
-}
-
-func (p *cephFSProvisioner) getClassForVolume(pv *v1.PersistentVolume) (*storage.StorageClass, error) {
-	className, found := pv.Annotations["volume.beta.kubernetes.io/storage-class"]
-	if !found {
-		return nil, fmt.Errorf("Volume has no class annotation")
-	}
-
-	class, err := p.client.Storage().StorageClasses().Get(className)
-	if err != nil {
-		return nil, err
-	}
-	return class, nil
-}
-
 var (
-	master     = flag.String("master", "", "Master URL")
-	kubeconfig = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	master         = flag.String("master", "", "Master URL")
+	kubeconfig     = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	keyringFile    = flag.String("ceph-keyring-file", "", "Absolute path to a Ceph keyring to take admin keys from for classes that don't specify adminSecretName. The file is re-read whenever it changes.")
+	quotaConfigMap = flag.String("quota-configmap", "", "ConfigMap, as namespace/name, of per-namespace caps on the number and total size of provisioned shares. Unset means no caps.")
+	logFormat      = flag.String("log-format", volume.LogFormatText, "Format of the provisioner's log lines about shares: text, for glog lines with key=value fields, or json, for one JSON object per line on stderr.")
+	healthAddress  = flag.String("health-address", "", "The address to serve /healthz and /readyz on, e.g. :8080. /readyz fails while the API server or the Ceph cluster of any cephfs class is unreachable. Unset means not served.")
+	healthPeriod   = flag.Duration("health-check-period", 30*time.Second, "How often to check the API server and the Ceph clusters of the cephfs classes for /readyz.")
+	cleanupImage   = flag.String("cleanup-job-image", "", "Image to purge the data of deleted shares with in Kubernetes Jobs, so that deleting large shares doesn't block the provisioner. It must have cephfs_provisioner. Unset means shares are purged by the provisioner itself.")
+	cleanupNS      = flag.String("cleanup-job-namespace", "default", "Namespace to run cleanup jobs in.")
+	cleanupCommand = flag.String("cleanup-job-command", "", "Space-separated command of cleanup jobs, to which the arguments to purge a share are appended. Unset means the provision command and its args. Can only be set if cleanup-job-image is set.")
+	cmdPath        = flag.String("provision-command", "/usr/local/bin/cephfs_provisioner", "Path of the script that creates and deletes shares.")
+	cmdArgs        = flag.String("provision-command-args", "", "Space-separated arguments to pass the provision command before those of each invocation.")
+	cmdTimeout     = flag.Duration("provision-command-timeout", 5*time.Minute, "How long an invocation of the provision command may run before it is killed. A share whose creation times out is deleted. 0 means forever.")
+	trashRetention = flag.Duration("trash-retention", 0, "How long to keep the data of deleted shares in the .trash directory under the volume root before purging it. 0 means shares are deleted right away. Can't be set if cleanup-job-image is set.")
+	trashPeriod    = flag.Duration("trash-purge-period", time.Hour, "How often to purge the shares in the trash whose retention period is over.")
+	trashRecords   = flag.String("trash-records-configmap", "default/cephfs-provisioner-trash", "ConfigMap, as namespace/name, to record the clusters shares were soft-deleted in, so that their trash is purged even once no class or PV refers to them. Provisioners with different trash retentions must not share it.")
+	auditLog       = flag.String("audit-log", "", "Absolute path of a file to append audit entries of destructive operations on shares to, one JSON object per line. Unset means they are logged like other lines.")
+	sharesNS       = flag.String("share-records-namespace", "", "Namespace to keep a CephFSShare record of each provisioned share in, for admin tooling. Unset means no records are kept.")
+	sharesPeriod   = flag.Duration("share-records-sync-period", 10*time.Minute, "How often to reconcile the share records with the provisioned PVs.")
 )
 
 func main() {
 	flag.Parse()
 	flag.Set("logtostderr", "true")
+	if err := volume.SetLogFormat(*logFormat); err != nil {
+		glog.Fatalf("Invalid -log-format: %v", err)
+	}
+	if err := volume.SetProvisionCmd(*cmdPath, strings.Fields(*cmdArgs), *cmdTimeout); err != nil {
+		glog.Fatalf("Invalid provision command flags: %v", err)
+	}
+	if *auditLog != "" {
+		if err := volume.SetAuditLog(*auditLog); err != nil {
+			glog.Fatalf("Invalid -audit-log: %v", err)
+		}
+	}
 
 	var config *rest.Config
 	var err error
@@ -312,7 +100,72 @@ func main() {
 
 	// Create the provisioner: it implements the Provisioner interface expected by
 	// the controller
-	cephFSProvisioner := newCephFSProvisioner(clientset)
+	var keyring *volume.Keyring
+	if *keyringFile != "" {
+		keyring, err = volume.NewKeyring(*keyringFile)
+		if err != nil {
+			glog.Fatalf("Error loading keyring: %v", err)
+		}
+	}
+	var quotas *volume.Quotas
+	if *quotaConfigMap != "" {
+		quotas, err = volume.NewQuotas(clientset, *quotaConfigMap)
+		if err != nil {
+			glog.Fatalf("Error configuring quotas: %v", err)
+		}
+	}
+	var cleanupJobs *volume.CleanupJobs
+	if *cleanupImage != "" {
+		cleanupJobs, err = volume.NewCleanupJobs(clientset, *cleanupNS, *cleanupImage, strings.Fields(*cleanupCommand))
+		if err != nil {
+			glog.Fatalf("Error configuring cleanup jobs: %v", err)
+		}
+	} else if *cleanupCommand != "" {
+		glog.Fatalf("Invalid flags specified: cleanup-job-command can only be set if cleanup-job-image is set.")
+	}
+	var trash *volume.Trash
+	if *trashRetention != 0 {
+		if cleanupJobs != nil {
+			glog.Fatalf("Invalid flags specified: trash-retention can't be set if cleanup-job-image is set.")
+		}
+		trash, err = volume.NewTrash(clientset, *trashRecords, *trashRetention)
+		if err != nil {
+			glog.Fatalf("Error configuring trash: %v", err)
+		}
+	}
+	var shares *volume.Shares
+	if *sharesNS != "" {
+		shares, err = volume.NewShares(clientset, *sharesNS)
+		if err != nil {
+			glog.Fatalf("Error configuring share records: %v", err)
+		}
+	}
+	cephFSProvisioner := volume.NewCephFSProvisioner(clientset, keyring, quotas, cleanupJobs, trash, shares)
+
+	if trash != nil {
+		purger, err := volume.NewTrashPurger(cephFSProvisioner, provisionerName)
+		if err != nil {
+			glog.Fatalf("Error creating trash purger: %v", err)
+		}
+		go purger.Run(*trashPeriod, wait.NeverStop)
+	}
+
+	if shares != nil {
+		syncer, err := volume.NewShareSyncer(cephFSProvisioner, provisionerName)
+		if err != nil {
+			glog.Fatalf("Error creating share record syncer: %v", err)
+		}
+		go syncer.Run(*sharesPeriod, wait.NeverStop)
+	}
+
+	if *healthAddress != "" {
+		health, err := volume.NewHealth(cephFSProvisioner, provisionerName)
+		if err != nil {
+			glog.Fatalf("Error creating health checks: %v", err)
+		}
+		go health.Run(*healthPeriod, wait.NeverStop)
+		go serveHealth(*healthAddress, health)
+	}
 
 	// Start the provision controller which will dynamically provision cephFS
 	// PVs
@@ -320,3 +173,9 @@ func main() {
 
 	pc.Run(wait.NeverStop)
 }
+
+// serveHealth serves /healthz and /readyz on address
+func serveHealth(address string, health *volume.Health) {
+	glog.Infof("Serving /healthz and /readyz at %s", address)
+	glog.Errorf("Error serving health checks: %v", http.ListenAndServe(address, health.Handler()))
+}
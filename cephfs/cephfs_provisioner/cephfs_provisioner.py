@@ -14,6 +14,7 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
+import errno
 import os
 import rados
 import getopt
@@ -32,6 +33,11 @@ except ImportError as e:
 
 VOlUME_GROUP="kubernetes"
 CONF_PATH="/etc/ceph/"
+# Highest version of the create_share output schema this script can write.
+# The provisioner asks for the highest version it can read with
+# --output-version and gets min(asked, OUTPUT_VERSION) back in the output's
+# "version" field. Without --output-version the unversioned schema is written.
+OUTPUT_VERSION=1
 
 class CephFSNativeDriver(object):
     """Driver for the Ceph Filesystem.
@@ -92,11 +98,14 @@ class CephFSNativeDriver(object):
         except:
             raise ValueError("Missing CEPH_AUTH_KEY")
 
+        # Directory volumes are created under instead of /volumes
+        volume_prefix = os.environ.get("CEPH_VOLUME_ROOT") or None
+
         conf_path = self._create_conf(cluster_name, mons)
         self._create_keyring(cluster_name, auth_id, auth_key)
 
         self._volume_client = ceph_volume_client.CephFSVolumeClient(
-            auth_id, conf_path, cluster_name)
+            auth_id, conf_path, cluster_name, volume_prefix=volume_prefix)
         try:
             self._volume_client.connect(None)
         except Exception:
@@ -197,13 +206,19 @@ class CephFSNativeDriver(object):
         return caps[0]
 
 
-    def create_share(self, path, user_id, size=None):
+    def create_share(self, path, user_id, size=None, output_version=0, readonly=False):
         """Create a CephFS volume.
         """
         volume_path = ceph_volume_client.VolumePath(VOlUME_GROUP, path)
 
-        # Create the CephFS volume
-        volume = self.volume_client.create_volume(volume_path, size=size)
+        # Create the CephFS volume, reusing it if a previous attempt that
+        # failed later on already created it
+        try:
+            volume = self.volume_client.create_volume(volume_path, size=size)
+        except (OSError, IOError) as e:
+            if e.errno != errno.EEXIST:
+                raise
+            volume = {'mount_path': self.volume_client._get_path(volume_path)}
 
         # To mount this you need to know the mon IPs and the path to the volume
         mon_addrs = self.volume_client.get_mon_addrs()
@@ -215,36 +230,126 @@ class CephFSNativeDriver(object):
         """TODO
         restrict to user_id
         """
-        auth_result = self._authorize_ceph(volume_path, user_id, False)
+        auth_result = self._authorize_ceph(volume_path, user_id, readonly)
         ret = {
             'path': export_location,
             'user': auth_result['entity'],
             'auth': auth_result['key']
         }
+        if output_version > 0:
+            ret['version'] = min(output_version, OUTPUT_VERSION)
         return json.dumps(ret)
 
 
-    def delete_share(self, path, user_id):
+    def delete_share(self, path, user_id, purge=True):
+        """Delete a CephFS volume. Without purge the volume is only moved to
+        the trash, for purge_share to remove its data later on.
+        """
         volume_path = ceph_volume_client.VolumePath(VOlUME_GROUP, path)
         self.volume_client._deauthorize(volume_path, user_id)
         self.volume_client.delete_volume(volume_path)
+        if purge:
+            self.volume_client.purge_volume(volume_path)
+
+    def trash_share(self, path, user_id, timestamp):
+        """Soft-delete a CephFS volume: remove its user and move the volume to
+        the trash directory under the volume prefix as <timestamp>-<path>,
+        keeping its data for purge_trash to remove once it has been kept long
+        enough.
+        """
+        volume_path = ceph_volume_client.VolumePath(VOlUME_GROUP, path)
+        self.volume_client._deauthorize(volume_path, user_id)
+        client_entity = "client.{0}".format(user_id)
+        try:
+            self.volume_client._rados_command('auth get', {'entity': client_entity})
+        except rados.Error:
+            pass
+        else:
+            self.volume_client._rados_command('auth del', {'entity': client_entity})
+        trash_dir = self._trash_dir()
+        self.volume_client._mkdir_p(trash_dir)
+        self.volume_client.fs.rename(self.volume_client._get_path(volume_path),
+                                     os.path.join(trash_dir, timestamp + "-" + path))
+
+    def list_trash(self):
+        """List the soft-deleted volumes in the trash directory.
+        """
+        trash_dir = self._trash_dir()
+        self.volume_client._mkdir_p(trash_dir)
+        fs = self.volume_client.fs
+        names = []
+        dir_handle = fs.opendir(trash_dir)
+        d = fs.readdir(dir_handle)
+        while d:
+            if d.d_name not in [".", ".."]:
+                names.append(d.d_name)
+            d = fs.readdir(dir_handle)
+        fs.closedir(dir_handle)
+        return json.dumps(names)
+
+    def purge_trash(self, name):
+        """Remove a soft-deleted volume from the trash directory.
+        """
+        if "/" in name or name in [".", ".."]:
+            raise ValueError("Invalid trash entry " + name)
+        self._rmtree(os.path.join(self._trash_dir(), name))
+
+    def _trash_dir(self):
+        return os.path.join(self.volume_client.volume_prefix, ".trash")
+
+    def _rmtree(self, root_path):
+        fs = self.volume_client.fs
+        dir_handle = fs.opendir(root_path)
+        d = fs.readdir(dir_handle)
+        while d:
+            if d.d_name not in [".", ".."]:
+                d_full = os.path.join(root_path, d.d_name)
+                if d.is_dir():
+                    self._rmtree(d_full)
+                else:
+                    fs.unlink(d_full)
+            d = fs.readdir(dir_handle)
+        fs.closedir(dir_handle)
+        fs.rmdir(root_path)
+
+    def purge_share(self, path):
+        """Remove the data of a CephFS volume deleted without purge.
+        """
+        volume_path = ceph_volume_client.VolumePath(VOlUME_GROUP, path)
         self.volume_client.purge_volume(volume_path)
 
+    def check(self):
+        """Connect to the cluster and ask the mons for their addresses, only to
+        verify that the cluster is reachable with the given credentials.
+        """
+        self.volume_client.get_mon_addrs()
+
     def __del__(self):
         if self._volume_client:
             self._volume_client.disconnect()
             self._volume_client = None
 
+def usage():
+    print >> sys.stderr, "Usage: " + sys.argv[0] + " [--remove [--no-purge | --trash=timestamp]] [--output-version=N] [--readonly] -n share_name -u ceph_user_id | --purge -n share_name | --list-trash | --purge-trash -n trash_entry | --check"
+    sys.exit(1)
+
 def main():
     create = True
     share = ""
     user = ""
+    output_version = 0
+    readonly = False
+    check = False
+    purge = True
+    purge_only = False
+    trash_timestamp = ""
+    list_trash = False
+    purge_trash = False
     cephfs = CephFSNativeDriver()
     try:
-        opts, args = getopt.getopt(sys.argv[1:], "rn:u:", ["remove"])
+        opts, args = getopt.getopt(sys.argv[1:], "rn:u:", ["remove", "output-version=", "readonly", "check", "no-purge", "purge", "trash=", "list-trash", "purge-trash"])
     except getopt.GetoptError:
-        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id"
-        sys.exit(1)
+        usage()
 
     for opt, arg in opts:
         if opt == '-n':
@@ -253,15 +358,55 @@ def main():
             user = arg
         elif opt in ("-r", "--remove"):
             create = False
+        elif opt == "--output-version":
+            try:
+                output_version = int(arg)
+            except ValueError:
+                usage()
+        elif opt == "--readonly":
+            readonly = True
+        elif opt == "--check":
+            check = True
+        elif opt == "--no-purge":
+            purge = False
+        elif opt == "--purge":
+            purge_only = True
+        elif opt == "--trash":
+            trash_timestamp = arg
+        elif opt == "--list-trash":
+            list_trash = True
+        elif opt == "--purge-trash":
+            purge_trash = True
+
+    if check == True:
+        cephfs.check()
+        return
+
+    if purge_only == True:
+        if share == "":
+            usage()
+        cephfs.purge_share(share)
+        return
+
+    if list_trash == True:
+        print cephfs.list_trash()
+        return
+
+    if purge_trash == True:
+        if share == "":
+            usage()
+        cephfs.purge_trash(share)
+        return
 
     if share == "" or user == "":
-        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id"
-        sys.exit(1)
+        usage()
 
     if create == True:
-        print cephfs.create_share(share, user)    
+        print cephfs.create_share(share, user, output_version=output_version, readonly=readonly)
+    elif trash_timestamp != "":
+        cephfs.trash_share(share, user, trash_timestamp)
     else:
-        cephfs.delete_share(share, user)    
+        cephfs.delete_share(share, user, purge=purge)    
         
         
 if __name__ == "__main__":
@@ -0,0 +1,82 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// auditOutput is where audit entries are appended as JSON lines. If nil
+	// they are logged like any other line. It is a variable so tests can
+	// replace it.
+	auditOutput io.Writer
+	auditMutex  sync.Mutex
+	// auditActor identifies the provisioner in audit entries: its host name,
+	// i.e. its pod's name
+	auditActor = hostname()
+)
+
+// SetAuditLog makes audit entries be appended to the file at path, one JSON
+// object per line, instead of being logged with the provisioner's other
+// lines. The file is created if it doesn't exist.
+func SetAuditLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %v", err)
+	}
+	auditOutput = f
+	return nil
+}
+
+// audit records that the destructive operation, e.g. deleting a share, was
+// carried out by this provisioner, with the fields of log saying for which
+// claim, PV and share, at what time and whether it failed with err
+func audit(log *logger, operation string, params *cephFSParameters, err error) {
+	fields := append(append([]interface{}{}, log.fields...),
+		"audit", operation,
+		"actor", auditActor,
+		"cluster", params.cluster,
+		"monitors", strings.Join(params.mon, ","))
+	msg := "share " + operation + " succeeded"
+	if err != nil {
+		msg = "share " + operation + " failed"
+		fields = append(fields, "err", err)
+	}
+	if auditOutput == nil {
+		log.log("info", "audit: "+msg, fields[len(log.fields):])
+		return
+	}
+	line := formatJSON(time.Now(), "audit", msg, fields)
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditOutput.Write(line)
+}
+
+// hostname returns the host name, or "unknown" if it can't be determined
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	defer func(output io.Writer) { auditOutput = output }(auditOutput)
+
+	dir, err := ioutil.TempDir("", "cephfs-audit")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	auditPath := path.Join(dir, "audit.log")
+	if err := SetAuditLog(auditPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer auditOutput.(*os.File).Close()
+
+	params := &cephFSParameters{cluster: "ceph", mon: []string{"10.0.0.1:6789", "10.0.0.2:6789"}}
+	log := newOperationLogger("delete", "pv", "pvc-1", "pvc", "default/claim-1", "share", "share-1", "user", "user-1")
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		return nil, nil, nil
+	}
+	if err := deleteShare(log, "share-1", "user-1", params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte("permission denied"), errors.New("exit status 1")
+	}
+	if err := deleteShare(log, "share-1", "user-1", params); err == nil {
+		t.Fatalf("expected error but got none")
+	}
+
+	content, err := ioutil.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit entries but got %q", string(content))
+	}
+	for i, expectedMsg := range []string{"share delete succeeded", "share delete failed"} {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("unexpected error parsing audit entry %q: %v", lines[i], err)
+		}
+		expected := map[string]interface{}{
+			"level":    "audit",
+			"msg":      expectedMsg,
+			"audit":    "delete",
+			"actor":    auditActor,
+			"pv":       "pvc-1",
+			"pvc":      "default/claim-1",
+			"share":    "share-1",
+			"user":     "user-1",
+			"cluster":  "ceph",
+			"monitors": "10.0.0.1:6789,10.0.0.2:6789",
+		}
+		for k, v := range expected {
+			if entry[k] != v {
+				t.Errorf("entry %d: expected %s %q but got %q", i, k, v, entry[k])
+			}
+		}
+		if _, ok := entry["time"]; !ok {
+			t.Errorf("entry %d: expected time but got none", i)
+		}
+		if _, ok := entry["err"]; ok != (i == 1) {
+			t.Errorf("entry %d: unexpected err %v", i, entry["err"])
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+	batch "k8s.io/client-go/pkg/apis/batch/v1"
+)
+
+const (
+	// cleanupJobLabel is set on cleanup Jobs and their secrets, to the name of
+	// the share they purge
+	cleanupJobLabel = "cephfs.kubernetes.io/cleanup"
+	// cleanupSecretKey is the key of the admin key in a cleanup Job's secret
+	cleanupSecretKey = "key"
+)
+
+// CleanupJobs purges the data of deleted shares in Kubernetes Jobs, so that
+// Delete only has to remove the share's user and move the share to the trash,
+// which is quick however large the share, rather than block the controller
+// for as long as removing the share's files takes. Each Job runs provisionCmd
+// with --purge in an image that has it, takes the class' admin key from a
+// secret owned by the Job, and is left for an administrator to delete once
+// it has completed.
+type CleanupJobs struct {
+	client    kubernetes.Interface
+	namespace string
+	image     string
+	command   []string
+}
+
+// NewCleanupJobs creates a CleanupJobs that runs Jobs in namespace with the
+// given image and command, to which the --purge arguments are appended. An
+// empty command means provisionCmd and its args, as set by SetProvisionCmd.
+func NewCleanupJobs(client kubernetes.Interface, namespace, image string, command []string) (*CleanupJobs, error) {
+	if namespace == "" {
+		return nil, errors.New("cleanup job namespace must not be empty")
+	}
+	if image == "" {
+		return nil, errors.New("cleanup job image must not be empty")
+	}
+	if len(command) == 0 {
+		command = append([]string{provisionCmdPath}, provisionCmdArgs...)
+	}
+	return &CleanupJobs{client: client, namespace: namespace, image: image, command: command}, nil
+}
+
+// start creates the Job, and its secret, that purges the share of the named
+// PV. A Job or secret that already exists, e.g. because an earlier Delete
+// failed after creating it, is left as is.
+func (c *CleanupJobs) start(pvName, share string, params *cephFSParameters) (*batch.Job, error) {
+	name := "cephfs-cleanup-" + pvName
+	labels := map[string]string{cleanupJobLabel: share}
+
+	env := []v1.EnvVar{}
+	for _, e := range params.env() {
+		kv := strings.SplitN(e, "=", 2)
+		if kv[0] == "CEPH_AUTH_KEY" {
+			continue
+		}
+		env = append(env, v1.EnvVar{Name: kv[0], Value: kv[1]})
+	}
+	env = append(env, v1.EnvVar{
+		Name: "CEPH_AUTH_KEY",
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: name},
+				Key:                  cleanupSecretKey,
+			},
+		},
+	})
+
+	job := &batch.Job{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: c.namespace, Labels: labels},
+		Spec: batch.JobSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyOnFailure,
+					Containers: []v1.Container{
+						{
+							Name:    "cleanup",
+							Image:   c.image,
+							Command: append(append([]string{}, c.command...), "--purge", "-n", share),
+							Env:     env,
+						},
+					},
+				},
+			},
+		},
+	}
+	created, err := c.client.Batch().Jobs(c.namespace).Create(job)
+	if apierrs.IsAlreadyExists(err) {
+		created, err = c.client.Batch().Jobs(c.namespace).Get(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating cleanup job %s/%s: %v", c.namespace, name, err)
+	}
+
+	// Owned by the Job so that deleting the Job deletes the key with it. The
+	// Job's pod waits for the secret if it starts first.
+	secret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+			Labels:    labels,
+			OwnerReferences: []v1.OwnerReference{
+				{APIVersion: "batch/v1", Kind: "Job", Name: created.Name, UID: created.UID},
+			},
+		},
+		Data: map[string][]byte{cleanupSecretKey: []byte(params.adminSecret)},
+	}
+	if _, err := c.client.Core().Secrets(c.namespace).Create(secret); err != nil && !apierrs.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("error creating secret of cleanup job %s/%s: %v", c.namespace, name, err)
+	}
+	return created, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller/test"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestDeleteWithCleanupJob(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var calls [][]string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		calls = append(calls, args)
+		return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1", "user": "client.kubernetes-dynamic-user-uid-claim-1", "auth": "key-1"}`), nil, nil
+	}
+
+	client := fake.NewSimpleClientset()
+	cleanupJobs, err := NewCleanupJobs(client, "kube-system", "quay.io/external_storage/cephfs-provisioner:latest", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+	p := NewCephFSProvisioner(client, keyring, nil, cleanupJobs, nil, nil)
+
+	options := test.NewVolumeOptions(test.NewClaim("claim-1", "default", "class-1", "1Gi"), map[string]string{"monitors": "10.0.0.1:6789"})
+	volume, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	// twice, as if the first Delete had failed after creating the job
+	for i := 0; i < 2; i++ {
+		if err := p.Delete(volume); err != nil {
+			t.Fatalf("unexpected error deleting: %v", err)
+		}
+	}
+
+	trashCall := []string{"-r", "--no-purge", "-n", "kubernetes-dynamic-pvc-uid-claim-1", "-u", "kubernetes-dynamic-user-uid-claim-1"}
+	if !reflect.DeepEqual(calls[len(calls)-1], trashCall) {
+		t.Errorf("expected call %v but got %v", trashCall, calls[len(calls)-1])
+	}
+
+	job, err := client.Batch().Jobs("kube-system").Get("cephfs-cleanup-" + volume.Name)
+	if err != nil {
+		t.Fatalf("unexpected error getting job: %v", err)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	expectedCommand := []string{provisionCmd, "--purge", "-n", "kubernetes-dynamic-pvc-uid-claim-1"}
+	if !reflect.DeepEqual(expectedCommand, container.Command) {
+		t.Errorf("expected command %v but got %v", expectedCommand, container.Command)
+	}
+	for _, env := range container.Env {
+		if env.Name == "CEPH_AUTH_KEY" && (env.Value != "" || env.ValueFrom == nil || env.ValueFrom.SecretKeyRef.Name != job.Name) {
+			t.Errorf("expected CEPH_AUTH_KEY from secret %q but got %+v", job.Name, env)
+		}
+	}
+
+	secret, err := client.Core().Secrets("kube-system").Get(job.Name)
+	if err != nil {
+		t.Fatalf("unexpected error getting secret: %v", err)
+	}
+	if key := string(secret.Data[cleanupSecretKey]); key != "admin-key" {
+		t.Errorf("expected key %q but got %q", "admin-key", key)
+	}
+	expectedOwners := []v1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: job.Name, UID: job.UID}}
+	if !reflect.DeepEqual(expectedOwners, secret.OwnerReferences) {
+		t.Errorf("expected owner references %v but got %v", expectedOwners, secret.OwnerReferences)
+	}
+}
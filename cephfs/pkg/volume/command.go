@@ -0,0 +1,102 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// provisionCmd is the default path of the ceph_volume_client based script
+// that creates and deletes shares
+const provisionCmd = "/usr/local/bin/cephfs_provisioner"
+
+var (
+	provisionCmdPath = provisionCmd
+	// provisionCmdArgs are passed to provisionCmd before the arguments of
+	// each invocation
+	provisionCmdArgs []string
+	// provisionCmdTimeout is how long an invocation of provisionCmd may run
+	// before it is killed. Zero means forever.
+	provisionCmdTimeout time.Duration
+)
+
+// SetProvisionCmd sets the path of provisionCmd, the arguments to pass it
+// before those of each invocation, e.g. to run it with a particular Python,
+// and how long an invocation may run before it is killed, zero meaning
+// forever.
+func SetProvisionCmd(path string, args []string, timeout time.Duration) error {
+	if path == "" {
+		return errors.New("provision command path must not be empty")
+	}
+	if timeout < 0 {
+		return errors.New("provision command timeout must not be negative")
+	}
+	provisionCmdPath = path
+	provisionCmdArgs = args
+	provisionCmdTimeout = timeout
+	return nil
+}
+
+// provisionCmdTimeoutError is returned by runProvisionCmd when provisionCmd
+// was killed for running longer than provisionCmdTimeout. Whatever it was
+// doing may be half done.
+type provisionCmdTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *provisionCmdTimeoutError) Error() string {
+	return fmt.Sprintf("killed after timeout of %v", e.timeout)
+}
+
+// isProvisionCmdTimeout returns whether err is a provisionCmdTimeoutError
+func isProvisionCmdTimeout(err error) bool {
+	_, ok := err.(*provisionCmdTimeoutError)
+	return ok
+}
+
+// runProvisionCmd runs provisionCmd with args and env, returning its stdout
+// and stderr separately so log messages don't break the JSON. It is a
+// variable so tests can replace it.
+var runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+	cmd := exec.Command(provisionCmdPath, append(append([]string{}, provisionCmdArgs...), args...)...)
+	cmd.Env = env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Run it in its own process group so that on timeout the processes it
+	// started, which would keep stdout and stderr open, are killed with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	var timer *time.Timer
+	if provisionCmdTimeout > 0 {
+		timer = time.AfterFunc(provisionCmdTimeout, func() {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+	}
+	err := cmd.Wait()
+	if timer != nil && !timer.Stop() {
+		err = &provisionCmdTimeoutError{timeout: provisionCmdTimeout}
+	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunProvisionCmd(t *testing.T) {
+	defer func(path string, args []string, timeout time.Duration) {
+		SetProvisionCmd(path, args, timeout)
+	}(provisionCmdPath, provisionCmdArgs, provisionCmdTimeout)
+
+	tests := []struct {
+		name           string
+		script         string
+		timeout        time.Duration
+		expectedStdout string
+		expectTimeout  bool
+		expectError    bool
+	}{
+		{
+			name:           "args after the configured args",
+			script:         `echo "$CEPH_MON $@"`,
+			expectedStdout: "10.0.0.1:6789 -n share-1\n",
+		},
+		{
+			name:           "finishes before the timeout",
+			script:         `echo done`,
+			timeout:        time.Minute,
+			expectedStdout: "done\n",
+		},
+		{
+			name:          "killed after the timeout",
+			script:        `sleep 10`,
+			timeout:       100 * time.Millisecond,
+			expectTimeout: true,
+			expectError:   true,
+		},
+		{
+			name:        "fails",
+			script:      `exit 1`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		if err := SetProvisionCmd("/bin/sh", []string{"-c", test.script, "sh"}, test.timeout); err != nil {
+			t.Fatalf("test %s: unexpected error: %v", test.name, err)
+		}
+		start := time.Now()
+		stdout, _, err := runProvisionCmd([]string{"CEPH_MON=10.0.0.1:6789"}, "-n", "share-1")
+		if test.expectError && err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		}
+		if isProvisionCmdTimeout(err) != test.expectTimeout {
+			t.Errorf("test %s: expected timeout %v but got error %v", test.name, test.expectTimeout, err)
+		}
+		if string(stdout) != test.expectedStdout {
+			t.Errorf("test %s: expected stdout %q but got %q", test.name, test.expectedStdout, string(stdout))
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("test %s: expected the command to be killed but it ran for %v", test.name, elapsed)
+		}
+	}
+}
+
+func TestCreateShareDeletesShareOnTimeout(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var calls [][]string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		calls = append(calls, args)
+		if len(calls) == 1 {
+			return nil, nil, &provisionCmdTimeoutError{timeout: time.Minute}
+		}
+		return nil, nil, nil
+	}
+
+	if _, err := createShare(newLogger(), "share-1", "user-1", false, &cephFSParameters{mon: []string{"10.0.0.1:6789"}}); err == nil {
+		t.Errorf("expected error but got none")
+	}
+	expected := [][]string{
+		{"-n", "share-1", "-u", "user-1", "--output-version=1"},
+		{"-r", "-n", "share-1", "-u", "user-1"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v but got %v", expected, calls)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+// errNotChecked is the readiness before the first check
+var errNotChecked = errors.New("not checked yet")
+
+// Health periodically checks that the provisioner can reach the API server
+// and, with the admin credentials of each of its classes, the Ceph clusters
+// of the classes, so that bad credentials or unreachable mons show up as the
+// provisioner being not ready rather than as failed provisions. It serves
+// /healthz, which only says the process is up, and /readyz, which reports
+// the last check.
+type Health struct {
+	provisioner     *cephFSProvisioner
+	provisionerName string
+
+	mutex sync.RWMutex
+	err   error
+}
+
+// NewHealth creates a Health for the classes of provisionerName of the given
+// provisioner, which must have been created by NewCephFSProvisioner.
+func NewHealth(provisioner controller.Provisioner, provisionerName string) (*Health, error) {
+	p, ok := provisioner.(*cephFSProvisioner)
+	if !ok {
+		return nil, fmt.Errorf("provisioner %T is not a CephFS provisioner", provisioner)
+	}
+	return &Health{provisioner: p, provisionerName: provisionerName, err: errNotChecked}, nil
+}
+
+// Run checks every period until stopCh is closed
+func (h *Health) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(h.update, period, stopCh)
+}
+
+// Handler returns a handler that serves /healthz and /readyz
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", h.serveReady)
+	return mux
+}
+
+// serveReady serves 200 if the last check succeeded and 503 with the
+// check's error otherwise
+func (h *Health) serveReady(w http.ResponseWriter, req *http.Request) {
+	h.mutex.RLock()
+	err := h.err
+	h.mutex.RUnlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *Health) update() {
+	err := h.check()
+	if err != nil {
+		glog.Errorf("Readiness check failed: %v", err)
+	}
+
+	h.mutex.Lock()
+	h.err = err
+	h.mutex.Unlock()
+}
+
+// check returns an error if the API server can't be reached or if the Ceph
+// cluster of any of the provisioner's classes can't be reached with the
+// class' admin credentials. Classes sharing a cluster, mons and admin
+// credentials are checked once.
+func (h *Health) check() error {
+	if _, err := h.provisioner.client.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("API server unreachable: %v", err)
+	}
+	classes, err := h.provisioner.client.Storage().StorageClasses().List(v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing storage classes: %v", err)
+	}
+
+	failures := []string{}
+	checked := map[string]error{}
+	for _, class := range classes.Items {
+		if class.Provisioner != h.provisionerName {
+			continue
+		}
+		params, err := h.provisioner.parseParameters(controller.ClassParameters(&class))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("class %q: %v", class.Name, err))
+			continue
+		}
+		key := strings.Join(params.env(), "\n")
+		err, ok := checked[key]
+		if !ok {
+			err = checkCluster(params)
+			checked[key] = err
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("class %q: %v", class.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// checkCluster connects to the cluster of params with provisionCmd
+func checkCluster(params *cephFSParameters) error {
+	_, stderr, err := runProvisionCmd(params.env(), "--check")
+	if err != nil {
+		return fmt.Errorf("Ceph cluster %q unreachable: %v, stderr: %q", params.cluster, err, excerpt(stderr))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller/test"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/runtime"
+)
+
+func TestHealth(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+
+	goodClass := test.NewStorageClass("good", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789"})
+	sameClusterClass := test.NewStorageClass("same-cluster", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789"})
+	claimParametersClass := test.NewStorageClass("claim-parameters", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789", "allowClaimParameterKeys": "monitors"})
+	badClass := test.NewStorageClass("bad", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.2:6789"})
+	noMonitorsClass := test.NewStorageClass("no-monitors", "ceph.com/cephfs", map[string]string{})
+	otherClass := test.NewStorageClass("other", "example.com/other", map[string]string{"monitors": "10.0.0.2:6789"})
+
+	tests := []struct {
+		name          string
+		objs          []runtime.Object
+		expectedCode  int
+		expectedBody  []string
+		expectedCalls int
+	}{
+		{
+			name:         "no classes",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "reachable cluster checked once",
+			objs:          []runtime.Object{goodClass, sameClusterClass},
+			expectedCode:  http.StatusOK,
+			expectedCalls: 1,
+		},
+		{
+			name:          "class allowing claims to override parameters",
+			objs:          []runtime.Object{claimParametersClass},
+			expectedCode:  http.StatusOK,
+			expectedCalls: 1,
+		},
+		{
+			name:          "unreachable cluster",
+			objs:          []runtime.Object{goodClass, badClass},
+			expectedCode:  http.StatusServiceUnavailable,
+			expectedBody:  []string{`class "bad"`, "permission denied"},
+			expectedCalls: 2,
+		},
+		{
+			name:         "invalid parameters",
+			objs:         []runtime.Object{noMonitorsClass},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: []string{`class "no-monitors"`},
+		},
+		{
+			name:         "other provisioner's class",
+			objs:         []runtime.Object{otherClass},
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		calls := 0
+		runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+			calls++
+			if len(args) != 1 || args[0] != "--check" {
+				t.Errorf("test %s: expected args [--check] but got %v", tc.name, args)
+			}
+			for _, e := range env {
+				if e == "CEPH_MON=10.0.0.2:6789" {
+					return nil, []byte("PermissionDeniedError: permission denied"), errors.New("exit status 1")
+				}
+			}
+			return nil, nil, nil
+		}
+
+		keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(tc.objs...), keyring, nil, nil, nil, nil)
+		health, err := NewHealth(p, "ceph.com/cephfs")
+		if err != nil {
+			t.Fatalf("test %s: unexpected error: %v", tc.name, err)
+		}
+		handler := health.Handler()
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("test %s: expected code %d before the first check but got %d", tc.name, http.StatusServiceUnavailable, recorder.Code)
+		}
+
+		health.update()
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		if recorder.Code != tc.expectedCode {
+			t.Errorf("test %s: expected code %d but got %d: %s", tc.name, tc.expectedCode, recorder.Code, recorder.Body.String())
+		}
+		for _, s := range tc.expectedBody {
+			if !strings.Contains(recorder.Body.String(), s) {
+				t.Errorf("test %s: expected body to contain %q but got %q", tc.name, s, recorder.Body.String())
+			}
+		}
+		if calls != tc.expectedCalls {
+			t.Errorf("test %s: expected %d checks but got %d", tc.name, tc.expectedCalls, calls)
+		}
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("test %s: expected /healthz code %d but got %d", tc.name, http.StatusOK, recorder.Code)
+		}
+	}
+}
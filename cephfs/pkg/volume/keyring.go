@@ -0,0 +1,168 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// Keyring holds the keys of a Ceph keyring file, re-reading the file whenever
+// it changes so that rotated credentials are picked up without a restart.
+type Keyring struct {
+	path string
+	// Map of Ceph user ID (without the "client." prefix) to key
+	keys  map[string]string
+	mutex sync.RWMutex
+	log   *logger
+}
+
+// NewKeyring reads the keyring file at path and starts watching it for
+// changes.
+func NewKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path, log: newLogger("keyring", path)}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating keyring watcher: %v", err)
+	}
+	// Watch the directory rather than the file: files mounted from secrets &
+	// configmaps are updated by atomically swapping a symlink, which a watch
+	// on the file itself doesn't survive.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("error watching keyring %s: %v", path, err)
+	}
+	go k.watch(watcher)
+
+	return k, nil
+}
+
+func (k *Keyring) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if glog.V(4) {
+				k.log.info("keyring directory event", "event", event)
+			}
+			if err := k.reload(); err != nil {
+				// Keep using the last good keys, the file may be mid-update
+				k.log.error("error reloading keyring, keeping previous keys", "err", err)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			k.log.error("error watching keyring", "err", err)
+		}
+	}
+}
+
+// reload re-reads the keyring file.
+func (k *Keyring) reload() error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys, err := parseKeyring(f)
+	if err != nil {
+		return fmt.Errorf("error parsing keyring %s: %v", k.path, err)
+	}
+
+	k.mutex.Lock()
+	changed := len(keys) != len(k.keys)
+	for id, key := range keys {
+		if k.keys[id] != key {
+			changed = true
+		}
+	}
+	k.keys = keys
+	k.mutex.Unlock()
+
+	if changed {
+		k.log.info("loaded keys from keyring", "users", len(keys))
+	}
+	return nil
+}
+
+// getKey returns the key of the given Ceph user ID.
+func (k *Keyring) getKey(id string) (string, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("no key for client.%s in keyring %s", id, k.path)
+	}
+	return key, nil
+}
+
+// parseKeyring parses a Ceph keyring, which is ini-like:
+//
+//	[client.admin]
+//	    key = AQCMpH9YM4Q1BhAAXGNQyyOne8ZsXqWGon/dIQ==
+//
+// Only "client." entities and their keys are returned.
+func parseKeyring(r io.Reader) (map[string]string, error) {
+	keys := make(map[string]string)
+	entity := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("invalid section header %q", line)
+			}
+			entity = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "key" {
+			continue
+		}
+		if !strings.HasPrefix(entity, "client.") {
+			continue
+		}
+		keys[strings.TrimPrefix(entity, "client.")] = strings.TrimSpace(kv[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no client keys found")
+	}
+	return keys, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// LogFormatText writes log lines through glog with their fields as
+	// key=value pairs after the message
+	LogFormatText = "text"
+	// LogFormatJSON writes log lines to stderr as JSON objects, one per line
+	LogFormatJSON = "json"
+)
+
+var (
+	logFormat = LogFormatText
+	// logOutput is where JSON log lines are written. It is a variable so
+	// tests can replace it.
+	logOutput io.Writer = os.Stderr
+	logMutex  sync.Mutex
+)
+
+// SetLogFormat sets how the provisioner's log lines are written, LogFormatText
+// or LogFormatJSON.
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatText, LogFormatJSON:
+		logFormat = format
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q, must be %q or %q", format, LogFormatText, LogFormatJSON)
+}
+
+// logger writes log lines carrying a list of key/value fields, e.g. the claim
+// and share an operation is about
+type logger struct {
+	fields []interface{}
+}
+
+// newLogger returns a logger whose lines carry keysAndValues
+func newLogger(keysAndValues ...interface{}) *logger {
+	return &logger{fields: keysAndValues}
+}
+
+// newOperationLogger returns a logger for one provision or delete operation
+// whose lines all carry the same new correlation ID, so the lines of an
+// operation can be told apart from those of concurrent ones
+func newOperationLogger(operation string, keysAndValues ...interface{}) *logger {
+	return newLogger("operation", operation, "correlationID", newCorrelationID()).with(keysAndValues...)
+}
+
+// with returns a logger whose lines also carry keysAndValues
+func (l *logger) with(keysAndValues ...interface{}) *logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	return &logger{fields: append(fields, keysAndValues...)}
+}
+
+func (l *logger) info(msg string, keysAndValues ...interface{}) {
+	l.log("info", msg, keysAndValues)
+}
+
+func (l *logger) error(msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, keysAndValues)
+}
+
+func (l *logger) log(level, msg string, keysAndValues []interface{}) {
+	fields := append(append([]interface{}{}, l.fields...), keysAndValues...)
+	if logFormat == LogFormatJSON {
+		line := formatJSON(time.Now(), level, msg, fields)
+		logMutex.Lock()
+		defer logMutex.Unlock()
+		logOutput.Write(line)
+		return
+	}
+	line := formatText(msg, fields)
+	// depth 2 attributes the line to the caller of info or error
+	if level == "error" {
+		glog.ErrorDepth(2, line)
+	} else {
+		glog.InfoDepth(2, line)
+	}
+}
+
+// formatText returns msg followed by the fields as key=value pairs, quoting
+// values that contain spaces or quotes
+func formatText(msg string, fields []interface{}) string {
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := field(fields, i)
+		s := fmt.Sprint(value)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(&buf, " %s=%s", key, s)
+	}
+	return buf.String()
+}
+
+// formatJSON returns a JSON object with the time, level, message and fields
+// of a log line, terminated by a newline
+func formatJSON(t time.Time, level, msg string, fields []interface{}) []byte {
+	object := map[string]interface{}{
+		"time":  t.UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   msg,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		key, value := field(fields, i)
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		object[key] = value
+	}
+	line, err := json.Marshal(object)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{
+			"time":  object["time"],
+			"level": level,
+			"msg":   msg,
+			"error": fmt.Sprintf("error encoding log fields: %v", err),
+		})
+	}
+	return append(line, '\n')
+}
+
+// field returns the i-th key of fields and the value following it. A key
+// without a value gets an empty one.
+func field(fields []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(fields[i])
+	if i+1 >= len(fields) {
+		return key, ""
+	}
+	return key, fields[i+1]
+}
+
+// newCorrelationID returns a random ID for an operation
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatText(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   []interface{}
+		expected string
+	}{
+		{
+			name:     "no fields",
+			expected: "msg",
+		},
+		{
+			name:     "plain values",
+			fields:   []interface{}{"pv", "pvc-1", "attempt", 2},
+			expected: "msg pv=pvc-1 attempt=2",
+		},
+		{
+			name:     "quoted values",
+			fields:   []interface{}{"err", errors.New("exit status 1"), "stderr", "", "path", "a=b"},
+			expected: `msg err="exit status 1" stderr="" path="a=b"`,
+		},
+		{
+			name:     "key without value",
+			fields:   []interface{}{"pv"},
+			expected: `msg pv=""`,
+		},
+	}
+	for _, test := range tests {
+		if line := formatText("msg", test.fields); line != test.expected {
+			t.Errorf("test %s: expected %q, got %q", test.name, test.expected, line)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	line := formatJSON(time.Date(2017, 4, 1, 12, 0, 0, 0, time.UTC), "error", "failed", []interface{}{"pv", "pvc-1", "attempt", 2, "err", errors.New("exit status 1")})
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		t.Errorf("expected line to end with a newline, got %q", line)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(line, &object); err != nil {
+		t.Fatalf("error decoding %q: %v", line, err)
+	}
+	expected := map[string]interface{}{
+		"time":    "2017-04-01T12:00:00Z",
+		"level":   "error",
+		"msg":     "failed",
+		"pv":      "pvc-1",
+		"attempt": float64(2),
+		"err":     "exit status 1",
+	}
+	if !reflect.DeepEqual(object, expected) {
+		t.Errorf("expected %v, got %v", expected, object)
+	}
+}
+
+func TestOperationLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logOutput, logFormat = &buf, LogFormatJSON
+	defer func() {
+		logOutput, logFormat = os.Stderr, LogFormatText
+	}()
+
+	log := newOperationLogger("provision", "pv", "pvc-1")
+	log.with("share", "share-1").info("created")
+	log.error("failed")
+	newOperationLogger("provision").info("other")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", buf.String())
+	}
+	var objects []map[string]interface{}
+	for _, line := range lines {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &object); err != nil {
+			t.Fatalf("error decoding %q: %v", line, err)
+		}
+		objects = append(objects, object)
+	}
+	if objects[0]["share"] != "share-1" || objects[0]["pv"] != "pvc-1" || objects[0]["operation"] != "provision" {
+		t.Errorf("expected fields of the logger and its parent, got %v", objects[0])
+	}
+	if _, ok := objects[1]["share"]; ok {
+		t.Errorf("expected fields added with with not to leak to the parent, got %v", objects[1])
+	}
+	if objects[0]["correlationID"] == "" || objects[0]["correlationID"] != objects[1]["correlationID"] {
+		t.Errorf("expected lines of an operation to have the same correlation ID, got %v and %v", objects[0]["correlationID"], objects[1]["correlationID"])
+	}
+	if objects[2]["correlationID"] == objects[0]["correlationID"] {
+		t.Errorf("expected lines of different operations to have different correlation IDs, got %v", objects[2]["correlationID"])
+	}
+}
+
+func TestSetLogFormat(t *testing.T) {
+	defer SetLogFormat(LogFormatText)
+	if err := SetLogFormat(LogFormatJSON); err != nil || logFormat != LogFormatJSON {
+		t.Errorf("expected format json, got %q, err: %v", logFormat, err)
+	}
+	if err := SetLogFormat("xml"); err == nil {
+		t.Errorf("expected error setting format xml")
+	}
+}
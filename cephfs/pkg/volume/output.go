@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// provisionOutputVersion is the highest version of provisionCmd's output
+	// schema the provisioner can read. It is passed to provisionCmd with
+	// --output-version and provisionCmd answers with the version it actually
+	// wrote, which may be lower but never higher.
+	provisionOutputVersion = 1
+	// How much of provisionCmd's output to include in errors
+	outputExcerptLength = 512
+)
+
+// provisionOutput is what provisionCmd prints to stdout on creating a share.
+// Version 1 is {"version": 1, "path": ..., "user": ..., "auth": ...}.
+type provisionOutput struct {
+	Version int    `json:"version"`
+	Path    string `json:"path"`
+	User    string `json:"user"`
+	Secret  string `json:"auth"`
+}
+
+// parseProvisionOutput parses and validates provisionCmd's output. The
+// returned errors include an excerpt of stderr since they end up in the
+// claim's ProvisioningFailed event, where it's the only clue an admin has as
+// to what went wrong in the script. They never include stdout, which may hold
+// the share's key whether it parses or not.
+func parseProvisionOutput(stdout, stderr []byte) (*provisionOutput, error) {
+	res := &provisionOutput{}
+	if err := json.Unmarshal(stdout, res); err != nil {
+		return nil, fmt.Errorf("error parsing provisioner output: %v, stderr: %q", err, excerpt(stderr))
+	}
+
+	if res.Version == 0 {
+		return nil, fmt.Errorf("provisioner output has no version, the provisioner script is older than the provisioner and must be upgraded, stderr: %q", excerpt(stderr))
+	}
+	if res.Version > provisionOutputVersion {
+		return nil, fmt.Errorf("provisioner output has version %d but at most %d was requested, stderr: %q", res.Version, provisionOutputVersion, excerpt(stderr))
+	}
+
+	missing := []string{}
+	if res.Path == "" {
+		missing = append(missing, "path")
+	}
+	if res.User == "" {
+		missing = append(missing, "user")
+	}
+	if res.Secret == "" {
+		missing = append(missing, "auth")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("provisioner output is missing %s, stderr: %q", strings.Join(missing, ", "), excerpt(stderr))
+	}
+	// the path is <monitors>:<path in CephFS>
+	if !strings.Contains(res.Path, "/") {
+		return nil, fmt.Errorf("provisioner output has path %q with no path in CephFS, stderr: %q", res.Path, excerpt(stderr))
+	}
+
+	return res, nil
+}
+
+// excerpt returns output, trimmed and truncated to outputExcerptLength bytes
+func excerpt(output []byte) string {
+	s := strings.TrimSpace(string(output))
+	if len(s) > outputExcerptLength {
+		return s[:outputExcerptLength] + "..."
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProvisionOutput(t *testing.T) {
+	tests := []struct {
+		name           string
+		stdout         string
+		stderr         string
+		expectedOutput *provisionOutput
+		expectedErr    string
+	}{
+		{
+			name:   "succeed",
+			stdout: `{"version": 1, "path": "/volumes/kubernetes/share", "user": "client.user", "auth": "key"}` + "\n",
+			expectedOutput: &provisionOutput{
+				Version: 1,
+				Path:    "/volumes/kubernetes/share",
+				User:    "client.user",
+				Secret:  "key",
+			},
+		},
+		{
+			name:        "not json",
+			stdout:      `{"version": 1, "auth": "key"`,
+			stderr:      "ceph: connection refused",
+			expectedErr: "ceph: connection refused",
+		},
+		{
+			name:        "no version",
+			stdout:      `{"path": "/volumes/kubernetes/share", "user": "client.user", "auth": "key"}`,
+			expectedErr: "no version",
+		},
+		{
+			name:        "newer version",
+			stdout:      `{"version": 2, "path": "/volumes/kubernetes/share", "user": "client.user", "auth": "key"}`,
+			expectedErr: "version 2",
+		},
+		{
+			name:        "missing fields",
+			stdout:      `{"version": 1, "auth": "key"}`,
+			expectedErr: "missing path, user",
+		},
+		{
+			name:        "path without path in CephFS",
+			stdout:      `{"version": 1, "path": "10.0.0.1:6789", "user": "client.user", "auth": "key"}`,
+			expectedErr: "no path in CephFS",
+		},
+	}
+	for _, test := range tests {
+		output, err := parseProvisionOutput([]byte(test.stdout), []byte(test.stderr))
+		if test.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("test %s: expected error containing %q, got %v", test.name, test.expectedErr, err)
+			}
+			if err != nil && strings.Contains(err.Error(), "key") {
+				t.Errorf("test %s: error leaks the share key: %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(test.expectedOutput, output) {
+			t.Errorf("test %s: expected output %+v, got %+v", test.name, test.expectedOutput, output)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("a", outputExcerptLength+10)
+	if e := excerpt([]byte(long)); e != long[:outputExcerptLength]+"..." {
+		t.Errorf("expected truncated excerpt, got %q", e)
+	}
+	if e := excerpt([]byte("  short\n")); e != "short" {
+		t.Errorf("expected \"short\", got %q", e)
+	}
+}
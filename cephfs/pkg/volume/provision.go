@@ -0,0 +1,662 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1beta1"
+	"k8s.io/client-go/pkg/types"
+	"k8s.io/client-go/pkg/util/uuid"
+)
+
+const (
+	provisionerIDAnn = "cephFSProvisionerIdentity"
+	cephShareAnn     = "cephShare"
+	// mountOptionsAnn is the annotation Kubernetes 1.6+ takes a PV's mount
+	// options from
+	mountOptionsAnn = "volume.beta.kubernetes.io/mount-options"
+
+	// How many times to run provisionCmd when it fails because the share or
+	// user already exists
+	provisionAttempts = 3
+)
+
+// existsErrorPatterns are what provisionCmd's stderr contains, lowercased,
+// when it fails because something it tried to create already exists: a
+// Python EEXIST, a cephfs ObjectExists or a Ceph monitor refusing to create
+// a user that exists with other caps
+var existsErrorPatterns = []string{
+	"eexist",
+	"[errno 17]",
+	"file exists",
+	"objectexists",
+	"already exists",
+	"exists but cap",
+}
+
+// cephFSParameters are the options parsed from a StorageClass
+type cephFSParameters struct {
+	cluster     string
+	adminID     string
+	adminSecret string
+	mon         []string
+	// the access modes claims may request, all of them unless restricted
+	accessModes []v1.PersistentVolumeAccessMode
+	// zone & region, if set, are added to provisioned PVs as failure-domain
+	// labels
+	zone   string
+	region string
+	// the CephFS directory shares are created under instead of /volumes, if
+	// set
+	volumeRoot string
+	// whether to give the users of shares of ReadOnlyMany-only claims
+	// read-only caps
+	readOnlyCaps bool
+	// the comma-separated options to mount shares with, if set
+	mountOptions string
+}
+
+type cephFSProvisioner struct {
+	// Kubernetes Client. Use to retrieve Ceph admin secret
+	client kubernetes.Interface
+	// Identity of this cephFSProvisioner, generated. Used to identify "this"
+	// provisioner's PVs.
+	identity types.UID
+	// Keyring to take the Ceph admin key from when a class doesn't specify
+	// adminSecretName. May be nil.
+	keyring *Keyring
+	// Per-namespace caps on provisioned shares. May be nil.
+	quotas *Quotas
+	// Jobs to purge the data of deleted shares in. If nil, Delete purges it.
+	cleanupJobs *CleanupJobs
+	// Trash to soft-delete shares to. If nil, Delete deletes them.
+	trash *Trash
+	// Records of provisioned shares. May be nil.
+	shares *Shares
+}
+
+// NewCephFSProvisioner creates a Provisioner that provisions CephFS shares
+// using the ceph_volume_client based provisionCmd. keyring, quotas,
+// cleanupJobs, trash and shares may be nil; cleanupJobs and trash must not
+// both be set.
+func NewCephFSProvisioner(client kubernetes.Interface, keyring *Keyring, quotas *Quotas, cleanupJobs *CleanupJobs, trash *Trash, shares *Shares) controller.Provisioner {
+	return &cephFSProvisioner{
+		client:      client,
+		identity:    uuid.NewUUID(),
+		keyring:     keyring,
+		quotas:      quotas,
+		cleanupJobs: cleanupJobs,
+		trash:       trash,
+		shares:      shares,
+	}
+}
+
+var _ controller.Provisioner = &cephFSProvisioner{}
+
+// Provision creates a storage asset and returns a PV object representing it.
+func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	log := newOperationLogger("provision", "pvc", options.PVC.Namespace+"/"+options.PVC.Name, "pv", options.PVName)
+	if options.PVC.Spec.Selector != nil {
+		return nil, &controller.TerminalError{Reason: "claim Selector is not supported"}
+	}
+	params, err := p.parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccessModes(options.PVC.Spec.AccessModes, params.accessModes); err != nil {
+		return nil, &controller.TerminalError{Reason: err.Error()}
+	}
+	// count the share against the namespace's quota unless provisioning fails
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if err := p.quotas.reserve(options.PVName, options.PVC.Namespace, capacity.Value()); err != nil {
+		return nil, err
+	}
+	provisioned := false
+	defer func() {
+		if !provisioned {
+			p.quotas.release(options.PVName)
+		}
+	}()
+	// name the share and user after the PV so that if provisioning fails
+	// after creating them, the next attempt reuses them instead of leaking
+	// them
+	share, user := shareAndUserNames(options.PVName)
+	readOnly := params.readOnlyCaps && isReadOnly(options.PVC.Spec.AccessModes)
+	log = log.with("share", share, "user", user, "readOnly", readOnly)
+	res, err := createShare(log, share, user, readOnly, params)
+	if err != nil {
+		return nil, err
+	}
+	sharePath := res.Path[strings.Index(res.Path, "/"):]
+	if err := checkSharePath(sharePath, params); err != nil {
+		log.error("share created outside the volume root, deleting it", "path", sharePath, "err", err)
+		if deleteErr := deleteShare(log, share, user, params); deleteErr != nil {
+			log.error("failed to delete share created outside the volume root", "err", deleteErr)
+		}
+		return nil, err
+	}
+	// create secret in PVC's namespace
+	nameSpace := options.PVC.Namespace
+	secretName := "ceph-" + user + "-secret"
+	if err := p.createOrUpdateSecret(nameSpace, secretName, share, res.Secret); err != nil {
+		log.error("failed to create secret, deleting share", "secret", nameSpace+"/"+secretName, "err", err)
+		// without the secret the share can't be used, and without a PV
+		// nothing would delete it if the claim went away
+		if deleteErr := deleteShare(log, share, user, params); deleteErr != nil {
+			log.error("failed to delete share after failing to create its secret", "err", deleteErr)
+		}
+		return nil, err
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: string(p.identity),
+				cephShareAnn:     share,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{ //FIXME: kernel cephfs doesn't enforce quota, capacity is not meaningless here.
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CephFS: &v1.CephFSVolumeSource{
+					Monitors: params.mon,
+					Path:     sharePath,
+					SecretRef: &v1.LocalObjectReference{
+						Name: secretName,
+					},
+					User:     user,
+					ReadOnly: readOnly,
+				},
+			},
+		},
+	}
+
+	labels := map[string]string{}
+	if params.zone != "" {
+		labels[unversioned.LabelZoneFailureDomain] = params.zone
+	}
+	if params.region != "" {
+		labels[unversioned.LabelZoneRegion] = params.region
+	}
+	if len(labels) > 0 {
+		pv.Labels = labels
+	}
+	if params.mountOptions != "" {
+		pv.Annotations[mountOptionsAnn] = params.mountOptions
+	}
+
+	// record parameters so the share can be deleted even if the class is
+	// deleted or changed. They only name the admin secret, they don't hold it.
+	if err := controller.SetProvisioningParameters(pv, options.Parameters); err != nil {
+		return nil, err
+	}
+
+	log.info("successfully created CephFS share", "path", pv.Spec.PersistentVolumeSource.CephFS.Path, "monitors", strings.Join(params.mon, ","))
+
+	spec := shareSpecForVolume(pv, time.Now())
+	spec.ClaimNamespace, spec.ClaimName = options.PVC.Namespace, options.PVC.Name
+	if err := p.shares.record(spec); err != nil {
+		log.error("failed to record share, the share syncer records it later", "err", err)
+	}
+
+	provisioned = true
+	return pv, nil
+}
+
+// shareAndUserNames returns the names of the share and the Ceph user of the
+// PV named pvName
+func shareAndUserNames(pvName string) (string, string) {
+	id := strings.TrimPrefix(pvName, "pvc-")
+	return "kubernetes-dynamic-pvc-" + id, "kubernetes-dynamic-user-" + id
+}
+
+// createShare creates the share and authorizes the user to use it with
+// provisionCmd, with read-only caps if readOnly. If provisionCmd fails because the share or user already
+// exists, e.g. when two attempts race, it is run again since it reuses what
+// exists when it finds it.
+func createShare(log *logger, share, user string, readOnly bool, params *cephFSParameters) (*provisionOutput, error) {
+	args := []string{"-n", share, "-u", user, fmt.Sprintf("--output-version=%d", provisionOutputVersion)}
+	if readOnly {
+		args = append(args, "--readonly")
+	}
+	for attempt := 1; ; attempt++ {
+		stdout, stderr, cmdErr := runProvisionCmd(params.env(), args...)
+		if cmdErr == nil {
+			res, err := parseProvisionOutput(stdout, stderr)
+			if err != nil {
+				log.error("failed to parse provisioner script output", "err", err)
+				return nil, err
+			}
+			return res, nil
+		}
+		log.error("failed to provision share", "attempt", attempt, "err", cmdErr, "stdout", string(stdout), "stderr", string(stderr))
+		if isProvisionCmdTimeout(cmdErr) {
+			// the share may be half created, e.g. without its user's caps,
+			// and there is no knowing which half, so start over next time
+			if deleteErr := deleteShare(log, share, user, params); deleteErr != nil {
+				log.error("failed to delete share after timing out creating it", "err", deleteErr)
+			}
+			return nil, fmt.Errorf("failed to provision share %q: %v", share, cmdErr)
+		}
+		if attempt < provisionAttempts && isExistsError(stderr) {
+			log.info("share or user already exists, retrying to reuse it", "attempt", attempt)
+			continue
+		}
+		return nil, fmt.Errorf("failed to provision share %q: %v, stderr: %q", share, cmdErr, excerpt(stderr))
+	}
+}
+
+// isExistsError returns whether provisionCmd's stderr says it failed because
+// something it tried to create already exists
+func isExistsError(stderr []byte) bool {
+	s := strings.ToLower(string(stderr))
+	for _, pattern := range existsErrorPatterns {
+		if strings.Contains(s, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// createOrUpdateSecret creates the secret holding the user's key or, if a
+// previous attempt already created it, updates it with the key. Secrets are
+// annotated with their share so that a secret of the same name that isn't
+// the share's, e.g. one a user created, is never overwritten.
+func (p *cephFSProvisioner) createOrUpdateSecret(namespace, secretName, share, key string) error {
+	secret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: namespace,
+			Name:      secretName,
+			Annotations: map[string]string{
+				cephShareAnn: share,
+			},
+		},
+		Data: map[string][]byte{
+			"key": []byte(key),
+		},
+		Type: "Opaque",
+	}
+
+	_, err := p.client.Core().Secrets(namespace).Create(secret)
+	if err == nil {
+		return nil
+	}
+	if !apierrs.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create secret %s/%s: %v", namespace, secretName, err)
+	}
+	existing, err := p.client.Core().Secrets(namespace).Get(secretName)
+	if err != nil {
+		return fmt.Errorf("failed to get existing secret %s/%s: %v", namespace, secretName, err)
+	}
+	if existing.Annotations[cephShareAnn] != share {
+		return fmt.Errorf("secret %s/%s already exists and is not the secret of share %q, not overwriting it", namespace, secretName, share)
+	}
+	existing.Data = secret.Data
+	existing.Type = secret.Type
+	if _, err := p.client.Core().Secrets(namespace).Update(existing); err != nil {
+		return fmt.Errorf("failed to update existing secret %s/%s: %v", namespace, secretName, err)
+	}
+	return nil
+}
+
+// Delete removes the storage asset that was created by Provision represented
+// by the given PV.
+func (p *cephFSProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != string(p.identity) {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	share, ok := volume.Annotations[cephShareAnn]
+	if !ok {
+		return errors.New("ceph share annotation not found on PV")
+	}
+	// delete CephFS
+	parameters, err := p.provisioningParameters(volume)
+	if err != nil {
+		return err
+	}
+	params, err := p.parseParameters(parameters)
+	if err != nil {
+		return err
+	}
+	user := volume.Spec.PersistentVolumeSource.CephFS.User
+	log := newOperationLogger("delete", "pv", volume.Name, "share", share, "user", user)
+	if volume.Spec.ClaimRef != nil {
+		log = log.with("pvc", volume.Spec.ClaimRef.Namespace+"/"+volume.Spec.ClaimRef.Name)
+	}
+	if err := checkSharePath(volume.Spec.PersistentVolumeSource.CephFS.Path, params); err != nil {
+		return err
+	}
+	if p.trash != nil {
+		if err := p.trash.softDeleteShare(log, share, user, parameters, params); err != nil {
+			return err
+		}
+		log.info("moved CephFS share to the trash, it is purged once its retention period is over", "retention", p.trash.retention)
+	} else if p.cleanupJobs != nil {
+		if err := trashShare(log, share, user, params); err != nil {
+			return err
+		}
+		job, err := p.cleanupJobs.start(volume.Name, share, params)
+		if err != nil {
+			log.error("failed to start cleanup job", "err", err)
+			return err
+		}
+		log.info("moved CephFS share to the trash, its data is purged by a cleanup job", "job", job.Namespace+"/"+job.Name)
+	} else {
+		if err := deleteShare(log, share, user, params); err != nil {
+			return err
+		}
+		log.info("successfully deleted CephFS share")
+	}
+	// in case the share's PV was never saved
+	p.quotas.release(volume.Name)
+	if err := p.shares.forget(volume.Name); err != nil {
+		log.error("failed to delete share record, the share syncer deletes it later", "err", err)
+	}
+
+	return nil
+}
+
+// deleteShare deletes the share and the user with provisionCmd
+func deleteShare(log *logger, share, user string, params *cephFSParameters) error {
+	stdout, stderr, cmdErr := runProvisionCmd(params.env(), "-r", "-n", share, "-u", user)
+	if cmdErr != nil {
+		log.error("failed to delete share", "err", cmdErr, "stdout", string(stdout), "stderr", string(stderr))
+		err := fmt.Errorf("failed to delete share %q: %v, stderr: %q", share, cmdErr, excerpt(stderr))
+		audit(log, "delete", params, err)
+		return err
+	}
+	audit(log, "delete", params, nil)
+	return nil
+}
+
+// trashShare deletes the user and moves the share to the trash with
+// provisionCmd, leaving its data for a cleanup job to purge
+func trashShare(log *logger, share, user string, params *cephFSParameters) error {
+	stdout, stderr, cmdErr := runProvisionCmd(params.env(), "-r", "--no-purge", "-n", share, "-u", user)
+	if cmdErr != nil {
+		log.error("failed to move share to the trash", "err", cmdErr, "stdout", string(stdout), "stderr", string(stderr))
+		err := fmt.Errorf("failed to move share %q to the trash: %v, stderr: %q", share, cmdErr, excerpt(stderr))
+		audit(log, "trash", params, err)
+		return err
+	}
+	audit(log, "trash", params, nil)
+	return nil
+}
+
+// env returns the environment provisionCmd needs to reach the cluster
+func (params *cephFSParameters) env() []string {
+	env := []string{
+		"CEPH_CLUSTER_NAME=" + params.cluster,
+		"CEPH_MON=" + strings.Join(params.mon[:], ","),
+		"CEPH_AUTH_ID=" + params.adminID,
+		"CEPH_AUTH_KEY=" + params.adminSecret}
+	if params.volumeRoot != "" {
+		env = append(env, "CEPH_VOLUME_ROOT="+params.volumeRoot)
+	}
+	return env
+}
+
+// checkSharePath checks that the path of a share is under the class' volume
+// root, if it has one, so a share is never created or deleted outside of it
+func checkSharePath(sharePath string, params *cephFSParameters) error {
+	if params.volumeRoot == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path.Clean(sharePath), params.volumeRoot+"/") {
+		return fmt.Errorf("share path %q is not under the volume root %q", sharePath, params.volumeRoot)
+	}
+	return nil
+}
+
+// parseVolumeRoot parses the volumeRoot parameter, an absolute CephFS path
+// other than the root directory
+func parseVolumeRoot(value string) (string, error) {
+	if !strings.HasPrefix(value, "/") {
+		return "", fmt.Errorf("invalid value for parameter volumeRoot: %q. must be an absolute path", value)
+	}
+	for _, element := range strings.Split(value, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("invalid value for parameter volumeRoot: %q. must not contain ..", value)
+		}
+	}
+	root := path.Clean(value)
+	if root == "/" {
+		return "", fmt.Errorf("invalid value for parameter volumeRoot: %q. must not be the root directory", value)
+	}
+	return root, nil
+}
+
+// parseParameters parses the parameters and gets the admin secret they name.
+// Errors in the parameters themselves are TerminalErrors, since only an edit
+// of the class, or of the claim overriding them, can fix them; failures to get
+// the secret aren't, since they may pass.
+func (p *cephFSProvisioner) parseParameters(parameters map[string]string) (*cephFSParameters, error) {
+	params, adminSecretNamespace, adminSecretName, err := p.parseOptions(parameters)
+	if err != nil {
+		return nil, &controller.TerminalError{Reason: err.Error()}
+	}
+	if adminSecretName != "" {
+		if params.adminSecret, err = p.parsePVSecret(adminSecretNamespace, adminSecretName); err != nil {
+			return nil, fmt.Errorf("failed to get admin secret from [%q/%q]: %v", adminSecretNamespace, adminSecretName, err)
+		}
+	} else {
+		if params.adminSecret, err = p.keyring.getKey(params.adminID); err != nil {
+			return nil, fmt.Errorf("failed to get admin secret from keyring: %v", err)
+		}
+	}
+	return params, nil
+}
+
+// parseOptions parses the parameters, returning the namespace and name of the
+// admin secret they name, if any, for parseParameters to get
+func (p *cephFSProvisioner) parseOptions(parameters map[string]string) (*cephFSParameters, string, string, error) {
+	var (
+		err                                   error
+		adminSecretName, adminSecretNamespace string
+	)
+
+	params := &cephFSParameters{}
+	adminSecretNamespace = "default"
+	params.adminID = "admin"
+	params.cluster = "ceph"
+	params.accessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "cluster":
+			params.cluster = v
+		case "monitors":
+			arr := strings.Split(v, ",")
+			for _, m := range arr {
+				params.mon = append(params.mon, m)
+			}
+		case "adminid":
+			params.adminID = v
+		case "adminsecretname":
+			adminSecretName = v
+		case "adminsecretnamespace":
+			adminSecretNamespace = v
+		case "accessmodes":
+			if params.accessModes, err = parseAccessModes(v); err != nil {
+				return nil, "", "", err
+			}
+		case "zone":
+			params.zone = v
+		case "region":
+			params.region = v
+		case "volumeroot":
+			if params.volumeRoot, err = parseVolumeRoot(v); err != nil {
+				return nil, "", "", err
+			}
+		case "readonlycaps":
+			if params.readOnlyCaps, err = strconv.ParseBool(v); err != nil {
+				return nil, "", "", fmt.Errorf("invalid value for parameter readOnlyCaps: %q, must be true or false", v)
+			}
+		case "mountoptions":
+			if params.mountOptions, err = parseMountOptions(v); err != nil {
+				return nil, "", "", err
+			}
+		default:
+			return nil, "", "", fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if adminSecretName == "" && p.keyring == nil {
+		return nil, "", "", fmt.Errorf("missing Ceph admin secret name")
+	}
+	if len(params.mon) < 1 {
+		return nil, "", "", fmt.Errorf("missing Ceph monitors")
+	}
+	return params, adminSecretNamespace, adminSecretName, nil
+}
+
+// parametersForVolume returns the parameters of the cluster the volume's share
+// was provisioned in: those Provision recorded on the PV or, for PVs
+// provisioned before it did, those of the PV's class.
+func (p *cephFSProvisioner) parametersForVolume(volume *v1.PersistentVolume) (*cephFSParameters, error) {
+	parameters, err := p.provisioningParameters(volume)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseParameters(parameters)
+}
+
+// provisioningParameters returns the unparsed parameters the volume was
+// provisioned with, see parametersForVolume
+func (p *cephFSProvisioner) provisioningParameters(volume *v1.PersistentVolume) (map[string]string, error) {
+	parameters, ok, err := controller.GetProvisioningParameters(volume)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		class, err := p.getClassForVolume(volume)
+		if err != nil {
+			return nil, fmt.Errorf("PV has no provisioning parameters and failed to get its class: %v", err)
+		}
+		parameters = controller.ClassParameters(class)
+	}
+	return parameters, nil
+}
+
+// parseMountOptions parses the mountOptions parameter, a comma-separated list
+// of mount options, returning it without the spaces around the options
+func parseMountOptions(value string) (string, error) {
+	options := []string{}
+	for _, option := range strings.Split(value, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" || strings.ContainsAny(option, " \t\n") {
+			return "", fmt.Errorf("invalid value for parameter mountOptions: %q. must be a comma-separated list of mount options", value)
+		}
+		options = append(options, option)
+	}
+	return strings.Join(options, ","), nil
+}
+
+// parseAccessModes parses the accessModes parameter, a comma-separated list of
+// access modes
+func parseAccessModes(value string) ([]v1.PersistentVolumeAccessMode, error) {
+	modes := []v1.PersistentVolumeAccessMode{}
+	for _, mode := range strings.Split(value, ",") {
+		switch m := v1.PersistentVolumeAccessMode(strings.TrimSpace(mode)); m {
+		case v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany:
+			modes = append(modes, m)
+		default:
+			return nil, fmt.Errorf("invalid value for parameter accessModes: %q. valid values are comma-separated lists of %s, %s and %s", value, v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany)
+		}
+	}
+	return modes, nil
+}
+
+// isReadOnly returns whether the access modes only allow reading, i.e. are
+// only ReadOnlyMany
+func isReadOnly(modes []v1.PersistentVolumeAccessMode) bool {
+	if len(modes) == 0 {
+		return false
+	}
+	for _, mode := range modes {
+		if mode != v1.ReadOnlyMany {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAccessModes checks that the claim requests only allowed access modes
+func checkAccessModes(requested, allowed []v1.PersistentVolumeAccessMode) error {
+	for _, r := range requested {
+		ok := false
+		for _, a := range allowed {
+			if r == a {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("claim requests access mode %s, but the class only allows %v", r, allowed)
+		}
+	}
+	return nil
+}
+
+func (p *cephFSProvisioner) parsePVSecret(namespace, secretName string) (string, error) {
+	if p.client == nil {
+		return "", fmt.Errorf("Cannot get kube client")
+	}
+	secrets, err := p.client.Core().Secrets(namespace).Get(secretName)
+	if err != nil {
+		return "", err
+	}
+	for _, data := range secrets.Data {
+		return string(data), nil
+	}
+
+	// If not found, the last secret in the map wins as done before
+	return "", fmt.Errorf("no secret found")
+}
+
+func (p *cephFSProvisioner) getClassForVolume(pv *v1.PersistentVolume) (*storage.StorageClass, error) {
+	className, found := pv.Annotations["volume.beta.kubernetes.io/storage-class"]
+	if !found {
+		return nil, fmt.Errorf("Volume has no class annotation")
+	}
+
+	class, err := p.client.Storage().StorageClasses().Get(className)
+	if err != nil {
+		return nil, err
+	}
+	return class, nil
+}
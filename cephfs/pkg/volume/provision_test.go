@@ -0,0 +1,626 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/test"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1beta1"
+	"k8s.io/client-go/pkg/runtime"
+)
+
+var allAccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany}
+
+func TestParametersForVolume(t *testing.T) {
+	adminSecret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{Namespace: "kube-system", Name: "ceph-admin"},
+		Data:       map[string][]byte{"key": []byte("admin-key")},
+	}
+	class := &storage.StorageClass{
+		ObjectMeta: v1.ObjectMeta{Name: "class-1"},
+		Parameters: map[string]string{
+			"cluster":              "class-cluster",
+			"monitors":             "10.0.0.2:6789",
+			"adminSecretName":      "ceph-admin",
+			"adminSecretNamespace": "kube-system",
+		},
+	}
+
+	recorded := map[string]string{
+		"cluster":              "ceph-2",
+		"monitors":             "10.0.0.1:6789",
+		"adminSecretName":      "ceph-admin",
+		"adminSecretNamespace": "kube-system",
+	}
+
+	tests := []struct {
+		name        string
+		objs        []runtime.Object
+		recorded    map[string]string
+		annotations map[string]string
+		expected    *cephFSParameters
+		expectError bool
+	}{
+		{
+			name:     "recorded parameters, class deleted",
+			objs:     []runtime.Object{adminSecret},
+			recorded: recorded,
+			expected: &cephFSParameters{
+				cluster:     "ceph-2",
+				adminID:     "admin",
+				adminSecret: "admin-key",
+				mon:         []string{"10.0.0.1:6789"},
+				accessModes: allAccessModes,
+			},
+		},
+		{
+			name:        "recorded parameters, secret missing",
+			recorded:    recorded,
+			expectError: true,
+		},
+		{
+			name:        "bad recorded parameters",
+			objs:        []runtime.Object{adminSecret, class},
+			annotations: map[string]string{"volume.kubernetes.io/provisioning-parameters": "cluster=ceph-2"},
+			expectError: true,
+		},
+		{
+			name:        "from class",
+			objs:        []runtime.Object{adminSecret, class},
+			annotations: map[string]string{"volume.beta.kubernetes.io/storage-class": "class-1"},
+			expected: &cephFSParameters{
+				cluster:     "class-cluster",
+				adminID:     "admin",
+				adminSecret: "admin-key",
+				mon:         []string{"10.0.0.2:6789"},
+				accessModes: allAccessModes,
+			},
+		},
+		{
+			name:        "nothing recorded and class deleted",
+			objs:        []runtime.Object{adminSecret},
+			annotations: map[string]string{"volume.beta.kubernetes.io/storage-class": "class-1"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(test.objs...), nil, nil, nil, nil, nil).(*cephFSProvisioner)
+		volume := &v1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "pv-1", Annotations: test.annotations}}
+		if test.recorded != nil {
+			controller.SetProvisioningParameters(volume, test.recorded)
+		}
+
+		params, err := p.parametersForVolume(volume)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("test %s: expected error, got none", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.expected, params) {
+			t.Errorf("test %s: expected parameters %+v, got %+v", test.name, test.expected, params)
+		}
+	}
+}
+
+func TestAccessModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameter   string
+		requested   []v1.PersistentVolumeAccessMode
+		expectError bool
+	}{
+		{
+			name:      "all allowed by default",
+			requested: allAccessModes,
+		},
+		{
+			name:      "allowed",
+			parameter: "ReadWriteOnce, ReadOnlyMany",
+			requested: []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany},
+		},
+		{
+			name:        "forbidden",
+			parameter:   "ReadWriteOnce,ReadOnlyMany",
+			requested:   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadWriteMany},
+			expectError: true,
+		},
+		{
+			name:        "bad parameter",
+			parameter:   "ReadWriteSome",
+			requested:   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(), &Keyring{keys: map[string]string{"admin": "admin-key"}}, nil, nil, nil, nil).(*cephFSProvisioner)
+		parameters := map[string]string{"monitors": "10.0.0.1:6789"}
+		if test.parameter != "" {
+			parameters["accessModes"] = test.parameter
+		}
+
+		params, err := p.parseParameters(parameters)
+		if err == nil {
+			err = checkAccessModes(test.requested, params.accessModes)
+		}
+		if test.expectError && err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestParameterErrorTypes(t *testing.T) {
+	tests := []struct {
+		name           string
+		parameters     map[string]string
+		expectTerminal bool
+	}{
+		{
+			name:           "invalid option",
+			parameters:     map[string]string{"monitors": "10.0.0.1:6789", "foo": "bar"},
+			expectTerminal: true,
+		},
+		{
+			name:           "missing monitors",
+			parameters:     map[string]string{},
+			expectTerminal: true,
+		},
+		{
+			name:       "admin secret not found",
+			parameters: map[string]string{"monitors": "10.0.0.1:6789", "adminSecretName": "missing"},
+		},
+		{
+			name:       "admin key not in keyring",
+			parameters: map[string]string{"monitors": "10.0.0.1:6789", "adminId": "missing"},
+		},
+	}
+	for _, test := range tests {
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(), &Keyring{keys: map[string]string{"admin": "admin-key"}}, nil, nil, nil, nil).(*cephFSProvisioner)
+		_, err := p.parseParameters(test.parameters)
+		if err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+			continue
+		}
+		if _, terminal := err.(*controller.TerminalError); terminal != test.expectTerminal {
+			t.Errorf("test %s: expected terminal error %v but got %T: %v", test.name, test.expectTerminal, err, err)
+		}
+	}
+}
+
+func TestMountOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameter   string
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "no mount options",
+		},
+		{
+			name:      "mount options",
+			parameter: "noatime, rasize=16777216",
+			expected:  "noatime,rasize=16777216",
+		},
+		{
+			name:        "empty option",
+			parameter:   "noatime,,rasize=16777216",
+			expectError: true,
+		},
+		{
+			name:        "option with space",
+			parameter:   "noatime,rasize 16777216",
+			expectError: true,
+		},
+	}
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1", "user": "client.kubernetes-dynamic-user-uid-claim-1", "auth": "key-1"}`), nil, nil
+	}
+	for _, tc := range tests {
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(), &Keyring{keys: map[string]string{"admin": "admin-key"}}, nil, nil, nil, nil).(*cephFSProvisioner)
+		parameters := map[string]string{"monitors": "10.0.0.1:6789"}
+		if tc.parameter != "" {
+			parameters["mountOptions"] = tc.parameter
+		}
+
+		pv, err := p.Provision(test.NewVolumeOptions(test.NewClaim("claim-1", "default", "class-1", "1Gi"), parameters))
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("test %s: expected error but got none", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		options, ok := pv.Annotations[mountOptionsAnn]
+		if options != tc.expected || ok != (tc.expected != "") {
+			t.Errorf("test %s: expected mount options %q but got %q", tc.name, tc.expected, options)
+		}
+	}
+}
+
+func TestVolumeRoot(t *testing.T) {
+	tests := []struct {
+		name         string
+		volumeRoot   string
+		sharePath    string
+		expectedRoot string
+		expectError  bool
+	}{
+		{
+			name:      "no volume root",
+			sharePath: "/volumes/kubernetes/share-1",
+		},
+		{
+			name:         "share under volume root",
+			volumeRoot:   "/kubernetes/prod/",
+			sharePath:    "/kubernetes/prod/kubernetes/share-1",
+			expectedRoot: "/kubernetes/prod",
+		},
+		{
+			name:         "share outside volume root",
+			volumeRoot:   "/kubernetes/prod",
+			sharePath:    "/kubernetes/production/kubernetes/share-1",
+			expectedRoot: "/kubernetes/prod",
+			expectError:  true,
+		},
+		{
+			name:         "share escaping volume root",
+			volumeRoot:   "/kubernetes/prod",
+			sharePath:    "/kubernetes/prod/../dev/kubernetes/share-1",
+			expectedRoot: "/kubernetes/prod",
+			expectError:  true,
+		},
+		{
+			name:        "relative volume root",
+			volumeRoot:  "kubernetes/prod",
+			expectError: true,
+		},
+		{
+			name:        "root directory",
+			volumeRoot:  "/",
+			expectError: true,
+		},
+		{
+			name:        "parent directory",
+			volumeRoot:  "/kubernetes/../prod",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(), &Keyring{keys: map[string]string{"admin": "admin-key"}}, nil, nil, nil, nil).(*cephFSProvisioner)
+		parameters := map[string]string{"monitors": "10.0.0.1:6789"}
+		if test.volumeRoot != "" {
+			parameters["volumeRoot"] = test.volumeRoot
+		}
+
+		params, err := p.parseParameters(parameters)
+		if err == nil {
+			if params.volumeRoot != test.expectedRoot {
+				t.Errorf("test %s: expected volume root %q but got %q", test.name, test.expectedRoot, params.volumeRoot)
+			}
+			if test.expectedRoot != "" && params.env()[len(params.env())-1] != "CEPH_VOLUME_ROOT="+test.expectedRoot {
+				t.Errorf("test %s: expected volume root in environment %v", test.name, params.env())
+			}
+			err = checkSharePath(test.sharePath, params)
+		}
+		if test.expectError && err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestCreateShare(t *testing.T) {
+	output := []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/share-1", "user": "client.user-1", "auth": "key-1"}`)
+	tests := []struct {
+		name             string
+		stderrs          []string
+		expectedAttempts int
+		expectError      bool
+	}{
+		{
+			name:             "succeed",
+			expectedAttempts: 1,
+		},
+		{
+			name:             "succeed after share exists",
+			stderrs:          []string{"OSError: [Errno 17] File exists"},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "succeed after user exists",
+			stderrs:          []string{"rados.Error: error calling ceph_mon_command: key for client.user-1 exists but cap mds does not match"},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "fail on other error",
+			stderrs:          []string{"rados.PermissionDeniedError: error connecting to the cluster"},
+			expectedAttempts: 1,
+			expectError:      true,
+		},
+		{
+			name:             "give up when it keeps existing",
+			stderrs:          []string{"File exists", "File exists", "File exists"},
+			expectedAttempts: provisionAttempts,
+			expectError:      true,
+		},
+	}
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	for _, test := range tests {
+		attempts := 0
+		runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+			attempts++
+			if attempts <= len(test.stderrs) {
+				return nil, []byte(test.stderrs[attempts-1]), errors.New("exit status 1")
+			}
+			return output, nil, nil
+		}
+
+		res, err := createShare(newLogger(), "share-1", "user-1", false, &cephFSParameters{mon: []string{"10.0.0.1:6789"}})
+		if test.expectError && err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		} else if !test.expectError && res.Secret != "key-1" {
+			t.Errorf("test %s: expected key %q but got %q", test.name, "key-1", res.Secret)
+		}
+		if attempts != test.expectedAttempts {
+			t.Errorf("test %s: expected %d attempts but got %d", test.name, test.expectedAttempts, attempts)
+		}
+	}
+}
+
+func TestReadOnlyCaps(t *testing.T) {
+	tests := []struct {
+		name             string
+		parameter        string
+		accessModes      []v1.PersistentVolumeAccessMode
+		expectedReadOnly bool
+		expectError      bool
+	}{
+		{
+			name:        "read-only claim without the parameter",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany},
+		},
+		{
+			name:             "read-only claim",
+			parameter:        "true",
+			accessModes:      []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany},
+			expectedReadOnly: true,
+		},
+		{
+			name:        "claim that may also write",
+			parameter:   "true",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany, v1.ReadWriteOnce},
+		},
+		{
+			name:        "bad parameter",
+			parameter:   "yes please",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany},
+			expectError: true,
+		},
+	}
+	// the loop variable shadows package test
+	newClaim, newOptions := test.NewClaim, test.NewVolumeOptions
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	for _, test := range tests {
+		var args []string
+		runProvisionCmd = func(env []string, a ...string) ([]byte, []byte, error) {
+			args = a
+			return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/share-1", "user": "client.user-1", "auth": "key-1"}`), nil, nil
+		}
+		p := NewCephFSProvisioner(fake.NewSimpleClientset(), &Keyring{keys: map[string]string{"admin": "admin-key"}}, nil, nil, nil, nil).(*cephFSProvisioner)
+		parameters := map[string]string{"monitors": "10.0.0.1:6789"}
+		if test.parameter != "" {
+			parameters["readOnlyCaps"] = test.parameter
+		}
+		claim := newClaim("claim-1", "default", "class-1", "1Gi")
+		claim.Spec.AccessModes = test.accessModes
+
+		pv, err := p.Provision(newOptions(claim, parameters))
+		if test.expectError {
+			if err == nil {
+				t.Errorf("test %s: expected error but got none", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if readOnly := len(args) > 0 && args[len(args)-1] == "--readonly"; readOnly != test.expectedReadOnly {
+			t.Errorf("test %s: expected read-only caps %v but got args %v", test.name, test.expectedReadOnly, args)
+		}
+		if pv.Spec.PersistentVolumeSource.CephFS.ReadOnly != test.expectedReadOnly {
+			t.Errorf("test %s: expected read-only PV %v but got %v", test.name, test.expectedReadOnly, pv.Spec.PersistentVolumeSource.CephFS.ReadOnly)
+		}
+	}
+}
+
+func TestCreateOrUpdateSecret(t *testing.T) {
+	newSecret := func(name, share string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: name, Annotations: map[string]string{cephShareAnn: share}},
+			Data:       map[string][]byte{"key": []byte("old-key")},
+		}
+	}
+	user := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "ceph-user-3-secret"},
+		Data:       map[string][]byte{"key": []byte("old-key")},
+	}
+	client := fake.NewSimpleClientset(newSecret("ceph-user-1-secret", "share-1"), newSecret("ceph-user-4-secret", "share-5"), user)
+	p := NewCephFSProvisioner(client, nil, nil, nil, nil, nil).(*cephFSProvisioner)
+
+	tests := []struct {
+		name        string
+		secret      string
+		share       string
+		expectedKey string
+		expectError bool
+	}{
+		{
+			name:        "update secret of a previous attempt",
+			secret:      "ceph-user-1-secret",
+			share:       "share-1",
+			expectedKey: "new-key",
+		},
+		{
+			name:        "create secret",
+			secret:      "ceph-user-2-secret",
+			share:       "share-2",
+			expectedKey: "new-key",
+		},
+		{
+			name:        "don't overwrite a secret the provisioner didn't create",
+			secret:      "ceph-user-3-secret",
+			share:       "share-3",
+			expectedKey: "old-key",
+			expectError: true,
+		},
+		{
+			name:        "don't overwrite the secret of another share",
+			secret:      "ceph-user-4-secret",
+			share:       "share-4",
+			expectedKey: "old-key",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		err := p.createOrUpdateSecret("default", test.secret, test.share, "new-key")
+		if test.expectError && err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		}
+		secret, err := p.client.Core().Secrets("default").Get(test.secret)
+		if err != nil {
+			t.Errorf("test %s: unexpected error getting secret: %v", test.name, err)
+		} else if key := string(secret.Data["key"]); key != test.expectedKey {
+			t.Errorf("test %s: expected key %q but got %q", test.name, test.expectedKey, key)
+		}
+	}
+}
+
+func TestProvisionAndDelete(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var mutex sync.Mutex
+	var calls [][]string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, args)
+		return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1", "user": "client.kubernetes-dynamic-user-uid-claim-1", "auth": "key-1"}`), nil, nil
+	}
+	keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+	class := test.NewStorageClass("class-1", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789"})
+	claim := test.NewClaim("claim-1", "default", "class-1", "1Gi")
+
+	p := NewCephFSProvisioner(nil, keyring, nil, nil, nil, nil).(*cephFSProvisioner)
+	h := test.NewHarness("ceph.com/cephfs", p, class, claim)
+	p.client = h.Client
+	h.Start()
+	volume, err := h.WaitForVolume(test.VolumeName(claim))
+	h.Stop()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedSource := v1.PersistentVolumeSource{
+		CephFS: &v1.CephFSVolumeSource{
+			Monitors:  []string{"10.0.0.1:6789"},
+			Path:      "/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1",
+			SecretRef: &v1.LocalObjectReference{Name: "ceph-kubernetes-dynamic-user-uid-claim-1-secret"},
+			User:      "kubernetes-dynamic-user-uid-claim-1",
+		},
+	}
+	if !reflect.DeepEqual(expectedSource, volume.Spec.PersistentVolumeSource) {
+		t.Errorf("expected volume source %+v but got %+v", expectedSource, volume.Spec.PersistentVolumeSource)
+	}
+	secret, err := h.Client.Core().Secrets("default").Get("ceph-kubernetes-dynamic-user-uid-claim-1-secret")
+	if err != nil {
+		t.Errorf("unexpected error getting secret: %v", err)
+	} else if key := string(secret.Data["key"]); key != "key-1" {
+		t.Errorf("expected key %q but got %q", "key-1", key)
+	}
+
+	// release the provisioned PV to a new controller
+	released := test.NewReleasedVolume(volume.Name, "ceph.com/cephfs", volume.Spec.PersistentVolumeSource, volume.Annotations)
+	h = test.NewHarness("ceph.com/cephfs", p, released)
+	p.client = h.Client
+	h.Start()
+	defer h.Stop()
+	if err := h.WaitForVolumeDeletion(volume.Name); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	deleteCall := []string{"-r", "-n", "kubernetes-dynamic-pvc-uid-claim-1", "-u", "kubernetes-dynamic-user-uid-claim-1"}
+	if !reflect.DeepEqual(calls[len(calls)-1], deleteCall) {
+		t.Errorf("expected call %v but got %v", deleteCall, calls[len(calls)-1])
+	}
+}
+
+func TestProvisionDeletesShareWithoutSecret(t *testing.T) {
+	// a secret of the user's name that the provisioner didn't create
+	secret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "ceph-kubernetes-dynamic-user-uid-claim-1-secret"},
+	}
+	p := NewCephFSProvisioner(fake.NewSimpleClientset(secret), &Keyring{keys: map[string]string{"admin": "admin-key"}}, nil, nil, nil, nil).(*cephFSProvisioner)
+
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var calls [][]string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		calls = append(calls, args)
+		return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/share-1", "user": "client.user-1", "auth": "key-1"}`), nil, nil
+	}
+
+	options := test.NewVolumeOptions(test.NewClaim("claim-1", "default", "class-1", "1Gi"), map[string]string{"monitors": "10.0.0.1:6789"})
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error but got none")
+	}
+	expected := [][]string{
+		{"-n", "kubernetes-dynamic-pvc-uid-claim-1", "-u", "kubernetes-dynamic-user-uid-claim-1", "--output-version=1"},
+		{"-r", "-n", "kubernetes-dynamic-pvc-uid-claim-1", "-u", "kubernetes-dynamic-user-uid-claim-1"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v but got %v", expected, calls)
+	}
+}
+
+func TestShareAndUserNames(t *testing.T) {
+	share, user := shareAndUserNames("pvc-8d3e5aa0-2a3f-11e7-93ae-92361f002671")
+	if share != "kubernetes-dynamic-pvc-8d3e5aa0-2a3f-11e7-93ae-92361f002671" || user != "kubernetes-dynamic-user-8d3e5aa0-2a3f-11e7-93ae-92361f002671" {
+		t.Errorf("unexpected share %q and user %q", share, user)
+	}
+}
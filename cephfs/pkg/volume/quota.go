@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Key of the quota that applies to namespaces without their own
+const defaultQuotaKey = "*"
+
+// namespaceQuota caps the shares provisioned for the claims of a namespace.
+// Unset fields are unlimited.
+type namespaceQuota struct {
+	// Shares is the maximum number of shares
+	Shares *int64 `json:"shares,omitempty"`
+	// Storage is the maximum total requested size of the shares
+	Storage *resource.Quantity `json:"storage,omitempty"`
+}
+
+// Quotas enforces per-namespace caps on the number and total size of the
+// shares the provisioner creates, since CephFS itself has no tenant quota.
+// The caps are read from a ConfigMap, re-read on every check, that maps
+// namespace names, or "*" for any other namespace, to JSON like
+// {"shares": 10, "storage": "100Gi"}. Namespaces without a quota are
+// unlimited.
+type Quotas struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	// Shares being provisioned and not yet saved as PVs, by PV name. Serializes
+	// checks so that concurrent provisions can't all squeeze under a cap.
+	reservations map[string]reservation
+	mutex        sync.Mutex
+}
+
+type reservation struct {
+	namespace string
+	bytes     int64
+}
+
+// NewQuotas creates Quotas that read caps from the ConfigMap named
+// "namespace/name" by configMap.
+func NewQuotas(client kubernetes.Interface, configMap string) (*Quotas, error) {
+	parts := strings.Split(configMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid quota configmap %q, expected namespace/name", configMap)
+	}
+	return &Quotas{
+		client:       client,
+		namespace:    parts[0],
+		name:         parts[1],
+		reservations: map[string]reservation{},
+	}, nil
+}
+
+// reserve checks that a share of the given size for a claim in namespace
+// fits in the namespace's quota and, if it does, counts it against the quota
+// until its PV exists or it is released. nil Quotas allow anything.
+func (q *Quotas) reserve(pvName, namespace string, bytes int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quota, err := q.getQuota(namespace)
+	if err != nil {
+		return err
+	}
+	if quota == nil {
+		return nil
+	}
+
+	shares, used, err := q.usage(namespace)
+	if err != nil {
+		return err
+	}
+	if quota.Shares != nil && shares+1 > *quota.Shares {
+		return fmt.Errorf("quota exceeded for namespace %s: %d of %d shares already provisioned", namespace, shares, *quota.Shares)
+	}
+	if quota.Storage != nil && used+bytes > quota.Storage.Value() {
+		return fmt.Errorf("quota exceeded for namespace %s: %v of %v storage already provisioned, %v requested", namespace, resource.NewQuantity(used, resource.BinarySI), quota.Storage, resource.NewQuantity(bytes, resource.BinarySI))
+	}
+
+	q.reservations[pvName] = reservation{namespace, bytes}
+	return nil
+}
+
+// release stops counting the named PV's reservation, if any, against its
+// namespace's quota
+func (q *Quotas) release(pvName string) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.reservations, pvName)
+}
+
+// getQuota returns the quota of namespace, nil if it has none
+func (q *Quotas) getQuota(namespace string) (*namespaceQuota, error) {
+	configMap, err := q.client.Core().ConfigMaps(q.namespace).Get(q.name)
+	if apierrs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting quota configmap %s/%s: %v", q.namespace, q.name, err)
+	}
+
+	key := namespace
+	value, ok := configMap.Data[key]
+	if !ok {
+		key = defaultQuotaKey
+		if value, ok = configMap.Data[key]; !ok {
+			return nil, nil
+		}
+	}
+	quota := &namespaceQuota{}
+	if err := json.Unmarshal([]byte(value), quota); err != nil {
+		return nil, fmt.Errorf("error parsing quota %q of configmap %s/%s: %v", key, q.namespace, q.name, err)
+	}
+	return quota, nil
+}
+
+// usage returns the number and total size of the shares provisioned for
+// namespace: those with PVs plus those reserved. Reservations of shares that
+// have PVs are dropped.
+func (q *Quotas) usage(namespace string) (int64, int64, error) {
+	pvs, err := q.client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error listing PVs to check quota: %v", err)
+	}
+
+	var shares, bytes int64
+	for _, pv := range pvs.Items {
+		if _, ok := pv.Annotations[cephShareAnn]; !ok {
+			continue
+		}
+		delete(q.reservations, pv.Name)
+		if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != namespace {
+			continue
+		}
+		capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		shares++
+		bytes += capacity.Value()
+	}
+	for _, r := range q.reservations {
+		if r.namespace == namespace {
+			shares++
+			bytes += r.bytes
+		}
+	}
+	return shares, bytes, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+)
+
+func TestQuotas(t *testing.T) {
+	quotaConfigMap := &v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Namespace: "kube-system", Name: "cephfs-quotas"},
+		Data: map[string]string{
+			"*":     `{"shares": 2}`,
+			"big":   `{"storage": "10Gi"}`,
+			"wrong": `shares: 2`,
+		},
+	}
+
+	tests := []struct {
+		name         string
+		objs         []runtime.Object
+		reservations []string
+		namespace    string
+		capacity     string
+		expectError  bool
+	}{
+		{
+			name:      "no configmap",
+			namespace: "default",
+			capacity:  "1Gi",
+		},
+		{
+			name:      "under default share cap",
+			objs:      []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "default", "1Gi")},
+			namespace: "default",
+			capacity:  "1Gi",
+		},
+		{
+			name:        "at default share cap",
+			objs:        []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "default", "1Gi"), newShareVolume("pv-2", "default", "1Gi")},
+			namespace:   "default",
+			capacity:    "1Gi",
+			expectError: true,
+		},
+		{
+			name:         "at default share cap with reservation",
+			objs:         []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "default", "1Gi")},
+			reservations: []string{"pv-2"},
+			namespace:    "default",
+			capacity:     "1Gi",
+			expectError:  true,
+		},
+		{
+			name:         "reservation of saved pv counted once",
+			objs:         []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "default", "1Gi")},
+			reservations: []string{"pv-1"},
+			namespace:    "default",
+			capacity:     "1Gi",
+		},
+		{
+			name:      "other namespaces' shares not counted",
+			objs:      []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "other", "1Gi"), newShareVolume("pv-2", "other", "1Gi")},
+			namespace: "default",
+			capacity:  "1Gi",
+		},
+		{
+			name:      "under storage cap",
+			objs:      []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "big", "8Gi")},
+			namespace: "big",
+			capacity:  "2Gi",
+		},
+		{
+			name:        "over storage cap",
+			objs:        []runtime.Object{quotaConfigMap, newShareVolume("pv-1", "big", "8Gi")},
+			namespace:   "big",
+			capacity:    "3Gi",
+			expectError: true,
+		},
+		{
+			name:        "bad quota",
+			objs:        []runtime.Object{quotaConfigMap},
+			namespace:   "wrong",
+			capacity:    "1Gi",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objs...)
+		q, err := NewQuotas(client, "kube-system/cephfs-quotas")
+		if err != nil {
+			t.Fatalf("Error creating quotas: %v", err)
+		}
+		for _, pvName := range test.reservations {
+			q.reservations[pvName] = reservation{test.namespace, 1024 * 1024 * 1024}
+		}
+
+		capacity := resource.MustParse(test.capacity)
+		err = q.reserve("pv-new", test.namespace, capacity.Value())
+		if test.expectError && err == nil {
+			t.Errorf("test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("test %s: unexpected error: %v", test.name, err)
+		}
+
+		q.release("pv-new")
+		if _, ok := q.reservations["pv-new"]; ok {
+			t.Errorf("test %s: expected reservation to be released", test.name)
+		}
+	}
+
+	if _, err := NewQuotas(fake.NewSimpleClientset(), "cephfs-quotas"); err == nil {
+		t.Errorf("expected error for configmap name without namespace")
+	}
+}
+
+func newShareVolume(name, claimNamespace, capacity string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{cephShareAnn: "kubernetes-dynamic-pvc-" + name},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(capacity),
+			},
+			ClaimRef: &v1.ObjectReference{Namespace: claimNamespace, Name: "claim-" + name},
+		},
+	}
+}
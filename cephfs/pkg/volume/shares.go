@@ -0,0 +1,301 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+const (
+	// shareResourceName is the name of the third party resource of share
+	// records. The API server turns its dashed first part into the kind,
+	// CephFSShare, and serves the records as cephfsshares.
+	shareResourceName = "ceph-f-s-share." + shareGroup
+	shareGroup        = "cephfs.kubernetes.io"
+	shareVersion      = "v1"
+	shareResource     = "cephfsshares"
+	shareKind         = "CephFSShare"
+
+	// provisionedByAnn is the annotation the controller sets on PVs to the
+	// name of the provisioner that provisioned them
+	provisionedByAnn = "pv.kubernetes.io/provisioned-by"
+
+	// shareRecordGracePeriod is how long a record may be without a PV before
+	// it is deleted. Shares are recorded before their PVs are saved.
+	shareRecordGracePeriod = 10 * time.Minute
+)
+
+// CephFSShare is the record of a provisioned share, named after its PV, that
+// the provisioner keeps next to the PV so that admin tooling can list and
+// reconcile shares with the Ceph cluster without parsing PV annotations
+type CephFSShare struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+
+	Spec CephFSShareSpec `json:"spec"`
+}
+
+// CephFSShareSpec describes a provisioned share
+type CephFSShareSpec struct {
+	// Share is the name of the share in the Ceph volume client
+	Share string `json:"share"`
+	// Path is the share's path in CephFS
+	Path string `json:"path"`
+	// User is the cephx user of the share, without "client."
+	User string `json:"user"`
+	// Monitors are the Ceph monitors of the share's cluster
+	Monitors []string `json:"monitors"`
+	// PersistentVolume is the name of the share's PV
+	PersistentVolume string `json:"persistentVolume"`
+	// ClaimNamespace and ClaimName are of the claim the share was provisioned
+	// for
+	ClaimNamespace string `json:"claimNamespace"`
+	ClaimName      string `json:"claimName"`
+	// Created is when the share was provisioned
+	Created unversioned.Time `json:"created"`
+}
+
+// cephFSShareList is a list of share records as the API server returns it
+type cephFSShareList struct {
+	Items []CephFSShare `json:"items"`
+}
+
+// Shares keeps a CephFSShare record in a namespace for every share the
+// provisioner provisions, and deletes it when the share is deleted. Failing to
+// keep a record doesn't fail provisioning or deletion: ShareSyncer catches
+// up on missed records.
+type Shares struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+// NewShares creates Shares that keep records in namespace, registering the
+// CephFSShare third party resource if it isn't yet
+func NewShares(client kubernetes.Interface, namespace string) (*Shares, error) {
+	if namespace == "" {
+		return nil, errors.New("share records namespace must not be empty")
+	}
+	resource := &v1beta1.ThirdPartyResource{
+		ObjectMeta:  v1.ObjectMeta{Name: shareResourceName},
+		Description: "Shares provisioned by the cephfs provisioner",
+		Versions:    []v1beta1.APIVersion{{Name: shareVersion}},
+	}
+	if _, err := client.Extensions().ThirdPartyResources().Create(resource); err != nil && !apierrs.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("error registering third party resource %s: %v", shareResourceName, err)
+	}
+	return &Shares{client: client, namespace: namespace}, nil
+}
+
+// shareRequest sends a request for the share records of namespace, or the
+// named record if name isn't empty, with the body, if any, and returns the
+// response body. It is a variable so tests can replace it.
+var shareRequest = func(client kubernetes.Interface, verb, namespace, name string, body []byte) ([]byte, error) {
+	segments := []string{"/apis", shareGroup, shareVersion, "namespaces", namespace, shareResource}
+	if name != "" {
+		segments = append(segments, name)
+	}
+	request := client.Core().RESTClient().Verb(verb).AbsPath(segments...)
+	if body != nil {
+		request = request.SetHeader("Content-Type", "application/json").Body(body)
+	}
+	return request.DoRaw()
+}
+
+// record creates or updates the record of the share of the PV named by spec.
+// nil Shares record nothing.
+func (s *Shares) record(spec CephFSShareSpec) error {
+	if s == nil {
+		return nil
+	}
+	share := &CephFSShare{
+		TypeMeta:   unversioned.TypeMeta{Kind: shareKind, APIVersion: shareGroup + "/" + shareVersion},
+		ObjectMeta: v1.ObjectMeta{Name: spec.PersistentVolume, Namespace: s.namespace},
+		Spec:       spec,
+	}
+	body, err := json.Marshal(share)
+	if err != nil {
+		return err
+	}
+	_, err = shareRequest(s.client, "POST", s.namespace, "", body)
+	if !apierrs.IsAlreadyExists(err) {
+		return err
+	}
+
+	// a previous attempt recorded the share, update it
+	existing, err := s.get(spec.PersistentVolume)
+	if err != nil {
+		return err
+	}
+	existing.Spec = spec
+	if body, err = json.Marshal(existing); err != nil {
+		return err
+	}
+	_, err = shareRequest(s.client, "PUT", s.namespace, spec.PersistentVolume, body)
+	return err
+}
+
+// forget deletes the record of the named PV's share, if any. nil Shares
+// forget nothing.
+func (s *Shares) forget(pvName string) error {
+	if s == nil {
+		return nil
+	}
+	_, err := shareRequest(s.client, "DELETE", s.namespace, pvName, nil)
+	if apierrs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// get returns the record of the named PV's share
+func (s *Shares) get(pvName string) (*CephFSShare, error) {
+	body, err := shareRequest(s.client, "GET", s.namespace, pvName, nil)
+	if err != nil {
+		return nil, err
+	}
+	share := &CephFSShare{}
+	if err := json.Unmarshal(body, share); err != nil {
+		return nil, fmt.Errorf("error parsing share record %s/%s: %v", s.namespace, pvName, err)
+	}
+	return share, nil
+}
+
+// list returns all share records
+func (s *Shares) list() ([]CephFSShare, error) {
+	body, err := shareRequest(s.client, "GET", s.namespace, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	list := &cephFSShareList{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return nil, fmt.Errorf("error parsing share records of namespace %s: %v", s.namespace, err)
+	}
+	return list.Items, nil
+}
+
+// shareSpecForVolume returns the record spec of the share of a PV the
+// provisioner provisioned, created at the given time
+func shareSpecForVolume(volume *v1.PersistentVolume, created time.Time) CephFSShareSpec {
+	spec := CephFSShareSpec{
+		Share:            volume.Annotations[cephShareAnn],
+		PersistentVolume: volume.Name,
+		Created:          unversioned.NewTime(created),
+	}
+	if source := volume.Spec.CephFS; source != nil {
+		spec.Path = source.Path
+		spec.User = source.User
+		spec.Monitors = source.Monitors
+	}
+	if ref := volume.Spec.ClaimRef; ref != nil {
+		spec.ClaimNamespace = ref.Namespace
+		spec.ClaimName = ref.Name
+	}
+	return spec
+}
+
+// ShareSyncer periodically reconciles the share records with the PVs of the
+// provisioner: it records the shares of PVs without a record, e.g. provisioned
+// before records were kept or while the API server refused them, and deletes
+// the records of PVs that no longer exist.
+type ShareSyncer struct {
+	provisioner     *cephFSProvisioner
+	provisionerName string
+}
+
+// NewShareSyncer creates a ShareSyncer for the PVs of provisionerName of the
+// given provisioner, which must have been created by NewCephFSProvisioner
+// with Shares.
+func NewShareSyncer(provisioner controller.Provisioner, provisionerName string) (*ShareSyncer, error) {
+	p, ok := provisioner.(*cephFSProvisioner)
+	if !ok {
+		return nil, fmt.Errorf("provisioner %T is not a CephFS provisioner", provisioner)
+	}
+	if p.shares == nil {
+		return nil, errors.New("provisioner keeps no share records")
+	}
+	return &ShareSyncer{provisioner: p, provisionerName: provisionerName}, nil
+}
+
+// Run syncs every period until stopCh is closed
+func (s *ShareSyncer) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := s.sync(); err != nil {
+			glog.Errorf("Error syncing share records: %v", err)
+		}
+	}, period, stopCh)
+}
+
+// sync records the shares of the provisioner's PVs that have no record, or
+// whose record differs, and deletes the records that have been without a PV
+// for longer than shareRecordGracePeriod
+func (s *ShareSyncer) sync() error {
+	shares := s.provisioner.shares
+	volumes, err := s.provisioner.client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing PVs: %v", err)
+	}
+	records, err := shares.list()
+	if err != nil {
+		return fmt.Errorf("error listing share records: %v", err)
+	}
+	recorded := map[string]CephFSShareSpec{}
+	for _, record := range records {
+		recorded[record.Name] = record.Spec
+	}
+
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		if volume.Annotations[provisionedByAnn] != s.provisionerName || volume.Annotations[cephShareAnn] == "" {
+			continue
+		}
+		spec, ok := recorded[volume.Name]
+		delete(recorded, volume.Name)
+		want := shareSpecForVolume(volume, volume.CreationTimestamp.Time)
+		if ok {
+			// keep the time the share was recorded as created
+			want.Created = spec.Created
+			if reflect.DeepEqual(spec, want) {
+				continue
+			}
+		}
+		if err := shares.record(want); err != nil {
+			glog.Errorf("Error recording share of PV %s: %v", volume.Name, err)
+		}
+	}
+	for pvName, spec := range recorded {
+		if time.Since(spec.Created.Time) < shareRecordGracePeriod {
+			continue
+		}
+		if err := shares.forget(pvName); err != nil {
+			glog.Errorf("Error deleting share record of deleted PV %s: %v", pvName, err)
+		}
+	}
+	return nil
+}
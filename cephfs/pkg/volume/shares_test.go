@@ -0,0 +1,220 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller/test"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakeShares is an API server that keeps share records in memory
+type fakeShares struct {
+	records map[string]CephFSShare
+}
+
+func (f *fakeShares) request(client kubernetes.Interface, verb, namespace, name string, body []byte) ([]byte, error) {
+	resource := unversioned.GroupResource{Group: shareGroup, Resource: shareResource}
+	share := CephFSShare{}
+	if body != nil {
+		if err := json.Unmarshal(body, &share); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case verb == "POST":
+		if _, ok := f.records[share.Name]; ok {
+			return nil, apierrs.NewAlreadyExists(resource, share.Name)
+		}
+		f.records[share.Name] = share
+	case verb == "PUT":
+		if _, ok := f.records[name]; !ok {
+			return nil, apierrs.NewNotFound(resource, name)
+		}
+		f.records[name] = share
+	case verb == "GET" && name == "":
+		list := cephFSShareList{}
+		for _, share := range f.records {
+			list.Items = append(list.Items, share)
+		}
+		return json.Marshal(list)
+	case verb == "GET":
+		share, ok := f.records[name]
+		if !ok {
+			return nil, apierrs.NewNotFound(resource, name)
+		}
+		return json.Marshal(share)
+	case verb == "DELETE":
+		if _, ok := f.records[name]; !ok {
+			return nil, apierrs.NewNotFound(resource, name)
+		}
+		delete(f.records, name)
+	default:
+		return nil, fmt.Errorf("unexpected request %s %s", verb, name)
+	}
+	return nil, nil
+}
+
+func TestNewShares(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for i := 0; i < 2; i++ {
+		if _, err := NewShares(client, "kube-system"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := client.Extensions().ThirdPartyResources().Get(shareResourceName); err != nil {
+		t.Errorf("expected third party resource %s but got error: %v", shareResourceName, err)
+	}
+	if _, err := NewShares(client, ""); err == nil {
+		t.Errorf("expected error creating shares without a namespace but got none")
+	}
+}
+
+func TestShareRecords(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1", "user": "client.kubernetes-dynamic-user-uid-claim-1", "auth": "key-1"}`), nil, nil
+	}
+	defer func(request func(kubernetes.Interface, string, string, string, []byte) ([]byte, error)) {
+		shareRequest = request
+	}(shareRequest)
+	f := &fakeShares{records: map[string]CephFSShare{}}
+	shareRequest = f.request
+
+	client := fake.NewSimpleClientset()
+	shares, err := NewShares(client, "kube-system")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+	p := NewCephFSProvisioner(client, keyring, nil, nil, nil, shares)
+
+	options := test.NewVolumeOptions(test.NewClaim("claim-1", "default", "class-1", "1Gi"), map[string]string{"monitors": "10.0.0.1:6789"})
+	volume, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	record, ok := f.records[volume.Name]
+	if !ok {
+		t.Fatalf("expected record of share %s but got none", volume.Name)
+	}
+	expected := CephFSShareSpec{
+		Share:            "kubernetes-dynamic-pvc-uid-claim-1",
+		Path:             "/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1",
+		User:             "kubernetes-dynamic-user-uid-claim-1",
+		Monitors:         []string{"10.0.0.1:6789"},
+		PersistentVolume: volume.Name,
+		ClaimNamespace:   "default",
+		ClaimName:        "claim-1",
+		Created:          record.Spec.Created,
+	}
+	if !reflect.DeepEqual(record.Spec, expected) {
+		t.Errorf("expected record %+v but got %+v", expected, record.Spec)
+	}
+	if record.Namespace != "kube-system" || record.Kind != shareKind {
+		t.Errorf("expected %s record in namespace kube-system but got %s record in namespace %s", shareKind, record.Kind, record.Namespace)
+	}
+
+	// provisioning again, e.g. after the PV failed to be saved, updates the
+	// record
+	if _, err := p.Provision(options); err != nil {
+		t.Fatalf("unexpected error provisioning again: %v", err)
+	}
+	if len(f.records) != 1 {
+		t.Errorf("expected 1 record but got %d", len(f.records))
+	}
+
+	if err := p.Delete(volume); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if _, ok := f.records[volume.Name]; ok {
+		t.Errorf("expected record of share %s to be deleted but it exists", volume.Name)
+	}
+}
+
+func TestSyncShares(t *testing.T) {
+	defer func(request func(kubernetes.Interface, string, string, string, []byte) ([]byte, error)) {
+		shareRequest = request
+	}(shareRequest)
+	now := time.Now()
+	newVolume := func(name, provisioner string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{provisionedByAnn: provisioner, cephShareAnn: "share-" + name},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CephFS: &v1.CephFSVolumeSource{Monitors: []string{"10.0.0.1:6789"}, Path: "/volumes/kubernetes/share-" + name, User: "user-" + name},
+				},
+				ClaimRef: &v1.ObjectReference{Namespace: "default", Name: "claim-" + name},
+			},
+		}
+	}
+	newRecord := func(volume *v1.PersistentVolume, created time.Time) CephFSShare {
+		return CephFSShare{ObjectMeta: v1.ObjectMeta{Name: volume.Name}, Spec: shareSpecForVolume(volume, created)}
+	}
+	recorded := newVolume("pv-recorded", "kubernetes.io/cephfs")
+	changed := newVolume("pv-changed", "kubernetes.io/cephfs")
+	staleRecord := newRecord(changed, now.Add(-time.Hour))
+	staleRecord.Spec.User = "old-user"
+
+	f := &fakeShares{
+		records: map[string]CephFSShare{
+			"pv-recorded": newRecord(recorded, now.Add(-time.Hour)),
+			"pv-changed":  staleRecord,
+			"pv-deleted":  newRecord(newVolume("pv-deleted", "kubernetes.io/cephfs"), now.Add(-time.Hour)),
+			"pv-new":      newRecord(newVolume("pv-new", "kubernetes.io/cephfs"), now),
+		},
+	}
+	shareRequest = f.request
+
+	client := fake.NewSimpleClientset(recorded, changed, newVolume("pv-unrecorded", "kubernetes.io/cephfs"), newVolume("pv-other", "other"))
+	p := NewCephFSProvisioner(client, nil, nil, nil, nil, &Shares{client: client, namespace: "kube-system"})
+	syncer, err := NewShareSyncer(p, "kubernetes.io/cephfs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := syncer.sync(); err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+
+	names := map[string]bool{}
+	for name := range f.records {
+		names[name] = true
+	}
+	expected := map[string]bool{"pv-recorded": true, "pv-changed": true, "pv-unrecorded": true, "pv-new": true}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected records %v but got %v", expected, names)
+	}
+	if user := f.records["pv-changed"].Spec.User; user != "user-pv-changed" {
+		t.Errorf("expected changed record to be updated to user user-pv-changed but got %s", user)
+	}
+
+	if _, err := NewShareSyncer(NewCephFSProvisioner(client, nil, nil, nil, nil, nil), "kubernetes.io/cephfs"); err == nil {
+		t.Errorf("expected error creating syncer for provisioner without share records but got none")
+	}
+}
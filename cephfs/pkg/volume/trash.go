@@ -0,0 +1,375 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+const (
+	// trashTimestampFormat is the format of the time a share was soft-deleted
+	// at that prefixes its name in the trash
+	trashTimestampFormat = "20060102T150405Z"
+
+	// Number of times an update of the trash records is retried when it
+	// conflicts with another writer's
+	trashRecordsUpdateRetryCount = 5
+)
+
+// Trash makes Delete soft-delete shares: remove their user and move them to
+// the .trash directory under the volume root as <timestamp>-<share>, keeping
+// their data for the retention period so that a share deleted by mistake can
+// be recovered by an administrator. A TrashPurger removes them once the
+// retention period is over.
+//
+// Before soft-deleting a share, the Trash records the provisioning parameters
+// of its cluster in a ConfigMap, so that the TrashPurger finds the cluster's
+// trash even once no class or PV refers to the cluster any more.
+type Trash struct {
+	retention time.Duration
+	// now is the current time. It is a variable so tests can replace it.
+	now func() time.Time
+
+	client kubernetes.Interface
+	// namespace & name of the ConfigMap of trash records
+	namespace string
+	name      string
+	// Serializes this process' updates of the records; updates from
+	// elsewhere are handled by retrying on conflict
+	mutex sync.Mutex
+}
+
+// trashRecord is the record of a cluster shares were soft-deleted in, the
+// JSON value of its key in the trash records ConfigMap
+type trashRecord struct {
+	// Parameters are the provisioning parameters of the cluster's shares
+	Parameters map[string]string `json:"parameters"`
+	// Updated is when a share was last soft-deleted in the cluster
+	Updated time.Time `json:"updated"`
+}
+
+// NewTrash creates a Trash that keeps soft-deleted shares for retention and
+// records the clusters it soft-deleted shares in in configMap, given as
+// namespace/name, which is created if it doesn't exist. Provisioners with
+// different retention periods must not share a ConfigMap.
+func NewTrash(client kubernetes.Interface, configMap string, retention time.Duration) (*Trash, error) {
+	if retention <= 0 {
+		return nil, errors.New("trash retention must be positive")
+	}
+	parts := strings.Split(configMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid trash records configmap %q, expected namespace/name", configMap)
+	}
+	return &Trash{
+		retention: retention,
+		now:       time.Now,
+		client:    client,
+		namespace: parts[0],
+		name:      parts[1],
+	}, nil
+}
+
+// clusterKey returns the key of the cluster of params in the trash records:
+// a hash of the cluster name, monitors, admin ID and volume root, which
+// unlike the admin key can be told apart from other clusters' without
+// fetching secrets
+func clusterKey(params *cephFSParameters) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join([]string{params.cluster, strings.Join(params.mon, ","), params.adminID, params.volumeRoot}, "\n")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// recordCluster records the cluster of params, whose provisioning parameters
+// are parameters, as having had a share soft-deleted now
+func (t *Trash) recordCluster(parameters map[string]string, params *cephFSParameters) error {
+	data, err := json.Marshal(trashRecord{Parameters: parameters, Updated: t.now().UTC()})
+	if err != nil {
+		return err
+	}
+	key := clusterKey(params)
+	return t.updateRecords(func(records map[string]string) {
+		records[key] = string(data)
+	})
+}
+
+// listRecords returns the trash records by cluster key
+func (t *Trash) listRecords() (map[string]trashRecord, error) {
+	configMap, err := t.client.Core().ConfigMaps(t.namespace).Get(t.name)
+	if apierrs.IsNotFound(err) {
+		return map[string]trashRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting trash records %s/%s: %v", t.namespace, t.name, err)
+	}
+	records := map[string]trashRecord{}
+	for key, value := range configMap.Data {
+		var record trashRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			glog.Warningf("Skipping invalid trash record %s in %s/%s: %v", key, t.namespace, t.name, err)
+			continue
+		}
+		records[key] = record
+	}
+	return records, nil
+}
+
+// forgetCluster removes the record of the cluster with key unless a share
+// was soft-deleted in it less than the retention period ago, i.e. unless its
+// trash may hold shares that aren't due for purging yet
+func (t *Trash) forgetCluster(key string) error {
+	cutoff := t.now().Add(-t.retention)
+	return t.updateRecords(func(records map[string]string) {
+		var record trashRecord
+		if err := json.Unmarshal([]byte(records[key]), &record); err == nil && record.Updated.After(cutoff) {
+			return
+		}
+		delete(records, key)
+	})
+}
+
+// updateRecords applies mutate to the trash records and saves them, creating
+// the ConfigMap if necessary
+func (t *Trash) updateRecords(mutate func(map[string]string)) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var err error
+	for i := 0; i < trashRecordsUpdateRetryCount; i++ {
+		var configMap *v1.ConfigMap
+		configMap, err = t.client.Core().ConfigMaps(t.namespace).Get(t.name)
+		if apierrs.IsNotFound(err) {
+			configMap = &v1.ConfigMap{
+				ObjectMeta: v1.ObjectMeta{
+					Namespace: t.namespace,
+					Name:      t.name,
+				},
+				Data: map[string]string{},
+			}
+			mutate(configMap.Data)
+			_, err = t.client.Core().ConfigMaps(t.namespace).Create(configMap)
+		} else if err == nil {
+			if configMap.Data == nil {
+				configMap.Data = map[string]string{}
+			}
+			mutate(configMap.Data)
+			_, err = t.client.Core().ConfigMaps(t.namespace).Update(configMap)
+		}
+		if err == nil || !(apierrs.IsConflict(err) || apierrs.IsAlreadyExists(err)) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error updating trash records %s/%s: %v", t.namespace, t.name, err)
+	}
+	return nil
+}
+
+// softDeleteShare records the cluster of params, then removes the user and
+// moves the share to the trash with provisionCmd
+func (t *Trash) softDeleteShare(log *logger, share, user string, parameters map[string]string, params *cephFSParameters) error {
+	timestamp := t.now().UTC().Format(trashTimestampFormat)
+	log = log.with("trashEntry", timestamp+"-"+share)
+	if err := t.recordCluster(parameters, params); err != nil {
+		log.error("failed to record the share's cluster, not soft-deleting it", "err", err)
+		return err
+	}
+	stdout, stderr, cmdErr := runProvisionCmd(params.env(), "-r", "--trash="+timestamp, "-n", share, "-u", user)
+	if cmdErr != nil {
+		log.error("failed to soft-delete share", "err", cmdErr, "stdout", string(stdout), "stderr", string(stderr))
+		err := fmt.Errorf("failed to soft-delete share %q: %v, stderr: %q", share, cmdErr, excerpt(stderr))
+		audit(log, "soft-delete", params, err)
+		return err
+	}
+	audit(log, "soft-delete", params, nil)
+	return nil
+}
+
+// TrashPurger periodically purges the shares in the trash of the Ceph
+// clusters of the provisioner's classes, of its PVs and of the trash records
+// that were soft-deleted longer than the retention period ago.
+type TrashPurger struct {
+	provisioner     *cephFSProvisioner
+	provisionerName string
+}
+
+// NewTrashPurger creates a TrashPurger for the classes of provisionerName of
+// the given provisioner, which must have been created by NewCephFSProvisioner
+// with a Trash.
+func NewTrashPurger(provisioner controller.Provisioner, provisionerName string) (*TrashPurger, error) {
+	p, ok := provisioner.(*cephFSProvisioner)
+	if !ok {
+		return nil, fmt.Errorf("provisioner %T is not a CephFS provisioner", provisioner)
+	}
+	if p.trash == nil {
+		return nil, errors.New("provisioner has no trash")
+	}
+	return &TrashPurger{provisioner: p, provisionerName: provisionerName}, nil
+}
+
+// Run purges every period until stopCh is closed
+func (t *TrashPurger) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(t.purge, period, stopCh)
+}
+
+// purge purges the expired shares in the trash of each cluster of the
+// provisioner's classes, of its PVs and of the trash records. Deleting a
+// share uses the parameters recorded on its PV, so its cluster may no longer
+// be that of any class. Clusters are purged once, and records of clusters
+// whose trash is empty are removed once no share may be soft-deleted in them
+// for less than the retention period.
+func (t *TrashPurger) purge() {
+	type cluster struct {
+		// what the cluster was found in, for logging
+		source string
+		params *cephFSParameters
+		// whether the cluster has a trash record
+		recorded bool
+	}
+	clusters := []*cluster{}
+	byKey := map[string]*cluster{}
+	// the clusters of parameters already parsed, so that the admin secret
+	// isn't fetched again for each PV of a class
+	byParameters := map[string]*cluster{}
+	add := func(source string, parameters map[string]string, recorded bool) {
+		data, _ := json.Marshal(parameters)
+		if c, ok := byParameters[string(data)]; ok {
+			c.recorded = c.recorded || recorded
+			return
+		}
+		params, err := t.provisioner.parseParameters(parameters)
+		if err != nil {
+			glog.Errorf("Error parsing parameters of %s to purge its trash: %v", source, err)
+			return
+		}
+		c, ok := byKey[clusterKey(params)]
+		if ok {
+			c.recorded = c.recorded || recorded
+		} else {
+			c = &cluster{source: source, params: params, recorded: recorded}
+			byKey[clusterKey(params)] = c
+			clusters = append(clusters, c)
+		}
+		byParameters[string(data)] = c
+	}
+
+	classes, err := t.provisioner.client.Storage().StorageClasses().List(v1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing storage classes to purge the trash of: %v", err)
+	} else {
+		for i := range classes.Items {
+			class := &classes.Items[i]
+			if class.Provisioner == t.provisionerName {
+				add(fmt.Sprintf("class %q", class.Name), controller.ClassParameters(class), false)
+			}
+		}
+	}
+	volumes, err := t.provisioner.client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing PVs to purge the trash of: %v", err)
+	} else {
+		for i := range volumes.Items {
+			volume := &volumes.Items[i]
+			if volume.Annotations[provisionedByAnn] != t.provisionerName {
+				continue
+			}
+			if parameters, ok, err := controller.GetProvisioningParameters(volume); err == nil && ok {
+				add(fmt.Sprintf("PV %q", volume.Name), parameters, false)
+			}
+		}
+	}
+	records, err := t.provisioner.trash.listRecords()
+	if err != nil {
+		glog.Errorf("Error listing trash records to purge the trash of: %v", err)
+	}
+	keys := []string{}
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(fmt.Sprintf("trash record %s", key), records[key].Parameters, true)
+	}
+
+	for _, c := range clusters {
+		remaining, err := t.purgeCluster(c.params)
+		if err != nil {
+			glog.Errorf("Error purging trash of %s: %v", c.source, err)
+			continue
+		}
+		if remaining == 0 && c.recorded {
+			if err := t.provisioner.trash.forgetCluster(clusterKey(c.params)); err != nil {
+				glog.Errorf("Error removing trash record of %s: %v", c.source, err)
+			}
+		}
+	}
+}
+
+// purgeCluster lists the trash of the cluster of params with provisionCmd and
+// purges the entries older than the retention period, returning how many
+// entries are left
+func (t *TrashPurger) purgeCluster(params *cephFSParameters) (int, error) {
+	stdout, stderr, err := runProvisionCmd(params.env(), "--list-trash")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list trash: %v, stderr: %q", err, excerpt(stderr))
+	}
+	var entries []string
+	if err := json.Unmarshal(stdout, &entries); err != nil {
+		return 0, fmt.Errorf("failed to parse trash listing %q: %v", excerpt(stdout), err)
+	}
+	now := t.provisioner.trash.now()
+	remaining := len(entries)
+	for _, entry := range entries {
+		i := strings.Index(entry, "-")
+		if i < 0 {
+			glog.Warningf("Skipping trash entry %q without a timestamp", entry)
+			continue
+		}
+		deleted, err := time.Parse(trashTimestampFormat, entry[:i])
+		if err != nil {
+			glog.Warningf("Skipping trash entry %q with an invalid timestamp: %v", entry, err)
+			continue
+		}
+		if now.Sub(deleted) < t.provisioner.trash.retention {
+			continue
+		}
+		log := newOperationLogger("purge", "trashEntry", entry, "share", entry[i+1:], "deletedAt", deleted.Format(time.RFC3339))
+		_, stderr, cmdErr := runProvisionCmd(params.env(), "--purge-trash", "-n", entry)
+		if cmdErr != nil {
+			log.error("failed to purge soft-deleted share", "err", cmdErr, "stderr", string(stderr))
+			audit(log, "purge", params, fmt.Errorf("failed to purge trash entry %q: %v, stderr: %q", entry, cmdErr, excerpt(stderr)))
+			continue
+		}
+		audit(log, "purge", params, nil)
+		log.info("purged soft-deleted share after its retention period")
+		remaining--
+	}
+	return remaining, nil
+}
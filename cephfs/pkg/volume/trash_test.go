@@ -0,0 +1,218 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/test"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestDeleteWithTrash(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var calls [][]string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		calls = append(calls, args)
+		return []byte(`{"version": 1, "path": "10.0.0.1:6789:/volumes/kubernetes/kubernetes-dynamic-pvc-uid-claim-1", "user": "client.kubernetes-dynamic-user-uid-claim-1", "auth": "key-1"}`), nil, nil
+	}
+	defer func(output io.Writer) { auditOutput = output }(auditOutput)
+	var audits bytes.Buffer
+	auditOutput = &audits
+
+	client := fake.NewSimpleClientset()
+	trash, err := NewTrash(client, "kube-system/cephfs-trash", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2017, 4, 1, 12, 0, 0, 0, time.UTC)
+	trash.now = func() time.Time { return now }
+	keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+	p := NewCephFSProvisioner(client, keyring, nil, nil, trash, nil)
+
+	options := test.NewVolumeOptions(test.NewClaim("claim-1", "default", "class-1", "1Gi"), map[string]string{"monitors": "10.0.0.1:6789"})
+	volume, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	if err := p.Delete(volume); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+
+	trashCall := []string{"-r", "--trash=20170401T120000Z", "-n", "kubernetes-dynamic-pvc-uid-claim-1", "-u", "kubernetes-dynamic-user-uid-claim-1"}
+	if !reflect.DeepEqual(calls[len(calls)-1], trashCall) {
+		t.Errorf("expected call %v but got %v", trashCall, calls[len(calls)-1])
+	}
+	if !bytes.Contains(audits.Bytes(), []byte(`"audit":"soft-delete"`)) {
+		t.Errorf("expected soft-delete audit entry but got %q", audits.String())
+	}
+	records, err := trash.listRecords()
+	if err != nil {
+		t.Fatalf("unexpected error listing trash records: %v", err)
+	}
+	expected := map[string]trashRecord{
+		clusterKey(&cephFSParameters{cluster: "ceph", mon: []string{"10.0.0.1:6789"}, adminID: "admin"}): {Parameters: map[string]string{"monitors": "10.0.0.1:6789"}, Updated: now},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected trash records %v but got %v", expected, records)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var calls [][]string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		calls = append(calls, args)
+		if args[0] == "--list-trash" {
+			return []byte(`["20170330T120000Z-share-old", "20170401T110000Z-share-new", "share-no-timestamp", "bad-share"]`), nil, nil
+		}
+		return nil, nil, nil
+	}
+	defer func(output io.Writer) { auditOutput = output }(auditOutput)
+	var audits bytes.Buffer
+	auditOutput = &audits
+
+	class1 := test.NewStorageClass("class-1", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789"})
+	// same cluster as class-1, purged once
+	class2 := test.NewStorageClass("class-2", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789"})
+	other := test.NewStorageClass("class-3", "other.com/other", map[string]string{"monitors": "10.0.0.2:6789"})
+
+	client := fake.NewSimpleClientset(class1, class2, other)
+	trash, err := NewTrash(client, "kube-system/cephfs-trash", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trash.now = func() time.Time { return time.Date(2017, 4, 1, 12, 0, 0, 0, time.UTC) }
+	keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+	p := NewCephFSProvisioner(client, keyring, nil, nil, trash, nil)
+	purger, err := NewTrashPurger(p, "ceph.com/cephfs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	purger.purge()
+
+	expected := [][]string{
+		{"--list-trash"},
+		{"--purge-trash", "-n", "20170330T120000Z-share-old"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v but got %v", expected, calls)
+	}
+	if !bytes.Contains(audits.Bytes(), []byte(`"audit":"purge"`)) || !bytes.Contains(audits.Bytes(), []byte(`"share":"share-old"`)) {
+		t.Errorf("expected purge audit entry but got %q", audits.String())
+	}
+}
+
+func TestPurgeTrashClusters(t *testing.T) {
+	defer func(run func([]string, ...string) ([]byte, []byte, error)) { runProvisionCmd = run }(runProvisionCmd)
+	var purged []string
+	runProvisionCmd = func(env []string, args ...string) ([]byte, []byte, error) {
+		mon := ""
+		for _, e := range env {
+			if strings.HasPrefix(e, "CEPH_MON=") {
+				mon = strings.TrimPrefix(e, "CEPH_MON=")
+			}
+		}
+		switch args[0] {
+		case "--list-trash":
+			switch mon {
+			case "10.0.0.2:6789":
+				return []byte(`["20170330T120000Z-share-` + mon + `"]`), nil, nil
+			case "10.0.0.3:6789":
+				return []byte(`["20170330T120000Z-share-` + mon + `", "20170401T110000Z-share-` + mon + `"]`), nil, nil
+			}
+			return []byte(`[]`), nil, nil
+		case "--purge-trash":
+			purged = append(purged, args[2])
+		}
+		return nil, nil, nil
+	}
+
+	now := time.Date(2017, 4, 1, 12, 0, 0, 0, time.UTC)
+	class := test.NewStorageClass("class-1", "ceph.com/cephfs", map[string]string{"monitors": "10.0.0.1:6789"})
+	// the PV of a share in a cluster whose class was deleted
+	volume := &v1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "pv-1", Annotations: map[string]string{provisionedByAnn: "ceph.com/cephfs"}}}
+	if err := controller.SetProvisioningParameters(volume, map[string]string{"monitors": "10.0.0.2:6789"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := fake.NewSimpleClientset(class, volume)
+	trash, err := NewTrash(client, "kube-system/cephfs-trash", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyring := &Keyring{keys: map[string]string{"admin": "admin-key"}}
+	p := NewCephFSProvisioner(client, keyring, nil, nil, trash, nil)
+
+	// shares were soft-deleted in clusters that no class or PV refers to any
+	// more: 10.0.0.3 has an expired and an unexpired share in its trash,
+	// 10.0.0.4's trash is empty and the last share was soft-deleted in
+	// 10.0.0.5 too recently for its record to go
+	record := func(mon string, updated time.Time) {
+		trash.now = func() time.Time { return updated }
+		if err := trash.recordCluster(map[string]string{"monitors": mon}, &cephFSParameters{cluster: "ceph", mon: []string{mon}, adminID: "admin"}); err != nil {
+			t.Fatalf("unexpected error recording cluster: %v", err)
+		}
+	}
+	record("10.0.0.3:6789", now.Add(-48*time.Hour))
+	record("10.0.0.4:6789", now.Add(-48*time.Hour))
+	record("10.0.0.5:6789", now.Add(-time.Hour))
+	trash.now = func() time.Time { return now }
+
+	purger, err := NewTrashPurger(p, "ceph.com/cephfs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	purger.purge()
+
+	expected := []string{"20170330T120000Z-share-10.0.0.2:6789", "20170330T120000Z-share-10.0.0.3:6789"}
+	if !reflect.DeepEqual(purged, expected) {
+		t.Errorf("expected purged entries %v but got %v", expected, purged)
+	}
+	records, err := trash.listRecords()
+	if err != nil {
+		t.Fatalf("unexpected error listing trash records: %v", err)
+	}
+	mons := []string{}
+	for _, r := range records {
+		mons = append(mons, r.Parameters["monitors"])
+	}
+	sort.Strings(mons)
+	if expected := []string{"10.0.0.3:6789", "10.0.0.5:6789"}; !reflect.DeepEqual(mons, expected) {
+		t.Errorf("expected trash records of %v but got %v", expected, mons)
+	}
+}
+
+func TestNewTrashPurger(t *testing.T) {
+	p := NewCephFSProvisioner(fake.NewSimpleClientset(), nil, nil, nil, nil, nil)
+	if _, err := NewTrashPurger(p, "ceph.com/cephfs"); err == nil {
+		t.Errorf("expected error creating purger for provisioner without trash but got none")
+	}
+	if _, err := NewTrash(fake.NewSimpleClientset(), "kube-system/cephfs-trash", 0); err == nil {
+		t.Errorf("expected error creating trash without retention but got none")
+	}
+	if _, err := NewTrash(fake.NewSimpleClientset(), "cephfs-trash", time.Hour); err == nil {
+		t.Errorf("expected error creating trash with a configmap without namespace but got none")
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package e2e tests the cephfs provisioner against a real Ceph cluster and
+// Kubernetes cluster, as set up by run.sh. Without -kubeconfig the tests are
+// skipped, so that they don't run with the unit tests.
+package e2e
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+	"k8s.io/client-go/pkg/util/wait"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	provisionerName = "kubernetes.io/cephfs"
+	classAnnotation = "volume.beta.kubernetes.io/storage-class"
+	poll            = 2 * time.Second
+)
+
+var (
+	kubeconfig           = flag.String("kubeconfig", "", "Absolute path to the kubeconfig of the cluster the provisioner runs against. If unset, the e2e tests are skipped.")
+	monitors             = flag.String("monitors", "", "Comma-separated Ceph monitors of the class the tests create.")
+	adminSecretName      = flag.String("admin-secret-name", "ceph-secret-admin", "Secret with the Ceph admin key.")
+	adminSecretNamespace = flag.String("admin-secret-namespace", "kube-system", "Namespace of the secret with the Ceph admin key.")
+	cephCommand          = flag.String("ceph-command", "", "Space-separated command to run the ceph CLI against the cluster with, e.g. \"docker exec ceph-demo ceph\". If unset, Ceph users aren't checked.")
+	podImage             = flag.String("pod-image", "gcr.io/google_containers/busybox:1.24", "Image of the pods that mount the provisioned shares.")
+	timeout              = flag.Duration("timeout", 5*time.Minute, "How long to wait for each step.")
+)
+
+func TestDynamicProvisioning(t *testing.T) {
+	if *kubeconfig == "" {
+		t.Skip("-kubeconfig not set, skipping e2e tests")
+	}
+	if *monitors == "" {
+		t.Fatalf("-monitors must be set")
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		t.Fatalf("error creating client config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	ns, err := client.Core().Namespaces().Create(&v1.Namespace{ObjectMeta: v1.ObjectMeta{GenerateName: "cephfs-e2e-"}})
+	if err != nil {
+		t.Fatalf("error creating namespace: %v", err)
+	}
+	defer client.Core().Namespaces().Delete(ns.Name, nil)
+
+	class, err := client.Storage().StorageClasses().Create(&v1beta1.StorageClass{
+		ObjectMeta:  v1.ObjectMeta{Name: ns.Name},
+		Provisioner: provisionerName,
+		Parameters: map[string]string{
+			"monitors":             *monitors,
+			"adminId":              "admin",
+			"adminSecretName":      *adminSecretName,
+			"adminSecretNamespace": *adminSecretNamespace,
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating class: %v", err)
+	}
+	defer client.Storage().StorageClasses().Delete(class.Name, nil)
+
+	claim, err := client.Core().PersistentVolumeClaims(ns.Name).Create(&v1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "claim-1",
+			Annotations: map[string]string{classAnnotation: class.Name},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi")},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating claim: %v", err)
+	}
+
+	t.Logf("waiting for claim %s/%s to be bound", ns.Name, claim.Name)
+	err = wait.Poll(poll, *timeout, func() (bool, error) {
+		claim, err = client.Core().PersistentVolumeClaims(ns.Name).Get(claim.Name)
+		if err != nil {
+			return false, err
+		}
+		return claim.Status.Phase == v1.ClaimBound, nil
+	})
+	if err != nil {
+		t.Fatalf("error waiting for claim to be bound: %v", err)
+	}
+	pv, err := client.Core().PersistentVolumes().Get(claim.Spec.VolumeName)
+	if err != nil {
+		t.Fatalf("error getting volume of claim: %v", err)
+	}
+
+	t.Logf("checking volume %s", pv.Name)
+	source := pv.Spec.CephFS
+	if source == nil {
+		t.Fatalf("expected CephFS volume but got %+v", pv.Spec.PersistentVolumeSource)
+	}
+	if strings.Join(source.Monitors, ",") != *monitors || source.Path == "" || source.User == "" || source.SecretRef == nil {
+		t.Errorf("expected volume with monitors %s, a path, a user and a secret but got %+v", *monitors, source)
+	}
+	if source.SecretRef != nil {
+		secretName := source.SecretRef.Name
+		secret, err := client.Core().Secrets(ns.Name).Get(secretName)
+		if err != nil {
+			t.Errorf("error getting secret %s/%s of volume: %v", ns.Name, secretName, err)
+		} else if len(secret.Data["key"]) == 0 {
+			t.Errorf("expected key in secret %s/%s but got none", ns.Name, secretName)
+		}
+	}
+	if err := checkCephUser(source.User, true); err != nil {
+		t.Errorf("error checking Ceph user of volume: %v", err)
+	}
+
+	t.Logf("checking that the share can be written and read")
+	if err := runPod(client, ns.Name, claim.Name, "write", "echo cephfs > /mnt/data"); err != nil {
+		t.Errorf("error writing to share: %v", err)
+	}
+	if err := runPod(client, ns.Name, claim.Name, "read", "grep -q cephfs /mnt/data"); err != nil {
+		t.Errorf("error reading from share: %v", err)
+	}
+
+	t.Logf("deleting claim %s/%s", ns.Name, claim.Name)
+	if err := client.Core().PersistentVolumeClaims(ns.Name).Delete(claim.Name, nil); err != nil {
+		t.Fatalf("error deleting claim: %v", err)
+	}
+	err = wait.Poll(poll, *timeout, func() (bool, error) {
+		_, err := client.Core().PersistentVolumes().Get(pv.Name)
+		if apierrs.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		t.Fatalf("error waiting for volume %s to be deleted: %v", pv.Name, err)
+	}
+	if err := checkCephUser(source.User, false); err != nil {
+		t.Errorf("error checking Ceph user of deleted volume: %v", err)
+	}
+}
+
+// runPod runs a pod mounting the claim at /mnt that runs the shell command and
+// waits for it to succeed
+func runPod(client kubernetes.Interface, namespace, claimName, name, command string) error {
+	pod, err := client.Core().Pods(namespace).Create(&v1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: name},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:         name,
+					Image:        *podImage,
+					Command:      []string{"/bin/sh", "-c", command},
+					VolumeMounts: []v1.VolumeMount{{Name: "share", MountPath: "/mnt"}},
+				},
+			},
+			RestartPolicy: v1.RestartPolicyNever,
+			Volumes: []v1.Volume{
+				{
+					Name: "share",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating pod: %v", err)
+	}
+	defer client.Core().Pods(namespace).Delete(pod.Name, nil)
+
+	return wait.Poll(poll, *timeout, func() (bool, error) {
+		pod, err := client.Core().Pods(namespace).Get(pod.Name)
+		if err != nil {
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case v1.PodSucceeded:
+			return true, nil
+		case v1.PodFailed:
+			return false, fmt.Errorf("pod %s/%s failed: %s", namespace, pod.Name, pod.Status.Message)
+		}
+		return false, nil
+	})
+}
+
+// checkCephUser checks that the Ceph user of a volume exists or not, if
+// -ceph-command is set
+func checkCephUser(user string, exists bool) error {
+	if *cephCommand == "" {
+		return nil
+	}
+	args := append(strings.Fields(*cephCommand), "auth", "get", "client."+user)
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if exists && err != nil {
+		return fmt.Errorf("expected user %s to exist but %v failed with error: %v, output: %s", user, args, err, out)
+	}
+	if !exists && err == nil {
+		return fmt.Errorf("expected user %s to be deleted but it exists", user)
+	}
+	return nil
+}
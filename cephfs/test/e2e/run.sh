@@ -0,0 +1,73 @@
+#!/bin/bash
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Runs the e2e tests: starts a single-container Ceph demo cluster and the
+# provisioner image against the Kubernetes cluster of KUBECONFIG, e.g. one
+# started by minikube, kind or hack/local-up-cluster.sh, then runs the tests
+# in this directory and cleans up. The nodes of the cluster must be able to
+# reach MON_IP and to mount CephFS.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+KUBECONFIG=${KUBECONFIG:-$HOME/.kube/config}
+KUBECTL=${KUBECTL:-kubectl}
+IMAGE=${IMAGE:-cephfs-provisioner:latest}
+CEPH_IMAGE=${CEPH_IMAGE:-ceph/demo:tag-build-master-jewel-ubuntu-16.04}
+MON_IP=${MON_IP:-$(hostname -i | awk '{print $1}')}
+CEPH_PUBLIC_NETWORK=${CEPH_PUBLIC_NETWORK:-${MON_IP%.*}.0/24}
+CEPH_CONTAINER=cephfs-e2e-ceph
+PROVISIONER_CONTAINER=cephfs-e2e-provisioner
+
+cleanup() {
+  docker rm -f "${PROVISIONER_CONTAINER}" "${CEPH_CONTAINER}" >/dev/null 2>&1 || true
+  "${KUBECTL}" --kubeconfig="${KUBECONFIG}" delete secret ceph-secret-admin --namespace=kube-system >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "Starting Ceph demo cluster with monitor ${MON_IP}"
+docker run -d --name "${CEPH_CONTAINER}" --net=host \
+  -e MON_IP="${MON_IP}" -e CEPH_PUBLIC_NETWORK="${CEPH_PUBLIC_NETWORK}" \
+  "${CEPH_IMAGE}" >/dev/null
+for i in $(seq 1 60); do
+  if docker exec "${CEPH_CONTAINER}" ceph mds stat 2>/dev/null | grep -q "up:active"; then
+    break
+  fi
+  if [ "$i" -eq 60 ]; then
+    echo "Ceph demo cluster did not come up" >&2
+    docker logs "${CEPH_CONTAINER}" >&2
+    exit 1
+  fi
+  sleep 5
+done
+
+echo "Creating Ceph admin secret"
+KEY=$(docker exec "${CEPH_CONTAINER}" ceph auth get-key client.admin)
+"${KUBECTL}" --kubeconfig="${KUBECONFIG}" create secret generic ceph-secret-admin \
+  --from-literal=key="${KEY}" --namespace=kube-system
+
+echo "Starting provisioner ${IMAGE}"
+docker run -d --name "${PROVISIONER_CONTAINER}" --privileged --net=host \
+  -v "$(dirname "${KUBECONFIG}")":/kube \
+  "${IMAGE}" /usr/local/bin/cephfs-provisioner -kubeconfig="/kube/$(basename "${KUBECONFIG}")" -logtostderr >/dev/null
+
+cd "$(dirname "${BASH_SOURCE[0]}")"
+if ! go test . -v -timeout=30m -kubeconfig="${KUBECONFIG}" -monitors="${MON_IP}:6789" \
+  -ceph-command="docker exec ${CEPH_CONTAINER} ceph"; then
+  docker logs "${PROVISIONER_CONTAINER}" >&2
+  exit 1
+fi
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"os"
@@ -43,6 +44,15 @@ const (
 	retryPeriod               = leaderelection.DefaultRetryPeriod
 	renewDeadline             = leaderelection.DefaultRenewDeadline
 	termLimit                 = leaderelection.DefaultTermLimit
+
+	// Environment variable the node the provisioner runs on is passed in by,
+	// via downward API
+	nodeNameEnv = "NODE_NAME"
+	// PV annotation for the nodes pods using the PV must be scheduled to,
+	// honoured by Kubernetes 1.7+ schedulers
+	annNodeAffinity = "volume.alpha.kubernetes.io/node-affinity"
+	// Node label the node affinity selects on
+	hostnameLabel = "kubernetes.io/hostname"
 )
 
 type hostPathProvisioner struct {
@@ -52,12 +62,24 @@ type hostPathProvisioner struct {
 	// Identity of this hostPathProvisioner, generated. Used to identify "this"
 	// provisioner's PVs.
 	identity types.UID
+
+	// The node this hostPathProvisioner runs on, if known. Provisioned PVs are
+	// given node affinity to it so that pods land where their data is.
+	nodeName string
 }
 
 func NewHostPathProvisioner() controller.Provisioner {
+	nodeName := os.Getenv(nodeNameEnv)
+	// When running one provisioner per node, the node's name identifies the
+	// provisioner responsible for a PV even across restarts
+	identity := uuid.NewUUID()
+	if nodeName != "" {
+		identity = types.UID(nodeName)
+	}
 	return &hostPathProvisioner{
 		pvDir:    "/tmp/hostpath-provisioner",
-		identity: uuid.NewUUID(),
+		identity: identity,
+		nodeName: nodeName,
 	}
 }
 
@@ -92,9 +114,43 @@ func (p *hostPathProvisioner) Provision(options controller.VolumeOptions) (*v1.P
 		},
 	}
 
+	if p.nodeName != "" {
+		affinity, err := p.nodeAffinity()
+		if err != nil {
+			os.RemoveAll(path)
+			return nil, err
+		}
+		pv.Annotations[annNodeAffinity] = affinity
+	}
+
 	return pv, nil
 }
 
+// nodeAffinity returns the JSON node affinity requiring pods to be scheduled
+// to this provisioner's node
+func (p *hostPathProvisioner) nodeAffinity() (string, error) {
+	affinity := &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{
+							Key:      hostnameLabel,
+							Operator: v1.NodeSelectorOpIn,
+							Values:   []string{p.nodeName},
+						},
+					},
+				},
+			},
+		},
+	}
+	affinityBytes, err := json.Marshal(affinity)
+	if err != nil {
+		return "", err
+	}
+	return string(affinityBytes), nil
+}
+
 // Delete removes the storage asset that was created by Provision represented
 // by the given PV.
 func (p *hostPathProvisioner) Delete(volume *v1.PersistentVolume) error {
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Keys of the credentials in a credentials secret, named like in the AWS
+	// shared credentials file
+	accessKeyIDKey     = "aws_access_key_id"
+	secretAccessKeyKey = "aws_secret_access_key"
+	sessionTokenKey    = "aws_session_token"
+
+	webIdentityProviderName = "WebIdentityRoleProvider"
+	webIdentitySessionName  = "efs-provisioner"
+	// How long before they expire to refresh web identity credentials
+	webIdentityExpiryWindow = time.Minute
+)
+
+// getCredentials returns the AWS credentials the provisioner should use: from
+// the secret named "namespace/name" by credentialsSecret if set, else from
+// the role roleARN assumed with the token in webIdentityTokenFile if set,
+// else nil, meaning the SDK's default chain of environment, shared
+// credentials file and instance metadata.
+func getCredentials(client kubernetes.Interface, awsRegion, credentialsSecret, webIdentityTokenFile, roleARN string) (*credentials.Credentials, error) {
+	if credentialsSecret != "" {
+		return getSecretCredentials(client, credentialsSecret)
+	}
+
+	if webIdentityTokenFile != "" || roleARN != "" {
+		if webIdentityTokenFile == "" || roleARN == "" {
+			return nil, fmt.Errorf("a web identity token file and a role ARN must be given together")
+		}
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String(awsRegion),
+			Credentials: credentials.AnonymousCredentials,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return credentials.NewCredentials(&webIdentityRoleProvider{
+			client:    sts.New(sess),
+			roleARN:   roleARN,
+			tokenFile: webIdentityTokenFile,
+		}), nil
+	}
+
+	return nil, nil
+}
+
+// getSecretCredentials returns static credentials read from the secret named
+// "namespace/name"
+func getSecretCredentials(client kubernetes.Interface, credentialsSecret string) (*credentials.Credentials, error) {
+	parts := strings.Split(credentialsSecret, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid credentials secret %q, expected namespace/name", credentialsSecret)
+	}
+	secret, err := client.Core().Secrets(parts[0]).Get(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting credentials secret %s: %v", credentialsSecret, err)
+	}
+	accessKeyID := strings.TrimSpace(string(secret.Data[accessKeyIDKey]))
+	secretAccessKey := strings.TrimSpace(string(secret.Data[secretAccessKeyKey]))
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("credentials secret %s must have keys %s and %s", credentialsSecret, accessKeyIDKey, secretAccessKeyKey)
+	}
+	sessionToken := strings.TrimSpace(string(secret.Data[sessionTokenKey]))
+	return credentials.NewStaticCredentials(accessKeyID, secretAccessKey, sessionToken), nil
+}
+
+// webIdentityAssumer is the part of the STS API webIdentityRoleProvider uses
+type webIdentityAssumer interface {
+	AssumeRoleWithWebIdentity(*sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+// webIdentityRoleProvider is a credentials.Provider that exchanges a web
+// identity token, e.g. a projected service account token as used by IAM
+// roles for service accounts, for the temporary credentials of a role. The
+// token file is re-read on every refresh since whoever writes it rotates it.
+type webIdentityRoleProvider struct {
+	credentials.Expiry
+
+	client    webIdentityAssumer
+	roleARN   string
+	tokenFile string
+}
+
+var _ credentials.Provider = &webIdentityRoleProvider{}
+
+// Retrieve assumes the role with the current token
+func (p *webIdentityRoleProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, fmt.Errorf("error reading web identity token file %s: %v", p.tokenFile, err)
+	}
+
+	out, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(webIdentitySessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, fmt.Errorf("error assuming role %s with web identity: %v", p.roleARN, err)
+	}
+
+	p.SetExpiration(*out.Credentials.Expiration, webIdentityExpiryWindow)
+
+	return credentials.Value{
+		AccessKeyID:     *out.Credentials.AccessKeyId,
+		SecretAccessKey: *out.Credentials.SecretAccessKey,
+		SessionToken:    *out.Credentials.SessionToken,
+		ProviderName:    webIdentityProviderName,
+	}, nil
+}
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/docker/docker/pkg/mount"
@@ -45,6 +46,10 @@ const (
 	provisionerNameKey = "PROVISIONER_NAME"
 	fileSystemIDKey    = "FILE_SYSTEM_ID"
 	awsRegionKey       = "AWS_REGION"
+	// fileSystemsKey is an alternative to provisionerNameKey & fileSystemIDKey
+	// for serving multiple file systems from one process. Its value is a
+	// comma-separated list of provisionerName=fileSystemID pairs.
+	fileSystemsKey = "FILE_SYSTEMS"
 
 	resyncPeriod              = 15 * time.Second
 	exponentialBackOffOnError = true
@@ -55,26 +60,32 @@ const (
 	termLimit                 = leaderelection.DefaultTermLimit
 )
 
+var (
+	credentialsSecret    = flag.String("aws-credentials-secret", "", "Namespace/name of a secret with keys aws_access_key_id, aws_secret_access_key and optionally aws_session_token to take AWS credentials from. Takes precedence over web identity.")
+	webIdentityTokenFile = flag.String("aws-web-identity-token-file", os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), "Path to a web identity token, e.g. a projected service account token, to exchange for the credentials of aws-role-arn. Defaults to the environment variable AWS_WEB_IDENTITY_TOKEN_FILE, as set for IAM roles for service accounts.")
+	roleARN              = flag.String("aws-role-arn", os.Getenv("AWS_ROLE_ARN"), "ARN of the IAM role to assume with aws-web-identity-token-file. Defaults to the environment variable AWS_ROLE_ARN.")
+	orphanReaperPeriod   = flag.Duration("orphan-reaper-period", 0, "How often to look for directories without PVs. 0 means never.")
+	orphanGracePeriod    = flag.Duration("orphan-grace-period", 10*time.Minute, "How long a directory must be without a PV to be an orphan.")
+	orphanAction         = flag.String("orphan-action", orphanActionReport, "What to do with orphaned directories: report, to only count them in metrics and events, archive, to move them to the .archived directory of their file system, or delete.")
+	metricsAddress       = flag.String("metrics-address", "", "Address to serve Prometheus metrics at /metrics on, e.g. :8080. Unset means metrics aren't served.")
+)
+
 type efsProvisioner struct {
 	dnsName    string
 	mountpoint string
 	source     string
 	svc        *efs.EFS
 	allocator  gidallocator.Allocator
+	client     kubernetes.Interface
+	// Whether to archive orphaned directories rather than delete them
+	archiveOrphans bool
 }
 
-// NewEFSProvisioner creates an AWS EFS volume provisioner
-func NewEFSProvisioner(client kubernetes.Interface) controller.Provisioner {
-	fileSystemID := os.Getenv(fileSystemIDKey)
-	if fileSystemID == "" {
-		glog.Fatalf("environment variable %s is not set! Please set it.", fileSystemIDKey)
-	}
-
-	awsRegion := os.Getenv(awsRegionKey)
-	if awsRegion == "" {
-		glog.Fatalf("environment variable %s is not set! Please set it.", awsRegionKey)
-	}
-
+// NewEFSProvisioner creates an AWS EFS volume provisioner for the given file
+// system, which must be mounted in the provisioner's container. If creds is
+// nil the SDK's default credential chain is used. If archiveOrphans is set,
+// directories without PVs are archived instead of deleted.
+func NewEFSProvisioner(client kubernetes.Interface, fileSystemID, awsRegion string, creds *credentials.Credentials, archiveOrphans bool) controller.Provisioner {
 	dnsName := getDNSName(fileSystemID, awsRegion)
 
 	mountpoint, source, err := getMount(dnsName)
@@ -82,7 +93,7 @@ func NewEFSProvisioner(client kubernetes.Interface) controller.Provisioner {
 		glog.Fatal(err)
 	}
 
-	sess, err := session.NewSession()
+	sess, err := session.NewSession(&aws.Config{Credentials: creds})
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -103,7 +114,62 @@ func NewEFSProvisioner(client kubernetes.Interface) controller.Provisioner {
 		source:     source,
 		svc:        svc,
 		allocator:  gidallocator.New(client),
+		client:     client,
+
+		archiveOrphans: archiveOrphans,
+	}
+}
+
+// getFileSystems returns a map of provisioner names to the IDs of the file
+// systems they provision from, either from fileSystemsKey or from the single
+// pair provisionerNameKey & fileSystemIDKey
+func getFileSystems() (map[string]string, error) {
+	fileSystems := os.Getenv(fileSystemsKey)
+	provisionerName := os.Getenv(provisionerNameKey)
+	fileSystemID := os.Getenv(fileSystemIDKey)
+
+	if fileSystems != "" {
+		if provisionerName != "" || fileSystemID != "" {
+			return nil, fmt.Errorf("environment variable %s is set, so %s and %s must not be", fileSystemsKey, provisionerNameKey, fileSystemIDKey)
+		}
+		return parseFileSystems(fileSystems)
+	}
+
+	if provisionerName == "" {
+		return nil, fmt.Errorf("environment variable %s is not set! Please set it.", provisionerNameKey)
+	}
+	if fileSystemID == "" {
+		return nil, fmt.Errorf("environment variable %s is not set! Please set it.", fileSystemIDKey)
+	}
+	return map[string]string{provisionerName: fileSystemID}, nil
+}
+
+// parseFileSystems parses a comma-separated list of
+// provisionerName=fileSystemID pairs
+func parseFileSystems(fileSystems string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(fileSystems, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected provisionerName=fileSystemID", fileSystemsKey, pair)
+		}
+		provisionerName, fileSystemID := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if provisionerName == "" || fileSystemID == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, expected provisionerName=fileSystemID", fileSystemsKey, pair)
+		}
+		if _, ok := m[provisionerName]; ok {
+			return nil, fmt.Errorf("provisioner name %s appears more than once in %s", provisionerName, fileSystemsKey)
+		}
+		m[provisionerName] = fileSystemID
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("environment variable %s has no entries", fileSystemsKey)
 	}
+	return m, nil
 }
 
 func getDNSName(fileSystemID, awsRegion string) string {
@@ -131,18 +197,53 @@ func (p *efsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 	if options.PVC.Spec.Selector != nil {
 		return nil, fmt.Errorf("claim.Spec.Selector is not supported")
 	}
+	tls, err := parseTLSParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	if importPath, ok := options.PVC.Annotations[importPathAnn]; ok {
+		name, gid, err := p.getImportedDirectory(options, importPath)
+		if err != nil {
+			return nil, err
+		}
+		pv := p.newVolume(options, name, gid)
+		pv.Annotations[importedDirectoryAnn] = name
+		tls.setTLS(pv)
+		return pv, nil
+	}
+
+	layout, err := parseLayoutParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := layout.prepare(p.mountpoint, options); err != nil {
+		return nil, err
+	}
+	name := layout.directoryName(options)
 
 	gid, err := p.allocator.AllocateNext(options)
 	if err != nil {
 		return nil, err
 	}
 
-	err = p.createVolume(p.getLocalPath(options), gid)
+	err = p.createVolume(p.getLocalPath(name), gid)
 	if err != nil {
+		if releaseErr := p.allocator.ReleaseUnused(options, gid); releaseErr != nil {
+			glog.Errorf("Failed to release gid %v of failed volume %q: %v", gid, options.PVName, releaseErr)
+		}
 		return nil, err
 	}
 
-	pv := &v1.PersistentVolume{
+	pv := p.newVolume(options, name, gid)
+	tls.setTLS(pv)
+
+	return pv, nil
+}
+
+// newVolume returns the PV of the directory of the given path relative to the
+// mountpoint, owned by the given GID
+func (p *efsProvisioner) newVolume(options controller.VolumeOptions, name string, gid int) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
 		ObjectMeta: v1.ObjectMeta{
 			Name: options.PVName,
 			Annotations: map[string]string{
@@ -158,14 +259,12 @@ func (p *efsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 			PersistentVolumeSource: v1.PersistentVolumeSource{
 				NFS: &v1.NFSVolumeSource{
 					Server:   p.dnsName,
-					Path:     p.getRemotePath(options),
+					Path:     p.getRemotePath(name),
 					ReadOnly: false,
 				},
 			},
 		},
 	}
-
-	return pv, nil
 }
 
 func (p *efsProvisioner) createVolume(path string, gid int) error {
@@ -193,29 +292,34 @@ func (p *efsProvisioner) createVolume(path string, gid int) error {
 	return nil
 }
 
-func (p *efsProvisioner) getLocalPath(options controller.VolumeOptions) string {
-	return path.Join(p.mountpoint, p.getDirectoryName(options))
+func (p *efsProvisioner) getLocalPath(name string) string {
+	return path.Join(p.mountpoint, name)
 }
 
-func (p *efsProvisioner) getRemotePath(options controller.VolumeOptions) string {
+func (p *efsProvisioner) getRemotePath(name string) string {
 	sourcePath := path.Clean(strings.Replace(p.source, p.dnsName+":", "", 1))
-	return path.Join(sourcePath, p.getDirectoryName(options))
-}
-
-func (p *efsProvisioner) getDirectoryName(options controller.VolumeOptions) string {
-	return options.PVC.Name + "-" + options.PVName
+	return path.Join(sourcePath, name)
 }
 
 // Delete removes the storage asset that was created by Provision represented
 // by the given PV.
 func (p *efsProvisioner) Delete(volume *v1.PersistentVolume) error {
+	if _, ok := volume.Annotations[listedDirectoryAnn]; ok {
+		return p.deleteOrphan(volume)
+	}
+	if name, ok := volume.Annotations[importedDirectoryAnn]; ok {
+		// The data was there before the PV and its GID was never allocated
+		glog.Infof("leaving imported directory %s of volume %s in place", name, volume.Name)
+		return nil
+	}
+
 	//TODO ignorederror
 	err := p.allocator.Release(volume)
 	if err != nil {
 		return err
 	}
 
-	path, err := p.getLocalPathToDelete(volume.Spec.NFS)
+	path, err := p.getLocalPathToDelete(getNFSSource(volume))
 	if err != nil {
 		return err
 	}
@@ -264,17 +368,52 @@ func main() {
 		glog.Fatalf("Error getting server version: %v", err)
 	}
 
-	// Create the provisioner: it implements the Provisioner interface expected by
-	// the controller
-	efsProvisioner := NewEFSProvisioner(clientset)
+	fileSystems, err := getFileSystems()
+	if err != nil {
+		glog.Fatal(err)
+	}
 
-	provisionerName := os.Getenv(provisionerNameKey)
-	if provisionerName == "" {
-		glog.Fatalf("environment variable %s is not set! Please set it.", provisionerNameKey)
+	awsRegion := os.Getenv(awsRegionKey)
+	if awsRegion == "" {
+		glog.Fatalf("environment variable %s is not set! Please set it.", awsRegionKey)
 	}
 
-	// Start the provision controller which will dynamically provision efs NFS
-	// PVs
-	pc := controller.NewProvisionController(clientset, resyncPeriod, provisionerName, efsProvisioner, serverVersion.GitVersion, exponentialBackOffOnError, failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit)
-	pc.Run(wait.NeverStop)
+	creds, err := getCredentials(clientset, awsRegion, *credentialsSecret, *webIdentityTokenFile, *roleARN)
+	if err != nil {
+		glog.Fatalf("Error getting AWS credentials: %v", err)
+	}
+
+	var options []func(*controller.ProvisionController) error
+	archiveOrphans := false
+	if *orphanReaperPeriod > 0 {
+		option, archive, err := orphanReaperOption(*orphanReaperPeriod, *orphanGracePeriod, *orphanAction)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		options = append(options, option)
+		archiveOrphans = archive
+	}
+
+	metricsServed := false
+	for provisionerName, fileSystemID := range fileSystems {
+		// Create the provisioner: it implements the Provisioner interface
+		// expected by the controller
+		efsProvisioner := NewEFSProvisioner(clientset, fileSystemID, awsRegion, creds, archiveOrphans)
+
+		// All controllers of the process record the same metrics, so only one
+		// needs to serve them
+		controllerOptions := append([]func(*controller.ProvisionController) error{}, options...)
+		if *metricsAddress != "" && !metricsServed {
+			controllerOptions = append(controllerOptions, controller.MetricsAddress(*metricsAddress))
+			metricsServed = true
+		}
+
+		// Start the provision controller which will dynamically provision efs
+		// NFS PVs. Each file system gets its own controller so that each can be
+		// asked for by its own StorageClasses.
+		glog.Infof("Provisioning from file system %s as %s", fileSystemID, provisionerName)
+		pc := controller.NewProvisionController(clientset, resyncPeriod, provisionerName, efsProvisioner, serverVersion.GitVersion, exponentialBackOffOnError, failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit, controllerOptions...)
+		go pc.Run(wait.NeverStop)
+	}
+	<-wait.NeverStop
 }
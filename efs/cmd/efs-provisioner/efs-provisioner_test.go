@@ -68,6 +68,54 @@ func TestGetLocalPathToDelete(t *testing.T) {
 	}
 }
 
+func TestParseFileSystems(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileSystems string
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			name:        "one file system",
+			fileSystems: "example.com/aws-efs=fs-47a2c22e",
+			expected:    map[string]string{"example.com/aws-efs": "fs-47a2c22e"},
+		},
+		{
+			name:        "many file systems with whitespace",
+			fileSystems: "example.com/aws-efs-a=fs-47a2c22e, example.com/aws-efs-b = fs-5ba2c232,",
+			expected:    map[string]string{"example.com/aws-efs-a": "fs-47a2c22e", "example.com/aws-efs-b": "fs-5ba2c232"},
+		},
+		{
+			name:        "duplicate provisioner name",
+			fileSystems: "example.com/aws-efs=fs-47a2c22e,example.com/aws-efs=fs-5ba2c232",
+			expectError: true,
+		},
+		{
+			name:        "missing file system id",
+			fileSystems: "example.com/aws-efs=",
+			expectError: true,
+		},
+		{
+			name:        "malformed pair",
+			fileSystems: "example.com/aws-efs",
+			expectError: true,
+		},
+		{
+			name:        "no entries",
+			fileSystems: ",",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		fileSystems, err := parseFileSystems(test.fileSystems)
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, fileSystems == nil, "nil file systems")
+			continue
+		}
+		evaluate(t, test.name, false, err, test.expected, fileSystems, "file systems")
+	}
+}
+
 func newTestEFSProvisioner() *efsProvisioner {
 	return &efsProvisioner{
 		dnsName:    dnsName,
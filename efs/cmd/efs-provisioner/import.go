@@ -0,0 +1,147 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// importPathAnn is set by users on a claim to the path, relative to the
+	// mountpoint, of an existing directory to bind the claim to instead of
+	// creating a new one
+	importPathAnn = "efs.provisioner/import-path"
+
+	// importedDirectoryAnn is set on the PVs of imported directories, to the
+	// path of the directory relative to the mountpoint, so Delete leaves the
+	// data alone
+	importedDirectoryAnn = "efs.kubernetes.io/imported-directory"
+)
+
+// parseImportRoot parses the class parameter importRoot, the directory,
+// relative to the mountpoint, under which claims of the class may import
+// directories, ignoring others. It returns "" if claims may not import.
+func parseImportRoot(parameters map[string]string) (string, error) {
+	for k, v := range parameters {
+		if strings.ToLower(k) != "importroot" {
+			continue
+		}
+		root, ok := cleanRelativePath(v)
+		if !ok {
+			return "", fmt.Errorf("invalid value %q for parameter %s: must be a path relative to the provisioner's directory", v, k)
+		}
+		return root, nil
+	}
+	return "", nil
+}
+
+// cleanRelativePath cleans the path and returns whether it stays within the
+// directory it is relative to
+func cleanRelativePath(p string) (string, bool) {
+	cleaned := path.Clean(strings.TrimPrefix(p, "/"))
+	if p == "" || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// getImportedDirectory validates the directory the claim asks to import and
+// returns its path relative to the mountpoint and its GID, which pods of the
+// claim get as a supplemental group. The directory must be under the class'
+// importRoot, must not be one Provision created or already be imported by
+// another PV, and must be owned by a group other than root.
+func (p *efsProvisioner) getImportedDirectory(options controller.VolumeOptions, importPath string) (string, int, error) {
+	root, err := parseImportRoot(options.Parameters)
+	if err != nil {
+		return "", 0, err
+	}
+	if root == "" {
+		return "", 0, fmt.Errorf("claim has annotation %s but its StorageClass doesn't allow imports, set parameter importRoot", importPathAnn)
+	}
+	name, ok := cleanRelativePath(importPath)
+	if !ok || name == "." {
+		return "", 0, fmt.Errorf("invalid value %q for annotation %s: must be a directory relative to the provisioner's directory", importPath, importPathAnn)
+	}
+	if !isUnder(name, root) {
+		return "", 0, fmt.Errorf("directory %s to import is not under the StorageClass' importRoot %s", name, root)
+	}
+	if isListedDirectoryName(name) || isUnder(name, archiveDirectory) {
+		return "", 0, fmt.Errorf("directory %s was created by the provisioner and can't be imported", name)
+	}
+
+	// Resolve symlinks so that a link under the root can't import from
+	// outside it
+	localRoot, err := filepath.EvalSymlinks(p.getLocalPath(root))
+	if err != nil {
+		return "", 0, fmt.Errorf("error resolving importRoot %s: %v", root, err)
+	}
+	local, err := filepath.EvalSymlinks(p.getLocalPath(name))
+	if err != nil {
+		return "", 0, fmt.Errorf("error resolving directory %s to import: %v", name, err)
+	}
+	if local != localRoot && !strings.HasPrefix(local, localRoot+"/") {
+		return "", 0, fmt.Errorf("directory %s to import resolves to %s, outside the StorageClass' importRoot %s", name, local, root)
+	}
+
+	info, err := os.Stat(local)
+	if err != nil {
+		return "", 0, fmt.Errorf("error checking directory %s to import: %v", name, err)
+	}
+	if !info.IsDir() {
+		return "", 0, fmt.Errorf("%s to import is not a directory", name)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", 0, fmt.Errorf("error getting group of directory %s to import", name)
+	}
+	if stat.Gid == 0 {
+		return "", 0, fmt.Errorf("directory %s to import is owned by group root, chgrp it to the group pods of the claim should get", name)
+	}
+
+	if err := p.checkNotImported(options.PVName, name); err != nil {
+		return "", 0, err
+	}
+	return name, int(stat.Gid), nil
+}
+
+// checkNotImported returns an error if a PV other than the named one already
+// imports the directory
+func (p *efsProvisioner) checkNotImported(pvName, name string) error {
+	volumes, err := p.client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing PVs to check directory %s isn't imported yet: %v", name, err)
+	}
+	for _, volume := range volumes.Items {
+		if volume.Name != pvName && volume.Annotations[importedDirectoryAnn] == name {
+			return fmt.Errorf("directory %s is already imported by PV %s", name, volume.Name)
+		}
+	}
+	return nil
+}
+
+// isUnder returns whether the relative path is dir or under it
+func isUnder(name, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/efs/pkg/util"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestImport(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "efs-provisioner-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"legacy/data", "legacy/root-owned", "legacy/app-" + testPVName, "other/data"} {
+		if err := os.MkdirAll(path.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("error creating dir: %v", err)
+		}
+	}
+	for _, dir := range []string{"legacy/data", "other/data"} {
+		if err := os.Chown(path.Join(tmpDir, dir), -1, 1001); err != nil {
+			t.Fatalf("error changing group of dir: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmpDir, "legacy/file"), nil, 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if err := os.Symlink(path.Join(tmpDir, "other/data"), path.Join(tmpDir, "legacy/link")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	imported := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "pvc-imported",
+			Annotations: map[string]string{importedDirectoryAnn: "other/data"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		importPath   string
+		parameters   map[string]string
+		expectedPath string
+		expectError  bool
+	}{
+		{
+			name:         "import",
+			importPath:   "legacy/data",
+			parameters:   map[string]string{"importRoot": "legacy"},
+			expectedPath: path.Join(source, "legacy/data"),
+		},
+		{
+			name:        "class doesn't allow imports",
+			importPath:  "legacy/data",
+			expectError: true,
+		},
+		{
+			name:        "outside import root",
+			importPath:  "other/data",
+			parameters:  map[string]string{"importRoot": "legacy"},
+			expectError: true,
+		},
+		{
+			name:        "escapes the mountpoint",
+			importPath:  "legacy/../../data",
+			parameters:  map[string]string{"importRoot": "."},
+			expectError: true,
+		},
+		{
+			name:        "symlink out of import root",
+			importPath:  "legacy/link",
+			parameters:  map[string]string{"importRoot": "legacy"},
+			expectError: true,
+		},
+		{
+			name:        "missing",
+			importPath:  "legacy/missing",
+			parameters:  map[string]string{"importRoot": "legacy"},
+			expectError: true,
+		},
+		{
+			name:        "not a directory",
+			importPath:  "legacy/file",
+			parameters:  map[string]string{"importRoot": "legacy"},
+			expectError: true,
+		},
+		{
+			name:        "owned by root",
+			importPath:  "legacy/root-owned",
+			parameters:  map[string]string{"importRoot": "legacy"},
+			expectError: true,
+		},
+		{
+			name:        "created by the provisioner",
+			importPath:  "legacy/app-" + testPVName,
+			parameters:  map[string]string{"importRoot": "legacy"},
+			expectError: true,
+		},
+		{
+			name:        "already imported",
+			importPath:  "other/data",
+			parameters:  map[string]string{"importRoot": "."},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		efsProvisioner := newTestEFSProvisioner()
+		efsProvisioner.mountpoint = tmpDir
+		efsProvisioner.client = fake.NewSimpleClientset(imported)
+		options := controller.VolumeOptions{
+			PVName:     testPVName,
+			Parameters: test.parameters,
+			PVC: &v1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{
+					Name:        "claim-1",
+					Namespace:   "default",
+					Annotations: map[string]string{importPathAnn: test.importPath},
+				},
+			},
+		}
+
+		pv, err := efsProvisioner.Provision(options)
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, pv == nil, "nil volume")
+			continue
+		}
+		if err != nil {
+			evaluate(t, test.name, false, err, nil, nil, "volume")
+			continue
+		}
+		evaluate(t, test.name, false, nil, test.expectedPath, pv.Spec.NFS.Path, "volume path")
+		evaluate(t, test.name, false, nil, "1001", pv.Annotations[util.VolumeGidAnnotationKey], "volume gid")
+		evaluate(t, test.name, false, nil, "legacy/data", pv.Annotations[importedDirectoryAnn], "imported directory")
+
+		err = efsProvisioner.Delete(pv)
+		_, statErr := os.Stat(path.Join(tmpDir, "legacy/data"))
+		evaluate(t, test.name, false, err, true, statErr == nil, "directory left by delete")
+	}
+}
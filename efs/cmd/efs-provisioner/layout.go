@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// layoutFlat puts the directories of all claims directly under the
+	// mountpoint
+	layoutFlat = "flat"
+	// layoutNamespace puts the directories of each namespace's claims under a
+	// directory named after the namespace
+	layoutNamespace = "namespace"
+
+	// namespaceDirectoryPerm lets pods traverse, but not list, a namespace's
+	// directory
+	namespaceDirectoryPerm = os.FileMode(0711)
+)
+
+// layoutParameters are the directory layout options parsed from a
+// StorageClass
+type layoutParameters struct {
+	// layout is layoutFlat or layoutNamespace
+	layout string
+	// namespaceQuota is how many bytes the directory of a namespace may hold
+	// before claims of the namespace are refused. Zero means unlimited.
+	namespaceQuota int64
+}
+
+// parseLayoutParameters parses the class parameters layout and namespaceQuota,
+// ignoring others
+func parseLayoutParameters(parameters map[string]string) (*layoutParameters, error) {
+	params := &layoutParameters{layout: layoutFlat}
+	quotaSet := false
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "layout":
+			if v != layoutFlat && v != layoutNamespace {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: must be %q or %q", v, k, layoutFlat, layoutNamespace)
+			}
+			params.layout = v
+		case "namespacequota":
+			quota, err := resource.ParseQuantity(v)
+			if err != nil || quota.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: must be a positive quantity", v, k)
+			}
+			params.namespaceQuota = quota.Value()
+			quotaSet = true
+		}
+	}
+	if quotaSet && params.layout != layoutNamespace {
+		return nil, fmt.Errorf("parameter namespaceQuota can only be set if layout is %q", layoutNamespace)
+	}
+	return params, nil
+}
+
+// directoryName returns the path, relative to the mountpoint, of the
+// directory of the claim's volume
+func (params *layoutParameters) directoryName(options controller.VolumeOptions) string {
+	name := options.PVC.Name + "-" + options.PVName
+	if params.layout == layoutNamespace {
+		return path.Join(options.PVC.Namespace, name)
+	}
+	return name
+}
+
+// prepare creates the directory of the claim's namespace, if the layout has
+// one, and checks that the namespace's quota leaves room for the claim
+func (params *layoutParameters) prepare(mountpoint string, options controller.VolumeOptions) error {
+	if params.layout != layoutNamespace {
+		return nil
+	}
+	dir := path.Join(mountpoint, options.PVC.Namespace)
+	if err := os.MkdirAll(dir, namespaceDirectoryPerm); err != nil {
+		return fmt.Errorf("error creating directory of namespace %s: %v", options.PVC.Namespace, err)
+	}
+	if params.namespaceQuota == 0 {
+		return nil
+	}
+	usage, err := diskUsage(dir)
+	if err != nil {
+		return fmt.Errorf("error getting disk usage of namespace %s: %v", options.PVC.Namespace, err)
+	}
+	requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if usage+requested.Value() > params.namespaceQuota {
+		return fmt.Errorf("namespace %s uses %d bytes of its quota of %d bytes, not enough for the %s requested", options.PVC.Namespace, usage, params.namespaceQuota, requested.String())
+	}
+	return nil
+}
+
+// diskUsage returns how many bytes the files under dir take up, as counted by
+// du. It is a variable so tests can replace it.
+var diskUsage = func(dir string) (int64, error) {
+	out, err := exec.Command("du", "-sk", dir).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("du failed with error: %v, output: %s", err, out)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("du printed no usage")
+	}
+	kib, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("du printed invalid usage %q: %v", fields[0], err)
+	}
+	return kib * 1024, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/test"
+)
+
+func TestParseLayoutParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expected    *layoutParameters
+		expectError bool
+	}{
+		{
+			name:     "defaults",
+			expected: &layoutParameters{layout: layoutFlat},
+		},
+		{
+			name:       "namespace layout with quota",
+			parameters: map[string]string{"layout": "namespace", "namespaceQuota": "1Gi"},
+			expected:   &layoutParameters{layout: layoutNamespace, namespaceQuota: 1 << 30},
+		},
+		{
+			name:        "invalid layout",
+			parameters:  map[string]string{"layout": "tree"},
+			expectError: true,
+		},
+		{
+			name:        "invalid quota",
+			parameters:  map[string]string{"layout": "namespace", "namespaceQuota": "-1Gi"},
+			expectError: true,
+		},
+		{
+			name:        "quota without namespace layout",
+			parameters:  map[string]string{"namespaceQuota": "1Gi"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		params, err := parseLayoutParameters(test.parameters)
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, params == nil, "nil parameters")
+			continue
+		}
+		evaluate(t, test.name, false, err, test.expected, params, "parameters")
+	}
+}
+
+func TestDirectoryName(t *testing.T) {
+	options := controller.VolumeOptions{PVName: testPVName, PVC: test.NewClaim("efs", "team-a", "class-1", "1Mi")}
+	flat := &layoutParameters{layout: layoutFlat}
+	evaluate(t, "flat", false, nil, "efs-"+testPVName, flat.directoryName(options), "directory name")
+	namespace := &layoutParameters{layout: layoutNamespace}
+	evaluate(t, "namespace", false, nil, "team-a/efs-"+testPVName, namespace.directoryName(options), "directory name")
+}
+
+func TestPrepareNamespaceQuota(t *testing.T) {
+	defer func(du func(string) (int64, error)) { diskUsage = du }(diskUsage)
+	usage := int64(0)
+	diskUsage = func(dir string) (int64, error) {
+		return usage, nil
+	}
+
+	tests := []struct {
+		name        string
+		usage       int64
+		request     string
+		expectError bool
+	}{
+		{
+			name:    "within quota",
+			usage:   1 << 29,
+			request: "1Mi",
+		},
+		{
+			name:        "quota used up",
+			usage:       1 << 30,
+			request:     "1Mi",
+			expectError: true,
+		},
+		{
+			name:        "request larger than what is left",
+			usage:       1 << 29,
+			request:     "600Mi",
+			expectError: true,
+		},
+	}
+	for _, tc := range tests {
+		func() {
+			tmpDir, err := ioutil.TempDir("", "efs-provisioner-test")
+			if err != nil {
+				t.Fatalf("error creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			usage = tc.usage
+			params := &layoutParameters{layout: layoutNamespace, namespaceQuota: 1 << 30}
+			options := controller.VolumeOptions{PVName: testPVName, PVC: test.NewClaim("efs", "team-a", "class-1", tc.request)}
+			err = params.prepare(tmpDir, options)
+			info, statErr := os.Stat(path.Join(tmpDir, "team-a"))
+			evaluate(t, tc.name, tc.expectError, err, true, statErr == nil && info.IsDir(), "namespace directory exists")
+		}()
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// listedDirectoryAnn is set on the PVs ListVolumes returns, to the name of
+	// the directory the PV stands for. PVs Provision returns don't have it, so
+	// Delete can tell directories without PVs apart.
+	listedDirectoryAnn = "efs.kubernetes.io/listed-directory"
+
+	// archiveDirectory is the directory under the mountpoint that orphaned
+	// directories are moved to when they are archived rather than deleted
+	archiveDirectory = ".archived"
+
+	orphanActionReport  = "report"
+	orphanActionArchive = "archive"
+	orphanActionDelete  = "delete"
+)
+
+// directoryNameRegexp matches the names directoryName gives directories:
+// the claim's name followed by the PV's, which is "pvc-" and the claim's UID
+var directoryNameRegexp = regexp.MustCompile(`^.+-(pvc-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
+
+var _ controller.Lister = &efsProvisioner{}
+
+// ListVolumes returns a PV for every directory under the mountpoint, or under
+// the directory of a namespace, that Provision created, for the controller's
+// orphan reaper to compare against the existing PVs. Directories with other
+// names are not the provisioner's and are left alone.
+func (p *efsProvisioner) ListVolumes() ([]*v1.PersistentVolume, error) {
+	entries, err := ioutil.ReadDir(p.mountpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error listing directories under %s: %v", p.mountpoint, err)
+	}
+
+	volumes := []*v1.PersistentVolume{}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == archiveDirectory {
+			continue
+		}
+		if volume := p.listedVolume(entry.Name()); volume != nil {
+			volumes = append(volumes, volume)
+			continue
+		}
+		// a namespace's directory, if the namespace layout created it
+		dir := path.Join(p.mountpoint, entry.Name())
+		children, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error listing directories under %s: %v", dir, err)
+		}
+		for _, child := range children {
+			if !child.IsDir() {
+				continue
+			}
+			if volume := p.listedVolume(path.Join(entry.Name(), child.Name())); volume != nil {
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+	return volumes, nil
+}
+
+// listedVolume returns a PV for the directory of the given path relative to
+// the mountpoint, or nil if the directory's name isn't one Provision gives
+func (p *efsProvisioner) listedVolume(name string) *v1.PersistentVolume {
+	match := directoryNameRegexp.FindStringSubmatch(path.Base(name))
+	if match == nil {
+		return nil
+	}
+	sourcePath := path.Clean(strings.Replace(p.source, p.dnsName+":", "", 1))
+	return &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: match[1],
+			Annotations: map[string]string{
+				listedDirectoryAnn: name,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server: p.dnsName,
+					Path:   path.Join(sourcePath, name),
+				},
+			},
+		},
+	}
+}
+
+// isListedDirectoryName returns whether name is a path ListVolumes returns:
+// a directory name Provision gives, optionally under a namespace's directory
+func isListedDirectoryName(name string) bool {
+	elements := strings.Split(name, "/")
+	if len(elements) > 2 {
+		return false
+	}
+	for _, element := range elements {
+		if element == "" || element == "." || element == ".." {
+			return false
+		}
+	}
+	return directoryNameRegexp.MatchString(elements[len(elements)-1])
+}
+
+// deleteOrphan deletes, or if archiveOrphans is set archives, the directory
+// of a PV ListVolumes returned. The directory's GID isn't released: the GID
+// tables are built from existing PVs, so it was never taken.
+func (p *efsProvisioner) deleteOrphan(volume *v1.PersistentVolume) error {
+	name := volume.Annotations[listedDirectoryAnn]
+	if !isListedDirectoryName(name) {
+		return fmt.Errorf("invalid directory name %q in annotation %s", name, listedDirectoryAnn)
+	}
+	dir := path.Join(p.mountpoint, name)
+
+	if !p.archiveOrphans {
+		return os.RemoveAll(dir)
+	}
+
+	archive := path.Join(p.mountpoint, archiveDirectory)
+	if err := os.MkdirAll(archive, 0700); err != nil {
+		return fmt.Errorf("error creating archive directory %s: %v", archive, err)
+	}
+	archived := path.Join(archive, name+"-"+time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(path.Dir(archived), 0700); err != nil {
+		return fmt.Errorf("error creating archive directory %s: %v", path.Dir(archived), err)
+	}
+	if err := os.Rename(dir, archived); err != nil {
+		return fmt.Errorf("error archiving %s: %v", dir, err)
+	}
+	glog.Infof("archived orphaned directory %s to %s", dir, archived)
+	return nil
+}
+
+// orphanReaperOption returns the controller option for the given orphan
+// action and whether directories are to be archived rather than deleted
+func orphanReaperOption(period, gracePeriod time.Duration, action string) (func(*controller.ProvisionController) error, bool, error) {
+	switch action {
+	case orphanActionReport:
+		return controller.OrphanReaper(period, gracePeriod, false), false, nil
+	case orphanActionArchive:
+		return controller.OrphanReaper(period, gracePeriod, true), true, nil
+	case orphanActionDelete:
+		return controller.OrphanReaper(period, gracePeriod, true), false, nil
+	}
+	return nil, false, fmt.Errorf("invalid orphan action %q, must be %q, %q or %q", action, orphanActionReport, orphanActionArchive, orphanActionDelete)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const testPVName = "pvc-557b4436-ed73-11e6-84b3-06a700dda5f5"
+
+func TestListVolumes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "efs-provisioner-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	namespacedPVName := "pvc-6f8b2e10-ed73-11e6-84b3-06a700dda5f5"
+	for _, dir := range []string{"efs-" + testPVName, archiveDirectory, path.Join(archiveDirectory, "efs-"+namespacedPVName), "not-a-volume", path.Join("team-a", "efs-"+namespacedPVName)} {
+		if err := os.MkdirAll(path.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("error creating dir: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmpDir, "file-"+testPVName), nil, 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	efsProvisioner := newTestEFSProvisioner()
+	efsProvisioner.mountpoint = tmpDir
+	volumes, err := efsProvisioner.ListVolumes()
+	expected := []*v1.PersistentVolume{
+		{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        testPVName,
+				Annotations: map[string]string{listedDirectoryAnn: "efs-" + testPVName},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					NFS: &v1.NFSVolumeSource{
+						Server: dnsName,
+						Path:   path.Join(source, "efs-"+testPVName),
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        namespacedPVName,
+				Annotations: map[string]string{listedDirectoryAnn: "team-a/efs-" + namespacedPVName},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					NFS: &v1.NFSVolumeSource{
+						Server: dnsName,
+						Path:   path.Join(source, "team-a", "efs-"+namespacedPVName),
+					},
+				},
+			},
+		},
+	}
+	evaluate(t, "list volumes", false, err, expected, volumes, "volumes")
+}
+
+func TestDeleteOrphan(t *testing.T) {
+	tests := []struct {
+		name           string
+		directory      string
+		archiveOrphans bool
+		expectArchived bool
+		expectError    bool
+	}{
+		{
+			name:      "delete",
+			directory: "efs-" + testPVName,
+		},
+		{
+			name:           "archive",
+			directory:      "efs-" + testPVName,
+			archiveOrphans: true,
+			expectArchived: true,
+		},
+		{
+			name:        "not a directory of the provisioner",
+			directory:   "../efs-" + testPVName,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		func() {
+			tmpDir, err := ioutil.TempDir("", "efs-provisioner-test")
+			if err != nil {
+				t.Fatalf("error creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			if err := os.Mkdir(path.Join(tmpDir, "efs-"+testPVName), 0755); err != nil {
+				t.Fatalf("error creating dir: %v", err)
+			}
+
+			efsProvisioner := newTestEFSProvisioner()
+			efsProvisioner.mountpoint = tmpDir
+			efsProvisioner.archiveOrphans = test.archiveOrphans
+			volume := &v1.PersistentVolume{
+				ObjectMeta: v1.ObjectMeta{
+					Name:        testPVName,
+					Annotations: map[string]string{listedDirectoryAnn: test.directory},
+				},
+			}
+			err = efsProvisioner.Delete(volume)
+			_, statErr := os.Stat(path.Join(tmpDir, "efs-"+testPVName))
+			evaluate(t, test.name, test.expectError, err, test.expectError, statErr == nil, "directory exists")
+			archived, _ := ioutil.ReadDir(path.Join(tmpDir, archiveDirectory))
+			evaluate(t, test.name, test.expectError, err, test.expectArchived, len(archived) == 1, "directory archived")
+		}()
+	}
+}
+
+func TestOrphanReaperOption(t *testing.T) {
+	tests := []struct {
+		action        string
+		expectArchive bool
+		expectError   bool
+	}{
+		{action: orphanActionReport},
+		{action: orphanActionArchive, expectArchive: true},
+		{action: orphanActionDelete},
+		{action: "shred", expectError: true},
+	}
+	for _, test := range tests {
+		_, archive, err := orphanReaperOption(time.Minute, time.Minute, test.action)
+		evaluate(t, test.action, test.expectError, err, test.expectArchive, archive, "archive")
+	}
+}
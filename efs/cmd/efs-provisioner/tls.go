@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// mountOptionsAnn is the annotation Kubernetes 1.6+ takes a PV's mount
+	// options from
+	mountOptionsAnn = "volume.beta.kubernetes.io/mount-options"
+	// tlsServerAnn is set on PVs mounted through a local TLS tunnel, whose NFS
+	// server is the tunnel's, to the DNS name of their file system
+	tlsServerAnn = "efs.kubernetes.io/tls-server"
+
+	// tlsTunnelHost is where the TLS tunnel to the file system, e.g. an
+	// stunnel run on every node by a DaemonSet, listens
+	tlsTunnelHost = "127.0.0.1"
+	// defaultTLSPort is the first of the ports efs-utils gives its tunnels
+	defaultTLSPort = 20049
+
+	// efsMountOptions are the NFS options AWS recommends for mounting EFS
+	efsMountOptions = "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport"
+)
+
+// tlsParameters are the encryption in transit options parsed from a
+// StorageClass
+type tlsParameters struct {
+	// encrypt is whether volumes are mounted through a TLS tunnel
+	encrypt bool
+	// port is the port the tunnel listens on, on every node
+	port int
+}
+
+// parseTLSParameters parses the class parameters encryptInTransit and tlsPort,
+// ignoring others
+func parseTLSParameters(parameters map[string]string) (*tlsParameters, error) {
+	params := &tlsParameters{port: defaultTLSPort}
+	portSet := false
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "encryptintransit":
+			encrypt, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: %v", v, k, err)
+			}
+			params.encrypt = encrypt
+		case "tlsport":
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: must be a port number", v, k)
+			}
+			params.port = port
+			portSet = true
+		}
+	}
+	if portSet && !params.encrypt {
+		return nil, fmt.Errorf("parameter tlsPort can only be set if encryptInTransit is true")
+	}
+	return params, nil
+}
+
+// setTLS points the NFS source of the PV at the TLS tunnel listening on each
+// node and annotates the PV with the mount options to reach it, so that the
+// volume is mounted with encryption in transit
+func (params *tlsParameters) setTLS(pv *v1.PersistentVolume) {
+	if !params.encrypt {
+		return
+	}
+	if pv.Annotations == nil {
+		pv.Annotations = map[string]string{}
+	}
+	pv.Annotations[tlsServerAnn] = pv.Spec.NFS.Server
+	pv.Annotations[mountOptionsAnn] = fmt.Sprintf("%s,port=%d", efsMountOptions, params.port)
+	pv.Spec.NFS.Server = tlsTunnelHost
+}
+
+// getNFSSource returns the NFS source of the PV with, if the PV is mounted
+// through a TLS tunnel, the server of its file system rather than the
+// tunnel's
+func getNFSSource(volume *v1.PersistentVolume) *v1.NFSVolumeSource {
+	server, ok := volume.Annotations[tlsServerAnn]
+	if !ok || volume.Spec.NFS == nil {
+		return volume.Spec.NFS
+	}
+	nfs := *volume.Spec.NFS
+	nfs.Server = server
+	return &nfs
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseTLSParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expected    *tlsParameters
+		expectError bool
+	}{
+		{
+			name:       "no parameters",
+			parameters: map[string]string{"gidMin": "40000"},
+			expected:   &tlsParameters{port: defaultTLSPort},
+		},
+		{
+			name:       "encrypt in transit",
+			parameters: map[string]string{"encryptInTransit": "true"},
+			expected:   &tlsParameters{encrypt: true, port: defaultTLSPort},
+		},
+		{
+			name:       "encrypt in transit with port",
+			parameters: map[string]string{"encryptInTransit": "true", "tlsPort": "20050"},
+			expected:   &tlsParameters{encrypt: true, port: 20050},
+		},
+		{
+			name:        "invalid encrypt in transit",
+			parameters:  map[string]string{"encryptInTransit": "yes please"},
+			expectError: true,
+		},
+		{
+			name:        "invalid port",
+			parameters:  map[string]string{"encryptInTransit": "true", "tlsPort": "70000"},
+			expectError: true,
+		},
+		{
+			name:        "port without encrypt in transit",
+			parameters:  map[string]string{"tlsPort": "20050"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		params, err := parseTLSParameters(test.parameters)
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, params == nil, "nil parameters")
+			continue
+		}
+		evaluate(t, test.name, false, err, test.expected, params, "parameters")
+	}
+}
+
+func TestSetTLS(t *testing.T) {
+	tests := []struct {
+		name                 string
+		params               *tlsParameters
+		expectedServer       string
+		expectedAnnotations  map[string]string
+		expectedPathToDelete string
+	}{
+		{
+			name:                 "no encryption",
+			params:               &tlsParameters{port: defaultTLSPort},
+			expectedServer:       dnsName,
+			expectedAnnotations:  map[string]string{},
+			expectedPathToDelete: path.Join(mountpoint, "pv"),
+		},
+		{
+			name:           "encryption",
+			params:         &tlsParameters{encrypt: true, port: 20050},
+			expectedServer: tlsTunnelHost,
+			expectedAnnotations: map[string]string{
+				tlsServerAnn:    dnsName,
+				mountOptionsAnn: "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport,port=20050",
+			},
+			expectedPathToDelete: path.Join(mountpoint, "pv"),
+		},
+	}
+	efsProvisioner := newTestEFSProvisioner()
+	for _, test := range tests {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{}},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					NFS: &v1.NFSVolumeSource{Server: dnsName, Path: path.Join(source, "pv")},
+				},
+			},
+		}
+		test.params.setTLS(pv)
+		evaluate(t, test.name, false, nil, test.expectedServer, pv.Spec.NFS.Server, "server")
+		evaluate(t, test.name, false, nil, test.expectedAnnotations, pv.Annotations, "annotations")
+
+		// the provisioner must still recognize the volume as its own
+		path, err := efsProvisioner.getLocalPathToDelete(getNFSSource(pv))
+		evaluate(t, test.name, false, err, test.expectedPathToDelete, path, "local path to delete")
+	}
+}
@@ -80,6 +80,29 @@ func (a *Allocator) AllocateNext(options controller.VolumeOptions) (int, error)
 	return gid, nil
 }
 
+// ReleaseUnused releases a GID that AllocateNext allocated for the given
+// VolumeOptions but that no PV ended up using, e.g. because provisioning
+// failed after allocating it.
+func (a *Allocator) ReleaseUnused(options controller.VolumeOptions, gid int) error {
+	class := util.GetClaimStorageClass(options.PVC)
+	gidMin, gidMax, err := parseClassParameters(options.Parameters)
+	if err != nil {
+		return err
+	}
+
+	gidTable, err := a.getGidTable(class, gidMin, gidMax)
+	if err != nil {
+		return fmt.Errorf("failed to get gidTable: %v", err)
+	}
+
+	err = gidTable.Release(gid)
+	if err != nil {
+		return fmt.Errorf("failed to release gid %v: %v", gid, err)
+	}
+
+	return nil
+}
+
 // Release releases the given volume's allocated GID from the appropriate GID
 // table.
 func (a *Allocator) Release(volume *v1.PersistentVolume) error {
@@ -201,7 +224,7 @@ func (a *Allocator) collectGids(className string, gidTable *allocator.MinMaxAllo
 
 		_, err = gidTable.Allocate(gid)
 		if err == allocator.ErrConflict {
-			glog.Warningf("gid %v found in pv %v was already allocated", gid)
+			glog.Warningf("gid %v found in pv %v was already allocated", gid, pvName)
 		} else if err != nil {
 			glog.Errorf("failed to store gid %v found in pv '%v': %v", gid, pvName, err)
 			return err
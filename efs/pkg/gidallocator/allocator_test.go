@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gidallocator
+
+import (
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/efs/pkg/util"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestAllocateNext(t *testing.T) {
+	// gid 2000 is taken by an existing PV of the class, 2001 by one of another
+	client := fake.NewSimpleClientset(newVolume("pv-1", "class-1", "2000"), newVolume("pv-2", "class-2", "2001"))
+	a := New(client)
+	options := newOptions("class-1", map[string]string{"gidMin": "2000", "gidMax": "2002"})
+
+	for _, expected := range []int{2001, 2002} {
+		gid, err := a.AllocateNext(options)
+		if err != nil {
+			t.Errorf("error allocating gid: '%v'", err)
+		}
+		if gid != expected {
+			t.Errorf("expected to get gid %d, but got %d", expected, gid)
+		}
+	}
+
+	if _, err := a.AllocateNext(options); err == nil {
+		t.Errorf("expected error allocating gid from full range")
+	}
+
+	if err := a.ReleaseUnused(options, 2001); err != nil {
+		t.Errorf("error releasing gid: '%v'", err)
+	}
+	if gid, err := a.AllocateNext(options); err != nil || gid != 2001 {
+		t.Errorf("expected to get released gid 2001, but got %d: '%v'", gid, err)
+	}
+}
+
+func TestAllocateNextInvalidRange(t *testing.T) {
+	a := New(fake.NewSimpleClientset())
+
+	for _, parameters := range []map[string]string{
+		{"gidMin": "foo"},
+		{"gidMin": "1000"},
+		{"gidMin": "3000", "gidMax": "2000"},
+	} {
+		if _, err := a.AllocateNext(newOptions("class-1", parameters)); err == nil {
+			t.Errorf("expected error allocating gid with parameters %v", parameters)
+		}
+	}
+}
+
+func newVolume(name, className, gid string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				util.StorageClassAnnotation: className,
+				util.VolumeGidAnnotationKey: gid,
+			},
+		},
+	}
+}
+
+func newOptions(className string, parameters map[string]string) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PVName: "pvc-1",
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        "claim-1",
+				Namespace:   v1.NamespaceDefault,
+				Annotations: map[string]string{util.StorageClassAnnotation: className},
+			},
+		},
+		Parameters: parameters,
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	vol "github.com/kubernetes-incubator/external-storage/flex/pkg/volume"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner          = flag.String("provisioner", "example.com/flex", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master               = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig           = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+	execCommand          = flag.String("execCommand", "/opt/storage/flex-provision.sh", "The executable the provisioner calls to provision and delete volumes. It is called with 'provision' or 'delete' as its only argument, a JSON request on stdin, and must write a JSON response to stdout.")
+	execTimeout          = flag.Duration("execTimeout", 5*time.Minute, "How long a call of execCommand may run before it is killed. 0 means forever.")
+	failedRetryThreshold = flag.Int("failed-retry-threshold", 10, "If the number of retries on provisioning failure need to be limited to a set number of attempts. Default 10")
+)
+
+const (
+	resyncPeriod              = 15 * time.Second
+	exponentialBackOffOnError = true
+	leasePeriod               = leaderelection.DefaultLeaseDuration
+	retryPeriod               = leaderelection.DefaultRetryPeriod
+	renewDeadline             = leaderelection.DefaultRenewDeadline
+	termLimit                 = leaderelection.DefaultTermLimit
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if _, err := os.Stat(*execCommand); err != nil {
+		glog.Fatalf("Invalid execCommand %s specified: %v", *execCommand, err)
+	}
+	if *execTimeout < 0 {
+		glog.Fatalf("Invalid execTimeout %v specified: must not be negative", *execTimeout)
+	}
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	flexProvisioner := vol.NewFlexProvisioner(*execCommand, *execTimeout)
+
+	// Start the provision controller which will dynamically provision PVs using
+	// the driver
+	pc := controller.NewProvisionController(clientset, resyncPeriod, *provisioner, flexProvisioner, serverVersion.GitVersion, exponentialBackOffOnError, *failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit)
+	pc.Run(wait.NeverStop)
+}
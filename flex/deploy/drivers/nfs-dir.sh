@@ -0,0 +1,69 @@
+#!/bin/sh
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Example flex provisioner driver. It backs each PV with a directory of an NFS
+# export that is mounted into the provisioner pod at $MOUNT_DIR. The StorageClass
+# must set the "server" and "path" parameters to the export's server and path.
+
+MOUNT_DIR=${MOUNT_DIR:-/export}
+
+respond() {
+	jq -n -c --arg status "$1" --arg message "$2" '{status: $status, message: $message}'
+}
+
+provision() {
+	req=$(cat)
+	pv=$(echo "$req" | jq -r '.pvName')
+	server=$(echo "$req" | jq -r '.parameters.server // empty')
+	path=$(echo "$req" | jq -r '.parameters.path // empty')
+	if [ -z "$server" ] || [ -z "$path" ]; then
+		respond Failure "parameters server and path are required"
+		exit 1
+	fi
+	if ! err=$(mkdir -m 0777 "$MOUNT_DIR/$pv" 2>&1); then
+		respond Failure "$err"
+		exit 1
+	fi
+	jq -n -c --arg server "$server" --arg path "$path/$pv" --arg dir "$pv" \
+		'{status: "Success", volume: {nfs: {server: $server, path: $path}}, annotations: {"flex.example.com/dir": $dir}}'
+}
+
+delete() {
+	req=$(cat)
+	dir=$(echo "$req" | jq -r '.annotations["flex.example.com/dir"] // empty')
+	if [ -z "$dir" ]; then
+		respond Ignored "volume was not provisioned by this driver"
+		exit 0
+	fi
+	if ! err=$(rm -rf "$MOUNT_DIR/$dir" 2>&1); then
+		respond Failure "$err"
+		exit 1
+	fi
+	respond Success ""
+}
+
+case "$1" in
+	provision)
+		provision
+		;;
+	delete)
+		delete
+		;;
+	*)
+		respond "Not supported" "operation $1 is not supported"
+		exit 1
+		;;
+esac
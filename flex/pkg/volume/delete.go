@@ -0,0 +1,49 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Delete calls the driver's delete operation for the volume backing the given
+// PV.
+func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
+	request := &DeleteRequest{
+		APIVersion:  APIVersion,
+		PVName:      volume.Name,
+		Annotations: volume.Annotations,
+		Volume:      volume.Spec.PersistentVolumeSource,
+	}
+
+	res, err := p.driver.Call(operationDelete, request)
+	if err != nil {
+		return err
+	}
+
+	switch res.Status {
+	case StatusSuccess:
+		return nil
+	case StatusIgnored:
+		return &controller.IgnoredError{Reason: fmt.Sprintf("driver ignored delete: %s", res.Message)}
+	default:
+		return fmt.Errorf("driver delete returned status %q: %s", res.Status, res.Message)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// APIVersion is the version of the driver call contract. It is sent to
+	// the driver with every call so that drivers can refuse requests they
+	// don't understand.
+	APIVersion = "v1"
+
+	operationProvision = "provision"
+	operationDelete    = "delete"
+
+	// StatusSuccess is the status a driver returns when the call succeeded.
+	StatusSuccess = "Success"
+	// StatusFailure is the status a driver returns when the call failed.
+	StatusFailure = "Failure"
+	// StatusIgnored is the status a driver returns from delete when the volume
+	// is not its to delete. It is reported to the controller as an
+	// IgnoredError.
+	StatusIgnored = "Ignored"
+	// StatusNotSupported is the status a driver returns when it doesn't
+	// implement the requested operation.
+	StatusNotSupported = "Not supported"
+)
+
+// ClaimInfo is the subset of the claim that a driver is told about.
+type ClaimInfo struct {
+	Namespace   string                          `json:"namespace"`
+	Name        string                          `json:"name"`
+	UID         string                          `json:"uid"`
+	Labels      map[string]string               `json:"labels,omitempty"`
+	Annotations map[string]string               `json:"annotations,omitempty"`
+	AccessModes []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	Selector    *unversioned.LabelSelector      `json:"selector,omitempty"`
+	// Capacity is the requested storage, e.g. "1Gi"
+	Capacity string `json:"capacity"`
+}
+
+// ProvisionRequest is written to the driver's stdin on "provision".
+type ProvisionRequest struct {
+	APIVersion    string                           `json:"apiVersion"`
+	PVName        string                           `json:"pvName"`
+	ReclaimPolicy v1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy"`
+	Parameters    map[string]string                `json:"parameters,omitempty"`
+	Claim         ClaimInfo                        `json:"claim"`
+}
+
+// DeleteRequest is written to the driver's stdin on "delete".
+type DeleteRequest struct {
+	APIVersion string `json:"apiVersion"`
+	PVName     string `json:"pvName"`
+	// Annotations are the ones the driver returned at provision time, plus
+	// whatever else ended up on the PV.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Volume is the PV's volume source, exactly as the driver returned it.
+	Volume v1.PersistentVolumeSource `json:"volume"`
+}
+
+// DriverResponse is read from the driver's stdout after every call.
+type DriverResponse struct {
+	// Status is one of StatusSuccess, StatusFailure, StatusIgnored or
+	// StatusNotSupported
+	Status string `json:"status"`
+	// Message is a human readable reason, surfaced in errors and events
+	Message string `json:"message,omitempty"`
+	// Volume is the volume source of the provisioned PV. Required on a
+	// successful provision.
+	Volume *v1.PersistentVolumeSource `json:"volume,omitempty"`
+	// Capacity optionally overrides the claim's requested capacity, e.g. when
+	// the backend rounds sizes up.
+	Capacity string `json:"capacity,omitempty"`
+	// AccessModes optionally overrides the claim's requested access modes.
+	AccessModes []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// Annotations are put on the PV and handed back to the driver on delete,
+	// so a driver can use them to remember what it created.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Labels are put on the PV.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// driver calls the admin-supplied executable.
+type driver interface {
+	Call(operation string, request interface{}) (*DriverResponse, error)
+}
+
+// execDriver is a driver that executes execCommand with the operation as its
+// only argument, the JSON-encoded request on stdin and expects a
+// JSON-encoded DriverResponse on stdout.
+type execDriver struct {
+	execCommand string
+	// timeout is how long a call may run before the driver is killed. Zero
+	// means forever.
+	timeout time.Duration
+}
+
+var _ driver = &execDriver{}
+
+func newExecDriver(execCommand string, timeout time.Duration) *execDriver {
+	return &execDriver{execCommand: execCommand, timeout: timeout}
+}
+
+func (d *execDriver) Call(operation string, request interface{}) (*DriverResponse, error) {
+	in, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s request: %v", operation, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(d.execCommand, operation)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Run it in its own process group so that on timeout the processes it
+	// started, which would keep stdout and stderr open, are killed with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("driver %s %s failed to start: %v", d.execCommand, operation, err)
+	}
+	var timer *time.Timer
+	if d.timeout > 0 {
+		timer = time.AfterFunc(d.timeout, func() {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+	}
+	cmdErr := cmd.Wait()
+	if timer != nil && !timer.Stop() {
+		return nil, fmt.Errorf("driver %s %s killed after timeout of %v, stderr: %s", d.execCommand, operation, d.timeout, strings.TrimSpace(stderr.String()))
+	}
+
+	res := &DriverResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+		if cmdErr != nil {
+			return nil, fmt.Errorf("driver %s %s failed with error: %v, stderr: %s", d.execCommand, operation, cmdErr, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("driver %s %s had bad output %q: %v", d.execCommand, operation, stdout.String(), err)
+	}
+	if cmdErr != nil && res.Status == StatusSuccess {
+		return nil, fmt.Errorf("driver %s %s reported success but failed with error: %v, stderr: %s", d.execCommand, operation, cmdErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return res, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// NewFlexProvisioner creates a Provisioner that delegates provisioning and
+// deletion to the given executable, killing it if a call runs longer than
+// timeout. Zero means calls may run forever.
+func NewFlexProvisioner(execCommand string, timeout time.Duration) controller.Provisioner {
+	return newFlexProvisionerInternal(newExecDriver(execCommand, timeout))
+}
+
+func newFlexProvisionerInternal(driver driver) *flexProvisioner {
+	return &flexProvisioner{
+		driver: driver,
+	}
+}
+
+type flexProvisioner struct {
+	// The driver to call for provisioning and deleting volumes
+	driver driver
+}
+
+var _ controller.Provisioner = &flexProvisioner{}
+
+// Provision calls the driver's provision operation and returns a PV object
+// for the volume it created.
+func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	request := &ProvisionRequest{
+		APIVersion:    APIVersion,
+		PVName:        options.PVName,
+		ReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+		Parameters:    options.Parameters,
+		Claim: ClaimInfo{
+			Namespace:   options.PVC.Namespace,
+			Name:        options.PVC.Name,
+			UID:         string(options.PVC.UID),
+			Labels:      options.PVC.Labels,
+			Annotations: options.PVC.Annotations,
+			AccessModes: options.PVC.Spec.AccessModes,
+			Selector:    options.PVC.Spec.Selector,
+			Capacity:    capacity.String(),
+		},
+	}
+
+	res, err := p.driver.Call(operationProvision, request)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != StatusSuccess {
+		return nil, fmt.Errorf("driver provision returned status %q: %s", res.Status, res.Message)
+	}
+	if res.Volume == nil {
+		return nil, fmt.Errorf("driver provision returned status %q but no volume", res.Status)
+	}
+
+	if res.Capacity != "" {
+		capacity, err = resource.ParseQuantity(res.Capacity)
+		if err != nil {
+			p.cleanup(options.PVName, res)
+			return nil, fmt.Errorf("driver provision returned invalid capacity %q: %v", res.Capacity, err)
+		}
+	}
+	accessModes := options.PVC.Spec.AccessModes
+	if len(res.AccessModes) != 0 {
+		accessModes = res.AccessModes
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        options.PVName,
+			Labels:      res.Labels,
+			Annotations: res.Annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   accessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: *res.Volume,
+		},
+	}
+
+	return pv, nil
+}
+
+// cleanup asks the driver to delete a volume it provisioned but which can't
+// be turned into a PV.
+func (p *flexProvisioner) cleanup(pvName string, res *DriverResponse) {
+	request := &DeleteRequest{
+		APIVersion:  APIVersion,
+		PVName:      pvName,
+		Annotations: res.Annotations,
+		Volume:      *res.Volume,
+	}
+	if _, err := p.driver.Call(operationDelete, request); err != nil {
+		glog.Errorf("error cleaning up volume %s after failed provision, please delete manually: %v", pvName, err)
+	}
+}
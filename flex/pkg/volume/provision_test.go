@@ -0,0 +1,260 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/pkg/util/testing"
+)
+
+func TestProvision(t *testing.T) {
+	nfs := &v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "foo", Path: "/bar"}}
+
+	tests := []struct {
+		name             string
+		response         *DriverResponse
+		callErr          error
+		expectedSource   v1.PersistentVolumeSource
+		expectedCapacity resource.Quantity
+		expectedModes    []v1.PersistentVolumeAccessMode
+		expectedAnns     map[string]string
+		expectDelete     bool
+		expectError      bool
+	}{
+		{
+			name:             "succeed",
+			response:         &DriverResponse{Status: StatusSuccess, Volume: nfs, Annotations: map[string]string{"foo": "bar"}},
+			expectedSource:   *nfs,
+			expectedCapacity: resource.MustParse("1Ki"),
+			expectedModes:    []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			expectedAnns:     map[string]string{"foo": "bar"},
+		},
+		{
+			name:             "driver overrides capacity and access modes",
+			response:         &DriverResponse{Status: StatusSuccess, Volume: nfs, Capacity: "1Gi", AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}},
+			expectedSource:   *nfs,
+			expectedCapacity: resource.MustParse("1Gi"),
+			expectedModes:    []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+		},
+		{
+			name:        "driver fails",
+			response:    &DriverResponse{Status: StatusFailure, Message: "no space"},
+			expectError: true,
+		},
+		{
+			name:        "driver succeeds without a volume",
+			response:    &DriverResponse{Status: StatusSuccess},
+			expectError: true,
+		},
+		{
+			name:         "driver returns bad capacity",
+			response:     &DriverResponse{Status: StatusSuccess, Volume: nfs, Capacity: "lots"},
+			expectDelete: true,
+			expectError:  true,
+		},
+		{
+			name:        "driver call errors",
+			callErr:     errors.New("fake error"),
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		d := &testDriver{response: test.response, err: test.callErr}
+		p := newFlexProvisionerInternal(d)
+
+		pv, err := p.Provision(newOptions("pvc-1", resource.MustParse("1Ki")))
+
+		deleted := len(d.calls) == 2 && d.calls[1] == operationDelete
+		evaluate(t, test.name, false, nil, test.expectDelete, deleted, "cleanup delete")
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, pv == nil, "nil pv")
+			continue
+		}
+		evaluate(t, test.name, false, err, "pvc-1", pv.Name, "pv name")
+		evaluate(t, test.name, false, err, test.expectedSource, pv.Spec.PersistentVolumeSource, "volume source")
+		capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		evaluate(t, test.name, false, err, test.expectedCapacity.String(), capacity.String(), "capacity")
+		evaluate(t, test.name, false, err, test.expectedModes, pv.Spec.AccessModes, "access modes")
+		evaluate(t, test.name, false, err, test.expectedAnns, pv.Annotations, "annotations")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      *DriverResponse
+		expectIgnored bool
+		expectError   bool
+	}{
+		{
+			name:     "succeed",
+			response: &DriverResponse{Status: StatusSuccess},
+		},
+		{
+			name:          "driver ignores",
+			response:      &DriverResponse{Status: StatusIgnored, Message: "not mine"},
+			expectIgnored: true,
+			expectError:   true,
+		},
+		{
+			name:        "driver fails",
+			response:    &DriverResponse{Status: StatusFailure},
+			expectError: true,
+		},
+		{
+			name:        "driver doesn't support delete",
+			response:    &DriverResponse{Status: StatusNotSupported},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p := newFlexProvisionerInternal(&testDriver{response: test.response})
+
+		err := p.Delete(&v1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "pvc-1"}})
+
+		_, ignored := err.(*controller.IgnoredError)
+		evaluate(t, test.name, test.expectError, err, test.expectIgnored, ignored, "ignored")
+	}
+}
+
+func TestExecDriver(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("flexProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name           string
+		script         string
+		timeout        time.Duration
+		expectedStatus string
+		expectError    bool
+	}{
+		{
+			name:           "driver reads request and succeeds",
+			script:         "#!/bin/sh\ngrep -q '\"pvName\":\"pvc-1\"' && [ \"$1\" = provision ] && echo '{\"status\": \"Success\"}'\n",
+			expectedStatus: StatusSuccess,
+		},
+		{
+			name:           "driver fails with a response",
+			script:         "#!/bin/sh\necho '{\"status\": \"Failure\", \"message\": \"oops\"}'\nexit 1\n",
+			expectedStatus: StatusFailure,
+		},
+		{
+			name:        "driver fails without a response",
+			script:      "#!/bin/sh\necho oops >&2\nexit 1\n",
+			expectError: true,
+		},
+		{
+			name:        "driver claims success but fails",
+			script:      "#!/bin/sh\necho '{\"status\": \"Success\"}'\nexit 1\n",
+			expectError: true,
+		},
+		{
+			name:        "driver writes garbage",
+			script:      "#!/bin/sh\necho oops\n",
+			expectError: true,
+		},
+		{
+			name:           "driver finishes within timeout",
+			script:         "#!/bin/sh\necho '{\"status\": \"Success\"}'\n",
+			timeout:        time.Minute,
+			expectedStatus: StatusSuccess,
+		},
+		{
+			name:        "driver times out",
+			script:      "#!/bin/sh\nsleep 60\necho '{\"status\": \"Success\"}'\n",
+			timeout:     100 * time.Millisecond,
+			expectError: true,
+		},
+	}
+	for i, test := range tests {
+		script := path.Join(tmpDir, "driver-"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(script, []byte(test.script), 0755); err != nil {
+			t.Fatalf("Error writing file %s: %v", script, err)
+		}
+		d := newExecDriver(script, test.timeout)
+
+		res, err := d.Call(operationProvision, &ProvisionRequest{APIVersion: APIVersion, PVName: "pvc-1"})
+
+		var status string
+		if res != nil {
+			status = res.Status
+		}
+		evaluate(t, test.name, test.expectError, err, test.expectedStatus, status, "status")
+	}
+}
+
+func newOptions(pvName string, capacity resource.Quantity) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{Name: "claim-1", Namespace: v1.NamespaceDefault},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): capacity,
+					},
+				},
+			},
+		},
+		Parameters: map[string]string{},
+	}
+}
+
+type testDriver struct {
+	response *DriverResponse
+	err      error
+	calls    []string
+}
+
+var _ driver = &testDriver{}
+
+func (d *testDriver) Call(operation string, request interface{}) (*DriverResponse, error) {
+	d.calls = append(d.calls, operation)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if operation == operationDelete && len(d.calls) > 1 {
+		return &DriverResponse{Status: StatusSuccess}, nil
+	}
+	return d.response, nil
+}
+
+func evaluate(t *testing.T, name string, expectError bool, err error, expected interface{}, got interface{}, output string) {
+	if !expectError && err != nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("unexpected error getting %s: %v", output, err)
+	} else if expectError && err == nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected error but got %s: %v", output, got)
+	} else if !reflect.DeepEqual(expected, got) {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/util/goroutinemap"
+)
+
+// claimBackoffJitter is the fraction of a claim's backoff delay that is added
+// to it at random, so claims that failed together don't retry together
+const claimBackoffJitter = 0.5
+
+// ClaimBackoff returns an option for NewProvisionController that makes the
+// controller back off exponentially from provisioning for a claim that
+// failed: it waits initialDelay after the first failure, twice that after the
+// second and so on up to maxDelay, plus up to half of the delay at random.
+// It supersedes NewProvisionController's exponentialBackOffOnError, which
+// only backs off while the failed operation is remembered.
+func ClaimBackoff(initialDelay, maxDelay time.Duration) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if initialDelay <= 0 {
+			return errors.New("initial claim backoff delay must be positive")
+		}
+		if maxDelay < initialDelay {
+			return fmt.Errorf("maximum claim backoff delay %v is less than initial delay %v", maxDelay, initialDelay)
+		}
+		c.claimBackoffInitial = initialDelay
+		c.claimBackoffMax = maxDelay
+		c.runningOperations = goroutinemap.NewGoRoutineMap(false)
+		return nil
+	}
+}
+
+// claimFailures is what the controller remembers about the failures to
+// provision for a claim
+type claimFailures struct {
+	// number of consecutive failures
+	count int
+	// whether the last failure was a TerminalError or a RetryableError
+	terminal  bool
+	retryable bool
+	// when provisioning may be retried, zero without ClaimBackoff
+	retryAfter time.Time
+	// the claim, less its leader election record, and the resource version
+	// of its class at the last failure, to tell whether they were edited
+	claim        *v1.PersistentVolumeClaim
+	classVersion string
+}
+
+// backoffDelay returns how long to wait before retrying after the given
+// number of consecutive failures
+func (ctrl *ProvisionController) backoffDelay(count int) time.Duration {
+	delay := ctrl.claimBackoffInitial
+	for i := 1; i < count && delay < ctrl.claimBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > ctrl.claimBackoffMax {
+		delay = ctrl.claimBackoffMax
+	}
+	return wait.Jitter(delay, claimBackoffJitter)
+}
+
+// newClaimFailures returns failures that follow the given previous ones,
+// which may be nil, for the claim failing with err
+func (ctrl *ProvisionController) newClaimFailures(claim *v1.PersistentVolumeClaim, previous *claimFailures, err error) *claimFailures {
+	failures := &claimFailures{count: 1}
+	switch err.(type) {
+	case *TerminalError:
+		failures.terminal = true
+	case *RetryableError:
+		failures.retryable = true
+	}
+	if previous != nil {
+		failures.count = previous.count + 1
+	}
+	if ctrl.claimBackoffInitial > 0 {
+		failures.retryAfter = time.Now().Add(ctrl.backoffDelay(failures.count))
+	}
+	if stripped, err := ctrl.removeRecord(claim); err == nil {
+		failures.claim = stripped
+	}
+	failures.classVersion = ctrl.classVersion(claim)
+	return failures
+}
+
+// edited returns whether the claim or its class changed since the failures
+func (ctrl *ProvisionController) edited(claim *v1.PersistentVolumeClaim, failures *claimFailures) bool {
+	if failures.claim == nil {
+		return false
+	}
+	stripped, err := ctrl.removeRecord(claim)
+	if err != nil {
+		return false
+	}
+	if !reflect.DeepEqual(stripped.Spec, failures.claim.Spec) ||
+		!reflect.DeepEqual(stripped.Labels, failures.claim.Labels) ||
+		!reflect.DeepEqual(stripped.Annotations, failures.claim.Annotations) {
+		return true
+	}
+	return ctrl.classVersion(claim) != failures.classVersion
+}
+
+// classVersion returns the resource version of the claim's class, empty if
+// it doesn't exist
+func (ctrl *ProvisionController) classVersion(claim *v1.PersistentVolumeClaim) string {
+	class, err := ctrl.getStorageClass(ctrl.getClaimClass(claim))
+	if err != nil {
+		return ""
+	}
+	return class.ResourceVersion
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+)
+
+// annSelectedNode is added to a claim by the scheduler when it has picked the
+// node a pod using the claim runs on, if the claim's class has volume binding
+// mode WaitForFirstConsumer. Its value is the node's name.
+const annSelectedNode = "volume.kubernetes.io/selected-node"
+
+// volumeBindingWaitForFirstConsumer is the volume binding mode of classes
+// whose volumes are only provisioned once a pod using the claim is scheduled
+const volumeBindingWaitForFirstConsumer = "WaitForFirstConsumer"
+
+// DelayedBinding returns an option for NewProvisionController that makes the
+// controller honour the volumeBindingMode of StorageClasses: for claims of
+// classes with mode WaitForFirstConsumer it doesn't provision until the
+// scheduler has selected a node for them. The node is passed to Provision in
+// VolumeOptions.SelectedNode whether or not this option is set. The client-go
+// the library depends on predates volumeBindingMode, so the controller reads
+// it from the JSON of each class, once per version of the class.
+func DelayedBinding() func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		c.delayedBinding = true
+		c.bindingModes = make(map[string]classBindingMode)
+		c.bindingModesMutex = &sync.Mutex{}
+		c.getRawClass = func(name string) ([]byte, error) {
+			return c.client.Storage().RESTClient().Get().Resource("storageclasses").Name(name).DoRaw()
+		}
+		return nil
+	}
+}
+
+// classBindingMode is the volume binding mode of a version of a class
+type classBindingMode struct {
+	resourceVersion string
+	mode            string
+}
+
+// waitsForFirstConsumer returns whether claims of the class must wait for the
+// scheduler to select a node before being provisioned, always false if
+// delayed binding isn't enabled
+func (ctrl *ProvisionController) waitsForFirstConsumer(class *v1beta1.StorageClass) (bool, error) {
+	if !ctrl.delayedBinding {
+		return false, nil
+	}
+	mode, err := ctrl.getVolumeBindingMode(class)
+	if err != nil {
+		return false, err
+	}
+	return mode == volumeBindingWaitForFirstConsumer, nil
+}
+
+func (ctrl *ProvisionController) getVolumeBindingMode(class *v1beta1.StorageClass) (string, error) {
+	ctrl.bindingModesMutex.Lock()
+	defer ctrl.bindingModesMutex.Unlock()
+
+	if cached, ok := ctrl.bindingModes[class.Name]; ok && cached.resourceVersion == class.ResourceVersion {
+		return cached.mode, nil
+	}
+
+	data, err := ctrl.getRawClass(class.Name)
+	if err != nil {
+		return "", fmt.Errorf("error getting StorageClass %q: %v", class.Name, err)
+	}
+	var raw struct {
+		VolumeBindingMode string `json:"volumeBindingMode"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return "", fmt.Errorf("error decoding StorageClass %q: %v", class.Name, err)
+	}
+	ctrl.bindingModes[class.Name] = classBindingMode{resourceVersion: class.ResourceVersion, mode: raw.VolumeBindingMode}
+	return raw.VolumeBindingMode, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+)
+
+// Capabilities are what a provisioner supports, for the controller to check
+// StorageClasses and claims against before calling the provisioner
+type Capabilities struct {
+	// The StorageClass parameters the provisioner understands, matched
+	// ignoring case. Nil means any.
+	Parameters []string
+	// The access modes claims may request. Nil means any.
+	AccessModes []v1.PersistentVolumeAccessMode
+	// The range of sizes claims may request, zero if unbounded. Where the
+	// MinimumVolumeSize or MaximumVolumeSize option is also passed, the
+	// narrower range applies.
+	MinimumVolumeSize, MaximumVolumeSize resource.Quantity
+}
+
+// CapabilityAdvertiser is an optional interface for a Provisioner to implement
+// so that the controller can find classes and claims it can't serve early:
+// StorageClasses of the provisioner with parameters it doesn't understand get
+// a warning event whenever they are added or updated, including when the
+// controller starts, and claims requesting access modes or sizes it doesn't
+// support get a ProvisioningFailed event instead of a confusing error from
+// Provision.
+type CapabilityAdvertiser interface {
+	// Capabilities returns what the provisioner supports. It is called once
+	// when the controller is created.
+	Capabilities() Capabilities
+}
+
+// applyCapabilities records the provisioner's capabilities, if it advertises
+// any, narrowing the range of volume sizes to provision to theirs
+func (ctrl *ProvisionController) applyCapabilities() error {
+	advertiser, ok := ctrl.provisioner.(CapabilityAdvertiser)
+	if !ok {
+		return nil
+	}
+	capabilities := advertiser.Capabilities()
+	ctrl.capabilities = &capabilities
+
+	if min := capabilities.MinimumVolumeSize; !min.IsZero() && (ctrl.minimumVolumeSize.IsZero() || min.Cmp(ctrl.minimumVolumeSize) > 0) {
+		ctrl.minimumVolumeSize = min
+	}
+	if max := capabilities.MaximumVolumeSize; !max.IsZero() && (ctrl.maximumVolumeSize.IsZero() || max.Cmp(ctrl.maximumVolumeSize) < 0) {
+		ctrl.maximumVolumeSize = max
+	}
+	if !ctrl.minimumVolumeSize.IsZero() && !ctrl.maximumVolumeSize.IsZero() && ctrl.minimumVolumeSize.Cmp(ctrl.maximumVolumeSize) > 0 {
+		return fmt.Errorf("minimum volume size %s is greater than maximum volume size %s", ctrl.minimumVolumeSize.String(), ctrl.maximumVolumeSize.String())
+	}
+	return nil
+}
+
+// addClass validates a class added to, or updated in, the informer's store
+func (ctrl *ProvisionController) addClass(obj interface{}) {
+	class, ok := obj.(*v1beta1.StorageClass)
+	if !ok {
+		glog.Errorf("Expected StorageClass but handler received %+v", obj)
+		return
+	}
+	if class.Provisioner != ctrl.provisionerName {
+		return
+	}
+	if err := ctrl.validateClass(class); err != nil {
+		glog.Warningf("StorageClass %q is invalid: %v", class.Name, err)
+		ctrl.eventRecorder.Event(class, v1.EventTypeWarning, "InvalidStorageClass", err.Error())
+	}
+}
+
+func (ctrl *ProvisionController) updateClass(oldObj, newObj interface{}) {
+	ctrl.addClass(newObj)
+}
+
+// validateClass returns an error naming the class' parameters the provisioner
+// doesn't understand, if any
+func (ctrl *ProvisionController) validateClass(class *v1beta1.StorageClass) error {
+	if ctrl.capabilities == nil || ctrl.capabilities.Parameters == nil {
+		return nil
+	}
+	unknown := []string{}
+	for k := range ClassParameters(class) {
+		known := false
+		for _, parameter := range ctrl.capabilities.Parameters {
+			if strings.EqualFold(k, parameter) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("parameters %s are not supported by provisioner %q", strings.Join(unknown, ", "), ctrl.provisionerName)
+	}
+	return nil
+}
+
+// checkAccessModes returns an error if the claim requests an access mode the
+// provisioner doesn't support
+func (ctrl *ProvisionController) checkAccessModes(claim *v1.PersistentVolumeClaim) error {
+	if ctrl.capabilities == nil || ctrl.capabilities.AccessModes == nil {
+		return nil
+	}
+	for _, mode := range claim.Spec.AccessModes {
+		supported := false
+		for _, m := range ctrl.capabilities.AccessModes {
+			if mode == m {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("requested access mode %s is not supported by provisioner %q", mode, ctrl.provisionerName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/types"
+	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// storageV1GroupVersion is the group version of GA StorageClasses, served by
+// Kubernetes 1.6 and later
+const storageV1GroupVersion = "storage.k8s.io/v1"
+
+// The client-go the library depends on predates Kubernetes 1.6: its claims
+// have no spec.storageClassName and it has no storage.k8s.io/v1 client. So,
+// like the volume binding mode of classes (see DelayedBinding), both are read
+// from the JSON the API server returns.
+
+// claimClassName is the spec.storageClassName of a version of a claim
+type claimClassName struct {
+	resourceVersion string
+	class           string
+}
+
+// getClaimClass returns name of class that is requested by given claim: its
+// beta annotation or, if it has none and the server is 1.6 or later, its
+// spec.storageClassName. Request for `nil` class is interpreted as request
+// for class "", i.e. for a classless PV.
+func (ctrl *ProvisionController) getClaimClass(claim *v1.PersistentVolumeClaim) string {
+	if class, found := claim.Annotations[annClass]; found {
+		return class
+	}
+	if ctrl.getRawClaim == nil {
+		return ""
+	}
+	class, err := ctrl.getClaimClassName(claim)
+	if err != nil {
+		glog.Errorf("Error getting storageClassName of claim %q: %v", claimToClaimKey(claim), err)
+		return ""
+	}
+	return class
+}
+
+// getClaimClassName returns the claim's spec.storageClassName, fetched once
+// per version of the claim
+func (ctrl *ProvisionController) getClaimClassName(claim *v1.PersistentVolumeClaim) (string, error) {
+	ctrl.claimClassesMutex.Lock()
+	defer ctrl.claimClassesMutex.Unlock()
+
+	if cached, ok := ctrl.claimClasses[claim.UID]; ok && cached.resourceVersion == claim.ResourceVersion {
+		return cached.class, nil
+	}
+
+	data, err := ctrl.getRawClaim(claim.Namespace, claim.Name)
+	if err != nil {
+		return "", err
+	}
+	var raw struct {
+		Metadata struct {
+			UID             types.UID `json:"uid"`
+			ResourceVersion string    `json:"resourceVersion"`
+		} `json:"metadata"`
+		Spec struct {
+			StorageClassName string `json:"storageClassName"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return "", fmt.Errorf("error decoding claim: %v", err)
+	}
+	// The claim fetched may be a newer version than the one the informer
+	// handed us, or a new claim of the same name
+	if raw.Metadata.UID != claim.UID {
+		return "", fmt.Errorf("claim was deleted")
+	}
+	ctrl.claimClasses[claim.UID] = claimClassName{resourceVersion: raw.Metadata.ResourceVersion, class: raw.Spec.StorageClassName}
+	return raw.Spec.StorageClassName, nil
+}
+
+// forgetClaimClass drops the cached spec.storageClassName of a deleted claim
+func (ctrl *ProvisionController) forgetClaimClass(claim *v1.PersistentVolumeClaim) {
+	if ctrl.getRawClaim == nil {
+		return
+	}
+	ctrl.claimClassesMutex.Lock()
+	defer ctrl.claimClassesMutex.Unlock()
+	delete(ctrl.claimClasses, claim.UID)
+}
+
+// servesStorageV1 returns whether the server serves StorageClasses from
+// storage.k8s.io/v1
+func servesStorageV1(client kubernetes.Interface) bool {
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(storageV1GroupVersion)
+	if err != nil || resources == nil {
+		return false
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == "storageclasses" {
+			return true
+		}
+	}
+	return false
+}
+
+// classSource lists and watches StorageClasses from storage.k8s.io/v1 if the
+// server serves it and from storage.k8s.io/v1beta1 otherwise. The group is
+// picked again on every list, i.e. whenever the watch is restarted from
+// scratch, so an upgraded server is noticed. v1 classes are decoded into
+// v1beta1.StorageClass, whose fields are a subset of theirs.
+type classSource struct {
+	client kubernetes.Interface
+
+	mutex sync.Mutex
+	// whether the last list was from storage.k8s.io/v1
+	fromV1 bool
+}
+
+var _ cache.ListerWatcher = &classSource{}
+
+func newClassSource(client kubernetes.Interface) cache.ListerWatcher {
+	return &classSource{client: client}
+}
+
+func (s *classSource) List(options api.ListOptions) (runtime.Object, error) {
+	fromV1 := servesStorageV1(s.client)
+	s.mutex.Lock()
+	s.fromV1 = fromV1
+	s.mutex.Unlock()
+
+	if !fromV1 {
+		var out v1.ListOptions
+		v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+		return s.client.Storage().StorageClasses().List(out)
+	}
+	data, err := s.request(options).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	list := &v1beta1.StorageClassList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("error decoding %s StorageClasses: %v", storageV1GroupVersion, err)
+	}
+	return list, nil
+}
+
+func (s *classSource) Watch(options api.ListOptions) (watch.Interface, error) {
+	s.mutex.Lock()
+	fromV1 := s.fromV1
+	s.mutex.Unlock()
+
+	if !fromV1 {
+		var out v1.ListOptions
+		v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+		return s.client.Storage().StorageClasses().Watch(out)
+	}
+	stream, err := s.request(options).Param("watch", "true").Stream()
+	if err != nil {
+		return nil, err
+	}
+	return watch.NewStreamWatcher(&classWatchDecoder{stream: stream, decoder: json.NewDecoder(stream)}), nil
+}
+
+// request returns a request for storage.k8s.io/v1 StorageClasses with the
+// options the reflector sets
+func (s *classSource) request(options api.ListOptions) *rest.Request {
+	req := s.client.Storage().RESTClient().Get().AbsPath("/apis", storageV1GroupVersion, "storageclasses")
+	if options.ResourceVersion != "" {
+		req = req.Param("resourceVersion", options.ResourceVersion)
+	}
+	if options.TimeoutSeconds != nil {
+		req = req.Param("timeoutSeconds", strconv.FormatInt(*options.TimeoutSeconds, 10))
+	}
+	return req
+}
+
+// classWatchDecoder decodes the events of a watch of storage.k8s.io/v1
+// StorageClasses
+type classWatchDecoder struct {
+	stream  io.ReadCloser
+	decoder *json.Decoder
+}
+
+var _ watch.Decoder = &classWatchDecoder{}
+
+func (d *classWatchDecoder) Decode() (watch.EventType, runtime.Object, error) {
+	var event struct {
+		Type   watch.EventType `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := d.decoder.Decode(&event); err != nil {
+		return "", nil, err
+	}
+	switch event.Type {
+	case watch.Added, watch.Modified, watch.Deleted:
+		class := &v1beta1.StorageClass{}
+		if err := json.Unmarshal(event.Object, class); err != nil {
+			return "", nil, fmt.Errorf("error decoding %s StorageClass: %v", storageV1GroupVersion, err)
+		}
+		return event.Type, class, nil
+	case watch.Error:
+		status := &unversioned.Status{}
+		if err := json.Unmarshal(event.Object, status); err != nil {
+			return "", nil, fmt.Errorf("error decoding watch error: %v", err)
+		}
+		return event.Type, status, nil
+	}
+	return "", nil, fmt.Errorf("unexpected watch event type %q", event.Type)
+}
+
+func (d *classWatchDecoder) Close() {
+	d.stream.Close()
+}
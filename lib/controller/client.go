@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ClientOptions are the options of the API client a provisioner passes to
+// NewProvisionController. The default 5 QPS and burst of 10 of client-go are
+// too few for a controller provisioning and deleting hundreds of volumes, which
+// then spends its time waiting on the rate limiter while claims pile up.
+type ClientOptions struct {
+	// Master URL and kubeconfig path to build the client config from. If both
+	// are empty, the client is configured from the in-cluster service account.
+	Master     string
+	Kubeconfig string
+	// QPS and Burst of the client's rate limiter, client-go's defaults if 0
+	QPS   float32
+	Burst int
+	// Timeout of each API request, none if 0
+	Timeout time.Duration
+}
+
+// AddFlags adds the flags kube-api-qps, kube-api-burst and kube-api-timeout
+// for the options to the flag set. Provisioners already define master and
+// kubeconfig flags of their own.
+func (o *ClientOptions) AddFlags(fs *flag.FlagSet) {
+	fs.Var(float32Value{&o.QPS}, "kube-api-qps", "QPS of the API client. If 0, client-go's default of 5 is used.")
+	fs.IntVar(&o.Burst, "kube-api-burst", 0, "Burst of the API client. If 0, client-go's default of 10 is used.")
+	fs.DurationVar(&o.Timeout, "kube-api-timeout", 0, "Timeout of each API request. If 0, requests don't time out.")
+}
+
+// OutOfCluster returns whether the options configure a client for running out
+// of cluster
+func (o *ClientOptions) OutOfCluster() bool {
+	return o.Master != "" || o.Kubeconfig != ""
+}
+
+// NewClientConfig returns the client config for the options. Its user agent is
+// tagged with the provisioner's name and version, e.g.
+// "example.com/nfs/v1.0.8 (linux/amd64) kubernetes/1bd8b09", so that API
+// audit logs tell provisioners apart.
+func NewClientConfig(options ClientOptions, provisionerName, version string) (*rest.Config, error) {
+	if options.QPS < 0 || options.Burst < 0 || options.Timeout < 0 {
+		return nil, fmt.Errorf("invalid client options: QPS %v, burst %d and timeout %v must not be negative", options.QPS, options.Burst, options.Timeout)
+	}
+	var config *rest.Config
+	var err error
+	if options.OutOfCluster() {
+		config, err = clientcmd.BuildConfigFromFlags(options.Master, options.Kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating client config: %v", err)
+	}
+	config.QPS = options.QPS
+	config.Burst = options.Burst
+	config.Timeout = options.Timeout
+	config.UserAgent = userAgent(provisionerName, version)
+	return config, nil
+}
+
+// NewClient returns a client for the options, see NewClientConfig
+func NewClient(options ClientOptions, provisionerName, version string) (kubernetes.Interface, error) {
+	config, err := NewClientConfig(options, provisionerName, version)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %v", err)
+	}
+	return client, nil
+}
+
+// userAgent returns client-go's default user agent with its binary name
+// replaced by the provisioner's name and version
+func userAgent(provisionerName, version string) string {
+	if version == "" {
+		version = "unknown"
+	}
+	// The default is "<binary>/<version> (<os>/<arch>) kubernetes/<commit>"
+	defaultAgent := rest.DefaultKubernetesUserAgent()
+	if i := strings.Index(defaultAgent, " "); i >= 0 {
+		return provisionerName + "/" + version + defaultAgent[i:]
+	}
+	return provisionerName + "/" + version
+}
+
+// float32Value is a flag.Value for a float32
+type float32Value struct {
+	value *float32
+}
+
+func (f float32Value) String() string {
+	if f.value == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(*f.value), 'g', -1, 32)
+}
+
+func (f float32Value) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return err
+	}
+	*f.value = float32(v)
+	return nil
+}
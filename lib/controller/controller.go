@@ -17,7 +17,9 @@ limitations under the License.
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os/exec"
 	"reflect"
 	"strings"
@@ -25,17 +27,23 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/metrics"
 	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
 	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/client-go/kubernetes"
 	core_v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/pkg/api"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/resource"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/storage/v1beta1"
 	"k8s.io/client-go/pkg/fields"
-	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/labels"
 	"k8s.io/client-go/pkg/types"
+	"k8s.io/client-go/pkg/util/sets"
 	"k8s.io/client-go/pkg/util/uuid"
+	"k8s.io/client-go/pkg/util/wait"
 	"k8s.io/client-go/pkg/version"
 	"k8s.io/client-go/pkg/watch"
 	"k8s.io/client-go/tools/cache"
@@ -92,12 +100,12 @@ type ProvisionController struct {
 	// provisioning is officially supported
 	is1dot4 bool
 
-	claimSource      cache.ListerWatcher
-	claimController  *cache.Controller
-	volumeSource     cache.ListerWatcher
-	volumeController *cache.Controller
-	classSource      cache.ListerWatcher
-	classReflector   *cache.Reflector
+	// Source of claims for watching a single claim's provisioning
+	claimSource cache.ListerWatcher
+
+	// Shared informers the control loops are driven by. They may be shared
+	// with other controllers, see the Informers option.
+	informers *InformerFactory
 
 	volumes cache.Store
 	claims  cache.Store
@@ -130,12 +138,209 @@ type ProvisionController struct {
 	failedRetryThreshold int
 
 	// map of failed claims
-	failedClaimsStats map[types.UID]int
+	failedClaimsStats map[types.UID]*claimFailures
 
 	failedClaimsStatsMutex *sync.Mutex
+
+	// Delays to back off from failed claims with, see the ClaimBackoff
+	// option. Zero to retry on every resync
+	claimBackoffInitial, claimBackoffMax time.Duration
+
+	// Whether to do leader election on the provisioner name so that only one
+	// of many controllers running with the same name runs its control loops
+	leaderElection bool
+
+	// The namespace of the endpoints object used as the leader election lock
+	leaderElectionNamespace string
+
+	// Journal of in-flight provisioning operations, nil if disabled
+	journal Journal
+
+	// The address to serve metrics on, empty if not serving them
+	metricsAddress string
+
+	// How often to look for orphaned storage assets, 0 if not looking
+	orphanReaperPeriod time.Duration
+	// How long an asset must be without a PV to be an orphan
+	orphanGracePeriod time.Duration
+	// Whether to delete orphans rather than only report them
+	deleteOrphans bool
+	// When the reaper first saw each asset without a PV, by PV name
+	orphansFirstSeen map[string]time.Time
+
+	// The range of sizes of claims to provision for, zero if unbounded
+	minimumVolumeSize, maximumVolumeSize resource.Quantity
+
+	// Whether to wait for a node to be selected for claims of classes with
+	// volume binding mode WaitForFirstConsumer
+	delayedBinding bool
+	// Volume binding modes of classes, by class name, and how to get a
+	// class' JSON to read its mode from
+	bindingModes      map[string]classBindingMode
+	bindingModesMutex *sync.Mutex
+	getRawClass       func(name string) ([]byte, error)
+
+	// The spec.storageClassName of claims without the beta class annotation,
+	// by UID. getRawClaim is nil if the server predates 1.6 and was never
+	// sent one.
+	claimClasses      map[types.UID]claimClassName
+	claimClassesMutex *sync.Mutex
+	getRawClaim       func(namespace, name string) ([]byte, error)
+
+	// The namespaces to provision for and not to, and the labels claims
+	// must match, nil if not filtering
+	namespaces, excludedNamespaces sets.String
+	claimSelector                  labels.Selector
+
+	// Whether to provision for pending claims listed from the API server
+	// every resyncPeriod, and how long a claim must be pending to be stuck
+	pendingClaimResync bool
+	stuckPendingAfter  time.Duration
+
+	// Hooks to pass the options of volumes through before provisioning them
+	preProvisionHooks []PreProvisionHook
+
+	// How long a controller owns a claim it annotated as its own, 0 to elect
+	// a leader for each claim instead
+	claimOwnershipTimeout time.Duration
+
+	// What the provisioner supports, nil unless it implements
+	// CapabilityAdvertiser
+	capabilities *Capabilities
+
+	// Whether to put a finalizer on created volumes that is removed once
+	// their storage assets are deleted
+	volumeProtection bool
+}
+
+// LeaderElection returns an option for NewProvisionController that makes
+// controllers with the same provisioner name elect a leader among themselves
+// using an endpoints object in the given namespace as a lock. Only the leader
+// runs the control loops, so many replicas of a provisioner can be deployed
+// for availability. A controller that loses the election after having won it
+// exits.
+func LeaderElection(namespace string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if namespace == "" {
+			return errors.New("leader election namespace must not be empty")
+		}
+		c.leaderElection = true
+		c.leaderElectionNamespace = namespace
+		return nil
+	}
+}
+
+// Informers returns an option for NewProvisionController that makes the
+// controller use the given factory's informers rather than creating its own.
+// Give every controller in a process the same factory to share caches among
+// them. The factory's resync period then overrides the controller's.
+func Informers(factory *InformerFactory) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if factory == nil {
+			return errors.New("informer factory must not be nil")
+		}
+		c.informers = factory
+		return nil
+	}
 }
 
-// NewProvisionController creates a new provision controller
+// ProvisioningJournal returns an option for NewProvisionController that makes
+// the controller record each provisioning operation in the given journal
+// before calling the provisioner and until the operation's PV is saved. When
+// the controller is run, before it starts watching claims, it finishes or
+// rolls back operations a previous run left in the journal: if the
+// provisioner had created a storage asset, its PV is saved if the claim still
+// needs it; otherwise the asset is deleted. Reconciliation can't tell an
+// operation left by a dead controller from one in progress in another, so use
+// a journal only with one replica of a provisioner or with LeaderElection.
+func ProvisioningJournal(journal Journal) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if journal == nil {
+			return errors.New("journal must not be nil")
+		}
+		c.journal = journal
+		return nil
+	}
+}
+
+// MetricsAddress returns an option for NewProvisionController that makes the
+// controller serve the Prometheus metrics of package metrics, along with any
+// others registered with Prometheus' default registry, at /metrics on the
+// given address, e.g. ":8080". The metrics are recorded whether or not they
+// are served, so when running several controllers in a process give the
+// option to only one of them.
+func MetricsAddress(address string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if address == "" {
+			return errors.New("metrics address must not be empty")
+		}
+		c.metricsAddress = address
+		return nil
+	}
+}
+
+// OrphanReaper returns an option for NewProvisionController that makes the
+// controller look for storage assets without PVs every period, using the
+// provisioner's ListVolumes, so the provisioner must implement Lister. An
+// asset that has been without a PV for longer than gracePeriod, which should
+// be longer than provisioning takes, is an orphan: it is counted in
+// metrics.OrphanedVolumes and an OrphanedVolume event is recorded for it and,
+// if deleteOrphans is true, it is deleted with the provisioner's Delete. Note
+// that an asset whose PV was deleted by hand, e.g. to get rid of a PV with
+// the Retain reclaim policy while keeping its data, is an orphan too: enable
+// deletion only if that never happens.
+func OrphanReaper(period, gracePeriod time.Duration, deleteOrphans bool) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if period <= 0 {
+			return errors.New("orphan reaper period must be positive")
+		}
+		if _, ok := c.provisioner.(Lister); !ok {
+			return errors.New("orphan reaper requires a provisioner that implements Lister")
+		}
+		c.orphanReaperPeriod = period
+		c.orphanGracePeriod = gracePeriod
+		c.deleteOrphans = deleteOrphans
+		c.orphansFirstSeen = make(map[string]time.Time)
+		return nil
+	}
+}
+
+// MinimumVolumeSize returns an option for NewProvisionController that makes
+// the controller refuse to provision volumes for claims requesting less than
+// size. Instead of calling the provisioner it records a ProvisioningFailed
+// event for the claim saying why.
+func MinimumVolumeSize(size resource.Quantity) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if size.Sign() <= 0 {
+			return errors.New("minimum volume size must be positive")
+		}
+		if !c.maximumVolumeSize.IsZero() && size.Cmp(c.maximumVolumeSize) > 0 {
+			return fmt.Errorf("minimum volume size %s is greater than maximum volume size %s", size.String(), c.maximumVolumeSize.String())
+		}
+		c.minimumVolumeSize = size
+		return nil
+	}
+}
+
+// MaximumVolumeSize returns an option for NewProvisionController that makes
+// the controller refuse to provision volumes for claims requesting more than
+// size. Instead of calling the provisioner it records a ProvisioningFailed
+// event for the claim saying why.
+func MaximumVolumeSize(size resource.Quantity) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if size.Sign() <= 0 {
+			return errors.New("maximum volume size must be positive")
+		}
+		if !c.minimumVolumeSize.IsZero() && size.Cmp(c.minimumVolumeSize) < 0 {
+			return fmt.Errorf("maximum volume size %s is less than minimum volume size %s", size.String(), c.minimumVolumeSize.String())
+		}
+		c.maximumVolumeSize = size
+		return nil
+	}
+}
+
+// NewProvisionController creates a new provision controller. Optional
+// behaviour is enabled by passing options, e.g. LeaderElection.
 func NewProvisionController(
 	client kubernetes.Interface,
 	resyncPeriod time.Duration,
@@ -148,9 +353,12 @@ func NewProvisionController(
 	renewDeadline time.Duration,
 	retryPeriod time.Duration,
 	termLimit time.Duration,
+	options ...func(*ProvisionController) error,
 ) *ProvisionController {
 	identity := uuid.NewUUID()
 
+	metrics.Register()
+
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartRecordingToSink(&core_v1.EventSinkImpl{Interface: client.Core().Events(v1.NamespaceAll)})
 	var eventRecorder record.EventRecorder
@@ -164,6 +372,8 @@ func NewProvisionController(
 	gitVersion := version.MustParse(serverGitVersion)
 	gitVersion1dot5 := version.MustParse("1.5.0")
 	is1dot4 := gitVersion.LT(gitVersion1dot5)
+	gitVersion1dot6 := version.MustParse("1.6.0")
+	is1dot6 := gitVersion.GTE(gitVersion1dot6)
 
 	controller := &ProvisionController{
 		client:                        client,
@@ -182,87 +392,121 @@ func NewProvisionController(
 		termLimit:                     termLimit,
 		leaderElectors:                make(map[types.UID]*leaderelection.LeaderElector),
 		mapMutex:                      &sync.Mutex{},
-		failedClaimsStats:             make(map[types.UID]int),
+		failedClaimsStats:             make(map[types.UID]*claimFailures),
 		failedRetryThreshold:          failedRetryThreshold,
 		failedClaimsStatsMutex:        &sync.Mutex{},
 	}
-
-	controller.claimSource = &cache.ListWatch{
-		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
-			var out v1.ListOptions
-			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
-			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).List(out)
-		},
-		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
-			var out v1.ListOptions
-			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
-			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).Watch(out)
-		},
+	if is1dot6 {
+		controller.claimClasses = make(map[types.UID]claimClassName)
+		controller.claimClassesMutex = &sync.Mutex{}
+		controller.getRawClaim = func(namespace, name string) ([]byte, error) {
+			return client.Core().RESTClient().Get().Namespace(namespace).Resource("persistentvolumeclaims").Name(name).DoRaw()
+		}
 	}
-	controller.claims, controller.claimController = cache.NewInformer(
-		controller.claimSource,
-		&v1.PersistentVolumeClaim{},
-		resyncPeriod,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    controller.addClaim,
-			UpdateFunc: controller.updateClaim,
-			DeleteFunc: nil,
-		},
-	)
 
-	controller.volumeSource = &cache.ListWatch{
-		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
-			var out v1.ListOptions
-			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
-			return client.Core().PersistentVolumes().List(out)
-		},
-		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
-			var out v1.ListOptions
-			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+	for _, option := range options {
+		if err := option(controller); err != nil {
+			glog.Fatalf("Error processing controller options: %v", err)
+		}
+	}
+	if err := controller.applyCapabilities(); err != nil {
+		glog.Fatalf("Error processing provisioner capabilities: %v", err)
+	}
 
-			return client.Core().PersistentVolumes().Watch(out)
-		},
+	if controller.informers == nil {
+		controller.informers = NewInformerFactory(client, resyncPeriod)
 	}
-	controller.volumes, controller.volumeController = cache.NewInformer(
-		controller.volumeSource,
-		&v1.PersistentVolume{},
-		resyncPeriod,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    nil,
-			UpdateFunc: controller.updateVolume,
-			DeleteFunc: nil,
-		},
-	)
+	controller.claimSource = newClaimSource(client)
 
-	controller.classSource = &cache.ListWatch{
-		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
-			var out v1.ListOptions
-			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
-			return client.Storage().StorageClasses().List(out)
-		},
-		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
-			var out v1.ListOptions
-			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
-			return client.Storage().StorageClasses().Watch(out)
-		},
+	claimInformer := controller.informers.Claims()
+	claimInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.addClaim,
+		UpdateFunc: controller.updateClaim,
+		DeleteFunc: controller.deleteClaim,
+	})
+	controller.claims = claimInformer.GetStore()
+
+	volumeInformer := controller.informers.Volumes()
+	volumeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    nil,
+		UpdateFunc: controller.updateVolume,
+		DeleteFunc: nil,
+	})
+	controller.volumes = volumeInformer.GetStore()
+
+	classInformer := controller.informers.Classes()
+	if controller.capabilities != nil {
+		classInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    controller.addClass,
+			UpdateFunc: controller.updateClass,
+			DeleteFunc: nil,
+		})
 	}
-	controller.classes = cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
-	controller.classReflector = cache.NewReflector(
-		controller.classSource,
-		&v1beta1.StorageClass{},
-		controller.classes,
-		resyncPeriod,
-	)
+	controller.classes = classInformer.GetStore()
 
 	return controller
 }
 
-// Run starts all of this controller's control loops
+// Run starts all of this controller's control loops. If leader election is
+// enabled, the loops are started only once this controller becomes the leader.
 func (ctrl *ProvisionController) Run(stopCh <-chan struct{}) {
-	glog.Infof("Starting provisioner controller %s!", string(ctrl.identity))
-	go ctrl.claimController.Run(stopCh)
-	go ctrl.volumeController.Run(stopCh)
-	go ctrl.classReflector.RunUntil(stopCh)
+	if ctrl.metricsAddress != "" {
+		go ctrl.serveMetrics()
+	}
+
+	run := func(stopCh <-chan struct{}) {
+		glog.Infof("Starting provisioner controller %s!", string(ctrl.identity))
+		if ctrl.journal != nil {
+			ctrl.reconcileJournal()
+		}
+		ctrl.informers.Start(stopCh)
+		if ctrl.orphanReaperPeriod > 0 {
+			go wait.Until(ctrl.reapOrphans, ctrl.orphanReaperPeriod, stopCh)
+		}
+		if ctrl.pendingClaimResync {
+			go wait.Until(ctrl.resyncPendingClaims, ctrl.resyncPeriod, stopCh)
+		}
+		<-stopCh
+	}
+
+	if !ctrl.leaderElection {
+		run(stopCh)
+		return
+	}
+
+	rl := rl.EndpointsLock{
+		EndpointsMeta: v1.ObjectMeta{
+			Namespace: ctrl.leaderElectionNamespace,
+			Name:      strings.Replace(ctrl.provisionerName, "/", "-", -1),
+		},
+		Client: ctrl.client,
+		LockConfig: rl.ResourceLockConfig{
+			Identity:      string(ctrl.identity),
+			EventRecorder: ctrl.eventRecorder,
+		},
+	}
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          &rl,
+		LeaseDuration: ctrl.leaseDuration,
+		RenewDeadline: ctrl.renewDeadline,
+		RetryPeriod:   ctrl.retryPeriod,
+		// Lead until we fail to renew, not just for one term
+		TermLimit: 0,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ <-chan struct{}) {
+				run(stopCh)
+			},
+			OnStoppedLeading: func() {
+				glog.Fatalf("Lost leader election %s, exiting", rl.Describe())
+			},
+		},
+	})
+	if err != nil {
+		glog.Fatalf("Error creating LeaderElector: %v", err)
+	}
+
+	glog.Infof("Controller %s waiting to become the leader of %s", string(ctrl.identity), rl.Describe())
+	go le.Run(nil)
 	<-stopCh
 }
 
@@ -276,16 +520,25 @@ func (ctrl *ProvisionController) addClaim(obj interface{}) {
 	}
 
 	if ctrl.shouldProvision(claim) {
+		// If this controller won the election on the provisioner name, there
+		// are no other controllers to race with for the claim
 		ctrl.mapMutex.Lock()
 		le, ok := ctrl.leaderElectors[claim.UID]
 		ctrl.mapMutex.Unlock()
-		if ok && le.IsLeader() {
+		if ctrl.leaderElection || (ok && le.IsLeader()) {
 			opName := fmt.Sprintf("provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
 			ctrl.scheduleOperation(opName, func() error {
 				err := ctrl.provisionClaimOperation(claim)
 				ctrl.updateStats(claim, err)
 				return err
 			})
+		} else if ctrl.claimOwnershipTimeout > 0 {
+			opName := fmt.Sprintf("provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
+			ctrl.scheduleOperation(opName, func() error {
+				err := ctrl.provisionOwnedClaimOperation(claim)
+				ctrl.updateStats(claim, err)
+				return err
+			})
 		} else {
 			opName := fmt.Sprintf("lock-provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
 			ctrl.scheduleOperation(opName, func() error {
@@ -302,6 +555,7 @@ func (ctrl *ProvisionController) updateClaim(oldObj, newObj interface{}) {
 	// If they are exactly the same it must be a forced resync (every
 	// resyncPeriod).
 	if reflect.DeepEqual(oldObj, newObj) {
+		metrics.LastResyncTime.WithLabelValues(ctrl.provisionerName, "persistentvolumeclaims").Set(float64(time.Now().Unix()))
 		ctrl.addClaim(newObj)
 		return
 	}
@@ -330,9 +584,32 @@ func (ctrl *ProvisionController) updateClaim(oldObj, newObj interface{}) {
 	}
 }
 
+// On delete claim, forget its failures.
+func (ctrl *ProvisionController) deleteClaim(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	claim, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		glog.Errorf("Expected PersistentVolumeClaim but deleteClaim received %+v", obj)
+		return
+	}
+
+	ctrl.forgetClaimClass(claim)
+
+	ctrl.failedClaimsStatsMutex.Lock()
+	defer ctrl.failedClaimsStatsMutex.Unlock()
+	delete(ctrl.failedClaimsStats, claim.UID)
+	metrics.ClaimProvisionFailures.DeleteLabelValues(ctrl.provisionerName, claimToClaimKey(claim))
+}
+
 // On update volume, check if the updated volume should be deleted and delete if
 // so. Updates occur at least every resyncPeriod.
 func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
+	if reflect.DeepEqual(oldObj, newObj) {
+		metrics.LastResyncTime.WithLabelValues(ctrl.provisionerName, "persistentvolumes").Set(float64(time.Now().Unix()))
+	}
+
 	volume, ok := newObj.(*v1.PersistentVolume)
 	if !ok {
 		glog.Errorf("Expected PersistentVolume but handler received %#v", newObj)
@@ -344,11 +621,21 @@ func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
 		ctrl.scheduleOperation(opName, func() error {
 			return ctrl.deleteVolumeOperation(volume)
 		})
+	} else if ctrl.shouldRemoveFinalizer(volume) {
+		opName := fmt.Sprintf("finalize-%s[%s]", volume.Name, string(volume.UID))
+		ctrl.scheduleOperation(opName, func() error {
+			return ctrl.removeFinalizerOperation(volume)
+		})
+	} else if ctrl.shouldUpdate(volume) {
+		opName := fmt.Sprintf("update-%s[%s]", volume.Name, string(volume.UID))
+		ctrl.scheduleOperation(opName, func() error {
+			return ctrl.updateVolumeOperation(volume)
+		})
 	}
 }
 
 // isOnlyRecordUpdate checks if the only update between the old & new claim is
-// the leader election record annotation.
+// the leader election record or claim owner annotation.
 func (ctrl *ProvisionController) isOnlyRecordUpdate(oldClaim, newClaim *v1.PersistentVolumeClaim) (bool, error) {
 	old, err := ctrl.removeRecord(oldClaim)
 	if err != nil {
@@ -361,8 +648,8 @@ func (ctrl *ProvisionController) isOnlyRecordUpdate(oldClaim, newClaim *v1.Persi
 	return reflect.DeepEqual(old, new), nil
 }
 
-// removeRecord returns a claim with its leader election record annotation and
-// ResourceVersion set blank
+// removeRecord returns a claim with its leader election record and claim owner
+// annotations and ResourceVersion set blank
 func (ctrl *ProvisionController) removeRecord(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
 	clone, err := api.Scheme.DeepCopy(claim)
 	if err != nil {
@@ -377,6 +664,7 @@ func (ctrl *ProvisionController) removeRecord(claim *v1.PersistentVolumeClaim) (
 		claimClone.Annotations = make(map[string]string)
 	}
 	claimClone.Annotations[rl.LeaderElectionRecordAnnotationKey] = ""
+	claimClone.Annotations[annClaimOwner] = ""
 
 	claimClone.ResourceVersion = ""
 
@@ -388,13 +676,37 @@ func (ctrl *ProvisionController) shouldProvision(claim *v1.PersistentVolumeClaim
 		return false
 	}
 
-	ctrl.failedClaimsStatsMutex.Lock()
-	if failureCount, exists := ctrl.failedClaimsStats[claim.UID]; exists == true {
+	if !ctrl.claimMatches(claim) {
+		ctrl.claimSkipped(skipReasonFiltered)
+		return false
+	}
 
-		if failureCount >= ctrl.failedRetryThreshold {
+	if claimTerminating(claim) {
+		glog.V(4).Infof("Claim %q is being deleted, skipping", claimToClaimKey(claim))
+		ctrl.claimSkipped(skipReasonTerminating)
+		return false
+	}
+
+	ctrl.failedClaimsStatsMutex.Lock()
+	if failures, exists := ctrl.failedClaimsStats[claim.UID]; exists == true {
+
+		if ctrl.edited(claim, failures) {
+			// The edit may have fixed what made provisioning fail
+			glog.Infof("Claim %q or its class was edited since provisioning for it last failed, retrying", claimToClaimKey(claim))
+			delete(ctrl.failedClaimsStats, claim.UID)
+			metrics.ClaimProvisionFailures.DeleteLabelValues(ctrl.provisionerName, claimToClaimKey(claim))
+		} else if failures.terminal {
+			glog.Errorf("Provisioning for claim %q failed with a terminal error, provisioner will not attempt retries for this claim", claimToClaimKey(claim))
+			ctrl.failedClaimsStatsMutex.Unlock()
+			return false
+		} else if !failures.retryable && failures.count >= ctrl.failedRetryThreshold {
 			glog.Errorf("Exceeded failedRetryThreshold threshold: %d, for claim %q, provisioner will not attempt retries for this claim", ctrl.failedRetryThreshold, claimToClaimKey(claim))
 			ctrl.failedClaimsStatsMutex.Unlock()
 			return false
+		} else if time.Now().Before(failures.retryAfter) {
+			glog.V(4).Infof("Claim %q: backing off from provisioning until %v", claimToClaimKey(claim), failures.retryAfter)
+			ctrl.failedClaimsStatsMutex.Unlock()
+			return false
 		}
 	}
 	ctrl.failedClaimsStatsMutex.Unlock()
@@ -402,25 +714,54 @@ func (ctrl *ProvisionController) shouldProvision(claim *v1.PersistentVolumeClaim
 	// Kubernetes 1.5 provisioning with annDynamicallyProvisioned
 	if provisioner, found := claim.Annotations[annDynamicallyProvisioned]; found {
 		if provisioner == ctrl.provisionerName {
-			return true
+			return !ctrl.waitingForNode(claim)
 		}
+		glog.V(4).Infof("Claim %q is for provisioner %q, skipping", claimToClaimKey(claim), provisioner)
+		ctrl.claimSkipped(skipReasonForeignProvisioner)
 		return false
 	}
 
 	// Kubernetes 1.4 provisioning, evaluating class.Provisioner
-	claimClass := getClaimClass(claim)
+	claimClass := ctrl.getClaimClass(claim)
 	_, err := ctrl.getStorageClass(claimClass)
 	if err != nil {
-		glog.Errorf("Claim %q: %v", claimToClaimKey(claim), err)
+		ctrl.classNotUsable(claim, claimClass, err)
 		return false
 	}
-	return true
+	return !ctrl.waitingForNode(claim)
+}
+
+// waitingForNode returns whether the claim's class has volume binding mode
+// WaitForFirstConsumer and the scheduler hasn't yet selected a node for it
+func (ctrl *ProvisionController) waitingForNode(claim *v1.PersistentVolumeClaim) bool {
+	if !ctrl.delayedBinding || hasAnnotation(claim.ObjectMeta, annSelectedNode) {
+		return false
+	}
+	class, err := ctrl.getStorageClass(ctrl.getClaimClass(claim))
+	if err != nil {
+		glog.Errorf("Claim %q: %v", claimToClaimKey(claim), err)
+		return true
+	}
+	wait, err := ctrl.waitsForFirstConsumer(class)
+	if err != nil {
+		glog.Errorf("Claim %q: %v", claimToClaimKey(claim), err)
+		return true
+	}
+	if wait {
+		glog.V(4).Infof("Claim %q: waiting for a node to be selected before provisioning", claimToClaimKey(claim))
+	}
+	return wait
 }
 
 func (ctrl *ProvisionController) shouldDelete(volume *v1.PersistentVolume) bool {
 	// In 1.5+ we delete only if the volume is in state Released. In 1.4 we must
-	// delete if the volume is in state Failed too.
-	if !ctrl.is1dot4 {
+	// delete if the volume is in state Failed too. A protected volume whose PV
+	// object was deleted by hand is deleted in any state but Bound.
+	if isDeletedProtectedVolume(volume) {
+		if volume.Status.Phase == v1.VolumeBound {
+			return false
+		}
+	} else if !ctrl.is1dot4 {
 		if volume.Status.Phase != v1.VolumeReleased {
 			return false
 		}
@@ -519,15 +860,17 @@ func (ctrl *ProvisionController) updateStats(claim *v1.PersistentVolumeClaim, er
 	ctrl.failedClaimsStatsMutex.Lock()
 	defer ctrl.failedClaimsStatsMutex.Unlock()
 	if err != nil {
-		if failureRetryCount, exists := ctrl.failedClaimsStats[claim.UID]; exists == true {
-			failureRetryCount = failureRetryCount + 1
-			ctrl.failedClaimsStats[claim.UID] = failureRetryCount
-		} else {
-			ctrl.failedClaimsStats[claim.UID] = 1
+		failures := ctrl.newClaimFailures(claim, ctrl.failedClaimsStats[claim.UID], err)
+		ctrl.failedClaimsStats[claim.UID] = failures
+		metrics.ClaimProvisionFailures.WithLabelValues(ctrl.provisionerName, claimToClaimKey(claim)).Set(float64(failures.count))
+		if failures.terminal {
+			ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningStopped", fmt.Sprintf("Failed to provision volume: %v, not retrying. Edit the claim or its storage class to retry", err))
+		} else if !failures.retryable && failures.count == ctrl.failedRetryThreshold {
+			ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningStopped", fmt.Sprintf("Failed to provision volume %d times, not retrying. Edit the claim or its storage class to retry", failures.count))
 		}
-
 	} else {
 		delete(ctrl.failedClaimsStats, claim.UID)
+		metrics.ClaimProvisionFailures.DeleteLabelValues(ctrl.provisionerName, claimToClaimKey(claim))
 	}
 }
 
@@ -536,7 +879,7 @@ func (ctrl *ProvisionController) updateStats(claim *v1.PersistentVolumeClaim, er
 // the operation is deleted, else the operation may be retried with expbackoff.
 func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVolumeClaim) error {
 	// Most code here is identical to that found in controller.go of kube's PV controller...
-	claimClass := getClaimClass(claim)
+	claimClass := ctrl.getClaimClass(claim)
 	glog.V(4).Infof("provisionClaimOperation [%s] started, class: %q", claimToClaimKey(claim), claimClass)
 
 	//  A previous doProvisionClaim may just have finished while we were waiting for
@@ -564,16 +907,65 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		return nil
 	}
 
+	var parameters map[string]string
+	if err = ctrl.checkVolumeSize(claim); err == nil {
+		err = ctrl.checkAccessModes(claim)
+	}
+	if err == nil {
+		parameters, err = claimParameters(storageClass, claim)
+	}
+	if err != nil {
+		// Only an edit of the claim or class can fix these
+		err = &TerminalError{Reason: err.Error()}
+		strerr := fmt.Sprintf("Failed to provision volume with StorageClass %q: %v", storageClass.Name, err)
+		glog.Errorf("Failed to provision volume for claim %q with StorageClass %q: %v", claimToClaimKey(claim), storageClass.Name, err)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		return err
+	}
+
+	var selectedNode *v1.Node
+	if nodeName, ok := claim.Annotations[annSelectedNode]; ok {
+		selectedNode, err = ctrl.client.Core().Nodes().Get(nodeName)
+		if err != nil {
+			strerr := fmt.Sprintf("Failed to get node %q selected for the claim: %v", nodeName, err)
+			glog.Errorf("Failed to provision volume for claim %q: %s", claimToClaimKey(claim), strerr)
+			ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+			return err
+		}
+	}
+
 	options := VolumeOptions{
 		// TODO SHOULD be set to `Delete` unless user manually congiures other reclaim policy.
 		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
-		PVName:     pvName,
-		PVC:        claim,
-		Parameters: storageClass.Parameters,
+		PVName:       pvName,
+		PVC:          claim,
+		Parameters:   parameters,
+		SelectedNode: selectedNode,
+	}
+
+	options, err = ctrl.preProvision(options)
+	if err != nil {
+		strerr := fmt.Sprintf("Failed to provision volume with StorageClass %q: %v", storageClass.Name, err)
+		glog.Errorf("Pre-provisioning of volume for claim %q with StorageClass %q failed: %v", claimToClaimKey(claim), storageClass.Name, err)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		return err
+	}
+
+	entry := JournalEntry{
+		PVName:         pvName,
+		ClaimNamespace: claim.Namespace,
+		ClaimName:      claim.Name,
+		ClaimUID:       claim.UID,
+	}
+	if err = ctrl.putJournalEntry(entry); err != nil {
+		// Don't provision what can't be rolled back if we die
+		glog.Errorf("Failed to journal provisioning of volume for claim %q: %v", claimToClaimKey(claim), err)
+		return err
 	}
 
 	volume, err = ctrl.provisioner.Provision(options)
 	if err != nil {
+		ctrl.removeJournalEntry(pvName)
 		strerr := fmt.Sprintf("Failed to provision volume with StorageClass %q: %v", storageClass.Name, err)
 		glog.Errorf("Failed to provision volume for claim %q with StorageClass %q: %v", claimToClaimKey(claim), storageClass.Name, err)
 		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
@@ -587,11 +979,39 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 
 	setAnnotation(&volume.ObjectMeta, annDynamicallyProvisioned, ctrl.provisionerName)
 	setAnnotation(&volume.ObjectMeta, annClass, claimClass)
+	if ctrl.volumeProtection && !hasFinalizer(volume.ObjectMeta, finalizerVolumeProtection) {
+		volume.Finalizers = append(volume.Finalizers, finalizerVolumeProtection)
+	}
+
+	entry.Volume = volume
+	if err = ctrl.putJournalEntry(entry); err != nil {
+		glog.Errorf("Failed to journal provisioned volume %q for claim %q: %v", volume.Name, claimToClaimKey(claim), err)
+	}
+
+	// Provision can take long enough for the claim to be deleted meanwhile.
+	// Saving the volume would leave its asset to the reclaim policy, which
+	// for Retain means forever, so roll it back now.
+	if ctrl.claimDeleted(claim) {
+		glog.Infof("claim %q was deleted while its volume %q was provisioned, deleting the volume", claimToClaimKey(claim), volume.Name)
+		if err := ctrl.deleteProvisionedVolume(claim, volume); err != nil {
+			// Left in the journal, if any, to be deleted next time the
+			// controller starts
+			glog.Errorf("Error cleaning provisioned volume for deleted claim %s: %v. Please delete manually.", claimToClaimKey(claim), err)
+		}
+		return nil
+	}
 
 	// Try to create the PV object several times
 	for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
 		glog.V(4).Infof("provisionClaimOperation [%s]: trying to save volume %s", claimToClaimKey(claim), volume.Name)
-		if _, err = ctrl.client.Core().PersistentVolumes().Create(volume); err == nil {
+		_, err = ctrl.client.Core().PersistentVolumes().Create(volume)
+		if apierrs.IsAlreadyExists(err) && ctrl.volumeSaved(volume) {
+			// Another attempt, e.g. by a controller that took over the claim,
+			// saved the same volume. Rolling ours back would delete its asset.
+			glog.Infof("volume %q for claim %q already saved", volume.Name, claimToClaimKey(claim))
+			err = nil
+		}
+		if err == nil {
 			// Save succeeded.
 			glog.Infof("volume %q for claim %q saved", volume.Name, claimToClaimKey(claim))
 			break
@@ -610,25 +1030,16 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		glog.Error(strerr)
 		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
 
-		for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
-			if err = ctrl.provisioner.Delete(volume); err == nil {
-				// Delete succeeded
-				glog.V(4).Infof("provisionClaimOperation [%s]: cleaning volume %s succeeded", claimToClaimKey(claim), volume.Name)
-				break
-			}
-			// Delete failed, try again after a while.
-			glog.Infof("failed to delete volume %q: %v", volume.Name, err)
-			time.Sleep(ctrl.createProvisionedPVInterval)
-		}
-
-		if err != nil {
+		if err = ctrl.deleteProvisionedVolume(claim, volume); err != nil {
 			// Delete failed several times. There is an orphaned volume and there
-			// is nothing we can do about it.
+			// is nothing we can do about it, except leave it in the journal, if
+			// any, to be deleted next time the controller starts.
 			strerr := fmt.Sprintf("Error cleaning provisioned volume for claim %s: %v. Please delete manually.", claimToClaimKey(claim), err)
 			glog.Error(strerr)
 			ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningCleanupFailed", strerr)
 		}
 	} else {
+		ctrl.removeJournalEntry(pvName)
 		glog.Infof("volume %q provisioned for claim %q", volume.Name, claimToClaimKey(claim))
 		msg := fmt.Sprintf("Successfully provisioned volume %s", volume.Name)
 		ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "ProvisioningSucceeded", msg)
@@ -637,6 +1048,117 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 	return nil
 }
 
+// volumeSaved returns whether a PV of the volume's name exists for the same
+// claim and the same asset as the volume
+func (ctrl *ProvisionController) volumeSaved(volume *v1.PersistentVolume) bool {
+	existing, err := ctrl.client.Core().PersistentVolumes().Get(volume.Name)
+	if err != nil {
+		return false
+	}
+	if existing.Spec.ClaimRef == nil || volume.Spec.ClaimRef == nil || existing.Spec.ClaimRef.UID != volume.Spec.ClaimRef.UID {
+		return false
+	}
+	return reflect.DeepEqual(existing.Spec.PersistentVolumeSource, volume.Spec.PersistentVolumeSource)
+}
+
+// checkVolumeSize returns an error if the size the claim requests is outside
+// the range set by the MinimumVolumeSize and MaximumVolumeSize options
+func (ctrl *ProvisionController) checkVolumeSize(claim *v1.PersistentVolumeClaim) error {
+	size := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if !ctrl.minimumVolumeSize.IsZero() && size.Cmp(ctrl.minimumVolumeSize) < 0 {
+		return fmt.Errorf("requested size %s is less than the minimum size %s supported by provisioner %q", size.String(), ctrl.minimumVolumeSize.String(), ctrl.provisionerName)
+	}
+	if !ctrl.maximumVolumeSize.IsZero() && size.Cmp(ctrl.maximumVolumeSize) > 0 {
+		return fmt.Errorf("requested size %s is greater than the maximum size %s supported by provisioner %q", size.String(), ctrl.maximumVolumeSize.String(), ctrl.provisionerName)
+	}
+	return nil
+}
+
+// putJournalEntry records entry in the journal, if any
+func (ctrl *ProvisionController) putJournalEntry(entry JournalEntry) error {
+	if ctrl.journal == nil {
+		return nil
+	}
+	return ctrl.journal.Put(entry)
+}
+
+// removeJournalEntry removes the named PV's entry from the journal, if any.
+// Failure only means the entry is reconciled, harmlessly, on next start.
+func (ctrl *ProvisionController) removeJournalEntry(pvName string) {
+	if ctrl.journal == nil {
+		return
+	}
+	if err := ctrl.journal.Remove(pvName); err != nil {
+		glog.Errorf("Failed to remove journal entry for volume %q: %v", pvName, err)
+	}
+}
+
+// reconcileJournal finishes or rolls back the provisioning operations left in
+// the journal by a previous run of the controller.
+func (ctrl *ProvisionController) reconcileJournal() {
+	entries, err := ctrl.journal.List()
+	if err != nil {
+		glog.Errorf("Failed to list journal entries, not reconciling them: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		ctrl.reconcileJournalEntry(entry)
+	}
+}
+
+func (ctrl *ProvisionController) reconcileJournalEntry(entry JournalEntry) {
+	claimKey := entry.ClaimNamespace + "/" + entry.ClaimName
+
+	_, err := ctrl.client.Core().PersistentVolumes().Get(entry.PVName)
+	if err == nil {
+		// The PV was saved, the operation only didn't get to removing its entry
+		glog.V(4).Infof("reconcileJournal: volume %q for claim %q exists", entry.PVName, claimKey)
+		ctrl.removeJournalEntry(entry.PVName)
+		return
+	}
+	if !apierrs.IsNotFound(err) {
+		glog.Errorf("Failed to get volume %q, not reconciling its journal entry: %v", entry.PVName, err)
+		return
+	}
+
+	if entry.Volume == nil {
+		// Provision never returned. The claim, if it still needs a volume, will
+		// be provisioned again under the same PV name; there is no asset we know
+		// of to roll back.
+		glog.Warningf("Provisioning of volume %q for claim %q was interrupted before the provisioner returned", entry.PVName, claimKey)
+		ctrl.removeJournalEntry(entry.PVName)
+		return
+	}
+
+	// The asset exists but its PV wasn't saved. Save it if the claim still
+	// needs it, else roll back.
+	claim, err := ctrl.client.Core().PersistentVolumeClaims(entry.ClaimNamespace).Get(entry.ClaimName)
+	if err == nil && claim.UID == entry.ClaimUID && claim.Spec.VolumeName == "" {
+		if _, err = ctrl.client.Core().PersistentVolumes().Create(entry.Volume); err == nil {
+			glog.Infof("volume %q for claim %q saved from journal", entry.PVName, claimKey)
+			msg := fmt.Sprintf("Successfully provisioned volume %s", entry.PVName)
+			ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "ProvisioningSucceeded", msg)
+			ctrl.removeJournalEntry(entry.PVName)
+			return
+		}
+		glog.Errorf("Failed to save volume %q for claim %q from journal, deleting it: %v", entry.PVName, claimKey, err)
+	}
+
+	if err := ctrl.provisioner.Delete(entry.Volume); err != nil {
+		if _, ok := err.(*IgnoredError); ok {
+			// The journal only holds volumes this controller provisioned, so
+			// the provisioner ignoring one, e.g. because its identity changed
+			// with the restart, doesn't mean the asset is someone else's
+			glog.Errorf("Provisioner ignored deleting volume %q left by interrupted provisioning for claim %q, keeping its journal entry, please delete it manually: %v", entry.PVName, claimKey, err)
+			return
+		}
+		glog.Errorf("Failed to delete volume %q left by interrupted provisioning for claim %q, will retry on next start: %v", entry.PVName, claimKey, err)
+		return
+	}
+	glog.Infof("volume %q left by interrupted provisioning for claim %q deleted", entry.PVName, claimKey)
+	ctrl.removeJournalEntry(entry.PVName)
+}
+
 // watchProvisioning returns a channel to which it sends the results of all
 // provisioning attempts for the given claim. The PVC being modified to no
 // longer need provisioning is considered a success.
@@ -807,6 +1329,15 @@ func (ctrl *ProvisionController) deleteVolumeOperation(volume *v1.PersistentVolu
 		return nil
 	}
 
+	if recycled, err := ctrl.recycleVolume(newVolume); recycled {
+		if ierr, ok := err.(*IgnoredError); ok {
+			// Recycle ignored, do nothing and hope another provisioner will recycle it.
+			glog.Infof("recycling of volume %q ignored: %v", volume.Name, ierr)
+			return nil
+		}
+		return err
+	}
+
 	if err := ctrl.provisioner.Delete(volume); err != nil {
 		if ierr, ok := err.(*IgnoredError); ok {
 			// Delete ignored, do nothing and hope another provisioner will delete it.
@@ -821,6 +1352,19 @@ func (ctrl *ProvisionController) deleteVolumeOperation(volume *v1.PersistentVolu
 
 	glog.Infof("volume %q deleted", volume.Name)
 
+	if hasFinalizer(newVolume.ObjectMeta, finalizerVolumeProtection) {
+		if err := ctrl.removeFinalizer(newVolume); err != nil {
+			// The controller will call Delete again on next update
+			glog.Errorf("Failed to remove finalizer from deleted volume %q: %v", volume.Name, err)
+			return err
+		}
+		if newVolume.DeletionTimestamp != nil {
+			// Already deleted, it goes away with its finalizer
+			glog.Infof("volume %q deleted from database", volume.Name)
+			return nil
+		}
+	}
+
 	glog.V(4).Infof("deleteVolumeOperation [%s]: success", volume.Name)
 	// Delete the volume
 	if err = ctrl.client.Core().PersistentVolumes().Delete(volume.Name, nil); err != nil {
@@ -845,8 +1389,14 @@ func (ctrl *ProvisionController) getProvisionedVolumeNameForClaim(claim *v1.Pers
 func (ctrl *ProvisionController) scheduleOperation(operationName string, operation func() error) {
 	glog.Infof("scheduleOperation[%s]", operationName)
 
-	err := ctrl.runningOperations.Run(operationName, operation)
+	queueDepth := metrics.OperationQueueDepth.WithLabelValues(ctrl.provisionerName)
+	queueDepth.Inc()
+	err := ctrl.runningOperations.Run(operationName, func() error {
+		defer queueDepth.Dec()
+		return operation()
+	})
 	if err != nil {
+		queueDepth.Dec()
 		if goroutinemap.IsAlreadyExists(err) {
 			glog.V(4).Infof("operation %q is already running, skipping", operationName)
 		} else {
@@ -855,6 +1405,14 @@ func (ctrl *ProvisionController) scheduleOperation(operationName string, operati
 	}
 }
 
+// serveMetrics serves metrics at /metrics on ctrl.metricsAddress
+func (ctrl *ProvisionController) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	glog.Infof("Serving metrics at %s/metrics", ctrl.metricsAddress)
+	glog.Errorf("Error serving metrics: %v", http.ListenAndServe(ctrl.metricsAddress, mux))
+}
+
 func (ctrl *ProvisionController) getStorageClass(name string) (*v1beta1.StorageClass, error) {
 	classObj, found, err := ctrl.classes.GetByKey(name)
 	if err != nil {
@@ -892,19 +1450,6 @@ func setAnnotation(obj *v1.ObjectMeta, ann string, value string) {
 	obj.Annotations[ann] = value
 }
 
-// getClaimClass returns name of class that is requested by given claim.
-// Request for `nil` class is interpreted as request for class "",
-// i.e. for a classless PV.
-func getClaimClass(claim *v1.PersistentVolumeClaim) string {
-	// TODO: change to PersistentVolumeClaim.Spec.Class value when this
-	// attribute is introduced.
-	if class, found := claim.Annotations[annClass]; found {
-		return class
-	}
-
-	return ""
-}
-
 func claimToClaimKey(claim *v1.PersistentVolumeClaim) string {
 	return fmt.Sprintf("%s/%s", claim.Namespace, claim.Name)
 }
@@ -17,27 +17,39 @@ limitations under the License.
 package controller
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/kubernetes-incubator/external-storage/lib/controller/metrics"
 	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
 	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	apierrs "k8s.io/client-go/pkg/api/errors"
 	fakev1core "k8s.io/client-go/kubernetes/typed/core/v1/fake"
 	"k8s.io/client-go/pkg/api/resource"
 	"k8s.io/client-go/pkg/api/testapi"
+	"k8s.io/client-go/pkg/api/unversioned"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/storage/v1beta1"
 	"k8s.io/client-go/pkg/conversion"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/pkg/types"
+	"k8s.io/client-go/pkg/util/wait"
 	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/rest"
 	testclient "k8s.io/client-go/testing"
 	fcache "k8s.io/client-go/tools/cache/testing"
 )
@@ -271,6 +283,1883 @@ func TestMultipleControllers(t *testing.T) {
 	}
 }
 
+func TestClaimOwnership(t *testing.T) {
+	tests := []struct {
+		name           string
+		owner          *claimOwner
+		numControllers int
+		expectedCalls  int
+	}{
+		{
+			name:           "call provision exactly once",
+			numControllers: 5,
+			expectedCalls:  1,
+		},
+		{
+			name:           "owned by another controller",
+			owner:          &claimOwner{Identity: "other", AcquireTime: time.Now()},
+			numControllers: 1,
+			expectedCalls:  0,
+		},
+		{
+			name:           "ownership by another controller expired",
+			owner:          &claimOwner{Identity: "other", AcquireTime: time.Now().Add(-time.Hour)},
+			numControllers: 1,
+			expectedCalls:  1,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+
+		// Create a reactor to reject Updates if object has already been modified,
+		// like etcd.
+		claimSource := fcache.NewFakePVCControllerSource()
+		reactor := claimReactor{
+			fake:        &fakev1core.FakeCoreV1{Fake: &client.Fake},
+			claims:      make(map[string]*v1.PersistentVolumeClaim),
+			lock:        sync.Mutex{},
+			claimSource: claimSource,
+		}
+		annotations := map[string]string{}
+		if test.owner != nil {
+			data, _ := json.Marshal(test.owner)
+			annotations[annClaimOwner] = string(data)
+		}
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", annotations)
+		reactor.claims["claim-1"] = claim
+		client.PrependReactor("update", "persistentvolumeclaims", reactor.React)
+		client.PrependReactor("get", "persistentvolumeclaims", reactor.React)
+
+		provisioner := newTestProvisioner()
+		ctrls := make([]*ProvisionController, test.numControllers)
+		for i := 0; i < test.numControllers; i++ {
+			ctrls[i] = newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+			if err := ClaimOwnership(time.Minute)(ctrls[i]); err != nil {
+				t.Fatalf("unexpected error processing options: %v", err)
+			}
+			ctrls[i].classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+		}
+
+		for i := 0; i < test.numControllers; i++ {
+			go ctrls[i].addClaim(claim)
+		}
+
+		// Long enough for all of the controllers to try provisioning
+		time.Sleep(500 * time.Millisecond)
+
+		if test.expectedCalls != len(provisioner.provisionCalls) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision calls %v but got %v", test.expectedCalls, len(provisioner.provisionCalls))
+		}
+		if test.expectedCalls == 0 {
+			continue
+		}
+		owner := claimOwner{}
+		if err := json.Unmarshal([]byte(reactor.claims["claim-1"].Annotations[annClaimOwner]), &owner); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error decoding owner annotation: %v", err)
+			continue
+		}
+		owned := false
+		for _, ctrl := range ctrls {
+			if owner.Identity == string(ctrl.identity) {
+				owned = true
+			}
+		}
+		if !owned {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected claim owned by a controller but got owner %q", owner.Identity)
+		}
+	}
+}
+
+func TestClaimOwnershipRenewal(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	claimSource := fcache.NewFakePVCControllerSource()
+	reactor := claimReactor{
+		fake:        &fakev1core.FakeCoreV1{Fake: &client.Fake},
+		claims:      make(map[string]*v1.PersistentVolumeClaim),
+		lock:        sync.Mutex{},
+		claimSource: claimSource,
+	}
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	reactor.claims["claim-1"] = claim
+	client.PrependReactor("update", "persistentvolumeclaims", reactor.React)
+	client.PrependReactor("get", "persistentvolumeclaims", reactor.React)
+
+	// Provision takes several times the ownership timeout
+	provisioner := &slowTestProvisioner{newTestProvisioner(), 400 * time.Millisecond}
+	ctrls := make([]*ProvisionController, 2)
+	for i := range ctrls {
+		ctrls[i] = newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+		if err := ClaimOwnership(150 * time.Millisecond)(ctrls[i]); err != nil {
+			t.Fatalf("unexpected error processing options: %v", err)
+		}
+		ctrls[i].classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+	}
+
+	go ctrls[0].addClaim(claim)
+	time.Sleep(250 * time.Millisecond)
+	go ctrls[1].addClaim(claim)
+	time.Sleep(500 * time.Millisecond)
+
+	if len(provisioner.provisionCalls) != 1 {
+		t.Errorf("expected 1 provision call but got %v", len(provisioner.provisionCalls))
+	}
+	reactor.lock.Lock()
+	data := reactor.claims["claim-1"].Annotations[annClaimOwner]
+	reactor.lock.Unlock()
+	owner := claimOwner{}
+	if err := json.Unmarshal([]byte(data), &owner); err != nil {
+		t.Fatalf("unexpected error decoding owner annotation: %v", err)
+	}
+	if owner.Identity != string(ctrls[0].identity) || !owner.RenewTime.After(owner.AcquireTime) {
+		t.Errorf("expected ownership by %s renewed but got %+v", ctrls[0].identity, owner)
+	}
+}
+
+func TestVolumeAlreadySaved(t *testing.T) {
+	tests := []struct {
+		name            string
+		savedClaimUID   types.UID
+		expectedDeletes int
+	}{
+		{
+			name:            "same volume saved by another attempt",
+			savedClaimUID:   "uid-1-1",
+			expectedDeletes: 0,
+		},
+		{
+			name:            "volume of another claim saved",
+			savedClaimUID:   "uid-1-2",
+			expectedDeletes: 1,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"))
+		provisioner := &savingTestProvisioner{newTestProvisioner(), client, test.savedClaimUID}
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+		ctrl.createProvisionedPVRetryCount = 2
+		ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		client.Core().PersistentVolumeClaims(claim.Namespace).Create(claim)
+		if err := ctrl.provisionClaimOperation(claim); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error provisioning: %v", err)
+		}
+		if len(provisioner.provisionCalls) != 1 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected 1 provision call but got %v", len(provisioner.provisionCalls))
+		}
+		if test.expectedDeletes != len(provisioner.deleteCalls) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected delete calls %v but got %v", test.expectedDeletes, len(provisioner.deleteCalls))
+		}
+	}
+}
+
+func TestStorageClassName(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverVersion string
+		annotation    string
+		expectedClass string
+		expectedGets  int
+	}{
+		{
+			name:          "storageClassName on 1.6",
+			serverVersion: "v1.6.0",
+			expectedClass: "class-1",
+			expectedGets:  1,
+		},
+		{
+			name:          "annotation takes precedence",
+			serverVersion: "v1.6.0",
+			annotation:    "class-2",
+			expectedClass: "class-2",
+			expectedGets:  0,
+		},
+		{
+			name:          "storageClassName not read before 1.6",
+			serverVersion: "v1.5.0",
+			expectedClass: "",
+			expectedGets:  0,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"))
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", newTestProvisioner(), test.serverVersion, false, failedRetryThreshold)
+		ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+		gets := 0
+		if ctrl.getRawClaim != nil {
+			ctrl.getRawClaim = func(namespace, name string) ([]byte, error) {
+				gets++
+				return []byte(`{"metadata": {"namespace": "default", "name": "claim-1", "uid": "uid-1-1", "resourceVersion": "0"}, "spec": {"storageClassName": "class-1"}}`), nil
+			}
+		}
+
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		delete(claim.Annotations, annClass)
+		if test.annotation != "" {
+			claim.Annotations[annClass] = test.annotation
+		}
+		client.Core().PersistentVolumeClaims(claim.Namespace).Create(claim)
+
+		// the class is fetched once per version of the claim
+		for i := 0; i < 2; i++ {
+			if class := ctrl.getClaimClass(claim); class != test.expectedClass {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected class %q but got %q", test.expectedClass, class)
+			}
+		}
+		if gets != test.expectedGets {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d gets of the claim but got %d", test.expectedGets, gets)
+		}
+		if test.expectedClass != "class-1" {
+			continue
+		}
+
+		if !ctrl.shouldProvision(claim) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision claim with only storageClassName but got false")
+			continue
+		}
+		if err := ctrl.provisionClaimOperation(claim); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error provisioning: %v", err)
+			continue
+		}
+		volume, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1")
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume but got error: %v", err)
+		} else if volume.Annotations[annClass] != "class-1" {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume of class class-1 but got %q", volume.Annotations[annClass])
+		}
+
+		ctrl.deleteClaim(claim)
+		if _, ok := ctrl.claimClasses[claim.UID]; ok {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected class of deleted claim to be forgotten")
+		}
+	}
+}
+
+func TestStorageClassGroups(t *testing.T) {
+	tests := []struct {
+		name         string
+		groupVersion string
+	}{
+		{
+			name:         "server serves only storage.k8s.io/v1",
+			groupVersion: "storage.k8s.io/v1",
+		},
+		{
+			name:         "server serves only storage.k8s.io/v1beta1",
+			groupVersion: "storage.k8s.io/v1beta1",
+		},
+	}
+	for _, test := range tests {
+		prefix := "/apis/" + test.groupVersion
+		var watches int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case r.URL.Path == prefix:
+				fmt.Fprintf(w, `{"kind": "APIResourceList", "apiVersion": "v1", "groupVersion": %q, "resources": [{"name": "storageclasses", "namespaced": false, "kind": "StorageClass"}]}`, test.groupVersion)
+			case r.URL.Path == prefix+"/watch/storageclasses" || r.URL.Path == prefix+"/storageclasses" && r.URL.Query().Get("watch") == "true":
+				if atomic.AddInt32(&watches, 1) > 1 {
+					<-r.Context().Done()
+					return
+				}
+				fmt.Fprintf(w, `{"type": "ADDED", "object": {"kind": "StorageClass", "apiVersion": %q, "metadata": {"name": "class-2", "resourceVersion": "2"}, "provisioner": "foo.bar/baz"}}`, test.groupVersion)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			case r.URL.Path == prefix+"/storageclasses":
+				fmt.Fprintf(w, `{"kind": "StorageClassList", "apiVersion": %q, "metadata": {"resourceVersion": "1"}, "items": [{"metadata": {"name": "class-1", "resourceVersion": "1"}, "provisioner": "foo.bar/baz", "parameters": {"foo": "bar"}}]}`, test.groupVersion)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatalf("error creating client: %v", err)
+		}
+
+		informer := NewInformerFactory(client, time.Hour).Classes()
+		stopCh := make(chan struct{})
+		go informer.Run(stopCh)
+		err = wait.Poll(10*time.Millisecond, 2*time.Second, func() (bool, error) {
+			return len(informer.GetStore().List()) == 2, nil
+		})
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected classes class-1 and class-2 but got %v", informer.GetStore().ListKeys())
+		}
+		if obj, found, _ := informer.GetStore().GetByKey("class-1"); found {
+			class := obj.(*v1beta1.StorageClass)
+			if class.Provisioner != "foo.bar/baz" || class.Parameters["foo"] != "bar" {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected class-1 with provisioner foo.bar/baz and parameter foo=bar but got %+v", class)
+			}
+		}
+		close(stopCh)
+		server.CloseClientConnections()
+		server.Close()
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	tests := []struct {
+		name           string
+		numControllers int
+		expectedCalls  int
+	}{
+		{
+			name:           "only the leader provisions",
+			numControllers: 3,
+			expectedCalls:  1,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newClaim("claim-1", "uid-1-1", "class-1", "", nil), newStorageClass("class-1", "foo.bar/baz"))
+
+		provisioner := newTestProvisioner()
+		ctrls := make([]*ProvisionController, test.numControllers)
+		stopChs := make([]chan struct{}, test.numControllers)
+		for i := 0; i < test.numControllers; i++ {
+			ctrls[i] = NewProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold, leaderelection.DefaultLeaseDuration, leaderelection.DefaultRenewDeadline, leaderelection.DefaultRetryPeriod, leaderelection.DefaultTermLimit, LeaderElection(v1.NamespaceDefault))
+			ctrls[i].createProvisionedPVInterval = 10 * time.Millisecond
+			stopChs[i] = make(chan struct{})
+			go ctrls[i].Run(stopChs[i])
+		}
+
+		time.Sleep(10 * resyncPeriod)
+
+		if test.expectedCalls != len(provisioner.provisionCalls) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision calls:\n %v\n but got:\n %v\n", test.expectedCalls, len(provisioner.provisionCalls))
+		}
+
+		lock := &rl.EndpointsLock{
+			EndpointsMeta: v1.ObjectMeta{Namespace: v1.NamespaceDefault, Name: "foo.bar-baz"},
+			Client:        client,
+		}
+		record, err := lock.Get()
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error getting leader election record: %v", err)
+		} else {
+			found := false
+			for _, ctrl := range ctrls {
+				if record.HolderIdentity == string(ctrl.identity) {
+					found = true
+				}
+			}
+			if !found {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected a controller to hold the lock but got holder %q", record.HolderIdentity)
+			}
+		}
+
+		for _, stopCh := range stopChs {
+			close(stopCh)
+		}
+	}
+}
+
+func TestSharedInformers(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newStorageClass("class-1", "foo.bar/baz"),
+		newStorageClass("class-2", "abc.def/ghi"),
+		newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		newClaim("claim-2", "uid-1-2", "class-2", "", nil),
+	)
+
+	informers := NewInformerFactory(client, resyncPeriod)
+	ctrl1 := NewProvisionController(client, resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, Informers(informers))
+	ctrl2 := NewProvisionController(client, resyncPeriod, "abc.def/ghi", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, Informers(informers))
+	if ctrl1.claims != ctrl2.claims || ctrl1.volumes != ctrl2.volumes || ctrl1.classes != ctrl2.classes {
+		t.Errorf("expected controllers to share caches")
+	}
+
+	stopCh := make(chan struct{})
+	go ctrl1.Run(stopCh)
+	go ctrl2.Run(stopCh)
+
+	time.Sleep(2 * resyncPeriod)
+	ctrl1.runningOperations.Wait()
+	ctrl2.runningOperations.Wait()
+
+	expectedVolumes := map[string]v1.PersistentVolume{}
+	for _, pv := range []*v1.PersistentVolume{
+		newProvisionedVolume(newStorageClass("class-1", "foo.bar/baz"), newClaim("claim-1", "uid-1-1", "class-1", "", nil)),
+		newProvisionedVolume(newStorageClass("class-2", "abc.def/ghi"), newClaim("claim-2", "uid-1-2", "class-2", "", nil)),
+	} {
+		expectedVolumes[pv.Name] = *pv
+	}
+	// The controllers provision concurrently so the list order isn't fixed
+	pvList, _ := client.Core().PersistentVolumes().List(v1.ListOptions{})
+	volumes := map[string]v1.PersistentVolume{}
+	for _, pv := range pvList.Items {
+		volumes[pv.Name] = pv
+	}
+	if !reflect.DeepEqual(expectedVolumes, volumes) {
+		t.Errorf("expected PVs:\n %v\n but got:\n %v\n", expectedVolumes, volumes)
+	}
+	close(stopCh)
+}
+
+func TestProvisioningJournal(t *testing.T) {
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	volume := newProvisionedVolume(newStorageClass("class-1", "foo.bar/baz"), claim)
+	entry := JournalEntry{PVName: volume.Name, ClaimNamespace: claim.Namespace, ClaimName: claim.Name, ClaimUID: claim.UID}
+	provisionedEntry := entry
+	provisionedEntry.Volume = volume
+
+	tests := []struct {
+		name            string
+		objs            []runtime.Object
+		entries         []JournalEntry
+		ignoreDeletes   bool
+		expectedVolumes []v1.PersistentVolume
+		expectedDeletes int
+		expectedEntries int
+	}{
+		{
+			name: "journal provisioning of claim-1",
+			objs: []runtime.Object{
+				newStorageClass("class-1", "foo.bar/baz"),
+				newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			},
+			expectedVolumes: []v1.PersistentVolume{*volume},
+		},
+		{
+			name: "save volume left in journal for claim-1",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			},
+			entries:         []JournalEntry{provisionedEntry},
+			expectedVolumes: []v1.PersistentVolume{*volume},
+		},
+		{
+			name:            "delete volume left in journal for deleted claim-1",
+			entries:         []JournalEntry{provisionedEntry},
+			expectedVolumes: []v1.PersistentVolume(nil),
+			expectedDeletes: 1,
+		},
+		{
+			name:            "keep journal entry of volume whose delete is ignored",
+			entries:         []JournalEntry{provisionedEntry},
+			ignoreDeletes:   true,
+			expectedVolumes: []v1.PersistentVolume(nil),
+			expectedDeletes: 1,
+			expectedEntries: 1,
+		},
+		{
+			name: "delete volume left in journal for recreated claim-1",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1-2", "class-1", "", nil),
+			},
+			entries:         []JournalEntry{provisionedEntry},
+			expectedVolumes: []v1.PersistentVolume(nil),
+			expectedDeletes: 1,
+		},
+		{
+			name: "drop journal entry of saved volume",
+			objs: []runtime.Object{
+				volume,
+			},
+			entries:         []JournalEntry{provisionedEntry},
+			expectedVolumes: []v1.PersistentVolume{*volume},
+		},
+		{
+			name:            "drop journal entry of unfinished provision call",
+			entries:         []JournalEntry{entry},
+			expectedVolumes: []v1.PersistentVolume(nil),
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objs...)
+		journal := NewConfigMapJournal(client, v1.NamespaceDefault, "foo.bar-baz-journal")
+		for _, entry := range test.entries {
+			if err := journal.Put(entry); err != nil {
+				t.Fatalf("Error putting journal entry: %v", err)
+			}
+		}
+		provisioner := newTestProvisioner()
+		var p Provisioner = provisioner
+		if test.ignoreDeletes {
+			p = &ignoringTestProvisioner{provisioner}
+		}
+		ctrl := NewProvisionController(client, resyncPeriod, "foo.bar/baz", p, "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, ProvisioningJournal(journal))
+		ctrl.createProvisionedPVInterval = 10 * time.Millisecond
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		ctrl.runningOperations.Wait()
+
+		pvList, _ := client.Core().PersistentVolumes().List(v1.ListOptions{})
+		if !reflect.DeepEqual(test.expectedVolumes, pvList.Items) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected PVs:\n %v\n but got:\n %v\n", test.expectedVolumes, pvList.Items)
+		}
+		if test.expectedDeletes != len(provisioner.deleteCalls) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected delete calls:\n %v\n but got:\n %v\n", test.expectedDeletes, len(provisioner.deleteCalls))
+		}
+		entries, err := journal.List()
+		if err != nil || len(entries) != test.expectedEntries {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d journal entries but got:\n %v, %v\n", test.expectedEntries, entries, err)
+		}
+		close(stopCh)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	client := fake.NewSimpleClientset(newStorageClass("class-1", "metrics.test/bad"), claim)
+	ctrl := newTestProvisionController(client, resyncPeriod, "metrics.test/bad", newBadTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+	stopCh := make(chan struct{})
+	go ctrl.Run(stopCh)
+
+	time.Sleep(2 * resyncPeriod)
+	close(stopCh)
+	ctrl.runningOperations.Wait()
+
+	if v := gaugeValue(t, metrics.ClaimProvisionFailures, "metrics.test/bad", "default/claim-1"); v < 1 {
+		t.Errorf("expected at least 1 claim provision failure but got %v", v)
+	}
+	if v := gaugeValue(t, metrics.OperationQueueDepth, "metrics.test/bad"); v != 0 {
+		t.Errorf("expected empty queue but got depth %v", v)
+	}
+	if v := gaugeValue(t, metrics.LastResyncTime, "metrics.test/bad", "persistentvolumeclaims"); v == 0 {
+		t.Errorf("expected claims resync time to be set")
+	}
+
+	ctrl.deleteClaim(claim)
+	if metrics.ClaimProvisionFailures.DeleteLabelValues("metrics.test/bad", "default/claim-1") {
+		t.Errorf("expected claim provision failures of deleted claim to be removed")
+	}
+}
+
+func TestOrphanReaper(t *testing.T) {
+	tests := []struct {
+		name            string
+		provisionerName string
+		gracePeriod     time.Duration
+		deleteOrphans   bool
+		expectedOrphans float64
+		expectedDeletes int
+	}{
+		{
+			name:            "report orphan",
+			provisionerName: "reaper.test/report",
+			expectedOrphans: 1,
+		},
+		{
+			name:            "delete orphan",
+			provisionerName: "reaper.test/delete",
+			deleteOrphans:   true,
+			expectedDeletes: 1,
+		},
+		{
+			name:            "orphan within grace period",
+			provisionerName: "reaper.test/grace",
+			gracePeriod:     time.Hour,
+			deleteOrphans:   true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, nil))
+		provisioner := &listerTestProvisioner{newTestProvisioner(), []string{"volume-1", "volume-2"}}
+		ctrl := NewProvisionController(client, resyncPeriod, test.provisionerName, provisioner, "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, OrphanReaper(resyncPeriod, test.gracePeriod, test.deleteOrphans))
+
+		ctrl.reapOrphans()
+
+		if len(provisioner.deleteCalls) != test.expectedDeletes {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d delete calls but got %d", test.expectedDeletes, len(provisioner.deleteCalls))
+		}
+		if v := gaugeValue(t, metrics.OrphanedVolumes, test.provisionerName); v != test.expectedOrphans {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %v orphans but got %v", test.expectedOrphans, v)
+		}
+	}
+}
+
+func TestPendingClaimResync(t *testing.T) {
+	old := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	old.CreationTimestamp = unversioned.NewTime(time.Now().Add(-time.Hour))
+	recent := newClaim("claim-2", "uid-1-2", "class-1", "", nil)
+	recent.CreationTimestamp = unversioned.Now()
+	other := newClaim("claim-3", "uid-1-3", "class-2", "", nil)
+	other.CreationTimestamp = unversioned.NewTime(time.Now().Add(-time.Hour))
+	class := newStorageClass("class-1", "pending.test/resync")
+	// The claims aren't in the controller's cache, as if their events were lost
+	client := fake.NewSimpleClientset(class, newStorageClass("class-2", "foo.bar/baz"), old, recent, other)
+	provisioner := newTestProvisioner()
+	ctrl := NewProvisionController(client, resyncPeriod, "pending.test/resync", provisioner, "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, PendingClaimResync(time.Minute))
+	ctrl.createProvisionedPVInterval = 10 * time.Millisecond
+	ctrl.classes.Add(class)
+
+	ctrl.resyncPendingClaims()
+	time.Sleep(2 * resyncPeriod)
+	ctrl.runningOperations.Wait()
+
+	if v := gaugeValue(t, metrics.StuckPendingClaims, "pending.test/resync"); v != 1 {
+		t.Errorf("expected 1 stuck claim but got %v", v)
+	}
+	pvList, _ := client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if len(pvList.Items) != 2 {
+		t.Errorf("expected 2 PVs for the pending claims of class-1 but got %d", len(pvList.Items))
+	}
+
+	if err := PendingClaimResync(0)(&ProvisionController{}); err == nil {
+		t.Errorf("expected error for non-positive threshold")
+	}
+}
+
+func TestOrphanReaperRequiresLister(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	err := OrphanReaper(resyncPeriod, 0, false)(&ProvisionController{client: client, provisioner: newTestProvisioner()})
+	if err == nil {
+		t.Errorf("expected error for provisioner that doesn't implement Lister")
+	}
+}
+
+func TestProvisioningParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		exclude     []string
+		annotations map[string]string
+		expected    map[string]string
+		expectedOK  bool
+		expectError bool
+	}{
+		{
+			name:       "record parameters",
+			parameters: map[string]string{"monitors": "10.0.0.1", "adminSecretName": "secret"},
+			expected:   map[string]string{"monitors": "10.0.0.1", "adminSecretName": "secret"},
+			expectedOK: true,
+		},
+		{
+			name:       "exclude parameters ignoring case",
+			parameters: map[string]string{"monitors": "10.0.0.1", "password": "foo", "Token": "bar"},
+			exclude:    []string{"Password", "token"},
+			expected:   map[string]string{"monitors": "10.0.0.1"},
+			expectedOK: true,
+		},
+		{
+			name:       "record no parameters",
+			expected:   map[string]string{},
+			expectedOK: true,
+		},
+		{
+			name:        "nothing recorded",
+			annotations: map[string]string{},
+		},
+		{
+			name:        "bad annotation",
+			annotations: map[string]string{annProvisioningParameters: "monitors=10.0.0.1"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		volume := newVolume("volume-1", v1.VolumeAvailable, v1.PersistentVolumeReclaimDelete, test.annotations)
+		if test.annotations == nil {
+			if err := SetProvisioningParameters(volume, test.parameters, test.exclude...); err != nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("unexpected error recording parameters: %v", err)
+			}
+		}
+
+		parameters, ok, err := GetProvisioningParameters(volume)
+		if test.expectError != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v", test.expectError, err)
+		}
+		if !reflect.DeepEqual(test.expected, parameters) || test.expectedOK != ok {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected parameters %v, %v but got %v, %v", test.expected, test.expectedOK, parameters, ok)
+		}
+	}
+}
+
+func TestUpdateVolume(t *testing.T) {
+	tests := []struct {
+		name              string
+		conflicts         int
+		annotation        string
+		expectedUpdates   int
+		expectedMutations int
+		expectError       bool
+	}{
+		{
+			name:              "update volume",
+			annotation:        "bar",
+			expectedUpdates:   1,
+			expectedMutations: 1,
+		},
+		{
+			name:              "volume needs no update",
+			annotation:        "foo",
+			expectedMutations: 1,
+		},
+		{
+			name:              "retry on conflict",
+			conflicts:         2,
+			annotation:        "bar",
+			expectedUpdates:   3,
+			expectedMutations: 3,
+		},
+		{
+			name:              "give up after conflicts",
+			conflicts:         updateVolumeRetries,
+			annotation:        "bar",
+			expectedUpdates:   updateVolumeRetries,
+			expectedMutations: updateVolumeRetries,
+			expectError:       true,
+		},
+	}
+	for _, test := range tests {
+		volume := newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{"foo": "foo"})
+		client := fake.NewSimpleClientset(volume)
+		updates := 0
+		client.PrependReactor("update", "persistentvolumes", func(action testclient.Action) (bool, runtime.Object, error) {
+			updates++
+			if updates <= test.conflicts {
+				return true, nil, apierrs.NewConflict(unversioned.GroupResource{Resource: "persistentvolumes"}, "volume-1", errors.New("fake conflict"))
+			}
+			return false, nil, nil
+		})
+		mutations := 0
+		mutate := func(volume *v1.PersistentVolume) (bool, error) {
+			mutations++
+			if volume.Annotations["foo"] == test.annotation {
+				return false, nil
+			}
+			volume.Annotations["foo"] = test.annotation
+			return true, nil
+		}
+
+		updated, err := UpdateVolume(client, volume, mutate)
+		if test.expectError != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v", test.expectError, err)
+		}
+		if updates != test.expectedUpdates || mutations != test.expectedMutations {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d updates and %d mutations but got %d and %d", test.expectedUpdates, test.expectedMutations, updates, mutations)
+		}
+		if volume.Annotations["foo"] != "foo" {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected given volume to be left unmodified")
+		}
+		if err == nil && updated.Annotations["foo"] != test.annotation {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected annotation %q but got %q", test.annotation, updated.Annotations["foo"])
+		}
+	}
+}
+
+func TestUpdater(t *testing.T) {
+	provisioner := &updaterTestProvisioner{newTestProvisioner()}
+	ctrl := newTestProvisionController(fake.NewSimpleClientset(), resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+
+	tests := []struct {
+		name           string
+		volume         *v1.PersistentVolume
+		expectedUpdate bool
+	}{
+		{
+			name:           "our volume",
+			volume:         newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			expectedUpdate: true,
+		},
+		{
+			name:           "another provisioner's volume",
+			volume:         newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "abc.def/ghi"}),
+			expectedUpdate: false,
+		},
+	}
+	for _, test := range tests {
+		if should := ctrl.shouldUpdate(test.volume); should != test.expectedUpdate {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should update %v but got %v", test.expectedUpdate, should)
+		}
+	}
+
+	volume := tests[0].volume
+	ctrl.client = fake.NewSimpleClientset(volume)
+	if err := ctrl.updateVolumeOperation(volume); err != nil {
+		t.Errorf("unexpected error updating volume: %v", err)
+	}
+	if updated, _ := ctrl.client.Core().PersistentVolumes().Get(volume.Name); updated.Annotations["foo"] != "bar" {
+		t.Errorf("expected volume to be updated but got annotations %v", updated.Annotations)
+	}
+
+	if ctrl := newTestProvisionController(fake.NewSimpleClientset(), resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold); ctrl.shouldUpdate(tests[0].volume) {
+		t.Errorf("expected no updates for provisioner that doesn't implement Updater")
+	}
+}
+
+func TestRecycler(t *testing.T) {
+	tests := []struct {
+		name            string
+		recycle         bool
+		claimRef         *v1.ObjectReference
+		expectedVolume   bool
+		expectedRecycles int
+		expectedDeletes  int
+	}{
+		{
+			name:             "recycle volume",
+			recycle:          true,
+			claimRef:         &v1.ObjectReference{Namespace: "default", Name: "claim-1", UID: "uid-1-1"},
+			expectedVolume:   true,
+			expectedRecycles: 1,
+		},
+		{
+			name:           "skip volume already recycled",
+			recycle:        true,
+			expectedVolume: true,
+		},
+		{
+			name:             "delete volume not to be recycled",
+			claimRef:         &v1.ObjectReference{Namespace: "default", Name: "claim-1", UID: "uid-1-1"},
+			expectedRecycles: 1,
+			expectedDeletes:  1,
+		},
+	}
+	for _, test := range tests {
+		volume := newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+		if test.claimRef != nil {
+			volume.Spec.ClaimRef = test.claimRef
+			volume.Annotations[annBoundByController] = "yes"
+		}
+		client := fake.NewSimpleClientset(volume)
+		provisioner := &recyclerTestProvisioner{testProvisioner: newTestProvisioner(), recycle: test.recycle}
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+
+		if err := ctrl.deleteVolumeOperation(volume); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v", err)
+		}
+		if provisioner.recycles != test.expectedRecycles || len(provisioner.deleteCalls) != test.expectedDeletes {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d Recycle and %d Delete calls but got %d and %d", test.expectedRecycles, test.expectedDeletes, provisioner.recycles, len(provisioner.deleteCalls))
+		}
+		recycled, err := client.Core().PersistentVolumes().Get("volume-1")
+		if !test.expectedVolume {
+			if err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected volume to be deleted")
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume to be kept but got error: %v", err)
+			continue
+		}
+		if recycled.Spec.ClaimRef != nil || hasAnnotation(recycled.ObjectMeta, annBoundByController) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume to be unbound but got claim %v and annotations %v", recycled.Spec.ClaimRef, recycled.Annotations)
+		}
+	}
+}
+
+func TestVolumeProtection(t *testing.T) {
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), claim)
+	ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+	VolumeProtection()(ctrl)
+	ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+	if err := ctrl.provisionClaimOperation(claim); err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	provisioned, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1")
+	if err != nil {
+		t.Fatalf("unexpected error getting provisioned volume: %v", err)
+	}
+	if !hasFinalizer(provisioned.ObjectMeta, finalizerVolumeProtection) {
+		t.Errorf("expected provisioned volume to have finalizer but got %v", provisioned.Finalizers)
+	}
+
+	tests := []struct {
+		name            string
+		phase           v1.PersistentVolumePhase
+		policy          v1.PersistentVolumeReclaimPolicy
+		deleted         bool
+		expectDelete    bool
+		expectVolume    bool
+		expectFinalizer bool
+	}{
+		{
+			name:         "released volume",
+			phase:        v1.VolumeReleased,
+			policy:       v1.PersistentVolumeReclaimDelete,
+			expectDelete: true,
+		},
+		{
+			name:         "deleted available volume",
+			phase:        v1.VolumeAvailable,
+			policy:       v1.PersistentVolumeReclaimDelete,
+			deleted:      true,
+			expectDelete: true,
+			expectVolume: true,
+		},
+		{
+			name:            "deleted bound volume",
+			phase:           v1.VolumeBound,
+			policy:          v1.PersistentVolumeReclaimDelete,
+			deleted:         true,
+			expectVolume:    true,
+			expectFinalizer: true,
+		},
+		{
+			name:         "deleted retained volume",
+			phase:        v1.VolumeReleased,
+			policy:       v1.PersistentVolumeReclaimRetain,
+			deleted:      true,
+			expectVolume: true,
+		},
+	}
+	for _, test := range tests {
+		volume := newVolume("volume-1", test.phase, test.policy, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+		volume.Finalizers = []string{finalizerVolumeProtection}
+		if test.deleted {
+			now := unversioned.Now()
+			volume.DeletionTimestamp = &now
+		}
+		client := fake.NewSimpleClientset(volume)
+		provisioner := newTestProvisioner()
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+
+		var err error
+		if ctrl.shouldDelete(volume) {
+			err = ctrl.deleteVolumeOperation(volume)
+		} else if ctrl.shouldRemoveFinalizer(volume) {
+			err = ctrl.removeFinalizerOperation(volume)
+		}
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v", err)
+		}
+		if deleted := len(provisioner.deleteCalls) > 0; deleted != test.expectDelete {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected Delete called %v but got %v", test.expectDelete, deleted)
+		}
+		// the fake client ignores finalizers: it deletes volumes right away
+		// and keeps those with a deletion timestamp
+		saved, err := client.Core().PersistentVolumes().Get("volume-1")
+		if (err == nil) != test.expectVolume {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume %v but got error %v", test.expectVolume, err)
+		}
+		if err == nil && hasFinalizer(saved.ObjectMeta, finalizerVolumeProtection) != test.expectFinalizer {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected finalizer %v but got finalizers %v", test.expectFinalizer, saved.Finalizers)
+		}
+	}
+}
+
+func TestVolumeSizeLimits(t *testing.T) {
+	tests := []struct {
+		name             string
+		size             string
+		options          []func(*ProvisionController) error
+		expectProvision  bool
+		expectOptionsErr bool
+	}{
+		{
+			name:            "no limits",
+			size:            "1Mi",
+			expectProvision: true,
+		},
+		{
+			name:            "within limits",
+			size:            "1Gi",
+			options:         []func(*ProvisionController) error{MinimumVolumeSize(resource.MustParse("1Gi")), MaximumVolumeSize(resource.MustParse("1Ti"))},
+			expectProvision: true,
+		},
+		{
+			name:    "below minimum",
+			size:    "1Mi",
+			options: []func(*ProvisionController) error{MinimumVolumeSize(resource.MustParse("1Gi"))},
+		},
+		{
+			name:    "above maximum",
+			size:    "2Ti",
+			options: []func(*ProvisionController) error{MaximumVolumeSize(resource.MustParse("1Ti"))},
+		},
+		{
+			name:             "minimum above maximum",
+			options:          []func(*ProvisionController) error{MaximumVolumeSize(resource.MustParse("1Gi")), MinimumVolumeSize(resource.MustParse("1Ti"))},
+			expectOptionsErr: true,
+		},
+		{
+			name:             "zero maximum",
+			options:          []func(*ProvisionController) error{MaximumVolumeSize(resource.MustParse("0"))},
+			expectOptionsErr: true,
+		},
+	}
+	for _, test := range tests {
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		if test.size != "" {
+			claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)] = resource.MustParse(test.size)
+		}
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), claim)
+		provisioner := newTestProvisioner()
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+
+		var err error
+		for _, option := range test.options {
+			if err = option(ctrl); err != nil {
+				break
+			}
+		}
+		if test.expectOptionsErr {
+			if err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected error processing options")
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error processing options: %v", err)
+			continue
+		}
+
+		// Fill the cache the storage class is looked up in
+		ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+		err = ctrl.provisionClaimOperation(claim)
+
+		if provisioned := len(provisioner.provisionCalls) == 1; provisioned != test.expectProvision {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision called %v but got %v", test.expectProvision, provisioned)
+		}
+		if !test.expectProvision && err == nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error provisioning claim outside size limits")
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	provisioner := &capabilitiesTestProvisioner{newTestProvisioner()}
+
+	tests := []struct {
+		name            string
+		size            string
+		accessModes     []v1.PersistentVolumeAccessMode
+		expectProvision bool
+	}{
+		{
+			name:            "supported claim",
+			size:            "1Gi",
+			accessModes:     []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			expectProvision: true,
+		},
+		{
+			name:        "unsupported access mode",
+			size:        "1Gi",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadWriteMany},
+		},
+		{
+			name:        "below minimum",
+			size:        "1Mi",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+		},
+	}
+	for _, test := range tests {
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)] = resource.MustParse(test.size)
+		claim.Spec.AccessModes = test.accessModes
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), claim)
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+
+		// Fill the cache the storage class is looked up in
+		ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+		err := ctrl.provisionClaimOperation(claim)
+
+		provisioned := false
+		select {
+		case <-provisioner.provisionCalls:
+			provisioned = true
+		default:
+		}
+		if provisioned != test.expectProvision {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision called %v but got %v", test.expectProvision, provisioned)
+		}
+		if !test.expectProvision && err == nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error provisioning unsupported claim")
+		}
+	}
+
+	goodClass := newStorageClass("good", "foo.bar/baz")
+	goodClass.Parameters = map[string]string{"Foo": "bar"}
+	badClass := newStorageClass("bad", "foo.bar/baz")
+	badClass.Parameters = map[string]string{"foo": "bar", "baz": "qux"}
+	// events can only be recorded on objects with a selfLink
+	goodClass.SelfLink = testapi.Storage.SelfLink("storageclasses", "good")
+	badClass.SelfLink = testapi.Storage.SelfLink("storageclasses", "bad")
+	client := fake.NewSimpleClientset()
+	ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+	if err := ctrl.validateClass(goodClass); err != nil {
+		t.Errorf("unexpected error validating class with supported parameters: %v", err)
+	}
+	ctrl.addClass(goodClass)
+	ctrl.addClass(badClass)
+
+	// events are recorded asynchronously
+	err := wait.Poll(10*time.Millisecond, 2*time.Second, func() (bool, error) {
+		events, err := client.Core().Events(v1.NamespaceDefault).List(v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, event := range events.Items {
+			if event.Reason == "InvalidStorageClass" && event.InvolvedObject.Name == "good" {
+				return false, fmt.Errorf("unexpected InvalidStorageClass event for class with supported parameters: %s", event.Message)
+			}
+			if event.Reason == "InvalidStorageClass" && event.InvolvedObject.Name == "bad" && strings.Contains(event.Message, "baz") {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Errorf("expected InvalidStorageClass event for class with unsupported parameters: %v", err)
+	}
+}
+
+func TestPreProvisionHooks(t *testing.T) {
+	injectParameter := func(options *VolumeOptions) error {
+		options.Parameters["injected"] = options.PVC.Namespace
+		return nil
+	}
+	veto := func(options *VolumeOptions) error {
+		return errors.New("vetoed")
+	}
+	renameVolume := func(options *VolumeOptions) error {
+		options.PVName = "renamed"
+		return nil
+	}
+	tests := []struct {
+		name               string
+		hooks              []PreProvisionHook
+		preProvisioner     bool
+		expectProvision    bool
+		expectedParameters map[string]string
+		expectOptionsErr   bool
+	}{
+		{
+			name:               "no hooks",
+			preProvisioner:     true,
+			expectProvision:    true,
+			expectedParameters: map[string]string{"foo": "bar", "pre-provisioned": "default"},
+		},
+		{
+			name:               "hook and provisioner's PreProvision in order",
+			hooks:              []PreProvisionHook{injectParameter},
+			preProvisioner:     true,
+			expectProvision:    true,
+			expectedParameters: map[string]string{"foo": "bar", "injected": "default", "pre-provisioned": "default"},
+		},
+		{
+			name:               "hook without PreProvisioner",
+			hooks:              []PreProvisionHook{injectParameter},
+			expectProvision:    true,
+			expectedParameters: map[string]string{"foo": "bar", "injected": "default"},
+		},
+		{
+			name:           "veto",
+			hooks:          []PreProvisionHook{veto, injectParameter},
+			preProvisioner: true,
+		},
+		{
+			name:  "renamed volume",
+			hooks: []PreProvisionHook{renameVolume},
+		},
+		{
+			name:             "empty hooks",
+			hooks:            []PreProvisionHook{},
+			expectOptionsErr: true,
+		},
+	}
+	for _, test := range tests {
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		class := newStorageClass("class-1", "foo.bar/baz")
+		class.Parameters = map[string]string{"foo": "bar"}
+		client := fake.NewSimpleClientset(class, claim)
+		provisioner := &preProvisionTestProvisioner{testProvisioner: newTestProvisioner()}
+		var p Provisioner = provisioner
+		if test.preProvisioner {
+			p = &preProvisionerTestProvisioner{provisioner}
+		}
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", p, "v1.5.0", false, failedRetryThreshold)
+
+		var err error
+		if test.hooks != nil {
+			err = PreProvisionHooks(test.hooks...)(ctrl)
+		}
+		if test.expectOptionsErr {
+			if err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected error processing options")
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error processing options: %v", err)
+			continue
+		}
+
+		// Fill the cache the storage class is looked up in
+		ctrl.classes.Add(class)
+		err = ctrl.provisionClaimOperation(claim)
+
+		if provisioned := provisioner.options != nil; provisioned != test.expectProvision {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision called %v but got %v", test.expectProvision, provisioned)
+			continue
+		}
+		if !test.expectProvision {
+			if err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected error provisioning vetoed claim")
+			}
+			continue
+		}
+		if !reflect.DeepEqual(test.expectedParameters, provisioner.options.Parameters) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected parameters %v but got %v", test.expectedParameters, provisioner.options.Parameters)
+		}
+		if !reflect.DeepEqual(map[string]string{"foo": "bar"}, class.Parameters) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected class parameters unmodified but got %v", class.Parameters)
+		}
+	}
+}
+
+func TestClaimParameters(t *testing.T) {
+	tests := []struct {
+		name               string
+		parameters         map[string]string
+		annotations        map[string]string
+		expectProvision    bool
+		expectedParameters map[string]string
+	}{
+		{
+			name:               "no overrides",
+			parameters:         map[string]string{"foo": "bar"},
+			expectProvision:    true,
+			expectedParameters: map[string]string{"foo": "bar"},
+		},
+		{
+			name:               "one override not allowed",
+			parameters:         map[string]string{"foo": "bar", "gid": "1000", allowClaimParameterKeys: "gid, foo"},
+			annotations:        map[string]string{annClaimParameterPrefix + "gid": "1001", annClaimParameterPrefix + "zone": "b"},
+			expectProvision:    false,
+			expectedParameters: nil,
+		},
+		{
+			name:               "allowed overrides, allow list stripped",
+			parameters:         map[string]string{"foo": "bar", "gid": "1000", allowClaimParameterKeys: "gid, zone"},
+			annotations:        map[string]string{annClaimParameterPrefix + "gid": "1001", annClaimParameterPrefix + "zone": "b"},
+			expectProvision:    true,
+			expectedParameters: map[string]string{"foo": "bar", "gid": "1001", "zone": "b"},
+		},
+		{
+			name:               "allow list without overrides",
+			parameters:         map[string]string{"foo": "bar", allowClaimParameterKeys: "gid"},
+			expectProvision:    true,
+			expectedParameters: map[string]string{"foo": "bar"},
+		},
+		{
+			name:        "class doesn't opt in",
+			parameters:  map[string]string{"foo": "bar"},
+			annotations: map[string]string{annClaimParameterPrefix + "foo": "baz"},
+		},
+	}
+	for _, test := range tests {
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", test.annotations)
+		class := newStorageClass("class-1", "foo.bar/baz")
+		class.Parameters = test.parameters
+		client := fake.NewSimpleClientset(class, claim)
+		provisioner := &preProvisionTestProvisioner{testProvisioner: newTestProvisioner()}
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+
+		// Fill the cache the storage class is looked up in
+		ctrl.classes.Add(class)
+		err := ctrl.provisionClaimOperation(claim)
+
+		if provisioned := provisioner.options != nil; provisioned != test.expectProvision {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision called %v but got %v", test.expectProvision, provisioned)
+			continue
+		}
+		if !test.expectProvision {
+			if err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected error provisioning claim with disallowed overrides")
+			}
+			continue
+		}
+		if !reflect.DeepEqual(test.expectedParameters, provisioner.options.Parameters) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected parameters %v but got %v", test.expectedParameters, provisioner.options.Parameters)
+		}
+	}
+}
+
+func TestClaimFilters(t *testing.T) {
+	tests := []struct {
+		name            string
+		namespace       string
+		labels          map[string]string
+		options         []func(*ProvisionController) error
+		expectProvision bool
+	}{
+		{
+			name:            "no filters",
+			namespace:       "team-a",
+			expectProvision: true,
+		},
+		{
+			name:            "allowed namespace",
+			namespace:       "team-a",
+			options:         []func(*ProvisionController) error{Namespaces("team-a", "team-b")},
+			expectProvision: true,
+		},
+		{
+			name:            "not allowed namespace",
+			namespace:       "team-c",
+			options:         []func(*ProvisionController) error{Namespaces("team-a", "team-b")},
+			expectProvision: false,
+		},
+		{
+			name:            "excluded namespace",
+			namespace:       "team-b",
+			options:         []func(*ProvisionController) error{Namespaces("team-a", "team-b"), ExcludeNamespaces("team-b")},
+			expectProvision: false,
+		},
+		{
+			name:            "matching labels",
+			namespace:       "team-a",
+			labels:          map[string]string{"team": "storage", "tier": "prod"},
+			options:         []func(*ProvisionController) error{ClaimSelector("team=storage,tier!=dev")},
+			expectProvision: true,
+		},
+		{
+			name:            "not matching labels",
+			namespace:       "team-a",
+			labels:          map[string]string{"team": "storage", "tier": "dev"},
+			options:         []func(*ProvisionController) error{ClaimSelector("team=storage,tier!=dev")},
+			expectProvision: false,
+		},
+	}
+	for _, test := range tests {
+		class := newStorageClass("class-1", "foo.bar/baz")
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		claim.Namespace = test.namespace
+		claim.Labels = test.labels
+		ctrl := newTestProvisionController(fake.NewSimpleClientset(class, claim), resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+		for _, option := range test.options {
+			if err := option(ctrl); err != nil {
+				t.Fatalf("test case %s: unexpected error applying option: %v", test.name, err)
+			}
+		}
+		ctrl.classes.Add(class)
+
+		if should := ctrl.shouldProvision(claim); should != test.expectProvision {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v", test.expectProvision, should)
+		}
+	}
+
+	for _, option := range []func(*ProvisionController) error{Namespaces(), ExcludeNamespaces(), ClaimSelector(""), ClaimSelector("team in (")} {
+		if err := option(&ProvisionController{}); err == nil {
+			t.Errorf("expected error applying invalid option")
+		}
+	}
+}
+
+func TestDelayedBinding(t *testing.T) {
+	tests := []struct {
+		name            string
+		delayedBinding  bool
+		mode            string
+		annotations     map[string]string
+		expectProvision bool
+	}{
+		{
+			name:            "immediate binding",
+			delayedBinding:  true,
+			mode:            "Immediate",
+			expectProvision: true,
+		},
+		{
+			name:            "wait for first consumer without a node",
+			delayedBinding:  true,
+			mode:            volumeBindingWaitForFirstConsumer,
+			expectProvision: false,
+		},
+		{
+			name:            "wait for first consumer with a node",
+			delayedBinding:  true,
+			mode:            volumeBindingWaitForFirstConsumer,
+			annotations:     map[string]string{annSelectedNode: "node-1"},
+			expectProvision: true,
+		},
+		{
+			name:            "delayed binding disabled",
+			mode:            volumeBindingWaitForFirstConsumer,
+			expectProvision: true,
+		},
+	}
+	for _, test := range tests {
+		class := newStorageClass("class-1", "foo.bar/baz")
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", test.annotations)
+		ctrl := newTestProvisionController(fake.NewSimpleClientset(class, claim), resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+		if test.delayedBinding {
+			DelayedBinding()(ctrl)
+			mode := test.mode
+			ctrl.getRawClass = func(name string) ([]byte, error) {
+				return []byte(fmt.Sprintf(`{"metadata": {"name": %q}, "provisioner": "foo.bar/baz", "volumeBindingMode": %q}`, name, mode)), nil
+			}
+		}
+		ctrl.classes.Add(class)
+
+		if should := ctrl.shouldProvision(claim); should != test.expectProvision {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v", test.expectProvision, should)
+		}
+	}
+
+	// The selected node is passed to the provisioner
+	class := newStorageClass("class-1", "foo.bar/baz")
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annSelectedNode: "node-1"})
+	node := &v1.Node{ObjectMeta: v1.ObjectMeta{Name: "node-1"}}
+	provisioner := &nodeTestProvisioner{testProvisioner: newTestProvisioner()}
+	ctrl := newTestProvisionController(fake.NewSimpleClientset(class, claim, node), resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+	ctrl.classes.Add(class)
+
+	if err := ctrl.provisionClaimOperation(claim); err != nil {
+		t.Errorf("unexpected error provisioning claim: %v", err)
+	}
+	if provisioner.selectedNode == nil || provisioner.selectedNode.Name != "node-1" {
+		t.Errorf("expected selected node node-1 but got %v", provisioner.selectedNode)
+	}
+}
+
+func TestClaimBackoff(t *testing.T) {
+	tests := []struct {
+		name           string
+		options        []func(*ProvisionController) error
+		failures       int
+		editClaim      bool
+		editClass      bool
+		expectedShould bool
+	}{
+		{
+			name:           "retry on resync without backoff",
+			failures:       1,
+			expectedShould: true,
+		},
+		{
+			name:           "back off",
+			options:        []func(*ProvisionController) error{ClaimBackoff(time.Hour, 2*time.Hour)},
+			failures:       1,
+			expectedShould: false,
+		},
+		{
+			name:           "stop after threshold",
+			failures:       failedRetryThreshold,
+			expectedShould: false,
+		},
+		{
+			name:           "resume after claim edit",
+			failures:       failedRetryThreshold,
+			editClaim:      true,
+			expectedShould: true,
+		},
+		{
+			name:           "resume after class edit",
+			failures:       failedRetryThreshold,
+			editClass:      true,
+			expectedShould: true,
+		},
+		{
+			name:           "stop backing off after claim edit",
+			options:        []func(*ProvisionController) error{ClaimBackoff(time.Hour, 2*time.Hour)},
+			failures:       1,
+			editClaim:      true,
+			expectedShould: true,
+		},
+	}
+	for _, test := range tests {
+		class := newStorageClass("class-1", "foo.bar/baz")
+		class.ResourceVersion = "1"
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		client := fake.NewSimpleClientset(claim)
+		ctrl := NewProvisionController(client, resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, test.options...)
+		ctrl.classes.Add(class)
+
+		for i := 0; i < test.failures; i++ {
+			ctrl.updateStats(claim, errors.New("fake error"))
+		}
+		if test.editClaim {
+			claim = newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{"foo": "bar"})
+		}
+		if test.editClass {
+			edited := newStorageClass("class-1", "foo.bar/baz")
+			edited.ResourceVersion = "2"
+			ctrl.classes.Update(edited)
+		}
+
+		should := ctrl.shouldProvision(claim)
+		if test.expectedShould != should {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v", test.expectedShould, should)
+		}
+		ctrl.updateStats(claim, nil)
+	}
+}
+
+func TestClaimBackoffDelay(t *testing.T) {
+	ctrl := NewProvisionController(fake.NewSimpleClientset(), resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, ClaimBackoff(time.Second, 4*time.Second))
+	for count, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 4 * time.Second} {
+		// jitter adds up to half the delay
+		if delay := ctrl.backoffDelay(count); delay < expected || delay > expected*3/2 {
+			t.Errorf("expected delay after %d failures between %v and %v but got %v", count, expected, expected*3/2, delay)
+		}
+	}
+}
+
+func TestClaimRetryStoppedEvent(t *testing.T) {
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	client := fake.NewSimpleClientset(claim)
+	ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+	for i := 0; i < failedRetryThreshold; i++ {
+		ctrl.updateStats(claim, errors.New("fake error"))
+	}
+
+	// events are recorded asynchronously
+	err := wait.Poll(10*time.Millisecond, 2*time.Second, func() (bool, error) {
+		events, err := client.Core().Events(v1.NamespaceDefault).List(v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, event := range events.Items {
+			if event.Reason == "ProvisioningStopped" && event.InvolvedObject.Name == "claim-1" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Errorf("expected ProvisioningStopped event for claim-1: %v", err)
+	}
+	ctrl.updateStats(claim, nil)
+}
+
+func TestProvisionErrorTypes(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		failures       int
+		editClaim      bool
+		ownerUpdate    bool
+		expectedShould bool
+	}{
+		{
+			name:           "error retried",
+			err:            errors.New("fake error"),
+			failures:       1,
+			expectedShould: true,
+		},
+		{
+			name:           "error stops retries at threshold",
+			err:            errors.New("fake error"),
+			failures:       failedRetryThreshold,
+			expectedShould: false,
+		},
+		{
+			name:           "terminal error stops retries",
+			err:            &TerminalError{Reason: "invalid parameter"},
+			failures:       1,
+			expectedShould: false,
+		},
+		{
+			name:           "terminal error stops retries after owner update",
+			err:            &TerminalError{Reason: "invalid parameter"},
+			failures:       1,
+			ownerUpdate:    true,
+			expectedShould: false,
+		},
+		{
+			name:           "error stops retries at threshold after owner update",
+			err:            errors.New("fake error"),
+			failures:       failedRetryThreshold,
+			ownerUpdate:    true,
+			expectedShould: false,
+		},
+		{
+			name:           "terminal error retried after edit",
+			err:            &TerminalError{Reason: "invalid parameter"},
+			failures:       1,
+			editClaim:      true,
+			expectedShould: true,
+		},
+		{
+			name:           "retryable error retried past threshold",
+			err:            &RetryableError{Reason: "backend unreachable"},
+			failures:       failedRetryThreshold + 1,
+			expectedShould: true,
+		},
+	}
+	for _, test := range tests {
+		class := newStorageClass("class-1", "foo.bar/baz")
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		client := fake.NewSimpleClientset(claim)
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+		ctrl.classes.Add(class)
+
+		for i := 0; i < test.failures; i++ {
+			ctrl.updateStats(claim, test.err)
+		}
+		if test.editClaim {
+			claim = newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{"foo": "bar"})
+		}
+		if test.ownerUpdate {
+			claim = newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annClaimOwner: `{"identity":"other"}`})
+			claim.ResourceVersion = "1"
+		}
+
+		should := ctrl.shouldProvision(claim)
+		if test.expectedShould != should {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v", test.expectedShould, should)
+		}
+		ctrl.updateStats(claim, nil)
+	}
+}
+
+func TestTerminatingClaims(t *testing.T) {
+	now := unversioned.Now()
+	terminating := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	terminating.DeletionTimestamp = &now
+	class := newStorageClass("class-1", "foo.bar/baz")
+	ctrl := newTestProvisionController(fake.NewSimpleClientset(terminating, class), resyncPeriod, "foo.bar/baz", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+	ctrl.classes.Add(class)
+	if ctrl.shouldProvision(terminating) {
+		t.Errorf("expected should provision false for terminating claim but got true")
+	}
+
+	tests := []struct {
+		name         string
+		objs         []runtime.Object
+		expectSaved  bool
+		expectDelete bool
+	}{
+		{
+			name:        "claim exists",
+			objs:        []runtime.Object{newClaim("claim-1", "uid-1-1", "class-1", "", nil)},
+			expectSaved: true,
+		},
+		{
+			name:         "claim deleted during provisioning",
+			expectDelete: true,
+		},
+		{
+			name:         "claim marked for deletion during provisioning",
+			objs:         []runtime.Object{terminating},
+			expectDelete: true,
+		},
+		{
+			name:         "claim recreated during provisioning",
+			objs:         []runtime.Object{newClaim("claim-1", "uid-1-2", "class-1", "", nil)},
+			expectDelete: true,
+		},
+	}
+	for _, test := range tests {
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		client := fake.NewSimpleClientset(test.objs...)
+		provisioner := newTestProvisioner()
+		ctrl := newTestProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold)
+		ctrl.classes.Add(class)
+
+		if err := ctrl.provisionClaimOperation(claim); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error provisioning: %v", err)
+		}
+		_, err := client.Core().PersistentVolumes().Get(ctrl.getProvisionedVolumeNameForClaim(claim))
+		if saved := err == nil; saved != test.expectSaved {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume saved %v but got %v", test.expectSaved, saved)
+		}
+		if deleted := len(provisioner.deleteCalls) > 0; deleted != test.expectDelete {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected delete called %v but got %v", test.expectDelete, deleted)
+		}
+	}
+
+	// A failed roll back leaves the volume, with its claim, in the journal
+	client := fake.NewSimpleClientset()
+	journal := NewConfigMapJournal(client, v1.NamespaceDefault, "foo.bar-baz-journal")
+	provisioner := &failingDeleteTestProvisioner{testProvisioner: newTestProvisioner()}
+	ctrl = NewProvisionController(client, resyncPeriod, "foo.bar/baz", provisioner, "v1.5.0", false, failedRetryThreshold, 2*resyncPeriod, resyncPeriod, resyncPeriod/2, 2*resyncPeriod, ProvisioningJournal(journal))
+	ctrl.createProvisionedPVInterval = 10 * time.Millisecond
+	ctrl.classes.Add(class)
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	if err := ctrl.provisionClaimOperation(claim); err != nil {
+		t.Errorf("unexpected error provisioning: %v", err)
+	}
+	if len(provisioner.deleted) == 0 || provisioner.deleted[0].Spec.ClaimRef == nil || provisioner.deleted[0].Spec.ClaimRef.UID != claim.UID {
+		t.Errorf("expected delete of volume with claim ref to %s but got %v", claim.UID, provisioner.deleted)
+	}
+	entries, err := journal.List()
+	if err != nil || len(entries) != 1 || entries[0].Volume == nil {
+		t.Errorf("expected journal entry with volume but got %v, %v", entries, err)
+	}
+}
+
+func TestSkippedClaims(t *testing.T) {
+	tests := []struct {
+		name           string
+		claim          *v1.PersistentVolumeClaim
+		options        []func(*ProvisionController) error
+		expectedReason string
+	}{
+		{
+			name:           "class of another provisioner",
+			claim:          newClaim("claim-1", "uid-1-1", "class-2", "", nil),
+			expectedReason: skipReasonForeignProvisioner,
+		},
+		{
+			name:           "annotated for another provisioner",
+			claim:          newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annDynamicallyProvisioned: "abc.def/ghi"}),
+			expectedReason: skipReasonForeignProvisioner,
+		},
+		{
+			name:           "no such class",
+			claim:          newClaim("claim-1", "uid-1-1", "class-3", "", nil),
+			expectedReason: skipReasonMissingClass,
+		},
+		{
+			name:           "filtered",
+			claim:          newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			options:        []func(*ProvisionController) error{Namespaces("team-a")},
+			expectedReason: skipReasonFiltered,
+		},
+	}
+	for _, test := range tests {
+		ctrl := newTestProvisionController(fake.NewSimpleClientset(test.claim), resyncPeriod, "skipped.test/claims", newTestProvisioner(), "v1.5.0", false, failedRetryThreshold)
+		for _, option := range test.options {
+			if err := option(ctrl); err != nil {
+				t.Fatalf("test case %s: unexpected error applying option: %v", test.name, err)
+			}
+		}
+		ctrl.classes.Add(newStorageClass("class-1", "skipped.test/claims"))
+		ctrl.classes.Add(newStorageClass("class-2", "abc.def/ghi"))
+
+		before := counterValue(t, metrics.SkippedClaims, "skipped.test/claims", test.expectedReason)
+		if ctrl.shouldProvision(test.claim) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected claim to be skipped")
+		}
+		if v := counterValue(t, metrics.SkippedClaims, "skipped.test/claims", test.expectedReason); v != before+1 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected 1 claim skipped for reason %s but got %v", test.expectedReason, v-before)
+		}
+	}
+}
+
+func TestNewClientConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       ClientOptions
+		expectedQPS   float32
+		expectedBurst int
+		expectError   bool
+	}{
+		{
+			name:    "defaults",
+			options: ClientOptions{Master: "http://127.0.0.1:8080"},
+		},
+		{
+			name:          "qps and burst",
+			options:       ClientOptions{Master: "http://127.0.0.1:8080", QPS: 50, Burst: 100, Timeout: time.Minute},
+			expectedQPS:   50,
+			expectedBurst: 100,
+		},
+		{
+			name:        "negative qps",
+			options:     ClientOptions{Master: "http://127.0.0.1:8080", QPS: -1},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		config, err := NewClientConfig(test.options, "client.test/provisioner", "v1.2.3")
+		if test.expectError {
+			if err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected error but got none")
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if config.QPS != test.expectedQPS || config.Burst != test.expectedBurst || config.Timeout != test.options.Timeout {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected QPS %v, burst %d and timeout %v but got %v, %d and %v", test.expectedQPS, test.expectedBurst, test.options.Timeout, config.QPS, config.Burst, config.Timeout)
+		}
+		if !strings.HasPrefix(config.UserAgent, "client.test/provisioner/v1.2.3 (") {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected user agent of provisioner client.test/provisioner v1.2.3 but got %q", config.UserAgent)
+		}
+	}
+}
+
+func counterValue(t *testing.T, vec *prometheus.CounterVec, labelValues ...string) float64 {
+	counter, err := vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		t.Fatalf("Error getting counter %v: %v", labelValues, err)
+	}
+	m := &dto.Metric{}
+	if err := counter.Write(m); err != nil {
+		t.Fatalf("Error reading counter %v: %v", labelValues, err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(t *testing.T, vec *prometheus.GaugeVec, labelValues ...string) float64 {
+	gauge, err := vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		t.Fatalf("Error getting gauge %v: %v", labelValues, err)
+	}
+	m := &dto.Metric{}
+	if err := gauge.Write(m); err != nil {
+		t.Fatalf("Error reading gauge %v: %v", labelValues, err)
+	}
+	return m.GetGauge().GetValue()
+}
+
 func TestShouldProvision(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -428,6 +2317,18 @@ func TestIsOnlyRecordUpdate(t *testing.T) {
 			new:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{rl.LeaderElectionRecordAnnotationKey: "a"}),
 			expectedIs: true,
 		},
+		{
+			name:       "is only claim owner update",
+			old:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annClaimOwner: "a"}),
+			new:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annClaimOwner: "b"}),
+			expectedIs: true,
+		},
+		{
+			name:       "is only claim owner update, owner added",
+			old:        newClaim("claim-1", "1-1", "class-1", "", nil),
+			new:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annClaimOwner: "a"}),
+			expectedIs: true,
+		},
 		{
 			name:       "isn't only record update, class changed as well",
 			old:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{rl.LeaderElectionRecordAnnotationKey: "a"}),
@@ -560,11 +2461,12 @@ func newProvisionedVolume(storageClass *v1beta1.StorageClass, claim *v1.Persiste
 }
 
 func newTestProvisioner() *testProvisioner {
-	return &testProvisioner{make(chan bool, 16)}
+	return &testProvisioner{make(chan bool, 16), make(chan bool, 16)}
 }
 
 type testProvisioner struct {
 	provisionCalls chan bool
+	deleteCalls    chan bool
 }
 
 var _ Provisioner = &testProvisioner{}
@@ -603,6 +2505,164 @@ func (p *testProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume
 }
 
 func (p *testProvisioner) Delete(volume *v1.PersistentVolume) error {
+	select {
+	case p.deleteCalls <- true:
+	default:
+	}
+	return nil
+}
+
+// ignoringTestProvisioner is a testProvisioner that ignores deleting volumes
+type ignoringTestProvisioner struct {
+	*testProvisioner
+}
+
+func (p *ignoringTestProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.testProvisioner.Delete(volume)
+	return &IgnoredError{Reason: "identity annotation on PV does not match ours"}
+}
+
+// failingDeleteTestProvisioner is a testProvisioner that fails to delete the
+// volumes, but records them
+type failingDeleteTestProvisioner struct {
+	*testProvisioner
+	deleted []*v1.PersistentVolume
+}
+
+func (p *failingDeleteTestProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.deleted = append(p.deleted, volume)
+	return errors.New("fake error")
+}
+
+// slowTestProvisioner is a testProvisioner that takes delay to provision
+type slowTestProvisioner struct {
+	*testProvisioner
+	delay time.Duration
+}
+
+func (p *slowTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	time.Sleep(p.delay)
+	return p.testProvisioner.Provision(options)
+}
+
+// savingTestProvisioner is a testProvisioner that, as if another attempt beat
+// it to it, saves the volume it provisions for the claim with the given UID
+type savingTestProvisioner struct {
+	*testProvisioner
+	client   kubernetes.Interface
+	claimUID types.UID
+}
+
+func (p *savingTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	volume, err := p.testProvisioner.Provision(options)
+	if err != nil {
+		return nil, err
+	}
+	saved := *volume
+	saved.Spec.ClaimRef = &v1.ObjectReference{Namespace: options.PVC.Namespace, Name: options.PVC.Name, UID: p.claimUID}
+	if _, err := p.client.Core().PersistentVolumes().Create(&saved); err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// listerTestProvisioner is a testProvisioner that lists the named volumes
+type listerTestProvisioner struct {
+	*testProvisioner
+	volumes []string
+}
+
+var _ Lister = &listerTestProvisioner{}
+
+func (p *listerTestProvisioner) ListVolumes() ([]*v1.PersistentVolume, error) {
+	volumes := []*v1.PersistentVolume{}
+	for _, name := range p.volumes {
+		volumes = append(volumes, newVolume(name, v1.VolumeAvailable, v1.PersistentVolumeReclaimDelete, nil))
+	}
+	return volumes, nil
+}
+
+// nodeTestProvisioner is a testProvisioner that records the node selected
+// for the claim it last provisioned for
+type nodeTestProvisioner struct {
+	*testProvisioner
+	selectedNode *v1.Node
+}
+
+func (p *nodeTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.selectedNode = options.SelectedNode
+	return p.testProvisioner.Provision(options)
+}
+
+// updaterTestProvisioner is a testProvisioner that updates volumes' "foo"
+// annotation to "bar"
+type updaterTestProvisioner struct {
+	*testProvisioner
+}
+
+var _ Updater = &updaterTestProvisioner{}
+
+func (p *updaterTestProvisioner) Update(volume *v1.PersistentVolume) (bool, error) {
+	if volume.Annotations["foo"] == "bar" {
+		return false, nil
+	}
+	volume.Annotations["foo"] = "bar"
+	return true, nil
+}
+
+// capabilitiesTestProvisioner is a testProvisioner that supports the "foo"
+// parameter, ReadWriteOnce claims and sizes of at least 1Gi
+type capabilitiesTestProvisioner struct {
+	*testProvisioner
+}
+
+var _ CapabilityAdvertiser = &capabilitiesTestProvisioner{}
+
+func (p *capabilitiesTestProvisioner) Capabilities() Capabilities {
+	return Capabilities{
+		Parameters:        []string{"foo"},
+		AccessModes:       []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+		MinimumVolumeSize: resource.MustParse("1Gi"),
+	}
+}
+
+// recyclerTestProvisioner is a testProvisioner that recycles volumes if
+// recycle is set
+type recyclerTestProvisioner struct {
+	*testProvisioner
+	recycle  bool
+	recycles int
+}
+
+var _ Recycler = &recyclerTestProvisioner{}
+
+func (p *recyclerTestProvisioner) Recycle(volume *v1.PersistentVolume) (bool, error) {
+	p.recycles++
+	return p.recycle, nil
+}
+
+// preProvisionTestProvisioner is a testProvisioner that records the options
+// it last provisioned with
+type preProvisionTestProvisioner struct {
+	*testProvisioner
+	options *VolumeOptions
+}
+
+func (p *preProvisionTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.options = &options
+	return p.testProvisioner.Provision(options)
+}
+
+// preProvisionerTestProvisioner is a preProvisionTestProvisioner that sets
+// the "pre-provisioned" parameter to the claim's namespace
+type preProvisionerTestProvisioner struct {
+	*preProvisionTestProvisioner
+}
+
+var _ PreProvisioner = &preProvisionerTestProvisioner{}
+
+func (p *preProvisionerTestProvisioner) PreProvision(options *VolumeOptions) error {
+	options.Parameters["pre-provisioned"] = options.PVC.Namespace
 	return nil
 }
 
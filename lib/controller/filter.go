@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/labels"
+	"k8s.io/client-go/pkg/util/sets"
+)
+
+// Namespaces returns an option for NewProvisionController that makes the
+// controller only provision for claims in the given namespaces, e.g. to scope
+// a provisioner instance to a tenant's namespaces. Claims in other namespaces
+// are left for other provisioners with the same name.
+func Namespaces(namespaces ...string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if len(namespaces) == 0 {
+			return errors.New("namespaces to provision for must not be empty")
+		}
+		c.namespaces = sets.NewString(namespaces...)
+		return nil
+	}
+}
+
+// ExcludeNamespaces returns an option for NewProvisionController that makes
+// the controller not provision for claims in the given namespaces. It can be
+// combined with Namespaces, in which case a namespace given to both is
+// excluded.
+func ExcludeNamespaces(namespaces ...string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if len(namespaces) == 0 {
+			return errors.New("namespaces to exclude must not be empty")
+		}
+		c.excludedNamespaces = sets.NewString(namespaces...)
+		return nil
+	}
+}
+
+// ClaimSelector returns an option for NewProvisionController that makes the
+// controller only provision for claims whose labels match the given label
+// selector, in the syntax of kubectl's --selector, e.g. "team=storage,tier!=dev".
+func ClaimSelector(selector string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return fmt.Errorf("invalid claim selector %q: %v", selector, err)
+		}
+		if parsed.Empty() {
+			return errors.New("claim selector must not be empty")
+		}
+		c.claimSelector = parsed
+		return nil
+	}
+}
+
+// claimMatches returns whether the claim is in a namespace and has labels
+// the controller provisions for
+func (ctrl *ProvisionController) claimMatches(claim *v1.PersistentVolumeClaim) bool {
+	if ctrl.namespaces != nil && !ctrl.namespaces.Has(claim.Namespace) {
+		glog.V(4).Infof("Claim %q is not in a namespace this controller provisions for, skipping", claimToClaimKey(claim))
+		return false
+	}
+	if ctrl.excludedNamespaces != nil && ctrl.excludedNamespaces.Has(claim.Namespace) {
+		glog.V(4).Infof("Claim %q is in an excluded namespace, skipping", claimToClaimKey(claim))
+		return false
+	}
+	if ctrl.claimSelector != nil && !ctrl.claimSelector.Matches(labels.Set(claim.Labels)) {
+		glog.V(4).Infof("Claim %q does not match the claim selector %q, skipping", claimToClaimKey(claim), ctrl.claimSelector)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// finalizerVolumeProtection is set on volumes the controller creates with the
+// VolumeProtection option, and removed once their storage assets are deleted
+const finalizerVolumeProtection = "external-storage.kubernetes.io/volume-protection"
+
+// VolumeProtection returns an option for NewProvisionController that makes the
+// controller put a finalizer on the volumes it creates, so that the PV objects
+// don't disappear before their storage assets are deleted. A volume deleted
+// by its reclaim policy keeps its PV object until the provisioner's Delete
+// succeeds. A PV object deleted by hand, which NewProvisionController would
+// otherwise never clean up after, stays Terminating until the volume is no
+// longer bound to a claim; then, if its reclaim policy is Delete, its storage
+// asset is deleted like a released volume's, and the finalizer removed.
+func VolumeProtection() func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		c.volumeProtection = true
+		return nil
+	}
+}
+
+// hasFinalizer returns whether the object has the finalizer
+func hasFinalizer(obj v1.ObjectMeta, finalizer string) bool {
+	for _, f := range obj.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// isDeletedProtectedVolume returns whether the volume is protected by the
+// controller's finalizer and its PV object has been deleted
+func isDeletedProtectedVolume(volume *v1.PersistentVolume) bool {
+	return volume.DeletionTimestamp != nil && hasFinalizer(volume.ObjectMeta, finalizerVolumeProtection)
+}
+
+// shouldRemoveFinalizer returns whether the volume is a deleted protected one
+// of the controller that won't be deleted by the provisioner, because its
+// reclaim policy isn't Delete, and only needs its finalizer removed
+func (ctrl *ProvisionController) shouldRemoveFinalizer(volume *v1.PersistentVolume) bool {
+	if !isDeletedProtectedVolume(volume) || volume.Status.Phase == v1.VolumeBound {
+		return false
+	}
+	if volume.Annotations[annDynamicallyProvisioned] != ctrl.provisionerName {
+		return false
+	}
+	return volume.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete
+}
+
+// removeFinalizer removes the controller's finalizer from the volume, which
+// lets its PV object be deleted once it has been
+func (ctrl *ProvisionController) removeFinalizer(volume *v1.PersistentVolume) error {
+	_, err := UpdateVolume(ctrl.client, volume, func(volume *v1.PersistentVolume) (bool, error) {
+		finalizers := []string{}
+		for _, f := range volume.Finalizers {
+			if f != finalizerVolumeProtection {
+				finalizers = append(finalizers, f)
+			}
+		}
+		if len(finalizers) == len(volume.Finalizers) {
+			return false, nil
+		}
+		volume.Finalizers = finalizers
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	glog.V(4).Infof("removed finalizer from volume %q", volume.Name)
+	return nil
+}
+
+// removeFinalizerOperation removes the finalizer of a deleted volume the
+// provisioner won't delete
+func (ctrl *ProvisionController) removeFinalizerOperation(volume *v1.PersistentVolume) error {
+	newVolume, err := ctrl.client.Core().PersistentVolumes().Get(volume.Name)
+	if err != nil {
+		return nil
+	}
+	if !ctrl.shouldRemoveFinalizer(newVolume) {
+		return nil
+	}
+	if err := ctrl.removeFinalizer(newVolume); err != nil {
+		glog.Errorf("Failed to remove finalizer from deleted volume %q: %v", volume.Name, err)
+		return err
+	}
+	glog.Infof("volume %q with reclaim policy %s deleted from database, its storage asset is kept", volume.Name, newVolume.Spec.PersistentVolumeReclaimPolicy)
+	return nil
+}
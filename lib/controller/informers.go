@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// InformerFactory creates the shared informers that ProvisionControllers use
+// to watch claims, volumes and storage classes. Controllers in the same
+// process that are given the same InformerFactory (see the Informers option)
+// share one list/watch and one cache per resource instead of each having
+// their own.
+type InformerFactory struct {
+	client       kubernetes.Interface
+	resyncPeriod time.Duration
+
+	lock      sync.Mutex
+	informers map[string]cache.SharedIndexInformer
+	started   map[string]bool
+}
+
+// NewInformerFactory creates an InformerFactory whose informers relist every
+// resyncPeriod. Controllers retry failed operations on relist, so it plays
+// the role of NewProvisionController's resyncPeriod for controllers using the
+// factory.
+func NewInformerFactory(client kubernetes.Interface, resyncPeriod time.Duration) *InformerFactory {
+	return &InformerFactory{
+		client:       client,
+		resyncPeriod: resyncPeriod,
+		informers:    make(map[string]cache.SharedIndexInformer),
+		started:      make(map[string]bool),
+	}
+}
+
+// Claims returns the shared informer for PersistentVolumeClaims in all
+// namespaces.
+func (f *InformerFactory) Claims() cache.SharedIndexInformer {
+	return f.informerFor("claims", newClaimSource(f.client), &v1.PersistentVolumeClaim{})
+}
+
+// Volumes returns the shared informer for PersistentVolumes.
+func (f *InformerFactory) Volumes() cache.SharedIndexInformer {
+	return f.informerFor("volumes", newVolumeSource(f.client), &v1.PersistentVolume{})
+}
+
+// Classes returns the shared informer for StorageClasses.
+func (f *InformerFactory) Classes() cache.SharedIndexInformer {
+	return f.informerFor("classes", newClassSource(f.client), &v1beta1.StorageClass{})
+}
+
+// Start runs every informer created so far that isn't already running. It may
+// be called more than once, e.g. by every controller using the factory.
+func (f *InformerFactory) Start(stopCh <-chan struct{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for name, informer := range f.informers {
+		if !f.started[name] {
+			go informer.Run(stopCh)
+			f.started[name] = true
+		}
+	}
+}
+
+func (f *InformerFactory) informerFor(name string, source cache.ListerWatcher, objType runtime.Object) cache.SharedIndexInformer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if informer, ok := f.informers[name]; ok {
+		return informer
+	}
+	informer := cache.NewSharedIndexInformer(source, objType, f.resyncPeriod, cache.Indexers{})
+	f.informers[name] = informer
+	return informer
+}
+
+func newClaimSource(client kubernetes.Interface) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			var out v1.ListOptions
+			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).List(out)
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			var out v1.ListOptions
+			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).Watch(out)
+		},
+	}
+}
+
+func newVolumeSource(client kubernetes.Interface) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			var out v1.ListOptions
+			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+			return client.Core().PersistentVolumes().List(out)
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			var out v1.ListOptions
+			v1.Convert_api_ListOptions_To_v1_ListOptions(&options, &out, nil)
+			return client.Core().PersistentVolumes().Watch(out)
+		},
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/types"
+)
+
+// Number of times a ConfigMapJournal retries an update that conflicted with
+// another writer's.
+const journalUpdateRetryCount = 5
+
+// Journal records provisioning operations that are in flight, so that if the
+// controller dies in the middle of one, e.g. after the provisioner created a
+// storage asset but before its PV was saved, the operation can be finished or
+// rolled back when the controller starts again. See the ProvisioningJournal
+// option.
+type Journal interface {
+	// Put adds or replaces the entry for entry.PVName
+	Put(entry JournalEntry) error
+	// Remove removes the entry for the named PV, if there is one
+	Remove(pvName string) error
+	// List returns all entries
+	List() ([]JournalEntry, error)
+}
+
+// JournalEntry is the record of one provisioning operation.
+type JournalEntry struct {
+	// PVName is the name of the PV being provisioned
+	PVName string `json:"pvName"`
+	// The claim the PV is being provisioned for
+	ClaimNamespace string    `json:"claimNamespace"`
+	ClaimName      string    `json:"claimName"`
+	ClaimUID       types.UID `json:"claimUID"`
+	// Volume is the PV returned by the provisioner. It is nil until Provision
+	// has returned, i.e. until there is a storage asset to roll back.
+	Volume *v1.PersistentVolume `json:"volume,omitempty"`
+}
+
+// ConfigMapJournal is a Journal that keeps its entries in a ConfigMap, one
+// JSON-encoded entry per key.
+type ConfigMapJournal struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	// Serializes this process' updates; updates from elsewhere are handled by
+	// retrying on conflict
+	mutex sync.Mutex
+}
+
+var _ Journal = &ConfigMapJournal{}
+
+// NewConfigMapJournal creates a Journal backed by the named ConfigMap, which
+// is created if it doesn't exist. Controllers that may provision the same
+// claims, i.e. controllers with the same provisioner name, should share a
+// ConfigMap; others should not.
+func NewConfigMapJournal(client kubernetes.Interface, namespace, name string) *ConfigMapJournal {
+	return &ConfigMapJournal{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Put adds or replaces the entry for entry.PVName
+func (j *ConfigMapJournal) Put(entry JournalEntry) error {
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return j.update(func(data map[string]string) {
+		data[entry.PVName] = string(entryBytes)
+	})
+}
+
+// Remove removes the entry for the named PV, if there is one
+func (j *ConfigMapJournal) Remove(pvName string) error {
+	return j.update(func(data map[string]string) {
+		delete(data, pvName)
+	})
+}
+
+// List returns all entries, sorted by PV name
+func (j *ConfigMapJournal) List() ([]JournalEntry, error) {
+	configMap, err := j.client.Core().ConfigMaps(j.namespace).Get(j.name)
+	if apierrs.IsNotFound(err) {
+		return []JournalEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []JournalEntry{}
+	for key, value := range configMap.Data {
+		var entry JournalEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding journal entry %s: %v", key, err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Sort(byPVName(entries))
+	return entries, nil
+}
+
+// update applies mutate to the ConfigMap's data and saves it, creating the
+// ConfigMap if necessary
+func (j *ConfigMapJournal) update(mutate func(map[string]string)) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var err error
+	for i := 0; i < journalUpdateRetryCount; i++ {
+		var configMap *v1.ConfigMap
+		configMap, err = j.client.Core().ConfigMaps(j.namespace).Get(j.name)
+		if apierrs.IsNotFound(err) {
+			configMap = &v1.ConfigMap{
+				ObjectMeta: v1.ObjectMeta{
+					Namespace: j.namespace,
+					Name:      j.name,
+				},
+				Data: map[string]string{},
+			}
+			mutate(configMap.Data)
+			_, err = j.client.Core().ConfigMaps(j.namespace).Create(configMap)
+		} else if err == nil {
+			if configMap.Data == nil {
+				configMap.Data = map[string]string{}
+			}
+			mutate(configMap.Data)
+			_, err = j.client.Core().ConfigMaps(j.namespace).Update(configMap)
+		}
+		if err == nil || !(apierrs.IsConflict(err) || apierrs.IsAlreadyExists(err)) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error updating journal %s/%s: %v", j.namespace, j.name, err)
+	}
+	return nil
+}
+
+type byPVName []JournalEntry
+
+func (e byPVName) Len() int           { return len(e) }
+func (e byPVName) Swap(i, k int)      { e[i], e[k] = e[k], e[i] }
+func (e byPVName) Less(i, k int) bool { return e[i].PVName < e[k].PVName }
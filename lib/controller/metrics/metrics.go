@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the Prometheus metrics of the provision controller
+// and of the API client it uses. They are registered with Prometheus' default
+// registry by Register, which NewProvisionController calls.
+package metrics
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+)
+
+// ControllerSubsystem is the Prometheus subsystem of the controller's metrics
+const ControllerSubsystem = "provision_controller"
+
+var (
+	// OperationQueueDepth is the number of provision, lock and delete
+	// operations scheduled and not yet finished, by provisioner name
+	OperationQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "queue_depth",
+			Help:      "Number of operations scheduled and not yet finished.",
+		},
+		[]string{"provisioner"},
+	)
+
+	// ClaimProvisionFailures is the number of consecutive times provisioning
+	// has failed for a claim, by provisioner name and claim namespace/name.
+	// Claims without failures have no series.
+	ClaimProvisionFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "claim_provision_failures",
+			Help:      "Number of consecutive failed attempts to provision a volume for a claim.",
+		},
+		[]string{"provisioner", "claim"},
+	)
+
+	// LastResyncTime is when the controller last saw a periodic resync of an
+	// informer, by provisioner name and resource
+	LastResyncTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "last_resync_timestamp_seconds",
+			Help:      "Unix time of the last periodic resync of an informer.",
+		},
+		[]string{"provisioner", "resource"},
+	)
+
+	// OrphanedVolumes is the number of storage assets without a PV found by
+	// the last pass of the orphan reaper, by provisioner name
+	OrphanedVolumes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "orphaned_volumes",
+			Help:      "Number of storage assets without a PV found by the last pass of the orphan reaper.",
+		},
+		[]string{"provisioner"},
+	)
+
+	// OrphanedVolumesDeleted is the number of orphaned storage assets the
+	// orphan reaper has deleted, by provisioner name
+	OrphanedVolumesDeleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "orphaned_volumes_deleted_total",
+			Help:      "Number of storage assets without a PV deleted by the orphan reaper.",
+		},
+		[]string{"provisioner"},
+	)
+
+	// StuckPendingClaims is the number of the provisioner's claims that were
+	// pending for longer than the PendingClaimResync option's threshold at
+	// the last resync of pending claims, by provisioner name
+	StuckPendingClaims = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "stuck_pending_claims",
+			Help:      "Number of claims pending for longer than the threshold at the last resync of pending claims.",
+		},
+		[]string{"provisioner"},
+	)
+
+	// SkippedClaims is the number of times the controller saw a pending
+	// claim and skipped it, by provisioner name and reason: foreign_provisioner
+	// if the claim is for another provisioner, missing_class if its class
+	// doesn't exist, filtered if the controller's namespace or claim selector
+	// options exclude it, or terminating if the claim is marked for deletion.
+	// Claims are seen at least every resync.
+	SkippedClaims = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "skipped_claims_total",
+			Help:      "Number of times a pending claim was skipped, by reason: foreign_provisioner, missing_class, filtered or terminating.",
+		},
+		[]string{"provisioner", "reason"},
+	)
+
+	// APIRequestLatency is the latency of API requests, by verb and URL with
+	// object names templated out
+	APIRequestLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of API requests.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"verb", "url"},
+	)
+
+	// APIRequestResults is the number of API requests, by status code (or
+	// error), method and host
+	APIRequestResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: ControllerSubsystem,
+			Name:      "api_requests_total",
+			Help:      "Number of API requests, by status code.",
+		},
+		[]string{"code", "method", "host"},
+	)
+)
+
+var registerMetrics sync.Once
+
+// Register registers the metrics with Prometheus' default registry and makes
+// client-go's REST clients report to APIRequestLatency and APIRequestResults,
+// unless something else in the process already told client-go where to
+// report. It is safe to call more than once.
+func Register() {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(OperationQueueDepth)
+		prometheus.MustRegister(ClaimProvisionFailures)
+		prometheus.MustRegister(LastResyncTime)
+		prometheus.MustRegister(OrphanedVolumes)
+		prometheus.MustRegister(OrphanedVolumesDeleted)
+		prometheus.MustRegister(StuckPendingClaims)
+		prometheus.MustRegister(SkippedClaims)
+		prometheus.MustRegister(APIRequestLatency)
+		prometheus.MustRegister(APIRequestResults)
+		clientmetrics.Register(&latencyAdapter{}, &resultAdapter{})
+	})
+}
+
+type latencyAdapter struct{}
+
+func (l *latencyAdapter) Observe(verb string, u url.URL, latency time.Duration) {
+	APIRequestLatency.WithLabelValues(verb, u.Path).Observe(latency.Seconds())
+}
+
+type resultAdapter struct{}
+
+func (r *resultAdapter) Increment(code, method, host string) {
+	APIRequestResults.WithLabelValues(code, method, host).Inc()
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// annClaimOwner annotation is set on a claim by the controller that owns it,
+// see the ClaimOwnership option
+const annClaimOwner = "volume.kubernetes.io/provisioner-owner"
+
+// claimOwner is the JSON value of annClaimOwner
+type claimOwner struct {
+	// Identity of the controller that owns the claim
+	Identity string `json:"identity"`
+	// When the controller took ownership of the claim
+	AcquireTime time.Time `json:"acquireTime"`
+	// When the controller last renewed its ownership of the claim, if it has
+	RenewTime time.Time `json:"renewTime,omitempty"`
+}
+
+// expired returns whether the ownership was neither taken nor renewed within
+// timeout
+func (o claimOwner) expired(timeout time.Duration) bool {
+	renewed := o.AcquireTime
+	if o.RenewTime.After(renewed) {
+		renewed = o.RenewTime
+	}
+	return time.Since(renewed) >= timeout
+}
+
+// ClaimOwnership returns an option for NewProvisionController that makes the
+// controller, before provisioning for a claim, take ownership of it by
+// annotating it with its identity, instead of electing a leader for the
+// claim. The annotation is updated with the claim's resource version, so of
+// several controllers with the same provisioner name racing to take a claim,
+// e.g. because they run without the LeaderElection option, only one succeeds
+// and the others ignore the claim. The owner renews its ownership every third
+// of timeout while it provisions; an owner that hasn't renewed it within
+// timeout, e.g. because it crashed, loses the claim to whichever controller
+// takes it next.
+func ClaimOwnership(timeout time.Duration) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if timeout <= 0 {
+			return errors.New("claim ownership timeout must be positive")
+		}
+		c.claimOwnershipTimeout = timeout
+		return nil
+	}
+}
+
+// acquireClaim returns whether the controller owns the claim, taking
+// ownership of it if no other controller owns it or the other's ownership
+// has expired
+func (ctrl *ProvisionController) acquireClaim(claim *v1.PersistentVolumeClaim) (bool, error) {
+	// The cached claim may be stale, get the latest version to race on
+	latest, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name)
+	if err != nil {
+		return false, fmt.Errorf("error getting claim: %v", err)
+	}
+	if latest.UID != claim.UID {
+		return false, nil
+	}
+
+	identity := string(ctrl.identity)
+	if data, ok := latest.Annotations[annClaimOwner]; ok {
+		owner := claimOwner{}
+		if err := json.Unmarshal([]byte(data), &owner); err != nil {
+			glog.Warningf("Claim %q has invalid annotation %s, taking ownership: %v", claimToClaimKey(claim), annClaimOwner, err)
+		} else if owner.Identity == identity {
+			return true, nil
+		} else if !owner.expired(ctrl.claimOwnershipTimeout) {
+			glog.V(4).Infof("Claim %q is owned by %s, skipping", claimToClaimKey(claim), owner.Identity)
+			return false, nil
+		} else {
+			glog.Infof("Ownership of claim %q by %s expired, taking ownership", claimToClaimKey(claim), owner.Identity)
+		}
+	}
+
+	data, err := json.Marshal(claimOwner{Identity: identity, AcquireTime: time.Now()})
+	if err != nil {
+		return false, fmt.Errorf("error encoding claim owner: %v", err)
+	}
+	setAnnotation(&latest.ObjectMeta, annClaimOwner, string(data))
+	if _, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Update(latest); err != nil {
+		if apierrs.IsConflict(err) {
+			// Another controller, or someone else, updated the claim first. If
+			// it wasn't another controller taking ownership, the update will be
+			// noticed and ownership taken then.
+			glog.V(4).Infof("Claim %q was updated while taking ownership, skipping", claimToClaimKey(claim))
+			return false, nil
+		}
+		return false, fmt.Errorf("error annotating claim: %v", err)
+	}
+	glog.V(4).Infof("Took ownership of claim %q", claimToClaimKey(claim))
+	return true, nil
+}
+
+// provisionOwnedClaimOperation provisions for the claim if the controller
+// owns it or can take ownership of it
+func (ctrl *ProvisionController) provisionOwnedClaimOperation(claim *v1.PersistentVolumeClaim) error {
+	owned, err := ctrl.acquireClaim(claim)
+	if err != nil {
+		glog.Errorf("Failed to take ownership of claim %q: %v", claimToClaimKey(claim), err)
+		return err
+	}
+	if !owned {
+		return nil
+	}
+
+	// Provision may take longer than the timeout. Keep the claim from being
+	// taken and provisioned again meanwhile.
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go ctrl.renewClaimOwnership(claim, stopCh)
+
+	return ctrl.provisionClaimOperation(claim)
+}
+
+// renewClaimOwnership renews the controller's ownership of the claim every
+// third of the ownership timeout until stopCh is closed
+func (ctrl *ProvisionController) renewClaimOwnership(claim *v1.PersistentVolumeClaim, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(ctrl.claimOwnershipTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			// A failed renewal is retried on the next tick, which is still
+			// within the timeout
+			if err := ctrl.renewClaim(claim); err != nil {
+				glog.Errorf("Failed to renew ownership of claim %q: %v", claimToClaimKey(claim), err)
+			}
+		}
+	}
+}
+
+// renewClaim updates the time the controller last renewed its ownership of
+// the claim
+func (ctrl *ProvisionController) renewClaim(claim *v1.PersistentVolumeClaim) error {
+	latest, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name)
+	if err != nil {
+		return fmt.Errorf("error getting claim: %v", err)
+	}
+	if latest.UID != claim.UID {
+		return nil
+	}
+
+	owner := claimOwner{}
+	if err := json.Unmarshal([]byte(latest.Annotations[annClaimOwner]), &owner); err != nil {
+		return fmt.Errorf("error decoding claim owner: %v", err)
+	}
+	if owner.Identity != string(ctrl.identity) {
+		return fmt.Errorf("claim is owned by %s", owner.Identity)
+	}
+	owner.RenewTime = time.Now()
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return fmt.Errorf("error encoding claim owner: %v", err)
+	}
+	setAnnotation(&latest.ObjectMeta, annClaimOwner, string(data))
+	if _, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Update(latest); err != nil {
+		return fmt.Errorf("error annotating claim: %v", err)
+	}
+	glog.V(4).Infof("Renewed ownership of claim %q", claimToClaimKey(claim))
+	return nil
+}
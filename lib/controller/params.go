@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+)
+
+// annClaimParameterPrefix prefixes the annotations with which a claim
+// overrides parameters of its class, e.g.
+// "volume.beta.kubernetes.io/params.gid": "1001" overrides parameter gid.
+const annClaimParameterPrefix = "volume.beta.kubernetes.io/params."
+
+// allowClaimParameterKeys is the class parameter listing, comma-separated,
+// the parameters its claims may override. Without it, claims may override
+// none. It is for the controller only and is not passed to the provisioner.
+const allowClaimParameterKeys = "allowClaimParameterKeys"
+
+// claimParameters returns the parameters to provision the claim with: the
+// class' parameters with those the claim overrides merged over them. It
+// returns an error if the claim overrides a parameter the class doesn't allow
+// claims to, so that users find out instead of silently getting the class'
+// value.
+func claimParameters(class *v1beta1.StorageClass, claim *v1.PersistentVolumeClaim) (map[string]string, error) {
+	overrides := map[string]string{}
+	for k, v := range claim.Annotations {
+		if strings.HasPrefix(k, annClaimParameterPrefix) {
+			overrides[strings.TrimPrefix(k, annClaimParameterPrefix)] = v
+		}
+	}
+	allowed, gated := class.Parameters[allowClaimParameterKeys]
+	if len(overrides) == 0 && !gated {
+		return class.Parameters, nil
+	}
+
+	allowedKeys := map[string]bool{}
+	for _, key := range strings.Split(allowed, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			allowedKeys[key] = true
+		}
+	}
+	disallowed := []string{}
+	for k := range overrides {
+		if !allowedKeys[k] {
+			disallowed = append(disallowed, k)
+		}
+	}
+	if len(disallowed) > 0 {
+		sort.Strings(disallowed)
+		return nil, fmt.Errorf("StorageClass %q does not allow claims to override parameters %s, set %s to the parameters they may override", class.Name, strings.Join(disallowed, ", "), allowClaimParameterKeys)
+	}
+
+	parameters := ClassParameters(class)
+	merged := make(map[string]string, len(parameters)+len(overrides))
+	for k, v := range parameters {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// ClassParameters returns the class' parameters for the provisioner, i.e.
+// without those for the controller only. Provisioners that read the
+// parameters of classes themselves, rather than from VolumeOptions, should
+// read them with it.
+func ClassParameters(class *v1beta1.StorageClass) map[string]string {
+	if _, ok := class.Parameters[allowClaimParameterKeys]; !ok {
+		return class.Parameters
+	}
+	parameters := make(map[string]string, len(class.Parameters)-1)
+	for k, v := range class.Parameters {
+		if k != allowClaimParameterKeys {
+			parameters[k] = v
+		}
+	}
+	return parameters
+}
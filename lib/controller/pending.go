@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/metrics"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// PendingClaimResync returns an option for NewProvisionController that makes
+// the controller, every resync period, list the pending claims from the API
+// server rather than the informer's cache and provision for those that are
+// its own, so claims whose events were lost, e.g. while the controller was
+// down, don't stay pending. Claims that have been pending for longer than
+// stuckAfter are counted in metrics.StuckPendingClaims.
+func PendingClaimResync(stuckAfter time.Duration) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if stuckAfter <= 0 {
+			return errors.New("time after which pending claims are stuck must be positive")
+		}
+		c.pendingClaimResync = true
+		c.stuckPendingAfter = stuckAfter
+		return nil
+	}
+}
+
+// resyncPendingClaims lists the pending claims and passes those that are the
+// controller's own to addClaim, which provisions for them unless an
+// operation for them is already running
+func (ctrl *ProvisionController) resyncPendingClaims() {
+	claims, err := ctrl.client.Core().PersistentVolumeClaims(v1.NamespaceAll).List(v1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Failed to list claims, not resyncing pending claims: %v", err)
+		return
+	}
+
+	now := time.Now()
+	stuck := 0
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if claim.Status.Phase != v1.ClaimPending || claim.Spec.VolumeName != "" || !ctrl.isOwnClaim(claim) {
+			continue
+		}
+		if now.Sub(claim.CreationTimestamp.Time) > ctrl.stuckPendingAfter {
+			glog.Warningf("Claim %q has been pending since %v", claimToClaimKey(claim), claim.CreationTimestamp)
+			stuck++
+		}
+		ctrl.addClaim(claim)
+	}
+	metrics.StuckPendingClaims.WithLabelValues(ctrl.provisionerName).Set(float64(stuck))
+}
+
+// isOwnClaim returns whether the claim is for this controller to provision:
+// whether it is annotated with the provisioner's name or, if it isn't
+// annotated, whether its class' provisioner is the provisioner's name
+func (ctrl *ProvisionController) isOwnClaim(claim *v1.PersistentVolumeClaim) bool {
+	if provisioner, found := claim.Annotations[annDynamicallyProvisioned]; found {
+		return provisioner == ctrl.provisionerName
+	}
+	_, err := ctrl.getStorageClass(ctrl.getClaimClass(claim))
+	return err == nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// PreProvisioner is an optional interface for a Provisioner to implement so
+// that it can inspect and modify the options of every volume before Provision
+// is called with them, e.g. to reject claims it can't serve early. See also
+// the PreProvisionHooks option for policies shared by provisioners.
+type PreProvisioner interface {
+	// PreProvision may modify the given options, whose PVC and Parameters are
+	// copies, except for PVName, which must be left as is. Returning an error
+	// vetoes provisioning: Provision isn't called, the error is recorded in a
+	// ProvisioningFailed event on the claim and provisioning is retried like
+	// after a failed Provision.
+	PreProvision(*VolumeOptions) error
+}
+
+// PreProvisionHook is a function with the semantics of PreProvisioner's
+// PreProvision, for policies any provisioner can be run with, e.g. clamping
+// sizes, injecting parameters by namespace or enforcing claim naming rules.
+type PreProvisionHook func(*VolumeOptions) error
+
+// PreProvisionHooks returns an option for NewProvisionController that makes
+// the controller call the given hooks, in order, with the options of every
+// volume before it is provisioned, and then the provisioner's PreProvision
+// if it implements PreProvisioner. The first hook to return an error vetoes
+// provisioning.
+func PreProvisionHooks(hooks ...PreProvisionHook) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if len(hooks) == 0 {
+			return errors.New("pre-provision hooks must not be empty")
+		}
+		for _, hook := range hooks {
+			if hook == nil {
+				return errors.New("pre-provision hooks must not be nil")
+			}
+		}
+		c.preProvisionHooks = hooks
+		return nil
+	}
+}
+
+// preProvision passes the options through the pre-provision hooks and the
+// provisioner's PreProvision, if any, and returns the resulting options. The
+// options' PVC and Parameters are copied first, since they are shared with
+// the informers' caches.
+func (ctrl *ProvisionController) preProvision(options VolumeOptions) (VolumeOptions, error) {
+	hooks := ctrl.preProvisionHooks
+	if preProvisioner, ok := ctrl.provisioner.(PreProvisioner); ok {
+		hooks = append(append([]PreProvisionHook{}, hooks...), preProvisioner.PreProvision)
+	}
+	if len(hooks) == 0 {
+		return options, nil
+	}
+
+	clone, err := api.Scheme.DeepCopy(options.PVC)
+	if err != nil {
+		return options, fmt.Errorf("error cloning claim: %v", err)
+	}
+	claimClone, ok := clone.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return options, fmt.Errorf("unexpected claim cast error: %v", claimClone)
+	}
+	options.PVC = claimClone
+	parameters := make(map[string]string, len(options.Parameters))
+	for k, v := range options.Parameters {
+		parameters[k] = v
+	}
+	options.Parameters = parameters
+
+	pvName := options.PVName
+	for _, hook := range hooks {
+		if err := hook(&options); err != nil {
+			return options, err
+		}
+		if options.PVName != pvName || options.PVC == nil {
+			return options, errors.New("pre-provision hook must not change the PV name or unset the claim")
+		}
+	}
+	return options, nil
+}
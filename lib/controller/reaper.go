@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/metrics"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// reapOrphans compares the storage assets the provisioner lists against the
+// existing PVs and reports, and optionally deletes, the assets that have been
+// without a PV for longer than the grace period. It is run periodically by a
+// single goroutine, so orphansFirstSeen needs no lock.
+func (ctrl *ProvisionController) reapOrphans() {
+	assets, err := ctrl.provisioner.(Lister).ListVolumes()
+	if err != nil {
+		glog.Errorf("Failed to list volumes, not looking for orphans: %v", err)
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	orphans := 0
+	for _, asset := range assets {
+		seen[asset.Name] = true
+		hasPV, err := ctrl.volumeExists(asset.Name)
+		if err != nil {
+			glog.Errorf("Failed to get volume %q, not checking whether it is an orphan: %v", asset.Name, err)
+			continue
+		}
+		if hasPV {
+			delete(ctrl.orphansFirstSeen, asset.Name)
+			continue
+		}
+
+		firstSeen, ok := ctrl.orphansFirstSeen[asset.Name]
+		if !ok {
+			firstSeen = now
+			ctrl.orphansFirstSeen[asset.Name] = now
+		}
+		if now.Sub(firstSeen) < ctrl.orphanGracePeriod {
+			continue
+		}
+
+		if ctrl.handleOrphan(asset) {
+			delete(ctrl.orphansFirstSeen, asset.Name)
+			continue
+		}
+		orphans++
+	}
+
+	// Forget assets that are gone
+	for name := range ctrl.orphansFirstSeen {
+		if !seen[name] {
+			delete(ctrl.orphansFirstSeen, name)
+		}
+	}
+	metrics.OrphanedVolumes.WithLabelValues(ctrl.provisionerName).Set(float64(orphans))
+}
+
+// volumeExists returns whether the named PV exists, asking the API server if
+// it isn't in the cache since the asset may be newer than the cache
+func (ctrl *ProvisionController) volumeExists(name string) (bool, error) {
+	if _, found, err := ctrl.volumes.GetByKey(name); err == nil && found {
+		return true, nil
+	}
+	_, err := ctrl.client.Core().PersistentVolumes().Get(name)
+	if err == nil {
+		return true, nil
+	}
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// handleOrphan reports the orphaned asset or, if deleteOrphans is set,
+// deletes it. It returns whether the asset was deleted.
+func (ctrl *ProvisionController) handleOrphan(asset *v1.PersistentVolume) bool {
+	if !ctrl.deleteOrphans {
+		glog.Warningf("Volume %q has no PV", asset.Name)
+		ctrl.eventRecorder.Event(asset, v1.EventTypeWarning, "OrphanedVolume", "Volume has no PersistentVolume")
+		return false
+	}
+
+	if err := ctrl.provisioner.Delete(asset); err != nil {
+		if ierr, ok := err.(*IgnoredError); ok {
+			glog.Infof("deletion of orphaned volume %q ignored: %v", asset.Name, ierr)
+			return false
+		}
+		glog.Errorf("Deletion of orphaned volume %q failed: %v", asset.Name, err)
+		ctrl.eventRecorder.Event(asset, v1.EventTypeWarning, "VolumeFailedDelete", fmt.Sprintf("Failed to delete orphaned volume: %v", err))
+		return false
+	}
+
+	glog.Infof("orphaned volume %q deleted", asset.Name)
+	ctrl.eventRecorder.Event(asset, v1.EventTypeNormal, "OrphanedVolumeDeleted", "Deleted volume that had no PersistentVolume")
+	metrics.OrphanedVolumesDeleted.WithLabelValues(ctrl.provisionerName).Inc()
+	return true
+}
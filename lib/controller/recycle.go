@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// annBoundByController annotation is set by the PV controller on volumes it
+// bound to a claim itself
+const annBoundByController = "pv.kubernetes.io/bound-by-controller"
+
+// Recycler is an optional interface for a Provisioner to implement so that it
+// can scrub released volumes and have them bound to new claims, instead of
+// deleting them, e.g. to keep a pool of volumes that claims bind to faster
+// than they could be provisioned.
+type Recycler interface {
+	// Recycle is called with a released PV the provisioner provisioned before
+	// Delete is. It removes the data on the storage asset backing the PV,
+	// keeping the asset itself, and returns true, or returns false if the PV
+	// should be deleted instead. Once it returns true the controller unbinds
+	// the PV from its old claim so that the PV becomes Available, and doesn't
+	// call Delete.
+	//
+	// May return IgnoredError to indicate that the call has been ignored and no
+	// action taken.
+	Recycle(*v1.PersistentVolume) (bool, error)
+}
+
+// recycleVolume recycles the volume if the provisioner implements Recycler
+// and wants to recycle it, and returns whether it was recycled, in which case
+// it must not be deleted
+func (ctrl *ProvisionController) recycleVolume(volume *v1.PersistentVolume) (bool, error) {
+	recycler, ok := ctrl.provisioner.(Recycler)
+	if !ok {
+		return false, nil
+	}
+	if volume.DeletionTimestamp != nil {
+		// Its PV object was deleted, so it can't be bound again
+		return false, nil
+	}
+	if volume.Spec.ClaimRef == nil {
+		// Recycled and unbound already, the PV controller has yet to notice
+		return true, nil
+	}
+	recycled, err := recycler.Recycle(volume)
+	if err != nil {
+		if _, ok := err.(*IgnoredError); ok {
+			return true, err
+		}
+		glog.Errorf("Recycling of volume %q failed: %v", volume.Name, err)
+		ctrl.eventRecorder.Event(volume, v1.EventTypeWarning, "VolumeFailedRecycle", err.Error())
+		return true, err
+	}
+	if !recycled {
+		return false, nil
+	}
+	glog.Infof("volume %q recycled", volume.Name)
+
+	// Unbind the volume like the PV controller does after recycling: without
+	// a claim reference it becomes Available
+	_, err = UpdateVolume(ctrl.client, volume, func(volume *v1.PersistentVolume) (bool, error) {
+		if volume.Spec.ClaimRef == nil {
+			return false, nil
+		}
+		volume.Spec.ClaimRef = nil
+		delete(volume.Annotations, annBoundByController)
+		return true, nil
+	})
+	if err != nil {
+		// The volume stays Released, so the controller will recycle it again
+		// on next update
+		return true, fmt.Errorf("recycled volume but error unbinding it: %v", err)
+	}
+	ctrl.eventRecorder.Event(volume, v1.EventTypeNormal, "VolumeRecycled", "Volume recycled")
+	return true, nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/metrics"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+)
+
+// Reasons the controller skips a pending claim, the reason label of
+// metrics.SkippedClaims
+const (
+	skipReasonForeignProvisioner = "foreign_provisioner"
+	skipReasonMissingClass       = "missing_class"
+	skipReasonFiltered           = "filtered"
+	skipReasonTerminating        = "terminating"
+)
+
+// claimSkipped counts a pending claim the controller skipped for the reason
+func (ctrl *ProvisionController) claimSkipped(reason string) {
+	metrics.SkippedClaims.WithLabelValues(ctrl.provisionerName, reason).Inc()
+}
+
+// classNotUsable logs and counts a pending claim skipped because getStorageClass
+// failed for its class. A class of another provisioner is the normal case of
+// a cluster with more than one, so it is only logged at debug level; a missing
+// class, which no provisioner will provision for, is an error.
+func (ctrl *ProvisionController) classNotUsable(claim *v1.PersistentVolumeClaim, claimClass string, err error) {
+	classObj, found, _ := ctrl.classes.GetByKey(claimClass)
+	if !found {
+		glog.Errorf("Claim %q: %v", claimToClaimKey(claim), err)
+		ctrl.claimSkipped(skipReasonMissingClass)
+		return
+	}
+	if class, ok := classObj.(*v1beta1.StorageClass); ok && class.Provisioner != ctrl.provisionerName {
+		glog.V(4).Infof("Claim %q is for provisioner %q of class %q, skipping", claimToClaimKey(claim), class.Provisioner, claimClass)
+		ctrl.claimSkipped(skipReasonForeignProvisioner)
+		return
+	}
+	glog.Errorf("Claim %q: %v", claimToClaimKey(claim), err)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// claimTerminating returns whether the claim is marked for deletion, e.g.
+// held back by the PVC protection finalizer, in which case nothing should be
+// provisioned for it
+func claimTerminating(claim *v1.PersistentVolumeClaim) bool {
+	return claim.DeletionTimestamp != nil
+}
+
+// claimDeleted returns whether the claim was deleted, or marked for deletion,
+// since provisioning for it started. If the claim can't be got the volume is
+// saved as usual: a PV of a deleted claim is released and reclaimed anyway.
+func (ctrl *ProvisionController) claimDeleted(claim *v1.PersistentVolumeClaim) bool {
+	current, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name)
+	if apierrs.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		glog.Errorf("Failed to get claim %q to check it still exists, saving its volume: %v", claimToClaimKey(claim), err)
+		return false
+	}
+	return current.UID != claim.UID || claimTerminating(current)
+}
+
+// deleteProvisionedVolume deletes the storage asset of a volume Provision
+// returned that won't be saved, trying several times, and removes its journal
+// entry once it is deleted
+func (ctrl *ProvisionController) deleteProvisionedVolume(claim *v1.PersistentVolumeClaim, volume *v1.PersistentVolume) error {
+	var err error
+	for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
+		if err = ctrl.provisioner.Delete(volume); err == nil {
+			glog.V(4).Infof("provisionClaimOperation [%s]: cleaning volume %s succeeded", claimToClaimKey(claim), volume.Name)
+			ctrl.removeJournalEntry(volume.Name)
+			return nil
+		}
+		// Delete failed, try again after a while.
+		glog.Infof("failed to delete volume %q: %v", volume.Name, err)
+		time.Sleep(ctrl.createProvisionedPVInterval)
+	}
+	return fmt.Errorf("failed to delete volume %q %d times: %v", volume.Name, ctrl.createProvisionedPVRetryCount, err)
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package test has utilities for testing provisioners without a cluster: a
+// Harness that runs a ProvisionController against a fake clientset, builders
+// for the claims, classes and volumes to seed it with, and a fake
+// Provisioner.
+//
+// A test seeds a Harness with the objects of a cluster, starts it and waits
+// for the controller to act on them:
+//
+//	claim := test.NewClaim("claim-1", "default", "class-1", "1Gi")
+//	h := test.NewHarness("example.com/foo", provisioner,
+//		test.NewStorageClass("class-1", "example.com/foo", nil), claim)
+//	h.Start()
+//	defer h.Stop()
+//	pv, err := h.WaitForVolume(test.VolumeName(claim))
+//
+// The fake clientset doesn't send watch events, so the controller only sees
+// the objects the Harness was created with, plus the volumes it creates
+// itself. Flows that need a cluster to change, e.g. a claim being deleted,
+// are tested by seeding the Harness with the changed cluster instead, e.g.
+// with a released volume.
+package test
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"sort"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/testapi"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/storage/v1beta1"
+	"k8s.io/client-go/pkg/types"
+)
+
+const (
+	annClass                  = "volume.beta.kubernetes.io/storage-class"
+	annDynamicallyProvisioned = "pv.kubernetes.io/provisioned-by"
+)
+
+// NewStorageClass returns a StorageClass of the given provisioner with the
+// given parameters
+func NewStorageClass(name, provisioner string, parameters map[string]string) *v1beta1.StorageClass {
+	return &v1beta1.StorageClass{
+		ObjectMeta: v1.ObjectMeta{
+			Name: name,
+		},
+		Provisioner: provisioner,
+		Parameters:  parameters,
+	}
+}
+
+// NewClaim returns a pending claim requesting capacity, e.g. "1Gi", from the
+// given class with the ReadWriteOnce access mode. Its UID is "uid-" followed
+// by its name, so the controller provisions the PV VolumeName(claim) for it.
+func NewClaim(name, namespace, class, capacity string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			UID:             types.UID("uid-" + name),
+			ResourceVersion: "0",
+			Annotations:     map[string]string{annClass: class},
+			SelfLink:        testapi.Default.SelfLink("pvc", ""),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse(capacity),
+				},
+			},
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase: v1.ClaimPending,
+		},
+	}
+}
+
+// VolumeName returns the name of the PV the controller provisions for the
+// claim
+func VolumeName(claim *v1.PersistentVolumeClaim) string {
+	return "pvc-" + string(claim.UID)
+}
+
+// NewVolumeOptions returns the options the controller passes to Provision for
+// the claim when its class has the given parameters, for testing Provision
+// directly
+func NewVolumeOptions(claim *v1.PersistentVolumeClaim, parameters map[string]string) controller.VolumeOptions {
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        VolumeName(claim),
+		PVC:                           claim,
+		Parameters:                    parameters,
+	}
+}
+
+// NewReleasedVolume returns a PV provisioned by the given provisioner whose
+// claim was deleted, which the controller deletes, with the given
+// source and annotations
+func NewReleasedVolume(name, provisioner string, source v1.PersistentVolumeSource, annotations map[string]string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{annDynamicallyProvisioned: provisioner},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+			},
+			PersistentVolumeSource: source,
+		},
+		Status: v1.PersistentVolumeStatus{
+			Phase: v1.VolumeReleased,
+		},
+	}
+	for k, v := range annotations {
+		pv.Annotations[k] = v
+	}
+	return pv
+}
+
+func sortEvents(events []v1.Event) {
+	sort.Sort(byTimestamp(events))
+}
+
+type byTimestamp []v1.Event
+
+func (e byTimestamp) Len() int      { return len(e) }
+func (e byTimestamp) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byTimestamp) Less(i, j int) bool {
+	return e[i].FirstTimestamp.Before(e[j].FirstTimestamp)
+}
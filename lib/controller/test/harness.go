@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+const (
+	// ResyncPeriod is the resync period of test controllers
+	ResyncPeriod = 100 * time.Millisecond
+	// FailedRetryThreshold is the failed retry threshold of test controllers
+	FailedRetryThreshold = 5
+
+	pollInterval = 10 * time.Millisecond
+)
+
+// Timeout is how long the Harness' WaitFor methods wait before giving up
+var Timeout = 5 * time.Second
+
+// NewTestProvisionController creates a ProvisionController for tests: one
+// that resyncs every ResyncPeriod, treats the server as 1.5 and doesn't back
+// off on errors.
+func NewTestProvisionController(client kubernetes.Interface, provisionerName string, provisioner controller.Provisioner, options ...func(*controller.ProvisionController) error) *controller.ProvisionController {
+	return controller.NewProvisionController(client, ResyncPeriod, provisionerName, provisioner, "v1.5.0", false, FailedRetryThreshold, 2*ResyncPeriod, ResyncPeriod, ResyncPeriod/2, 2*ResyncPeriod, options...)
+}
+
+// Harness runs a test ProvisionController against a fake clientset
+type Harness struct {
+	// Client is the fake clientset the controller runs against. Tests may
+	// prepend reactors to it before calling Start, e.g. to make creating
+	// PVs fail.
+	Client *fake.Clientset
+	// Controller is the controller, created by NewTestProvisionController
+	Controller *controller.ProvisionController
+
+	stopCh chan struct{}
+}
+
+// NewHarness creates a Harness for a controller of the given provisioner
+// whose fake clientset has the given objects.
+func NewHarness(provisionerName string, provisioner controller.Provisioner, objs ...runtime.Object) *Harness {
+	return NewHarnessWithOptions(provisionerName, provisioner, objs, nil)
+}
+
+// NewHarnessWithOptions is NewHarness for a controller created with the given
+// options, e.g. controller.MinimumVolumeSize.
+func NewHarnessWithOptions(provisionerName string, provisioner controller.Provisioner, objs []runtime.Object, options []func(*controller.ProvisionController) error) *Harness {
+	client := fake.NewSimpleClientset(objs...)
+	return &Harness{
+		Client:     client,
+		Controller: NewTestProvisionController(client, provisionerName, provisioner, options...),
+	}
+}
+
+// Start runs the controller until Stop is called
+func (h *Harness) Start() {
+	h.stopCh = make(chan struct{})
+	go h.Controller.Run(h.stopCh)
+}
+
+// Stop stops the controller
+func (h *Harness) Stop() {
+	if h.stopCh != nil {
+		close(h.stopCh)
+		h.stopCh = nil
+	}
+}
+
+// Volumes returns the PVs in the fake clientset
+func (h *Harness) Volumes() ([]v1.PersistentVolume, error) {
+	list, err := h.Client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Events returns the events the controller recorded for the object of the
+// given name, in all namespaces, in the order they were recorded. An empty
+// name returns all events.
+func (h *Harness) Events(name string) ([]v1.Event, error) {
+	list, err := h.Client.Core().Events(v1.NamespaceAll).List(v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	events := []v1.Event{}
+	for _, event := range list.Items {
+		if name == "" || event.InvolvedObject.Name == name {
+			events = append(events, event)
+		}
+	}
+	sortEvents(events)
+	return events, nil
+}
+
+// WaitForVolume waits for the PV of the given name to be created and returns
+// it
+func (h *Harness) WaitForVolume(name string) (*v1.PersistentVolume, error) {
+	var volume *v1.PersistentVolume
+	err := wait.Poll(pollInterval, Timeout, func() (bool, error) {
+		var err error
+		volume, err = h.Client.Core().PersistentVolumes().Get(name)
+		if err != nil {
+			if apierrs.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("PV %q wasn't created within %v", name, Timeout)
+	}
+	return volume, err
+}
+
+// WaitForVolumeDeletion waits for the PV of the given name to be deleted
+func (h *Harness) WaitForVolumeDeletion(name string) error {
+	err := wait.Poll(pollInterval, Timeout, func() (bool, error) {
+		_, err := h.Client.Core().PersistentVolumes().Get(name)
+		if err != nil {
+			if apierrs.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("PV %q wasn't deleted within %v", name, Timeout)
+	}
+	return err
+}
+
+// WaitForEvent waits for the controller to record an event with the given
+// reason, e.g. ProvisioningFailed, for the object of the given name and
+// returns it
+func (h *Harness) WaitForEvent(name, reason string) (*v1.Event, error) {
+	var found *v1.Event
+	err := wait.Poll(pollInterval, Timeout, func() (bool, error) {
+		events, err := h.Events(name)
+		if err != nil {
+			return false, err
+		}
+		for i := range events {
+			if events[i].Reason == reason {
+				found = &events[i]
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("no %s event was recorded for %q within %v", reason, name, Timeout)
+	}
+	return found, err
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestHarness(t *testing.T) {
+	claim := NewClaim("claim-1", "default", "class-1", "1Gi")
+	released := NewReleasedVolume("pvc-2", "foo.bar/baz", v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "fake", Path: "/pvc-2"}}, nil)
+	p := &FakeProvisioner{}
+	h := NewHarness("foo.bar/baz", p, NewStorageClass("class-1", "foo.bar/baz", map[string]string{"foo": "bar"}), claim, released)
+	h.Start()
+	defer h.Stop()
+
+	volume, err := h.WaitForVolume(VolumeName(claim))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if volume.Spec.ClaimRef == nil || volume.Spec.ClaimRef.Name != "claim-1" {
+		t.Errorf("expected PV bound to claim-1 but got claim ref %v", volume.Spec.ClaimRef)
+	}
+	if _, err := h.WaitForEvent("claim-1", "ProvisioningSucceeded"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := h.WaitForVolumeDeletion("pvc-2"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	provisioned := p.Provisioned()
+	if len(provisioned) != 1 || !reflect.DeepEqual(provisioned[0].Parameters, map[string]string{"foo": "bar"}) {
+		t.Errorf("expected one Provision call with the class' parameters but got %v", provisioned)
+	}
+	if deleted := p.Deleted(); !reflect.DeepEqual(deleted, []string{"pvc-2"}) {
+		t.Errorf("expected Delete call for pvc-2 but got %v", deleted)
+	}
+}
+
+func TestHarnessProvisioningFailed(t *testing.T) {
+	claim := NewClaim("claim-1", "default", "class-1", "1Gi")
+	p := &FakeProvisioner{ProvisionErr: errors.New("fake error")}
+	h := NewHarness("foo.bar/baz", p, NewStorageClass("class-1", "foo.bar/baz", nil), claim)
+	h.Start()
+	defer h.Stop()
+
+	event, err := h.WaitForEvent("claim-1", "ProvisioningFailed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.Type != v1.EventTypeWarning {
+		t.Errorf("expected warning event but got %q", event.Type)
+	}
+	volumes, err := h.Volumes()
+	if err != nil || len(volumes) != 0 {
+		t.Errorf("expected no PVs but got %v, error %v", volumes, err)
+	}
+}
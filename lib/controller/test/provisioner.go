@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"sync"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// FakeProvisioner is a Provisioner that records its calls and provisions NFS
+// PVs of a server that doesn't exist. It is safe for concurrent use.
+type FakeProvisioner struct {
+	// ProvisionErr, if set, is returned by Provision
+	ProvisionErr error
+	// DeleteErr, if set, is returned by Delete
+	DeleteErr error
+
+	mutex       sync.Mutex
+	provisioned []controller.VolumeOptions
+	deleted     []string
+}
+
+var _ controller.Provisioner = &FakeProvisioner{}
+
+// Provision records the options and returns a PV for them, or ProvisionErr
+func (p *FakeProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.provisioned = append(p.provisioned, options)
+	if p.ProvisionErr != nil {
+		return nil, p.ProvisionErr
+	}
+
+	return &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: options.PVName,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server: "fake",
+					Path:   "/" + options.PVName,
+				},
+			},
+		},
+	}, nil
+}
+
+// Delete records the volume's name and returns DeleteErr
+func (p *FakeProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deleted = append(p.deleted, volume.Name)
+	return p.DeleteErr
+}
+
+// Provisioned returns the options of the calls to Provision so far
+func (p *FakeProvisioner) Provisioned() []controller.VolumeOptions {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]controller.VolumeOptions(nil), p.provisioned...)
+}
+
+// Deleted returns the names of the volumes of the calls to Delete so far
+func (p *FakeProvisioner) Deleted() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.deleted...)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	apierrs "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Number of times UpdateVolume tries to save a volume that keeps changing
+// under it before giving up
+const updateVolumeRetries = 5
+
+// UpdateVolume applies mutate to a copy of volume and, if mutate reports that
+// it modified the copy, saves it. If saving fails because the volume was
+// changed meanwhile, it gets the latest version and tries again, a few times.
+// It returns the saved volume, or volume itself if mutate didn't modify it.
+func UpdateVolume(client kubernetes.Interface, volume *v1.PersistentVolume, mutate func(*v1.PersistentVolume) (bool, error)) (*v1.PersistentVolume, error) {
+	for i := 0; ; i++ {
+		clone, err := api.Scheme.DeepCopy(volume)
+		if err != nil {
+			return nil, fmt.Errorf("error cloning volume %q: %v", volume.Name, err)
+		}
+		volumeClone, ok := clone.(*v1.PersistentVolume)
+		if !ok {
+			return nil, fmt.Errorf("unexpected volume cast error: %v", clone)
+		}
+
+		modified, err := mutate(volumeClone)
+		if err != nil {
+			return nil, err
+		}
+		if !modified {
+			return volume, nil
+		}
+
+		updated, err := client.Core().PersistentVolumes().Update(volumeClone)
+		if err == nil {
+			return updated, nil
+		}
+		if !apierrs.IsConflict(err) || i+1 >= updateVolumeRetries {
+			return nil, fmt.Errorf("error updating volume %q: %v", volume.Name, err)
+		}
+		glog.V(4).Infof("volume %q changed while updating it, retrying", volume.Name)
+
+		name := volume.Name
+		if volume, err = client.Core().PersistentVolumes().Get(name); err != nil {
+			return nil, fmt.Errorf("error getting volume %q: %v", name, err)
+		}
+	}
+}
+
+// shouldUpdate returns whether the provisioner should be given the chance to
+// update the volume: it must implement Updater and have provisioned the
+// volume
+func (ctrl *ProvisionController) shouldUpdate(volume *v1.PersistentVolume) bool {
+	if _, ok := ctrl.provisioner.(Updater); !ok {
+		return false
+	}
+	return volume.Annotations[annDynamicallyProvisioned] == ctrl.provisionerName
+}
+
+// updateVolumeOperation lets the provisioner update the volume and saves it
+// if the provisioner modified it
+func (ctrl *ProvisionController) updateVolumeOperation(volume *v1.PersistentVolume) error {
+	updated, err := UpdateVolume(ctrl.client, volume, ctrl.provisioner.(Updater).Update)
+	if err != nil {
+		glog.Errorf("Update of volume %q failed: %v", volume.Name, err)
+		ctrl.eventRecorder.Event(volume, v1.EventTypeWarning, "VolumeFailedUpdate", err.Error())
+		return err
+	}
+	if updated != volume {
+		glog.Infof("volume %q updated", volume.Name)
+	}
+	return nil
+}
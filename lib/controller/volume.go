@@ -17,18 +17,30 @@ limitations under the License.
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"k8s.io/client-go/pkg/api/v1"
 )
 
+// annProvisioningParameters annotation holds the parameters a PV was
+// provisioned with, JSON-encoded, if its provisioner recorded them with
+// SetProvisioningParameters
+const annProvisioningParameters = "volume.kubernetes.io/provisioning-parameters"
+
 // Provisioner is an interface that creates templates for PersistentVolumes
 // and can create the volume as a new resource in the infrastructure provider.
 // It can also remove the volume it created from the underlying storage
 // provider.
 type Provisioner interface {
 	// Provision creates a volume i.e. the storage asset and returns a PV object
-	// for the volume
+	// for the volume.
+	//
+	// May return TerminalError to indicate that provisioning can't succeed
+	// until the claim or its class is edited, or RetryableError to indicate
+	// that it failed for a reason expected to pass. Other errors are retried
+	// up to the controller's failedRetryThreshold.
 	Provision(VolumeOptions) (*v1.PersistentVolume, error)
 	// Delete removes the storage asset that was created by Provision backing the
 	// given PV. Does not delete the PV object itself.
@@ -38,6 +50,33 @@ type Provisioner interface {
 	Delete(*v1.PersistentVolume) error
 }
 
+// Lister is an optional interface for a Provisioner to implement so that the
+// controller can find storage assets it created that have no PV, e.g. because
+// it crashed after Provision returned but before the PV was saved. See the
+// OrphanReaper option.
+type Lister interface {
+	// ListVolumes returns a PV for every storage asset the provisioner has
+	// created, as Provision returned it or at least with the name Provision was
+	// given and everything Delete needs to delete the asset. Only assets of
+	// this provisioner instance must be returned, whether or not their PVs
+	// exist.
+	ListVolumes() ([]*v1.PersistentVolume, error)
+}
+
+// Updater is an optional interface for a Provisioner to implement so that it
+// can keep the PVs it provisioned up to date, e.g. refresh annotations, point
+// them at rotated secrets or fix their capacity after the backend resized the
+// volume. Otherwise the controller never changes a PV after creating it.
+type Updater interface {
+	// Update modifies the given PV, a copy of one the provisioner provisioned,
+	// as needed and returns whether it modified it. The controller calls it
+	// whenever the PV changes and on every resync, so it must be cheap when the
+	// PV needs no update, and saves the PV if it was modified. If saving fails
+	// because the PV changed meanwhile, Update is called again with the new
+	// version.
+	Update(*v1.PersistentVolume) (bool, error)
+}
+
 // IgnoredError is the value for Delete to return to indicate that the call has
 // been ignored and no action taken. In case multiple provisioners are serving
 // the same storage class, provisioners may ignore PVs they are not responsible
@@ -51,6 +90,32 @@ func (e *IgnoredError) Error() string {
 	return fmt.Sprintf("ignored because %s", e.Reason)
 }
 
+// TerminalError is the value for Provision to return to indicate that
+// provisioning for the claim can't succeed until the claim or its storage
+// class is edited, e.g. because a parameter is invalid. The controller stops
+// retrying right away, instead of after failedRetryThreshold failures, and
+// records a ProvisioningStopped event on the claim with the reason.
+type TerminalError struct {
+	Reason string
+}
+
+func (e *TerminalError) Error() string {
+	return e.Reason
+}
+
+// RetryableError is the value for Provision to return to indicate that
+// provisioning failed for a reason expected to pass, e.g. the storage backend
+// being unreachable. The controller keeps retrying, backing off as it does
+// for other errors, however many times provisioning fails: failedRetryThreshold
+// doesn't apply.
+type RetryableError struct {
+	Reason string
+}
+
+func (e *RetryableError) Error() string {
+	return e.Reason
+}
+
 // VolumeOptions contains option information about a volume
 // https://github.com/kubernetes/kubernetes/blob/release-1.4/pkg/volume/plugins.go
 type VolumeOptions struct {
@@ -66,4 +131,57 @@ type VolumeOptions struct {
 	PVC *v1.PersistentVolumeClaim
 	// Volume provisioning parameters from StorageClass
 	Parameters map[string]string
+	// Node the scheduler selected for the first pod using the claim, for
+	// topology-aware provisioners to create the volume where the node can
+	// reach it. Nil unless the claim's class has volume binding mode
+	// WaitForFirstConsumer, see the DelayedBinding option.
+	SelectedNode *v1.Node
+}
+
+// SetProvisioningParameters records parameters, normally the Parameters of the
+// VolumeOptions the volume was provisioned with, in an annotation of the
+// volume so that Delete can get them back with GetProvisioningParameters even
+// if the StorageClass has since been deleted or edited. Parameters whose keys
+// match one of exclude, ignoring case, are left out: anyone who can read PVs
+// can read the annotation, so parameters holding secrets must be excluded.
+// Parameters naming secrets, like a secret's name and namespace, are fine.
+func SetProvisioningParameters(volume *v1.PersistentVolume, parameters map[string]string, exclude ...string) error {
+	recorded := make(map[string]string)
+	for k, v := range parameters {
+		excluded := false
+		for _, e := range exclude {
+			if strings.EqualFold(k, e) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			recorded[k] = v
+		}
+	}
+	data, err := json.Marshal(recorded)
+	if err != nil {
+		return fmt.Errorf("error encoding provisioning parameters: %v", err)
+	}
+	if volume.Annotations == nil {
+		volume.Annotations = make(map[string]string)
+	}
+	volume.Annotations[annProvisioningParameters] = string(data)
+	return nil
+}
+
+// GetProvisioningParameters returns the parameters SetProvisioningParameters
+// recorded on the volume and whether it recorded any. Volumes provisioned
+// before their provisioner recorded parameters have none, in which case the
+// provisioner may fall back to the parameters of the volume's StorageClass.
+func GetProvisioningParameters(volume *v1.PersistentVolume) (map[string]string, bool, error) {
+	data, ok := volume.Annotations[annProvisioningParameters]
+	if !ok {
+		return nil, false, nil
+	}
+	parameters := make(map[string]string)
+	if err := json.Unmarshal([]byte(data), &parameters); err != nil {
+		return nil, false, fmt.Errorf("error decoding annotation %s of volume %q: %v", annProvisioningParameters, volume.Name, err)
+	}
+	return parameters, true, nil
 }
@@ -117,7 +117,10 @@ type LeaderElector struct {
 	reportedLeader string
 }
 
-// Run starts the leader election loop
+// Run starts the leader election loop. Leadership is given up when task
+// receives a result, when TermLimit is reached or when renewing fails. A nil
+// task never receives, so with a TermLimit of 0 the leader leads until it
+// fails to renew.
 func (le *LeaderElector) Run(task <-chan bool) {
 	defer func() {
 		runtime.HandleCrash()
@@ -129,10 +132,12 @@ func (le *LeaderElector) Run(task <-chan bool) {
 	stop := make(chan struct{})
 	go le.config.Callbacks.OnStartedLeading(stop)
 	timeout := make(chan bool, 1)
-	go func() {
-		time.Sleep(le.config.TermLimit)
-		timeout <- true
-	}()
+	if le.config.TermLimit > 0 {
+		go func() {
+			time.Sleep(le.config.TermLimit)
+			timeout <- true
+		}()
+	}
 	le.renew(task, timeout)
 	close(stop)
 	le.config.Callbacks.OnStoppedLeading()
@@ -0,0 +1,217 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	cephfs "github.com/kubernetes-incubator/external-storage/cephfs/pkg/volume"
+	flex "github.com/kubernetes-incubator/external-storage/flex/pkg/volume"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes"
+)
+
+// config is the contents of the multi-provisioner's configuration file.
+type config struct {
+	Provisioners []provisionerConfig `json:"provisioners"`
+}
+
+// provisionerConfig maps a provisioner name to the backend that serves it.
+type provisionerConfig struct {
+	// Name is the provisioner name StorageClasses refer to
+	Name string `json:"name"`
+	// Backend is the key of one of backends
+	Backend string `json:"backend"`
+	// Parameters configure the backend, they are backend-specific
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// backend creates a Provisioner named name from a provisionerConfig's
+// parameters, along with the runners of its background work, if any.
+type backend func(client kubernetes.Interface, name string, parameters map[string]string) (controller.Provisioner, []runner, error)
+
+// runner does a backend's background work, e.g. purging the trash, until
+// stopCh is closed. Runners only run while the provisioners do, i.e. on the
+// leader if leader election is enabled.
+type runner func(stopCh <-chan struct{})
+
+// backends are the provisioners the multi-provisioner can host.
+var backends = map[string]backend{
+	"cephfs": newCephFSBackend,
+	"flex":   newFlexBackend,
+}
+
+// newCephFSBackend accepts the parameters "keyringFile", "quotaConfigMap",
+// "cleanupJobImage", "cleanupJobNamespace", "cleanupJobCommand",
+// "trashRetention", "trashPurgePeriod", "trashRecordsConfigMap",
+// "shareRecordsNamespace" and "shareRecordsSyncPeriod", see the cephfs
+// provisioner's -ceph-keyring-file, -quota-configmap, -cleanup-job-*, -trash-*
+// and -share-records-* flags.
+func newCephFSBackend(client kubernetes.Interface, name string, parameters map[string]string) (controller.Provisioner, []runner, error) {
+	var keyring *cephfs.Keyring
+	var quotas *cephfs.Quotas
+	cleanupImage, cleanupNamespace, cleanupCommand := "", "default", ""
+	var trashRetention time.Duration
+	trashPeriod, sharesPeriod := time.Hour, 10*time.Minute
+	sharesNamespace := ""
+	trashRecords := "default/cephfs-provisioner-trash"
+	for k, v := range parameters {
+		var err error
+		switch k {
+		case "keyringFile":
+			keyring, err = cephfs.NewKeyring(v)
+		case "quotaConfigMap":
+			quotas, err = cephfs.NewQuotas(client, v)
+		case "cleanupJobImage":
+			cleanupImage = v
+		case "cleanupJobNamespace":
+			cleanupNamespace = v
+		case "cleanupJobCommand":
+			cleanupCommand = v
+		case "trashRetention":
+			trashRetention, err = time.ParseDuration(v)
+		case "trashPurgePeriod":
+			trashPeriod, err = time.ParseDuration(v)
+		case "trashRecordsConfigMap":
+			trashRecords = v
+		case "shareRecordsNamespace":
+			sharesNamespace = v
+		case "shareRecordsSyncPeriod":
+			sharesPeriod, err = time.ParseDuration(v)
+		default:
+			return nil, nil, fmt.Errorf("invalid cephfs parameter %q", k)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid cephfs parameter %s: %v", k, err)
+		}
+	}
+	var cleanupJobs *cephfs.CleanupJobs
+	if cleanupImage != "" {
+		var err error
+		cleanupJobs, err = cephfs.NewCleanupJobs(client, cleanupNamespace, cleanupImage, strings.Fields(cleanupCommand))
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if cleanupCommand != "" {
+		return nil, nil, fmt.Errorf("cephfs parameter cleanupJobCommand can only be set if cleanupJobImage is set")
+	}
+	var trash *cephfs.Trash
+	if trashRetention != 0 {
+		if cleanupJobs != nil {
+			return nil, nil, fmt.Errorf("cephfs parameter trashRetention can't be set if cleanupJobImage is set")
+		}
+		var err error
+		trash, err = cephfs.NewTrash(client, trashRecords, trashRetention)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var shares *cephfs.Shares
+	if sharesNamespace != "" {
+		var err error
+		shares, err = cephfs.NewShares(client, sharesNamespace)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	provisioner := cephfs.NewCephFSProvisioner(client, keyring, quotas, cleanupJobs, trash, shares)
+
+	var runners []runner
+	if trash != nil {
+		purger, err := cephfs.NewTrashPurger(provisioner, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		runners = append(runners, func(stopCh <-chan struct{}) { purger.Run(trashPeriod, stopCh) })
+	}
+	if shares != nil {
+		syncer, err := cephfs.NewShareSyncer(provisioner, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		runners = append(runners, func(stopCh <-chan struct{}) { syncer.Run(sharesPeriod, stopCh) })
+	}
+	return provisioner, runners, nil
+}
+
+// newFlexBackend requires the parameter "execCommand" and accepts
+// "execTimeout", see the flex provisioner's -execCommand and -execTimeout
+// flags.
+func newFlexBackend(client kubernetes.Interface, name string, parameters map[string]string) (controller.Provisioner, []runner, error) {
+	execCommand := ""
+	execTimeout := 5 * time.Minute
+	for k, v := range parameters {
+		switch k {
+		case "execCommand":
+			execCommand = v
+		case "execTimeout":
+			var err error
+			execTimeout, err = time.ParseDuration(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid flex parameter %s: %v", k, err)
+			}
+			if execTimeout < 0 {
+				return nil, nil, fmt.Errorf("invalid flex parameter %s: must not be negative", k)
+			}
+		default:
+			return nil, nil, fmt.Errorf("invalid flex parameter %q", k)
+		}
+	}
+	if execCommand == "" {
+		return nil, nil, fmt.Errorf("flex parameter execCommand is required")
+	}
+	return flex.NewFlexProvisioner(execCommand, execTimeout), nil, nil
+}
+
+// loadConfig reads and validates the configuration file at path.
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data)
+}
+
+func parseConfig(data []byte) (*config, error) {
+	c := &config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing config: %v", err)
+	}
+
+	if len(c.Provisioners) == 0 {
+		return nil, fmt.Errorf("config has no provisioners")
+	}
+	names := make(map[string]bool)
+	for _, p := range c.Provisioners {
+		if p.Name == "" {
+			return nil, fmt.Errorf("provisioner with backend %q has no name", p.Backend)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("provisioner name %q appears more than once", p.Name)
+		}
+		names[p.Name] = true
+		if _, ok := backends[p.Backend]; !ok {
+			return nil, fmt.Errorf("provisioner %q has unknown backend %q", p.Name, p.Backend)
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		expected    *config
+		expectError bool
+	}{
+		{
+			name: "cephfs and flex",
+			data: `
+provisioners:
+- name: kubernetes.io/cephfs
+  backend: cephfs
+- name: example.com/flex
+  backend: flex
+  parameters:
+    execCommand: /opt/storage/flex-provision.sh
+`,
+			expected: &config{
+				Provisioners: []provisionerConfig{
+					{Name: "kubernetes.io/cephfs", Backend: "cephfs"},
+					{Name: "example.com/flex", Backend: "flex", Parameters: map[string]string{"execCommand": "/opt/storage/flex-provision.sh"}},
+				},
+			},
+		},
+		{
+			name:        "no provisioners",
+			data:        "provisioners: []\n",
+			expectError: true,
+		},
+		{
+			name: "unknown backend",
+			data: `
+provisioners:
+- name: example.com/rbd
+  backend: rbd
+`,
+			expectError: true,
+		},
+		{
+			name: "duplicate name",
+			data: `
+provisioners:
+- name: example.com/flex
+  backend: flex
+- name: example.com/flex
+  backend: cephfs
+`,
+			expectError: true,
+		},
+		{
+			name: "missing name",
+			data: `
+provisioners:
+- backend: flex
+`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		c, err := parseConfig([]byte(test.data))
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, c == nil, "nil config")
+			continue
+		}
+		evaluate(t, test.name, false, err, test.expected, c, "config")
+	}
+}
+
+func TestNewFlexBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "execCommand given",
+			parameters: map[string]string{"execCommand": "/bin/true"},
+		},
+		{
+			name:       "execTimeout given",
+			parameters: map[string]string{"execCommand": "/bin/true", "execTimeout": "1m"},
+		},
+		{
+			name:        "bad execTimeout",
+			parameters:  map[string]string{"execCommand": "/bin/true", "execTimeout": "-1m"},
+			expectError: true,
+		},
+		{
+			name:        "execCommand missing",
+			parameters:  map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"execCommand": "/bin/true", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p, _, err := newFlexBackend(nil, "example.com/flex", test.parameters)
+		evaluate(t, test.name, test.expectError, err, !test.expectError, p != nil, "provisioner")
+	}
+}
+
+func TestNewCephFSBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		runners     int
+		expectError bool
+	}{
+		{
+			name:       "no parameters",
+			parameters: map[string]string{},
+		},
+		{
+			name:       "trash",
+			parameters: map[string]string{"trashRetention": "24h", "trashPurgePeriod": "30m"},
+			runners:    1,
+		},
+		{
+			name:       "trash and share records",
+			parameters: map[string]string{"trashRetention": "24h", "shareRecordsNamespace": "kube-system"},
+			runners:    2,
+		},
+		{
+			name:        "bad trash retention",
+			parameters:  map[string]string{"trashRetention": "a day"},
+			expectError: true,
+		},
+		{
+			name:        "negative trash retention",
+			parameters:  map[string]string{"trashRetention": "-1h"},
+			expectError: true,
+		},
+		{
+			name:        "trash and cleanup jobs",
+			parameters:  map[string]string{"trashRetention": "24h", "cleanupJobImage": "ceph/cephfs-cleanup"},
+			expectError: true,
+		},
+		{
+			name:        "bad share records sync period",
+			parameters:  map[string]string{"shareRecordsNamespace": "kube-system", "shareRecordsSyncPeriod": "often"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p, runners, err := newCephFSBackend(fake.NewSimpleClientset(), "kubernetes.io/cephfs", test.parameters)
+		evaluate(t, test.name, test.expectError, err, !test.expectError, p != nil, "provisioner")
+		if !test.expectError && len(runners) != test.runners {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d runners but got %d", test.runners, len(runners))
+		}
+	}
+}
+
+func evaluate(t *testing.T, name string, expectError bool, err error, expected interface{}, got interface{}, output string) {
+	if !expectError && err != nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("unexpected error getting %s: %v", output, err)
+	} else if expectError && err == nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected error but got %s: %v", output, got)
+	} else if !reflect.DeepEqual(expected, got) {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
+	}
+}
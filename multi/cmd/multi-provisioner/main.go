@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	cephfs "github.com/kubernetes-incubator/external-storage/cephfs/pkg/volume"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
+	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/uuid"
+	"k8s.io/client-go/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	master                  = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig              = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+	configFile              = flag.String("config", "/etc/multi-provisioner/config.yaml", "Path to the file listing the provisioners to run and their backends.")
+	failedRetryThreshold    = flag.Int("failed-retry-threshold", 10, "If the number of retries on provisioning failure need to be limited to a set number of attempts. Default 10")
+	leaderElect             = flag.Bool("leader-elect", false, "Whether to elect a leader among replicas of the multi-provisioner, so that only the leader runs its provisioners.")
+	leaderElectNamespace    = flag.String("leader-elect-namespace", v1.NamespaceDefault, "Namespace of the endpoints object used as the leader election lock.")
+	leaderElectEndpointName = flag.String("leader-elect-name", "multi-provisioner", "Name of the endpoints object used as the leader election lock.")
+	cephFSCmdPath           = flag.String("cephfs-provision-command", "/usr/local/bin/cephfs_provisioner", "Path of the script that creates and deletes the shares of cephfs backends.")
+	cephFSCmdArgs           = flag.String("cephfs-provision-command-args", "", "Space-separated arguments to pass the cephfs provision command before those of each invocation.")
+	cephFSCmdTimeout        = flag.Duration("cephfs-provision-command-timeout", 5*time.Minute, "How long an invocation of the cephfs provision command may run before it is killed. A share whose creation times out is deleted. 0 means forever.")
+)
+
+const (
+	resyncPeriod              = 15 * time.Second
+	exponentialBackOffOnError = true
+	leasePeriod               = leaderelection.DefaultLeaseDuration
+	retryPeriod               = leaderelection.DefaultRetryPeriod
+	renewDeadline             = leaderelection.DefaultRenewDeadline
+	termLimit                 = leaderelection.DefaultTermLimit
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		glog.Fatalf("Error loading config %s: %v", *configFile, err)
+	}
+	// The provision command is the same for every cephfs backend in the
+	// process
+	if err := cephfs.SetProvisionCmd(*cephFSCmdPath, strings.Fields(*cephFSCmdArgs), *cephFSCmdTimeout); err != nil {
+		glog.Fatalf("Invalid cephfs provision command flags: %v", err)
+	}
+
+	var config *rest.Config
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// All the controllers share one set of informers
+	informers := controller.NewInformerFactory(clientset, resyncPeriod)
+	pcs := make([]*controller.ProvisionController, 0, len(c.Provisioners))
+	var runners []runner
+	for _, p := range c.Provisioners {
+		provisioner, backendRunners, err := backends[p.Backend](clientset, p.Name, p.Parameters)
+		if err != nil {
+			glog.Fatalf("Error creating %s provisioner %s: %v", p.Backend, p.Name, err)
+		}
+		runners = append(runners, backendRunners...)
+		glog.Infof("Running %s provisioner %s", p.Backend, p.Name)
+		pc := controller.NewProvisionController(clientset, resyncPeriod, p.Name, provisioner, serverVersion.GitVersion, exponentialBackOffOnError, *failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit, controller.Informers(informers))
+		pcs = append(pcs, pc)
+	}
+
+	run := func(stopCh <-chan struct{}) {
+		for _, pc := range pcs {
+			go pc.Run(stopCh)
+		}
+		for _, r := range runners {
+			go r(stopCh)
+		}
+		<-stopCh
+	}
+
+	if !*leaderElect {
+		run(wait.NeverStop)
+		return
+	}
+
+	// One election for the whole process rather than one per provisioner, so
+	// that the provisioners always run together on the leader
+	lock := rl.EndpointsLock{
+		EndpointsMeta: v1.ObjectMeta{
+			Namespace: *leaderElectNamespace,
+			Name:      *leaderElectEndpointName,
+		},
+		Client: clientset,
+		LockConfig: rl.ResourceLockConfig{
+			Identity: string(uuid.NewUUID()),
+		},
+	}
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          &lock,
+		LeaseDuration: leasePeriod,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		TermLimit:     0,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ <-chan struct{}) {
+				run(wait.NeverStop)
+			},
+			OnStoppedLeading: func() {
+				glog.Fatalf("Lost leader election %s, exiting", lock.Describe())
+			},
+		},
+	})
+	if err != nil {
+		glog.Fatalf("Error creating LeaderElector: %v", err)
+	}
+	le.Run(nil)
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"strings"
 	"time"
 
@@ -26,12 +27,9 @@ import (
 	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
 	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
 	vol "github.com/kubernetes-incubator/external-storage/nfs/pkg/volume"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/util/validation"
 	"k8s.io/client-go/pkg/util/validation/field"
 	"k8s.io/client-go/pkg/util/wait"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
@@ -45,8 +43,32 @@ var (
 	enableXfsQuota       = flag.Bool("enable-xfs-quota", false, "If the provisioner will set xfs quotas for each volume it provisions. Requires that the directory it creates volumes in ('/export') is xfs mounted with option prjquota/pquota, and that it has the privilege to run xfs_quota. Default false.")
 	failedRetryThreshold = flag.Int("failed-retry-threshold", 10, "If the number of retries on provisioning failure need to be limited to a set number of attempts. Default 10")
 	serverHostname       = flag.String("server-hostname", "", "The hostname for the NFS server to export from. Only applicable when running out-of-cluster i.e. it can only be set if either master or kubeconfig are set. If unset, the first IP output by `hostname -i` is used.")
+	krb5Keytab           = flag.String("krb5-keytab", "", "Path to a keytab containing the NFS server's nfs/<hostname> principal. If set, NFS Ganesha accepts the Kerberos security flavors krb5, krb5i and krb5p and classes may ask for them with the sec parameter. Can only be set if both run-server and use-ganesha are true.")
+	enableKrb5           = flag.Bool("enable-krb5", false, "If the NFS server, not run by the provisioner, is set up for Kerberos, so classes may ask for the security flavors krb5, krb5i and krb5p with the sec parameter. Can only be set if run-server is false. Default false.")
+	serviceHostname      = flag.String("service-hostname", "", "The hostname to put on PVs as their NFS server instead of the cluster IP of the service SERVICE_NAME, e.g. one ExternalDNS publishes for the service, so PVs stay mountable when the service's IP changes. Can only be set if running in-cluster.")
+	annotateService      = flag.Bool("annotate-service", false, "If the provisioner will annotate the service SERVICE_NAME with external-dns.alpha.kubernetes.io/hostname set to service-hostname, for ExternalDNS to publish the hostname. Can only be set if service-hostname is set. Default false.")
+	metricsAddress       = flag.String("metrics-address", "", "The address to serve Prometheus metrics at /metrics on, e.g. :8080. If unset, metrics are not served.")
+	usagePeriod          = flag.Duration("usage-period", 0, "How often to measure the disk space used by each volume, for the nfs_provisioner_volume_used_bytes metric and the usage report. If 0, usage is not measured. Default 0.")
+	recycle              = flag.Bool("recycle", false, "If the provisioner will scrub the data of released volumes and return them to Available for new claims, keeping their directories and exports, instead of deleting them. Default false.")
+	usageAddress         = flag.String("usage-address", "", "The address to serve the JSON usage report at /usage on, e.g. :8081. Can only be set if usage-period is set.")
+	nfsVersions          = flag.String("nfs-versions", "3,4", "Comma-separated NFS versions the server serves: 3, 4 (4.0) and/or 4.1. PVs get a vers mount option if the server doesn't serve NFSv3 or only serves it. If run-server is false, the versions the external server serves. Default 3,4.")
+	nfsPort              = flag.Int("nfs-port", 2049, "The port the server serves NFS on. PVs get a port mount option if it isn't 2049. If run-server is false, the port of the external server. Default 2049.")
+	mountdPort           = flag.Int("mountd-port", 20048, "The port the server serves the NFSv3 MOUNT protocol on. PVs get a mountport mount option if it isn't 20048 and NFSv3 is served. Default 20048.")
+	runRpcbind           = flag.Bool("run-rpcbind", true, "If the provisioner will start rpcbind, which NFSv3 needs. Can only be set to false if nfs-versions doesn't include 3. Only applicable if run-server is true. Default true.")
+	runStatd             = flag.Bool("run-statd", true, "If the provisioner will start rpc.statd, which NFSv3 locking needs. Can only be set to false if nfs-versions doesn't include 3. Only applicable if run-server is true. Default true.")
+	sshHost              = flag.String("ssh-host", "", "The host of an existing kernel NFS server for the provisioner to manage over SSH in export-manager mode: it creates directories in remote-export-dir and exports them by editing /etc/exports there instead of locally. PVs get the host as their server unless server-hostname is set. Can only be set if run-server, use-ganesha and enable-xfs-quota are false.")
+	sshUser              = flag.String("ssh-user", "", "The user to log in to ssh-host as. It must be allowed to create directories in remote-export-dir, edit /etc/exports and run exportfs. Required if ssh-host is set.")
+	sshKey               = flag.String("ssh-key", "", "Path to the private key to log in to ssh-host with, e.g. mounted from a secret. Required if ssh-host is set.")
+	sshKnownHosts        = flag.String("ssh-known-hosts", "", "Path to a known_hosts file to check ssh-host's host key against, e.g. mounted from a secret. Required if ssh-host is set.")
+	remoteExportDir      = flag.String("remote-export-dir", "/export", "The directory on ssh-host to create volumes in. Default /export.")
 )
 
+// version is the provisioner's version, set at build time
+var version = ""
+
+// clientOptions are the options of the API client, set by the kube-api-* flags
+var clientOptions controller.ClientOptions
+
 const (
 	exportDir     = "/export"
 	ganeshaConfig = "/export/vfs.conf"
@@ -58,6 +80,7 @@ const (
 
 func main() {
 	flag.Set("logtostderr", "true")
+	clientOptions.AddFlags(flag.CommandLine)
 	flag.Parse()
 
 	if errs := validateProvisioner(*provisioner, field.NewPath("provisioner")); len(errs) != 0 {
@@ -75,32 +98,80 @@ func main() {
 		glog.Fatalf("Invalid flags specified: custom grace period must be in the range 0-180")
 	}
 
+	if *krb5Keytab != "" && (!*runServer || !*useGanesha) {
+		glog.Fatalf("Invalid flags specified: krb5-keytab can only be set if both run-server and use-ganesha are true.")
+	}
+	if *enableKrb5 && *runServer {
+		glog.Fatalf("Invalid flags specified: enable-krb5 can only be set if run-server is false, set krb5-keytab instead.")
+	}
+
+	versions, err := server.ParseVersions(*nfsVersions)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: %v", err)
+	}
+	protocols := server.Protocols{
+		Versions:   versions,
+		NFSPort:    *nfsPort,
+		MountdPort: *mountdPort,
+		RunRpcbind: *runRpcbind,
+		RunStatd:   *runStatd,
+	}
+	if err := protocols.Validate(); err != nil {
+		glog.Fatalf("Invalid flags specified: %v", err)
+	}
+	if (!*runRpcbind || !*runStatd) && !*runServer {
+		glog.Fatalf("Invalid flags specified: run-rpcbind and run-statd can only be set if run-server is true.")
+	}
+	if (*krb5Keytab != "" || *enableKrb5) && !protocols.Serves(server.Version41) {
+		glog.Fatalf("Invalid flags specified: krb5-keytab and enable-krb5 can only be set if nfs-versions includes 4.1, which Kerberos volumes are mounted with.")
+	}
+
+	if *usageAddress != "" && *usagePeriod == 0 {
+		glog.Fatalf("Invalid flags specified: usage-address can only be set if usage-period is set.")
+	}
+
+	var remote *vol.RemoteServer
+	dir := exportDir
+	if *sshHost != "" {
+		if *runServer || *useGanesha || *enableXfsQuota {
+			glog.Fatalf("Invalid flags specified: ssh-host can only be set if run-server, use-ganesha and enable-xfs-quota are false.")
+		}
+		if *serviceHostname != "" {
+			glog.Fatalf("Invalid flags specified: service-hostname can't be set if ssh-host is set, set server-hostname instead.")
+		}
+		var err error
+		remote, err = vol.NewRemoteServer(*sshHost, *sshUser, *sshKey, *sshKnownHosts)
+		if err != nil {
+			glog.Fatalf("Invalid flags specified: %v", err)
+		}
+		dir = *remoteExportDir
+		glog.Infof("Managing directories in %s & exports on NFS server %s over SSH", dir, *sshHost)
+	}
+
 	// Create the client according to whether we are running in or out-of-cluster
 	outOfCluster := *master != "" || *kubeconfig != ""
 
-	if !outOfCluster && *serverHostname != "" {
+	if !outOfCluster && *serverHostname != "" && remote == nil {
 		glog.Fatalf("Invalid flags specified: if server-hostname is set, either master or kube-config must also be set.")
 	}
+	if outOfCluster && *serviceHostname != "" {
+		glog.Fatalf("Invalid flags specified: service-hostname can only be set if running in-cluster, set server-hostname instead.")
+	}
+	if *annotateService && *serviceHostname == "" {
+		glog.Fatalf("Invalid flags specified: annotate-service can only be set if service-hostname is set.")
+	}
 
 	if *runServer {
 		glog.Infof("Starting NFS server!")
-		err := server.Start(ganeshaConfig, *gracePeriod)
+		err := server.Start(ganeshaConfig, *gracePeriod, *krb5Keytab, protocols)
 		if err != nil {
 			glog.Fatalf("Error starting NFS server: %v", err)
 		}
 	}
 
-	var config *rest.Config
-	var err error
-	if outOfCluster {
-		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
-	} else {
-		config, err = rest.InClusterConfig()
-	}
-	if err != nil {
-		glog.Fatalf("Failed to create config: %v", err)
-	}
-	clientset, err := kubernetes.NewForConfig(config)
+	clientOptions.Master = *master
+	clientOptions.Kubeconfig = *kubeconfig
+	clientset, err := controller.NewClient(clientOptions, *provisioner, version)
 	if err != nil {
 		glog.Fatalf("Failed to create client: %v", err)
 	}
@@ -114,13 +185,37 @@ func main() {
 
 	// Create the provisioner: it implements the Provisioner interface expected by
 	// the controller
-	nfsProvisioner := vol.NewNFSProvisioner(exportDir, clientset, outOfCluster, *useGanesha, ganeshaConfig, *rootSquash, *enableXfsQuota, *serverHostname)
+	nfsProvisioner := vol.NewNFSProvisioner(dir, clientset, outOfCluster, *useGanesha, ganeshaConfig, *rootSquash, *enableXfsQuota, *serverHostname, *enableKrb5 || *krb5Keytab != "", *serviceHostname, *annotateService, *recycle, remote, protocols)
+
+	if *usagePeriod > 0 {
+		usageReporter, err := vol.NewUsageReporter(nfsProvisioner)
+		if err != nil {
+			glog.Fatalf("Error creating usage reporter: %v", err)
+		}
+		go usageReporter.Run(*usagePeriod, wait.NeverStop)
+		if *usageAddress != "" {
+			go serveUsage(*usageAddress, usageReporter)
+		}
+	}
+
+	var options []func(*controller.ProvisionController) error
+	if *metricsAddress != "" {
+		options = append(options, controller.MetricsAddress(*metricsAddress))
+	}
 
 	// Start the provision controller which will dynamically provision NFS PVs
-	pc := controller.NewProvisionController(clientset, 15*time.Second, *provisioner, nfsProvisioner, serverVersion.GitVersion, false, *failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit)
+	pc := controller.NewProvisionController(clientset, 15*time.Second, *provisioner, nfsProvisioner, serverVersion.GitVersion, false, *failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit, options...)
 	pc.Run(wait.NeverStop)
 }
 
+// serveUsage serves the usage report at /usage on address
+func serveUsage(address string, usageReporter *vol.UsageReporter) {
+	mux := http.NewServeMux()
+	mux.Handle("/usage", usageReporter)
+	glog.Infof("Serving usage report at %s/usage", address)
+	glog.Errorf("Error serving usage report: %v", http.ListenAndServe(address, mux))
+}
+
 // validateProvisioner tests if provisioner is a valid qualified name.
 // https://github.com/kubernetes/kubernetes/blob/release-1.4/pkg/apis/storage/validation/validation.go
 func validateProvisioner(provisioner string, fldPath *field.Path) field.ErrorList {
@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultNFSPort is the port NFS is served on unless configured otherwise
+	DefaultNFSPort = 2049
+	// DefaultMountdPort is the port the NFSv3 MOUNT protocol is served on
+	// unless configured otherwise
+	DefaultMountdPort = 20048
+	// RpcbindPort is the port of rpcbind, through which NFSv3 clients find
+	// mountd
+	RpcbindPort = 111
+)
+
+// NFS versions the server can serve
+const (
+	Version3  = "3"
+	Version40 = "4"
+	Version41 = "4.1"
+)
+
+// Protocols are the NFS versions the server serves and the ports it serves
+// them on
+type Protocols struct {
+	// The NFS versions to serve, Version3, Version40 and/or Version41
+	Versions []string
+	// The port to serve NFS on
+	NFSPort int
+	// The port to serve the NFSv3 MOUNT protocol on
+	MountdPort int
+	// Whether to run rpcbind & rpc.statd, which only NFSv3 needs. NFSv4 only
+	// servers can do without them, and so without port 111 open.
+	RunRpcbind bool
+	RunStatd   bool
+}
+
+// DefaultProtocols returns the protocols served unless configured otherwise:
+// NFSv3 and NFSv4.0 on the default ports, with rpcbind & rpc.statd
+func DefaultProtocols() Protocols {
+	return Protocols{
+		Versions:   []string{Version3, Version40},
+		NFSPort:    DefaultNFSPort,
+		MountdPort: DefaultMountdPort,
+		RunRpcbind: true,
+		RunStatd:   true,
+	}
+}
+
+// ParseVersions parses a comma-separated list of NFS versions
+func ParseVersions(value string) ([]string, error) {
+	versions := []string{}
+	seen := map[string]bool{}
+	for _, version := range strings.Split(value, ",") {
+		version = strings.TrimSpace(version)
+		switch version {
+		case Version3, Version40, Version41:
+		default:
+			return nil, fmt.Errorf("invalid NFS version %q in %q. valid versions are %s, %s and %s", version, value, Version3, Version40, Version41)
+		}
+		if !seen[version] {
+			versions = append(versions, version)
+			seen[version] = true
+		}
+	}
+	return versions, nil
+}
+
+// Serves returns whether the protocols include the NFS version
+func (p Protocols) Serves(version string) bool {
+	for _, v := range p.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the protocols can't be served
+func (p Protocols) Validate() error {
+	if len(p.Versions) == 0 {
+		return fmt.Errorf("no NFS versions to serve")
+	}
+	for _, port := range []int{p.NFSPort, p.MountdPort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	if p.Serves(Version3) && (!p.RunRpcbind || !p.RunStatd) {
+		return fmt.Errorf("NFSv3 needs rpcbind and rpc.statd")
+	}
+	return nil
+}
+
+// MountOptions returns the mount options clients need to mount from a server
+// serving the protocols, none if the defaults do: a vers option if the server
+// doesn't serve NFSv3, so clients don't fall back to it, or serves only NFSv3,
+// and port & mountport options for ports other than the defaults.
+func (p Protocols) MountOptions() []string {
+	options := []string{}
+	switch {
+	case !p.Serves(Version3) && p.Serves(Version41):
+		options = append(options, "vers=4.1")
+	case !p.Serves(Version3):
+		options = append(options, "vers=4")
+	case len(p.Versions) == 1:
+		options = append(options, "vers=3")
+	}
+	if p.NFSPort != DefaultNFSPort {
+		options = append(options, "port="+strconv.Itoa(p.NFSPort))
+	}
+	if p.Serves(Version3) && p.MountdPort != DefaultMountdPort {
+		options = append(options, "mountport="+strconv.Itoa(p.MountdPort))
+	}
+	return options
+}
+
+// setProtocols sets the NFS versions and ports of the ganesha config
+func setProtocols(ganeshaConfig string, protocols Protocols) error {
+	nfsProtocols := []string{}
+	minorVersions := []string{}
+	if protocols.Serves(Version3) {
+		nfsProtocols = append(nfsProtocols, "3")
+	}
+	if protocols.Serves(Version40) || protocols.Serves(Version41) {
+		nfsProtocols = append(nfsProtocols, "4")
+	}
+	if protocols.Serves(Version40) {
+		minorVersions = append(minorVersions, "0")
+	}
+	if protocols.Serves(Version41) {
+		minorVersions = append(minorVersions, "1")
+	}
+
+	read, err := ioutil.ReadFile(ganeshaConfig)
+	if err != nil {
+		return err
+	}
+	config := string(read)
+	config = setParam(config, "NFS_Core_Param", "NFS_Protocols", strings.Join(nfsProtocols, ", "))
+	config = setParam(config, "NFS_Core_Param", "NFS_Port", strconv.Itoa(protocols.NFSPort))
+	config = setParam(config, "NFS_Core_Param", "MNT_Port", strconv.Itoa(protocols.MountdPort))
+	if len(minorVersions) > 0 {
+		config = setParam(config, "NFSV4", "Minor_Versions", strings.Join(minorVersions, ", "))
+	}
+	return ioutil.WriteFile(ganeshaConfig, []byte(config), 0)
+}
+
+// setParam sets the param of the block of the ganesha config to value,
+// replacing its line if there is one and adding one, or the whole block,
+// otherwise
+func setParam(config, block, param, value string) string {
+	newLine := param + " = " + value + ";"
+
+	re := regexp.MustCompile(param + " = [^;]*;")
+	if oldLine := re.FindString(config); oldLine != "" {
+		return strings.Replace(config, oldLine, newLine, -1)
+	}
+
+	re = regexp.MustCompile(block + "\n{\n")
+	if start := re.FindString(config); start != "" {
+		return strings.Replace(config, start, start+"\t"+newLine+"\n", 1)
+	}
+	return config + "\n" + block + "\n{\n\t" + newLine + "\n}\n"
+}
@@ -69,24 +69,35 @@ NFSV4
 }
 `)
 
-// Start starts the NFS server. If an error is encountered at any point it returns it instantly
-func Start(ganeshaConfig string, gracePeriod uint) error {
-	// Start rpcbind if it is not started yet
-	cmd := exec.Command("/usr/sbin/rpcinfo", "127.0.0.1")
-	if err := cmd.Run(); err != nil {
-		cmd := exec.Command("/usr/sbin/rpcbind", "-w")
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("Starting rpcbind failed with error: %v, output: %s", err, out)
+// Start starts the NFS server. If an error is encountered at any point it returns it instantly.
+// If krb5Keytab is set, ganesha is configured to accept Kerberos security
+// flavors using the keytab's nfs principal. The server serves the given NFS
+// versions on the given ports, and starts rpcbind & rpc.statd only if told to.
+func Start(ganeshaConfig string, gracePeriod uint, krb5Keytab string, protocols Protocols) error {
+	if err := protocols.Validate(); err != nil {
+		return err
+	}
+
+	if protocols.RunRpcbind {
+		// Start rpcbind if it is not started yet
+		cmd := exec.Command("/usr/sbin/rpcinfo", "127.0.0.1")
+		if err := cmd.Run(); err != nil {
+			cmd := exec.Command("/usr/sbin/rpcbind", "-w")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("Starting rpcbind failed with error: %v, output: %s", err, out)
+			}
 		}
 	}
 
-	cmd = exec.Command("/usr/sbin/rpc.statd")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("rpc.statd failed with error: %v, output: %s", err, out)
+	if protocols.RunStatd {
+		cmd := exec.Command("/usr/sbin/rpc.statd")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("rpc.statd failed with error: %v, output: %s", err, out)
+		}
 	}
 
 	// Start dbus, needed for ganesha dynamic exports
-	cmd = exec.Command("dbus-daemon", "--system")
+	cmd := exec.Command("dbus-daemon", "--system")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("dbus-daemon failed with error: %v, output: %s", err, out)
 	}
@@ -106,6 +117,19 @@ func Start(ganeshaConfig string, gracePeriod uint) error {
 	if err != nil {
 		return fmt.Errorf("error setting fsid device to ganesha config: %v", err)
 	}
+	err = setProtocols(ganeshaConfig, protocols)
+	if err != nil {
+		return fmt.Errorf("error setting protocols to ganesha config: %v", err)
+	}
+	if krb5Keytab != "" {
+		if _, err := os.Stat(krb5Keytab); err != nil {
+			return fmt.Errorf("error reading krb5 keytab %s: %v", krb5Keytab, err)
+		}
+		err = setKrb5(ganeshaConfig, krb5Keytab)
+		if err != nil {
+			return fmt.Errorf("error setting krb5 to ganesha config: %v", err)
+		}
+	}
 	// Start ganesha.nfsd
 	cmd = exec.Command("ganesha.nfsd", "-L", "/var/log/ganesha.log", "-f", ganeshaConfig)
 	if out, err := cmd.CombinedOutput(); err != nil {
@@ -129,11 +153,11 @@ func setFsidDevice(ganeshaConfig string, fsidDevice bool) error {
 
 	if oldLine == nil {
 		// fsid_device line not there, append it after MNT_Port
-		re := regexp.MustCompile("MNT_Port = 20048;")
+		re := regexp.MustCompile("MNT_Port = [0-9]+;")
 
 		mntPort := re.Find(read)
 
-		block := "MNT_Port = 20048;\n" +
+		block := string(mntPort) + "\n" +
 			"\t" + newLine
 
 		replaced := strings.Replace(string(read), string(mntPort), block, -1)
@@ -197,6 +221,46 @@ func setGracePeriod(ganeshaConfig string, gracePeriod uint) error {
 	return nil
 }
 
+func setKrb5(ganeshaConfig string, krb5Keytab string) error {
+	newBlock := "NFS_KRB5\n{\n" +
+		"\tPrincipalName = nfs;\n" +
+		"\tKeytabPath = " + krb5Keytab + ";\n" +
+		"\tActive_krb5 = true;\n" +
+		"}\n"
+
+	re := regexp.MustCompile("NFS_KRB5\n{[^}]*}\n")
+
+	read, err := ioutil.ReadFile(ganeshaConfig)
+	if err != nil {
+		return err
+	}
+
+	oldBlock := re.Find(read)
+
+	if oldBlock == nil {
+		// NFS_KRB5 block not there, append it
+		file, err := os.OpenFile(ganeshaConfig, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err = file.WriteString("\n" + newBlock); err != nil {
+			return err
+		}
+		file.Sync()
+	} else {
+		// NFS_KRB5 block there, just replace it
+		replaced := strings.Replace(string(read), string(oldBlock), newBlock, -1)
+		err = ioutil.WriteFile(ganeshaConfig, []byte(replaced), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Stop stops the NFS server.
 func Stop() {
 	// /bin/dbus-send --system   --dest=org.ganesha.nfsd --type=method_call /org/ganesha/nfsd/admin org.ganesha.nfsd.admin.shutdown
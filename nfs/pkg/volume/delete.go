@@ -70,6 +70,9 @@ func (p *nfsProvisioner) provisioned(volume *v1.PersistentVolume) (bool, error)
 
 func (p *nfsProvisioner) deleteDirectory(volume *v1.PersistentVolume) error {
 	path := path.Join(p.exportDir, volume.ObjectMeta.Name)
+	if p.remote != nil {
+		return p.remote.removeAll(path)
+	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil
 	}
@@ -18,10 +18,12 @@ package volume
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/golang/glog"
@@ -30,14 +32,39 @@ import (
 )
 
 type exporter interface {
-	AddExportBlock(string) (string, uint16, error)
+	AddExportBlock(string, exportOptions) (string, uint16, error)
 	RemoveExportBlock(string, uint16) error
+	RestoreExportBlock(string, uint16) (bool, error)
 	Export(string) error
 	Unexport(*v1.PersistentVolume) error
 }
 
 type exportBlockCreator interface {
-	CreateExportBlock(string, string) string
+	CreateExportBlock(string, string, exportOptions) string
+}
+
+// exportOptions are the options of an export that a class may set
+type exportOptions struct {
+	// The security flavors to export with
+	sec []string
+	// The CIDRs or IPs of the clients allowed to mount the export, all if empty
+	clients []string
+	// Whether to export read-only
+	readOnly bool
+	// Whether to squash root, nil to leave it to the exporter's default
+	rootSquash *bool
+	// The uid & gid to map squashed users to, empty to leave them to the NFS
+	// server's default
+	anonUID string
+	anonGID string
+}
+
+// squashRoot returns whether to squash root, given the exporter's default
+func (o exportOptions) squashRoot(defaultRootSquash bool) bool {
+	if o.rootSquash != nil {
+		return *o.rootSquash
+	}
+	return defaultRootSquash
 }
 
 type genericExporter struct {
@@ -71,11 +98,11 @@ func newGenericExporter(ebc exportBlockCreator, config string, re *regexp.Regexp
 	}
 }
 
-func (e *genericExporter) AddExportBlock(path string) (string, uint16, error) {
+func (e *genericExporter) AddExportBlock(path string, opts exportOptions) (string, uint16, error) {
 	exportID := generateID(e.mapMutex, e.exportIDs)
 	exportIDStr := strconv.FormatUint(uint64(exportID), 10)
 
-	block := e.ebc.CreateExportBlock(exportIDStr, path)
+	block := e.ebc.CreateExportBlock(exportIDStr, path, opts)
 
 	// Add the export block to the config file
 	if err := addToFile(e.fileMutex, e.config, block); err != nil {
@@ -90,6 +117,30 @@ func (e *genericExporter) RemoveExportBlock(block string, exportID uint16) error
 	return removeFromFile(e.fileMutex, e.config, block)
 }
 
+// RestoreExportBlock adds a block created by an earlier AddExportBlock back to
+// the config file if it is missing from there and reserves its exportID. It
+// returns whether the block was missing and so needs to be exported again.
+func (e *genericExporter) RestoreExportBlock(block string, exportID uint16) (bool, error) {
+	e.mapMutex.Lock()
+	e.exportIDs[exportID] = true
+	e.mapMutex.Unlock()
+
+	e.fileMutex.Lock()
+	read, err := ioutil.ReadFile(e.config)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return false, fmt.Errorf("error reading config %s: %v", e.config, err)
+	}
+	if strings.Contains(string(read), block) {
+		return false, nil
+	}
+
+	if err := addToFile(e.fileMutex, e.config, block); err != nil {
+		return false, fmt.Errorf("error adding export block %s to config %s: %v", block, e.config, err)
+	}
+	return true, nil
+}
+
 type ganeshaExporter struct {
 	genericExporter
 }
@@ -148,20 +199,41 @@ type ganeshaExportBlockCreator struct {
 var _ exportBlockCreator = &ganeshaExportBlockCreator{}
 
 // CreateBlock creates the text block to add to the ganesha config file.
-func (e *ganeshaExportBlockCreator) CreateExportBlock(exportID, path string) string {
+func (e *ganeshaExportBlockCreator) CreateExportBlock(exportID, path string, opts exportOptions) string {
 	squash := "no_root_squash"
-	if e.rootSquash {
+	if opts.squashRoot(e.rootSquash) {
 		squash = "root_id_squash"
 	}
-	return "\nEXPORT\n{\n" +
+	access := "RW"
+	if opts.readOnly {
+		access = "RO"
+	}
+	exportAccess := access
+	if len(opts.clients) > 0 {
+		// Only the clients in the CLIENT block get access
+		exportAccess = "None"
+	}
+	block := "\nEXPORT\n{\n" +
 		"\tExport_Id = " + exportID + ";\n" +
 		"\tPath = " + path + ";\n" +
 		"\tPseudo = " + path + ";\n" +
-		"\tAccess_Type = RW;\n" +
+		"\tAccess_Type = " + exportAccess + ";\n" +
 		"\tSquash = " + squash + ";\n" +
-		"\tSecType = sys;\n" +
-		"\tFilesystem_id = " + exportID + "." + exportID + ";\n" +
-		"\tFSAL {\n\t\tName = VFS;\n\t}\n}\n"
+		"\tSecType = " + strings.Join(opts.sec, ",") + ";\n" +
+		"\tFilesystem_id = " + exportID + "." + exportID + ";\n"
+	if opts.anonUID != "" {
+		block += "\tAnonymous_uid = " + opts.anonUID + ";\n"
+	}
+	if opts.anonGID != "" {
+		block += "\tAnonymous_gid = " + opts.anonGID + ";\n"
+	}
+	if len(opts.clients) > 0 {
+		block += "\tCLIENT {\n" +
+			"\t\tClients = " + strings.Join(opts.clients, ", ") + ";\n" +
+			"\t\tAccess_Type = " + access + ";\n" +
+			"\t}\n"
+	}
+	return block + "\tFSAL {\n\t\tName = VFS;\n\t}\n}\n"
 }
 
 type kernelExporter struct {
@@ -207,10 +279,29 @@ type kernelExportBlockCreator struct {
 var _ exportBlockCreator = &kernelExportBlockCreator{}
 
 // CreateBlock creates the text block to add to the /etc/exports file.
-func (e *kernelExportBlockCreator) CreateExportBlock(exportID, path string) string {
+func (e *kernelExportBlockCreator) CreateExportBlock(exportID, path string, opts exportOptions) string {
 	squash := "no_root_squash"
-	if e.rootSquash {
+	if opts.squashRoot(e.rootSquash) {
 		squash = "root_squash"
 	}
-	return "\n" + path + " *(rw,insecure," + squash + ",fsid=" + exportID + ")\n"
+	access := "rw"
+	if opts.readOnly {
+		access = "ro"
+	}
+	options := access + ",insecure," + squash + ",sec=" + strings.Join(opts.sec, ":") + ",fsid=" + exportID
+	if opts.anonUID != "" {
+		options += ",anonuid=" + opts.anonUID
+	}
+	if opts.anonGID != "" {
+		options += ",anongid=" + opts.anonGID
+	}
+	clients := opts.clients
+	if len(clients) == 0 {
+		clients = []string{"*"}
+	}
+	block := "\n" + path
+	for _, client := range clients {
+		block += " " + client + "(" + options + ")"
+	}
+	return block + "\n"
 }
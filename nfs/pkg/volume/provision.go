@@ -19,6 +19,7 @@ package volume
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api/resource"
 	"k8s.io/client-go/pkg/api/v1"
@@ -65,6 +67,14 @@ const (
 	// A PV annotation for the identity of the nfsProvisioner that provisioned it
 	annProvisionerID = "Provisioner_Id"
 
+	// A PV annotation for the options kubelet should mount the PV with.
+	// Honoured by Kubernetes 1.6+.
+	annMountOptions = "volume.beta.kubernetes.io/mount-options"
+
+	// A Service annotation ExternalDNS publishes a DNS record of the service's
+	// address for
+	annExternalDNSHostname = "external-dns.alpha.kubernetes.io/hostname"
+
 	podIPEnv     = "POD_IP"
 	serviceEnv   = "SERVICE_NAME"
 	namespaceEnv = "POD_NAMESPACE"
@@ -72,8 +82,23 @@ const (
 )
 
 // NewNFSProvisioner creates a Provisioner that provisions NFS PVs backed by
-// the given directory.
-func NewNFSProvisioner(exportDir string, client kubernetes.Interface, outOfCluster bool, useGanesha bool, ganeshaConfig string, rootSquash bool, enableXfsQuota bool, serverHostname string) controller.Provisioner {
+// the given directory. If serviceHostname is set, PVs get it rather than the
+// service cluster IP as their server and, if annotateService is set, the
+// provisioner annotates its service for ExternalDNS to publish the hostname.
+// If remote is set, the directory is on the remote server, where the
+// provisioner manages directories and exports over SSH instead of locally.
+// PVs get the mount options the NFS server's protocols need.
+func NewNFSProvisioner(exportDir string, client kubernetes.Interface, outOfCluster bool, useGanesha bool, ganeshaConfig string, rootSquash bool, enableXfsQuota bool, serverHostname string, enableKrb5 bool, serviceHostname string, annotateService bool, recycle bool, remote *RemoteServer, protocols server.Protocols) controller.Provisioner {
+	if remote != nil {
+		provisioner := newRemoteNFSProvisionerInternal(exportDir, client, remote, newRemoteExporter(remote, rootSquash), serverHostname, enableKrb5)
+		provisioner.recycle = recycle
+		provisioner.protocols = protocols
+		if err := provisioner.recoverExports(); err != nil {
+			glog.Errorf("Error recovering exports, volumes whose exports are missing from the config will be unavailable: %v", err)
+		}
+		return provisioner
+	}
+
 	var exporter exporter
 	if useGanesha {
 		exporter = newGaneshaExporter(ganeshaConfig, rootSquash)
@@ -90,10 +115,18 @@ func NewNFSProvisioner(exportDir string, client kubernetes.Interface, outOfClust
 	} else {
 		quotaer = newDummyQuotaer()
 	}
-	return newNFSProvisionerInternal(exportDir, client, outOfCluster, exporter, quotaer, serverHostname)
+	provisioner := newNFSProvisionerInternal(exportDir, client, outOfCluster, exporter, quotaer, serverHostname, enableKrb5)
+	provisioner.serviceHostname = serviceHostname
+	provisioner.annotateService = annotateService
+	provisioner.recycle = recycle
+	provisioner.protocols = protocols
+	if err := provisioner.recoverExports(); err != nil {
+		glog.Errorf("Error recovering exports, volumes whose exports are missing from the config will be unavailable: %v", err)
+	}
+	return provisioner
 }
 
-func newNFSProvisionerInternal(exportDir string, client kubernetes.Interface, outOfCluster bool, exporter exporter, quotaer quotaer, serverHostname string) *nfsProvisioner {
+func newNFSProvisionerInternal(exportDir string, client kubernetes.Interface, outOfCluster bool, exporter exporter, quotaer quotaer, serverHostname string, enableKrb5 bool) *nfsProvisioner {
 	if _, err := os.Stat(exportDir); os.IsNotExist(err) {
 		glog.Fatalf("exportDir %s does not exist!", exportDir)
 	}
@@ -121,7 +154,9 @@ func newNFSProvisionerInternal(exportDir string, client kubernetes.Interface, ou
 		exporter:       exporter,
 		quotaer:        quotaer,
 		serverHostname: serverHostname,
+		enableKrb5:     enableKrb5,
 		identity:       identity,
+		protocols:      server.DefaultProtocols(),
 		podIPEnv:       podIPEnv,
 		serviceEnv:     serviceEnv,
 		namespaceEnv:   namespaceEnv,
@@ -153,10 +188,31 @@ type nfsProvisioner struct {
 	// running as a Docker container
 	serverHostname string
 
+	// The hostname to put as the server of provisioned PVs instead of the
+	// service cluster IP, e.g. one published by ExternalDNS, so PVs stay
+	// mountable when the service is recreated with a different IP. Only
+	// applicable when using a service
+	serviceHostname string
+
+	// Whether to annotate the service with serviceHostname for ExternalDNS
+	annotateService bool
+
+	// Whether to scrub released volumes and keep them for new claims instead
+	// of deleting them
+	recycle bool
+
+	// Whether the NFS server is set up for Kerberos, i.e. whether classes may
+	// ask for krb5, krb5i & krb5p security flavors
+	enableKrb5 bool
+
 	// Identity of this nfsProvisioner, generated & persisted to exportDir or
 	// recovered from there. Used to mark provisioned PVs
 	identity types.UID
 
+	// The NFS versions & ports the NFS server serves, which determine the
+	// mount options of provisioned PVs and the ports the service must have
+	protocols server.Protocols
+
 	// Environment variables the provisioner pod needs valid values for in order to
 	// put a service cluster IP as the server of provisioned NFS PVs, passed in
 	// via downward API. If serviceEnv is set, namespaceEnv must be too.
@@ -164,6 +220,10 @@ type nfsProvisioner struct {
 	serviceEnv   string
 	namespaceEnv string
 	nodeEnv      string
+
+	// The server to manage directories & exports on over SSH in export-manager
+	// mode, exportDir being a directory on it. Nil if they're managed locally
+	remote *RemoteServer
 }
 
 var _ controller.Provisioner = &nfsProvisioner{}
@@ -171,21 +231,24 @@ var _ controller.Provisioner = &nfsProvisioner{}
 // Provision creates a volume i.e. the storage asset and returns a PV object for
 // the volume.
 func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
-	server, path, supGroup, exportBlock, exportID, projectBlock, projectID, err := p.createVolume(options)
+	volume, err := p.createVolume(options)
 	if err != nil {
 		return nil, err
 	}
 
 	annotations := make(map[string]string)
 	annotations[annCreatedBy] = createdBy
-	annotations[annExportBlock] = exportBlock
-	annotations[annExportID] = strconv.FormatUint(uint64(exportID), 10)
-	annotations[annProjectBlock] = projectBlock
-	annotations[annProjectID] = strconv.FormatUint(uint64(projectID), 10)
-	if supGroup != 0 {
-		annotations[VolumeGidAnnotationKey] = strconv.FormatUint(supGroup, 10)
+	annotations[annExportBlock] = volume.exportBlock
+	annotations[annExportID] = strconv.FormatUint(uint64(volume.exportID), 10)
+	annotations[annProjectBlock] = volume.projectBlock
+	annotations[annProjectID] = strconv.FormatUint(uint64(volume.projectID), 10)
+	if volume.supGroup != 0 {
+		annotations[VolumeGidAnnotationKey] = strconv.FormatUint(volume.supGroup, 10)
 	}
 	annotations[annProvisionerID] = string(p.identity)
+	if mountOptions := p.getMountOptions(volume.exportOptions.sec); mountOptions != "" {
+		annotations[annMountOptions] = mountOptions
+	}
 
 	pv := &v1.PersistentVolume{
 		ObjectMeta: v1.ObjectMeta{
@@ -201,9 +264,9 @@ func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 			},
 			PersistentVolumeSource: v1.PersistentVolumeSource{
 				NFS: &v1.NFSVolumeSource{
-					Server:   server,
-					Path:     path,
-					ReadOnly: false,
+					Server:   volume.server,
+					Path:     volume.path,
+					ReadOnly: volume.exportOptions.readOnly,
 				},
 			},
 		},
@@ -212,46 +275,77 @@ func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 	return pv, nil
 }
 
+// volume is the storage asset created by createVolume, described by what
+// Provision needs to put in its PV
+type volume struct {
+	// The server IP & path to mount
+	server string
+	path   string
+	// A zero/non-zero supplemental group
+	supGroup uint64
+	// The block added to either the ganesha config or /etc/exports, and its
+	// exportID
+	exportBlock string
+	exportID    uint16
+	// The block added to the xfs projects file, and its projectID
+	projectBlock string
+	projectID    uint16
+	// The options the volume is exported with
+	exportOptions exportOptions
+}
+
 // createVolume creates a volume i.e. the storage asset. It creates a unique
-// directory under /export and exports it. Returns the server IP, the path, a
-// zero/non-zero supplemental group, the block it added to either the ganesha
-// config or /etc/exports, and the exportID
-// TODO return values
-func (p *nfsProvisioner) createVolume(options controller.VolumeOptions) (string, string, uint64, string, uint16, string, uint16, error) {
-	gid, err := p.validateOptions(options)
+// directory under /export and exports it.
+func (p *nfsProvisioner) createVolume(options controller.VolumeOptions) (*volume, error) {
+	gid, exportOptions, err := p.validateOptions(options)
 	if err != nil {
-		return "", "", 0, "", 0, "", 0, fmt.Errorf("error validating options for volume: %v", err)
+		// Only an edit of the claim or its class can fix these
+		return nil, &controller.TerminalError{Reason: fmt.Sprintf("error validating options for volume: %v", err)}
+	}
+	// Space may be freed, and getting it may fail for a reason that passes
+	if err := p.checkAvailableSpace(options); err != nil {
+		return nil, fmt.Errorf("error checking available space for volume: %v", err)
 	}
 
 	server, err := p.getServer()
 	if err != nil {
-		return "", "", 0, "", 0, "", 0, fmt.Errorf("error getting NFS server IP for volume: %v", err)
+		return nil, fmt.Errorf("error getting NFS server IP for volume: %v", err)
 	}
 
 	path := path.Join(p.exportDir, options.PVName)
 
 	err = p.createDirectory(options.PVName, gid)
 	if err != nil {
-		return "", "", 0, "", 0, "", 0, fmt.Errorf("error creating directory for volume: %v", err)
+		return nil, fmt.Errorf("error creating directory for volume: %v", err)
 	}
 
-	exportBlock, exportID, err := p.createExport(options.PVName)
+	exportBlock, exportID, err := p.createExport(options.PVName, exportOptions)
 	if err != nil {
-		os.RemoveAll(path)
-		return "", "", 0, "", 0, "", 0, fmt.Errorf("error creating export for volume: %v", err)
+		p.removeAll(path)
+		return nil, fmt.Errorf("error creating export for volume: %v", err)
 	}
 
 	projectBlock, projectID, err := p.createQuota(options.PVName, options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)])
 	if err != nil {
-		os.RemoveAll(path)
-		return "", "", 0, "", 0, "", 0, fmt.Errorf("error creating quota for volume: %v", err)
-	}
-
-	return server, path, 0, exportBlock, exportID, projectBlock, projectID, nil
+		p.removeAll(path)
+		return nil, fmt.Errorf("error creating quota for volume: %v", err)
+	}
+
+	return &volume{
+		server:        server,
+		path:          path,
+		supGroup:      0,
+		exportBlock:   exportBlock,
+		exportID:      exportID,
+		projectBlock:  projectBlock,
+		projectID:     projectID,
+		exportOptions: exportOptions,
+	}, nil
 }
 
-func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (string, error) {
+func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (string, exportOptions, error) {
 	gid := "none"
+	opts := exportOptions{sec: []string{"sys"}}
 	for k, v := range options.Parameters {
 		switch strings.ToLower(k) {
 		case "gid":
@@ -260,10 +354,44 @@ func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (stri
 			} else if i, err := strconv.ParseUint(v, 10, 64); err == nil && i != 0 {
 				gid = v
 			} else {
-				return "", fmt.Errorf("invalid value for parameter gid: %v. valid values are: 'none' or a non-zero integer", v)
+				return "", exportOptions{}, fmt.Errorf("invalid value for parameter gid: %v. valid values are: 'none' or a non-zero integer", v)
+			}
+		case "sec":
+			var err error
+			opts.sec, err = p.parseSec(v)
+			if err != nil {
+				return "", exportOptions{}, err
+			}
+		case "clients":
+			var err error
+			opts.clients, err = parseClients(v)
+			if err != nil {
+				return "", exportOptions{}, err
+			}
+		case "readonly":
+			readOnly, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", exportOptions{}, fmt.Errorf("invalid value for parameter readOnly: %v. valid values are: 'true' or 'false'", v)
+			}
+			opts.readOnly = readOnly
+		case "rootsquash":
+			rootSquash, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", exportOptions{}, fmt.Errorf("invalid value for parameter rootSquash: %v. valid values are: 'true' or 'false'", v)
+			}
+			opts.rootSquash = &rootSquash
+		case "anonuid":
+			if _, err := strconv.ParseUint(v, 10, 32); err != nil {
+				return "", exportOptions{}, fmt.Errorf("invalid value for parameter anonUID: %v. valid values are non-negative integers", v)
+			}
+			opts.anonUID = v
+		case "anongid":
+			if _, err := strconv.ParseUint(v, 10, 32); err != nil {
+				return "", exportOptions{}, fmt.Errorf("invalid value for parameter anonGID: %v. valid values are non-negative integers", v)
 			}
+			opts.anonGID = v
 		default:
-			return "", fmt.Errorf("invalid parameter: %q", k)
+			return "", exportOptions{}, fmt.Errorf("invalid parameter: %q", k)
 		}
 	}
 
@@ -271,25 +399,106 @@ func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (stri
 	// pv.Labels MUST be set to match claim.spec.selector
 	// gid selector? with or without pv annotation?
 	if options.PVC.Spec.Selector != nil {
-		return "", fmt.Errorf("claim.Spec.Selector is not supported")
+		return "", exportOptions{}, fmt.Errorf("claim.Spec.Selector is not supported")
 	}
 
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(p.exportDir, &stat); err != nil {
-		return "", fmt.Errorf("error calling statfs on %v: %v", p.exportDir, err)
+	return gid, opts, nil
+}
+
+// checkAvailableSpace returns an error if exportDir hasn't the space the claim
+// requests available
+func (p *nfsProvisioner) checkAvailableSpace(options controller.VolumeOptions) error {
+	available, err := p.availableSpace()
+	if err != nil {
+		return err
 	}
 	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
 	requestBytes := capacity.Value()
-	available := int64(stat.Bavail) * int64(stat.Bsize)
 	if requestBytes > available {
-		return "", fmt.Errorf("insufficient available space %v bytes to satisfy claim for %v bytes", available, requestBytes)
+		return fmt.Errorf("insufficient available space %v bytes to satisfy claim for %v bytes", available, requestBytes)
 	}
+	return nil
+}
 
-	return gid, nil
+// availableSpace returns the bytes available in exportDir
+func (p *nfsProvisioner) availableSpace() (int64, error) {
+	if p.remote != nil {
+		available, err := p.remote.availableSpace(p.exportDir)
+		if err != nil {
+			return 0, fmt.Errorf("error getting available space of %v on %s: %v", p.exportDir, p.remote.host, err)
+		}
+		return available, nil
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(p.exportDir, &stat); err != nil {
+		return 0, fmt.Errorf("error calling statfs on %v: %v", p.exportDir, err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// parseSec parses the sec parameter, a colon-separated list of security
+// flavors like the kernel export option of the same name.
+func (p *nfsProvisioner) parseSec(value string) ([]string, error) {
+	sec := []string{}
+	for _, flavor := range strings.Split(value, ":") {
+		flavor = strings.ToLower(strings.TrimSpace(flavor))
+		switch flavor {
+		case "sys":
+		case "krb5", "krb5i", "krb5p":
+			if !p.enableKrb5 {
+				return nil, fmt.Errorf("invalid value for parameter sec: %v. security flavor %s requires the provisioner to be set up for Kerberos", value, flavor)
+			}
+		default:
+			return nil, fmt.Errorf("invalid value for parameter sec: %v. valid values are colon-separated lists of 'sys', 'krb5', 'krb5i' and 'krb5p'", value)
+		}
+		sec = append(sec, flavor)
+	}
+	return sec, nil
+}
+
+// parseClients parses the clients parameter, a comma-separated list of the
+// CIDRs or IPs of the clients allowed to mount. Anything else is rejected, not
+// least because it would end up in the NFS server's config.
+func parseClients(value string) ([]string, error) {
+	clients := []string{}
+	for _, client := range strings.Split(value, ",") {
+		client = strings.TrimSpace(client)
+		if _, _, err := net.ParseCIDR(client); err != nil && net.ParseIP(client) == nil {
+			return nil, fmt.Errorf("invalid value for parameter clients: %v. valid values are comma-separated lists of CIDRs and IPs", value)
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// getMountOptions returns the options a volume exported with the given
+// security flavors must be mounted with, or "" if it can be mounted with the
+// defaults: those the server's protocols need and, for Kerberos flavors,
+// vers=4.1 and the flavor. Kerberos flavors need NFSv4.1 to be mounted from
+// the pseudo filesystem.
+func (p *nfsProvisioner) getMountOptions(sec []string) string {
+	options := p.protocols.MountOptions()
+	if len(sec) == 0 || sec[0] == "sys" {
+		return strings.Join(options, ",")
+	}
+	krb5Options := []string{"vers=4.1"}
+	for _, option := range options {
+		if !strings.HasPrefix(option, "vers=") && !strings.HasPrefix(option, "mountport=") {
+			krb5Options = append(krb5Options, option)
+		}
+	}
+	return strings.Join(append(krb5Options, "sec="+sec[0]), ",")
 }
 
 // getServer gets the server IP to put in a provisioned PV's spec.
 func (p *nfsProvisioner) getServer() (string, error) {
+	if p.remote != nil {
+		if p.serverHostname != "" {
+			return p.serverHostname, nil
+		}
+		return p.remote.host, nil
+	}
+
 	if p.outOfCluster {
 		if p.serverHostname != "" {
 			return p.serverHostname, nil
@@ -340,10 +549,12 @@ func (p *nfsProvisioner) getServer() (string, error) {
 		protocol v1.Protocol
 	}
 	expectedPorts := map[endpointPort]bool{
-		endpointPort{2049, v1.ProtocolTCP}:  true,
-		endpointPort{20048, v1.ProtocolTCP}: true,
-		endpointPort{111, v1.ProtocolUDP}:   true,
-		endpointPort{111, v1.ProtocolTCP}:   true,
+		endpointPort{int32(p.protocols.NFSPort), v1.ProtocolTCP}: true,
+	}
+	if p.protocols.Serves(server.Version3) {
+		expectedPorts[endpointPort{int32(p.protocols.MountdPort), v1.ProtocolTCP}] = true
+		expectedPorts[endpointPort{server.RpcbindPort, v1.ProtocolUDP}] = true
+		expectedPorts[endpointPort{server.RpcbindPort, v1.ProtocolTCP}] = true
 	}
 	endpoints, err := p.client.Core().Endpoints(namespace).Get(serviceName)
 	for _, subset := range endpoints.Subsets {
@@ -370,15 +581,43 @@ func (p *nfsProvisioner) getServer() (string, error) {
 		return "", fmt.Errorf("service %s=%s is valid but it doesn't have a cluster IP", p.serviceEnv, serviceName)
 	}
 
+	if p.serviceHostname != "" {
+		if p.annotateService && service.Annotations[annExternalDNSHostname] != p.serviceHostname {
+			if err := p.annotateServiceHostname(service); err != nil {
+				return "", err
+			}
+		}
+		glog.Infof("using service %s=%s hostname %s as NFS server IP", p.serviceEnv, serviceName, p.serviceHostname)
+		return p.serviceHostname, nil
+	}
+
 	glog.Infof("using service %s=%s cluster IP %s as NFS server IP", p.serviceEnv, serviceName, service.Spec.ClusterIP)
 	return service.Spec.ClusterIP, nil
 }
 
+// annotateServiceHostname sets the service's ExternalDNS hostname annotation
+// to serviceHostname, so ExternalDNS keeps the hostname pointing at the
+// service whatever its address
+func (p *nfsProvisioner) annotateServiceHostname(service *v1.Service) error {
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[annExternalDNSHostname] = p.serviceHostname
+	if _, err := p.client.Core().Services(service.Namespace).Update(service); err != nil {
+		return fmt.Errorf("error annotating service %s/%s with hostname %s: %v", service.Namespace, service.Name, p.serviceHostname, err)
+	}
+	glog.Infof("annotated service %s/%s with %s=%s", service.Namespace, service.Name, annExternalDNSHostname, p.serviceHostname)
+	return nil
+}
+
 // createDirectory creates the given directory in exportDir with appropriate
 // permissions and ownership according to the given gid parameter string.
 func (p *nfsProvisioner) createDirectory(directory, gid string) error {
 	// TODO quotas
 	path := path.Join(p.exportDir, directory)
+	if p.remote != nil {
+		return p.remote.createDirectory(path, gid)
+	}
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		return fmt.Errorf("the path already exists")
 	}
@@ -414,10 +653,10 @@ func (p *nfsProvisioner) createDirectory(directory, gid string) error {
 
 // createExport creates the export by adding a block to the appropriate config
 // file and exporting it
-func (p *nfsProvisioner) createExport(directory string) (string, uint16, error) {
+func (p *nfsProvisioner) createExport(directory string, opts exportOptions) (string, uint16, error) {
 	path := path.Join(p.exportDir, directory)
 
-	block, exportID, err := p.exporter.AddExportBlock(path)
+	block, exportID, err := p.exporter.AddExportBlock(path, opts)
 	if err != nil {
 		return "", 0, fmt.Errorf("error adding export block for path %s: %v", path, err)
 	}
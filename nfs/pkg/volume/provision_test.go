@@ -17,9 +17,12 @@ limitations under the License.
 package volume
 
 import (
+	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"path"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -29,6 +32,7 @@ import (
 	"testing"
 
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api/resource"
 	"k8s.io/client-go/pkg/api/unversioned"
@@ -51,6 +55,7 @@ func TestCreateVolume(t *testing.T) {
 		expectedBlock    string
 		expectedExportID uint16
 		expectError      bool
+		expectTerminal   bool
 	}{
 		{
 			name: "succeed creating volume",
@@ -99,6 +104,24 @@ func TestCreateVolume(t *testing.T) {
 			expectedBlock:    "",
 			expectedExportID: 0,
 			expectError:      true,
+			expectTerminal:   true,
+		},
+		{
+			name: "insufficient space",
+			options: controller.VolumeOptions{
+				PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+				PVName:     "pvc-3",
+				PVC:        newClaim(resource.MustParse("1Ei"), []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany}, nil),
+				Parameters: map[string]string{},
+			},
+			envKey:           podIPEnv,
+			expectedServer:   "",
+			expectedPath:     "",
+			expectedGroup:    0,
+			expectedBlock:    "",
+			expectedExportID: 0,
+			expectError:      true,
+			expectTerminal:   false,
 		},
 		{
 			name: "bad server",
@@ -156,18 +179,24 @@ func TestCreateVolume(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error creating file %s: %v", conf, err)
 	}
-	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{config: conf}, newDummyQuotaer(), "")
+	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{config: conf}, newDummyQuotaer(), "", false)
 
 	for _, test := range tests {
 		os.Setenv(test.envKey, "1.1.1.1")
 
-		server, path, supGroup, block, exportID, _, _, err := p.createVolume(test.options)
+		volume, err := p.createVolume(test.options)
 
-		evaluate(t, test.name, test.expectError, err, test.expectedServer, server, "server")
-		evaluate(t, test.name, test.expectError, err, test.expectedPath, path, "path")
-		evaluate(t, test.name, test.expectError, err, test.expectedGroup, supGroup, "group")
-		evaluate(t, test.name, test.expectError, err, test.expectedBlock, block, "block")
-		evaluate(t, test.name, test.expectError, err, test.expectedExportID, exportID, "export id")
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, volume == nil, "nil volume")
+			_, terminal := err.(*controller.TerminalError)
+			evaluate(t, test.name, true, err, test.expectTerminal, terminal, "terminal error")
+		} else {
+			evaluate(t, test.name, false, err, test.expectedServer, volume.server, "server")
+			evaluate(t, test.name, false, err, test.expectedPath, volume.path, "path")
+			evaluate(t, test.name, false, err, test.expectedGroup, volume.supGroup, "group")
+			evaluate(t, test.name, false, err, test.expectedBlock, volume.exportBlock, "block")
+			evaluate(t, test.name, false, err, test.expectedExportID, volume.exportID, "export id")
+		}
 
 		os.Unsetenv(test.envKey)
 	}
@@ -177,11 +206,17 @@ func TestValidateOptions(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
 
+	noRootSquash := false
+
 	tests := []struct {
 		name        string
 		options     controller.VolumeOptions
+		enableKrb5  bool
 		expectedGid string
-		expectError bool
+		expectedSec []string
+		// If set, compared with the export options but for sec
+		expectedOptions *exportOptions
+		expectError     bool
 	}{
 		{
 			name: "empty parameters",
@@ -210,6 +245,58 @@ func TestValidateOptions(t *testing.T) {
 			expectedGid: "1",
 			expectError: false,
 		},
+		{
+			name: "sec parameter value krb5",
+			options: controller.VolumeOptions{
+				Parameters: map[string]string{"sec": "krb5p:krb5i"},
+				PVC:        newClaim(resource.MustParse("1Ki"), nil, nil),
+			},
+			enableKrb5:  true,
+			expectedGid: "none",
+			expectedSec: []string{"krb5p", "krb5i"},
+			expectError: false,
+		},
+		{
+			name:        "sec parameter value krb5 without kerberos",
+			options:     controller.VolumeOptions{Parameters: map[string]string{"sec": "krb5"}},
+			expectedGid: "",
+			expectError: true,
+		},
+		{
+			name:        "bad sec parameter value",
+			options:     controller.VolumeOptions{Parameters: map[string]string{"sec": "sys:foo"}},
+			enableKrb5:  true,
+			expectedGid: "",
+			expectError: true,
+		},
+		{
+			name: "export option parameters",
+			options: controller.VolumeOptions{
+				Parameters: map[string]string{"clients": "10.0.0.0/8, 192.168.0.1", "readOnly": "true", "rootSquash": "false", "anonUID": "65534", "anonGID": "65534"},
+				PVC:        newClaim(resource.MustParse("1Ki"), nil, nil),
+			},
+			expectedGid:     "none",
+			expectedOptions: &exportOptions{clients: []string{"10.0.0.0/8", "192.168.0.1"}, readOnly: true, rootSquash: &noRootSquash, anonUID: "65534", anonGID: "65534"},
+			expectError:     false,
+		},
+		{
+			name:        "bad clients parameter value",
+			options:     controller.VolumeOptions{Parameters: map[string]string{"clients": "10.0.0.0/8;}"}},
+			expectedGid: "",
+			expectError: true,
+		},
+		{
+			name:        "bad readOnly parameter value",
+			options:     controller.VolumeOptions{Parameters: map[string]string{"readOnly": "maybe"}},
+			expectedGid: "",
+			expectError: true,
+		},
+		{
+			name:        "bad anonUID parameter value",
+			options:     controller.VolumeOptions{Parameters: map[string]string{"anonUID": "-2"}},
+			expectedGid: "",
+			expectError: true,
+		},
 		{
 			name:        "bad parameter name",
 			options:     controller.VolumeOptions{Parameters: map[string]string{"foo": "bar"}},
@@ -243,23 +330,25 @@ func TestValidateOptions(t *testing.T) {
 			expectedGid: "",
 			expectError: true,
 		},
-		{
-			name: "bad capacity",
-			options: controller.VolumeOptions{
-				PVC: newClaim(resource.MustParse("1Ei"), nil, nil),
-			},
-			expectedGid: "",
-			expectError: true,
-		},
 	}
 
 	client := fake.NewSimpleClientset()
-	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "", false)
 
 	for _, test := range tests {
-		gid, err := p.validateOptions(test.options)
+		p.enableKrb5 = test.enableKrb5
+		if !test.expectError && test.expectedSec == nil {
+			test.expectedSec = []string{"sys"}
+		}
+
+		gid, opts, err := p.validateOptions(test.options)
 
 		evaluate(t, test.name, test.expectError, err, test.expectedGid, gid, "gid")
+		evaluate(t, test.name, test.expectError, err, test.expectedSec, opts.sec, "sec")
+		if test.expectedOptions != nil {
+			test.expectedOptions.sec = test.expectedSec
+			evaluate(t, test.name, test.expectError, err, *test.expectedOptions, opts, "export options")
+		}
 	}
 }
 
@@ -313,7 +402,7 @@ func TestCreateDirectory(t *testing.T) {
 	}
 
 	client := fake.NewSimpleClientset()
-	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "", false)
 
 	for _, test := range tests {
 		path := p.exportDir + test.directory
@@ -339,6 +428,63 @@ func TestCreateDirectory(t *testing.T) {
 	}
 }
 
+func TestRecycle(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name             string
+		recycle          bool
+		provisionerID    string
+		expectedRecycled bool
+		expectIgnored    bool
+		expectError      bool
+	}{
+		{
+			name:             "recycle",
+			recycle:          true,
+			expectedRecycled: true,
+		},
+		{
+			name:             "recycling disabled",
+			recycle:          false,
+			expectedRecycled: false,
+		},
+		{
+			name:          "another provisioner's volume",
+			recycle:       true,
+			provisionerID: "foo",
+			expectIgnored: true,
+			expectError:   true,
+		},
+	}
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "", false)
+	for _, test := range tests {
+		dir := path.Join(tmpDir, "pvc-1")
+		os.MkdirAll(path.Join(dir, "subdir"), 0777)
+		ioutil.WriteFile(path.Join(dir, "file"), []byte("data"), 0644)
+
+		provisionerID := string(p.identity)
+		if test.provisionerID != "" {
+			provisionerID = test.provisionerID
+		}
+		volume := &v1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "pvc-1", Annotations: map[string]string{annProvisionerID: provisionerID}}}
+		p.recycle = test.recycle
+		recycled, err := p.Recycle(volume)
+
+		_, ignored := err.(*controller.IgnoredError)
+		evaluate(t, test.name, test.expectError, err, test.expectIgnored, ignored, "ignored")
+		evaluate(t, test.name, test.expectError, err, test.expectedRecycled, recycled, "recycled")
+		entries, _ := ioutil.ReadDir(dir)
+		evaluate(t, test.name, false, nil, test.expectedRecycled, len(entries) == 0, "scrubbed")
+		if _, err := os.Stat(dir); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected directory to be kept but got error: %v", err)
+		}
+	}
+}
+
 func TestAddToRemoveFromFile(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
@@ -424,6 +570,205 @@ func TestGetExistingIDs(t *testing.T) {
 	}
 }
 
+func TestCreateExportBlock(t *testing.T) {
+	rootSquash, noRootSquash := true, false
+	tests := []struct {
+		name            string
+		ebc             exportBlockCreator
+		opts            exportOptions
+		expectedOptions []string
+	}{
+		{
+			name:            "ganesha defaults",
+			ebc:             &ganeshaExportBlockCreator{},
+			opts:            exportOptions{sec: []string{"sys"}},
+			expectedOptions: []string{"\tAccess_Type = RW;\n", "\tSquash = no_root_squash;\n", "\tSecType = sys;\n"},
+		},
+		{
+			name: "ganesha options",
+			ebc:  &ganeshaExportBlockCreator{},
+			opts: exportOptions{sec: []string{"sys"}, clients: []string{"10.0.0.0/8", "192.168.0.1"}, readOnly: true, rootSquash: &rootSquash, anonUID: "65534", anonGID: "65533"},
+			expectedOptions: []string{
+				"\tAccess_Type = None;\n",
+				"\tSquash = root_id_squash;\n",
+				"\tAnonymous_uid = 65534;\n",
+				"\tAnonymous_gid = 65533;\n",
+				"\tCLIENT {\n\t\tClients = 10.0.0.0/8, 192.168.0.1;\n\t\tAccess_Type = RO;\n\t}\n",
+			},
+		},
+		{
+			name:            "kernel defaults",
+			ebc:             &kernelExportBlockCreator{rootSquash: true},
+			opts:            exportOptions{sec: []string{"sys"}},
+			expectedOptions: []string{"\n/export/pvc-1 *(rw,insecure,root_squash,sec=sys,fsid=1)\n"},
+		},
+		{
+			name:            "kernel options",
+			ebc:             &kernelExportBlockCreator{rootSquash: true},
+			opts:            exportOptions{sec: []string{"sys"}, clients: []string{"10.0.0.0/8", "192.168.0.1"}, readOnly: true, rootSquash: &noRootSquash, anonUID: "65534"},
+			expectedOptions: []string{"\n/export/pvc-1 10.0.0.0/8(ro,insecure,no_root_squash,sec=sys,fsid=1,anonuid=65534) 192.168.0.1(ro,insecure,no_root_squash,sec=sys,fsid=1,anonuid=65534)\n"},
+		},
+	}
+	for _, test := range tests {
+		block := test.ebc.CreateExportBlock("1", "/export/pvc-1", test.opts)
+		for _, option := range test.expectedOptions {
+			evaluate(t, test.name, false, nil, true, strings.Contains(block, option), "block containing "+option)
+		}
+	}
+}
+
+func TestRestoreExportBlock(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	existing := "\n/export/pvc-1 *(rw,insecure,no_root_squash,sec=sys,fsid=1)\n"
+	conf := tmpDir + "/test"
+	if err := ioutil.WriteFile(conf, []byte(existing), 0600); err != nil {
+		t.Fatalf("Error writing file %s: %v", conf, err)
+	}
+	e := newGenericExporter(&kernelExportBlockCreator{}, conf, regexp.MustCompile("fsid=([0-9]+)"))
+
+	tests := []struct {
+		name             string
+		block            string
+		exportID         uint16
+		expectedRestored bool
+	}{
+		{
+			name:             "block in config",
+			block:            existing,
+			exportID:         1,
+			expectedRestored: false,
+		},
+		{
+			name:             "block missing from config",
+			block:            "\n/export/pvc-2 *(rw,insecure,no_root_squash,sec=sys,fsid=2)\n",
+			exportID:         2,
+			expectedRestored: true,
+		},
+	}
+	for _, test := range tests {
+		restored, err := e.RestoreExportBlock(test.block, test.exportID)
+		evaluate(t, test.name, false, err, test.expectedRestored, restored, "restored")
+
+		read, _ := ioutil.ReadFile(conf)
+		evaluate(t, test.name, false, err, 1, strings.Count(string(read), test.block), "blocks in config")
+		evaluate(t, test.name, false, err, true, e.exportIDs[test.exportID], "export id reserved")
+	}
+}
+
+func TestRecoverExports(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	exporter := &testExporter{existingBlocks: map[string]bool{"block-2": true}}
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, exporter, newDummyQuotaer(), "", false)
+
+	newVolume := func(name, provisionerID, block string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: v1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					annProvisionerID: provisionerID,
+					annExportBlock:   block,
+					annExportID:      "1",
+				},
+			},
+		}
+	}
+	volumes := []*v1.PersistentVolume{
+		// Export missing from config
+		newVolume("pvc-1", string(p.identity), "block-1"),
+		// Export in config
+		newVolume("pvc-2", string(p.identity), "block-2"),
+		// Another provisioner's
+		newVolume("pvc-3", "foo", "block-3"),
+		// Backing directory missing
+		newVolume("pvc-4", string(p.identity), "block-4"),
+	}
+	for _, volume := range volumes {
+		if volume.Name != "pvc-4" {
+			os.Mkdir(tmpDir+"/"+volume.Name, 0777)
+		}
+		client.Core().PersistentVolumes().Create(volume)
+	}
+
+	err := p.recoverExports()
+	evaluate(t, "recover exports", false, err, []string{tmpDir + "/pvc-1"}, exporter.exported, "exported paths")
+}
+
+func TestUsageReporter(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "", false)
+	r, err := NewUsageReporter(p)
+	if err != nil {
+		t.Fatalf("Error creating usage reporter: %v", err)
+	}
+
+	newVolume := func(name, provisionerID string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{annProvisionerID: provisionerID},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
+				},
+				ClaimRef: &v1.ObjectReference{Namespace: "default", Name: "claim-" + name},
+			},
+		}
+	}
+	volumes := []*v1.PersistentVolume{
+		newVolume("pvc-2", string(p.identity)),
+		newVolume("pvc-1", string(p.identity)),
+		// Another provisioner's
+		newVolume("pvc-3", "foo"),
+	}
+	for _, volume := range volumes {
+		os.Mkdir(tmpDir+"/"+volume.Name, 0777)
+		client.Core().PersistentVolumes().Create(volume)
+	}
+	if err := ioutil.WriteFile(tmpDir+"/pvc-1/file", make([]byte, 64*1024), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	// Hard links are counted once
+	if err := os.Link(tmpDir+"/pvc-1/file", tmpDir+"/pvc-1/link"); err != nil {
+		t.Fatalf("Error linking file: %v", err)
+	}
+
+	r.update()
+
+	emptyUsed, err := directoryUsage(tmpDir + "/pvc-2")
+	if err != nil {
+		t.Fatalf("Error measuring usage: %v", err)
+	}
+	pvs := []string{}
+	for _, usage := range r.report {
+		pvs = append(pvs, usage.PV)
+	}
+	evaluate(t, "usage report", false, nil, []string{"pvc-1", "pvc-2"}, pvs, "reported PVs")
+	if len(r.report) != 2 {
+		return
+	}
+	used := r.report[0].UsedBytes - emptyUsed
+	if used < 64*1024 || used >= 2*64*1024 {
+		t.Errorf("Expected usage of pvc-1 between 64Ki and 128Ki above an empty directory but got %d", used)
+	}
+	evaluate(t, "usage report", false, nil, int64(1024*1024), r.report[0].CapacityBytes, "capacity")
+	evaluate(t, "usage report", false, nil, "claim-pvc-1", r.report[0].Claim, "claim")
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, nil)
+	var served []VolumeUsage
+	err = json.Unmarshal(recorder.Body.Bytes(), &served)
+	evaluate(t, "serve usage report", false, err, 2, len(served), "number of served PVs")
+}
+
 func TestGetServer(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
@@ -593,7 +938,7 @@ func TestGetServer(t *testing.T) {
 		}
 
 		client := fake.NewSimpleClientset(test.objs...)
-		p := newNFSProvisionerInternal(tmpDir+"/", client, test.outOfCluster, &testExporter{}, newDummyQuotaer(), test.serverHostname)
+		p := newNFSProvisionerInternal(tmpDir+"/", client, test.outOfCluster, &testExporter{}, newDummyQuotaer(), test.serverHostname, false)
 
 		server, err := p.getServer()
 
@@ -606,6 +951,70 @@ func TestGetServer(t *testing.T) {
 	}
 }
 
+func TestGetServerServiceHostname(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	annotated := newService("foo", "1.1.1.1")
+	annotated.Annotations = map[string]string{annExternalDNSHostname: "nfs.example.com"}
+	tests := []struct {
+		name               string
+		service            *v1.Service
+		serviceHostname    string
+		annotateService    bool
+		expectedServer     string
+		expectedAnnotation string
+	}{
+		{
+			name:           "no hostname, use cluster IP",
+			service:        newService("foo", "1.1.1.1"),
+			expectedServer: "1.1.1.1",
+		},
+		{
+			name:            "hostname",
+			service:         newService("foo", "1.1.1.1"),
+			serviceHostname: "nfs.example.com",
+			expectedServer:  "nfs.example.com",
+		},
+		{
+			name:               "hostname, annotate service",
+			service:            newService("foo", "1.1.1.1"),
+			serviceHostname:    "nfs.example.com",
+			annotateService:    true,
+			expectedServer:     "nfs.example.com",
+			expectedAnnotation: "nfs.example.com",
+		},
+		{
+			name:               "hostname, replace annotation",
+			service:            annotated,
+			serviceHostname:    "nfs2.example.com",
+			annotateService:    true,
+			expectedServer:     "nfs2.example.com",
+			expectedAnnotation: "nfs2.example.com",
+		},
+	}
+	os.Setenv(podIPEnv, "2.2.2.2")
+	os.Setenv(serviceEnv, "foo")
+	os.Setenv(namespaceEnv, "default")
+	defer os.Unsetenv(podIPEnv)
+	defer os.Unsetenv(serviceEnv)
+	defer os.Unsetenv(namespaceEnv)
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.service, newEndpoints("foo", []string{"2.2.2.2"}, []endpointPort{{2049, v1.ProtocolTCP}, {20048, v1.ProtocolTCP}, {111, v1.ProtocolUDP}, {111, v1.ProtocolTCP}}))
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "", false)
+		p.serviceHostname = test.serviceHostname
+		p.annotateService = test.annotateService
+
+		server, err := p.getServer()
+		evaluate(t, test.name, false, err, test.expectedServer, server, "server")
+
+		if test.expectedAnnotation != "" {
+			service, err := client.Core().Services("default").Get("foo")
+			evaluate(t, test.name, false, err, test.expectedAnnotation, service.Annotations[annExternalDNSHostname], "service annotation")
+		}
+	}
+}
+
 func newClaim(capacity resource.Quantity, accessmodes []v1.PersistentVolumeAccessMode, selector *unversioned.LabelSelector) *v1.PersistentVolumeClaim {
 	claim := &v1.PersistentVolumeClaim{
 		ObjectMeta: v1.ObjectMeta{},
@@ -666,11 +1075,16 @@ func newEndpoints(name string, ips []string, ports []endpointPort) *v1.Endpoints
 
 type testExporter struct {
 	config string
+
+	// Export blocks that RestoreExportBlock finds in the config
+	existingBlocks map[string]bool
+	// Paths exported
+	exported []string
 }
 
 var _ exporter = &testExporter{}
 
-func (e *testExporter) AddExportBlock(path string) (string, uint16, error) {
+func (e *testExporter) AddExportBlock(path string, opts exportOptions) (string, uint16, error) {
 	return "\nExport_Id = 0;\n", 0, nil
 }
 
@@ -678,10 +1092,15 @@ func (e *testExporter) RemoveExportBlock(block string, exportID uint16) error {
 	return nil
 }
 
+func (e *testExporter) RestoreExportBlock(block string, exportID uint16) (bool, error) {
+	return !e.existingBlocks[block], nil
+}
+
 func (e *testExporter) Export(path string) error {
 	if strings.Contains(path, "FAIL_TO_EXPORT_ME") {
 		return errors.New("fake error")
 	}
+	e.exported = append(e.exported, path)
 	return nil
 }
 
@@ -701,3 +1120,44 @@ func evaluate(t *testing.T, name string, expectError bool, err error, expected i
 		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
 	}
 }
+
+func TestGetMountOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols server.Protocols
+		sec       []string
+		expected  string
+	}{
+		{
+			name:      "defaults",
+			protocols: server.DefaultProtocols(),
+			expected:  "",
+		},
+		{
+			name:      "v4.1 only",
+			protocols: server.Protocols{Versions: []string{server.Version41}, NFSPort: 2049, MountdPort: 20048},
+			expected:  "vers=4.1",
+		},
+		{
+			name:      "v4 only, custom port",
+			protocols: server.Protocols{Versions: []string{server.Version40, server.Version41}, NFSPort: 12049, MountdPort: 20048},
+			expected:  "vers=4.1,port=12049",
+		},
+		{
+			name:      "v3 only, custom ports",
+			protocols: server.Protocols{Versions: []string{server.Version3}, NFSPort: 12049, MountdPort: 30048},
+			expected:  "vers=3,port=12049,mountport=30048",
+		},
+		{
+			name:      "krb5, custom ports",
+			protocols: server.Protocols{Versions: []string{server.Version3, server.Version41}, NFSPort: 12049, MountdPort: 30048},
+			sec:       []string{"krb5p", "sys"},
+			expected:  "vers=4.1,port=12049,sec=krb5p",
+		},
+	}
+	for _, test := range tests {
+		p := &nfsProvisioner{protocols: test.protocols}
+		options := p.getMountOptions(test.sec)
+		evaluate(t, test.name, false, nil, test.expected, options, "mount options")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// recoverExports exports again the volumes this provisioner provisioned whose
+// export blocks are missing from the exporter's config and reserves the
+// exportIDs of all of them. The config and the provisioner's identity live in
+// exportDir, so when the provisioner is rescheduled with the same exportDir,
+// e.g. a disk that can be attached to any node, it normally finds its exports
+// there; the PVs' annotations are the fallback for when it does not, e.g.
+// because the config was recreated or edited by hand.
+func (p *nfsProvisioner) recoverExports() error {
+	volumes, err := p.client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing PVs: %v", err)
+	}
+
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+			continue
+		}
+		if err := p.recoverExport(volume); err != nil {
+			glog.Errorf("Error recovering export of volume %q: %v", volume.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *nfsProvisioner) recoverExport(volume *v1.PersistentVolume) error {
+	block, exportID, err := getBlockAndID(volume, annExportBlock, annExportID)
+	if err != nil {
+		return fmt.Errorf("error getting block &/or id from annotations: %v", err)
+	}
+
+	path := path.Join(p.exportDir, volume.Name)
+	if p.remote != nil {
+		if exists, err := p.remote.exists(path); err != nil || !exists {
+			return fmt.Errorf("error checking volume's backing path %s on %s, not exporting it: exists %v, error %v", path, p.remote.host, exists, err)
+		}
+	} else if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("error checking volume's backing path %s, not exporting it: %v", path, err)
+	}
+
+	restored, err := p.exporter.RestoreExportBlock(block, exportID)
+	if err != nil {
+		return err
+	}
+	if !restored {
+		return nil
+	}
+	if err := p.exporter.Export(path); err != nil {
+		return fmt.Errorf("restored export block but error exporting it: %v", err)
+	}
+	glog.Infof("restored export of volume %q", volume.Name)
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+var _ controller.Recycler = &nfsProvisioner{}
+
+// Recycle scrubs the directory backing the given released PV, if the
+// provisioner recycles volumes, so that the PV can be bound to a new claim.
+// The directory itself, its export and its quota are kept, so the PV stays
+// mountable as it is.
+func (p *nfsProvisioner) Recycle(volume *v1.PersistentVolume) (bool, error) {
+	if !p.recycle {
+		return false, nil
+	}
+	provisioned, err := p.provisioned(volume)
+	if err != nil {
+		return false, fmt.Errorf("error determining if this provisioner was the one to provision volume %q: %v", volume.Name, err)
+	}
+	if !provisioned {
+		strerr := fmt.Sprintf("this provisioner id %s didn't provision volume %q and so can't recycle it; id %s did & can", p.identity, volume.Name, volume.Annotations[annProvisionerID])
+		return false, &controller.IgnoredError{Reason: strerr}
+	}
+
+	if err := p.scrubDirectory(volume); err != nil {
+		return false, fmt.Errorf("error scrubbing volume's backing path: %v", err)
+	}
+
+	return true, nil
+}
+
+// scrubDirectory removes everything in the directory backing the volume,
+// keeping the directory with its permissions
+func (p *nfsProvisioner) scrubDirectory(volume *v1.PersistentVolume) error {
+	dir := path.Join(p.exportDir, volume.ObjectMeta.Name)
+	if p.remote != nil {
+		return p.remote.scrubDirectory(dir)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(path.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
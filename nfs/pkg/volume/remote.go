@@ -0,0 +1,358 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/types"
+	"k8s.io/client-go/pkg/util/uuid"
+)
+
+// remoteExportsConfig is the kernel NFS server config a remoteExporter adds
+// export blocks to
+const remoteExportsConfig = "/etc/exports"
+
+// RemoteServer is an NFS server the provisioner doesn't run but manages over
+// SSH, in export-manager mode: it creates the directories backing volumes on
+// the server and exports them by adding blocks to the server's /etc/exports
+// and running exportfs there. The server must run the kernel NFS server and
+// have sh and the usual coreutils.
+type RemoteServer struct {
+	// The host to SSH to and, unless the provisioner's serverHostname is set,
+	// to put as the server of provisioned PVs
+	host string
+	// The user to log in as, who must be allowed to create directories in the
+	// export directory, edit /etc/exports and run exportfs
+	user string
+	// The private key to log in with, e.g. one mounted from a secret
+	keyFile string
+	// The known_hosts file to check the server's host key against
+	knownHostsFile string
+}
+
+// NewRemoteServer creates a RemoteServer to manage over SSH as user with the
+// private key in keyFile, checking its host key against knownHostsFile.
+func NewRemoteServer(host, user, keyFile, knownHostsFile string) (*RemoteServer, error) {
+	if host == "" {
+		return nil, fmt.Errorf("no host given")
+	}
+	if user == "" {
+		return nil, fmt.Errorf("no user given for host %s", host)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		return nil, fmt.Errorf("error reading private key %q: %v", keyFile, err)
+	}
+	if knownHostsFile == "" {
+		return nil, fmt.Errorf("no known hosts file given for host %s", host)
+	}
+	if _, err := os.Stat(knownHostsFile); err != nil {
+		return nil, fmt.Errorf("error reading known hosts %q: %v", knownHostsFile, err)
+	}
+	return &RemoteServer{host: host, user: user, keyFile: keyFile, knownHostsFile: knownHostsFile}, nil
+}
+
+// sshArgs returns the arguments to run the command on the server with ssh
+func (s *RemoteServer) sshArgs(command []string) []string {
+	args := []string{"-i", s.keyFile, "-o", "BatchMode=yes", "-l", s.user, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=" + s.knownHostsFile}
+	// ssh hands the command to the remote user's shell as one string
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = shellQuote(arg)
+	}
+	return append(args, s.host, "--", strings.Join(quoted, " "))
+}
+
+// shellQuote quotes s so that a POSIX shell reads it as one word
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// runRemoteCommand runs the command on the server, with stdin as its input,
+// and returns its output. It is a variable so tests can replace it.
+var runRemoteCommand = func(s *RemoteServer, stdin string, command ...string) ([]byte, error) {
+	cmd := exec.Command("ssh", s.sshArgs(command)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s on %s failed with error: %v, output: %s", command[0], s.host, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *RemoteServer) run(command ...string) ([]byte, error) {
+	return runRemoteCommand(s, "", command...)
+}
+
+func (s *RemoteServer) readFile(file string) ([]byte, error) {
+	return s.run("cat", file)
+}
+
+func (s *RemoteServer) writeFile(file string, content string) error {
+	_, err := runRemoteCommand(s, content, "sh", "-c", `cat > "$1"`, "sh", file)
+	return err
+}
+
+func (s *RemoteServer) appendFile(file string, content string) error {
+	_, err := runRemoteCommand(s, content, "sh", "-c", `cat >> "$1"`, "sh", file)
+	return err
+}
+
+// exists returns whether the file exists on the server
+func (s *RemoteServer) exists(file string) (bool, error) {
+	out, err := s.run("sh", "-c", `if [ -e "$1" ]; then echo true; else echo false; fi`, "sh", file)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.TrimSpace(string(out)))
+}
+
+// availableSpace returns the bytes available to unprivileged users on the
+// filesystem of dir, like statfs
+func (s *RemoteServer) availableSpace(dir string) (int64, error) {
+	out, err := s.run("stat", "-f", "-c", "%a %S", dir)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("stat printed invalid output %q", out)
+	}
+	blocks, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("stat printed invalid available blocks %q: %v", fields[0], err)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("stat printed invalid block size %q: %v", fields[1], err)
+	}
+	return blocks * size, nil
+}
+
+// createDirectory creates the directory on the server with appropriate
+// permissions and ownership according to the given gid parameter string,
+// like nfsProvisioner's createDirectory
+func (s *RemoteServer) createDirectory(dir, gid string) error {
+	perm := "0777"
+	if gid != "none" {
+		perm = "0071"
+	}
+	// mkdir fails if the path already exists and applies the mode regardless
+	// of umask
+	if _, err := s.run("mkdir", "-m", perm, dir); err != nil {
+		return err
+	}
+	if gid != "none" {
+		if _, err := s.run("chgrp", gid, dir); err != nil {
+			s.removeAll(dir)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RemoteServer) removeAll(dir string) error {
+	_, err := s.run("rm", "-rf", dir)
+	return err
+}
+
+// scrubDirectory removes everything in the directory, keeping the directory
+// with its permissions
+func (s *RemoteServer) scrubDirectory(dir string) error {
+	_, err := s.run("find", dir, "-mindepth", "1", "-maxdepth", "1", "-exec", "rm", "-rf", "{}", "+")
+	return err
+}
+
+// directoryUsage returns the disk space used by the files under dir, as
+// counted by du
+func (s *RemoteServer) directoryUsage(dir string) (int64, error) {
+	out, err := s.run("du", "-sk", dir)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("du printed no usage")
+	}
+	kib, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("du printed invalid usage %q: %v", fields[0], err)
+	}
+	return kib * 1024, nil
+}
+
+// newRemoteNFSProvisionerInternal creates an nfsProvisioner that provisions
+// volumes backed by directories in exportDir on the remote server. Its
+// identity is persisted to exportDir on the server like a local one's.
+func newRemoteNFSProvisionerInternal(exportDir string, client kubernetes.Interface, remote *RemoteServer, exporter exporter, serverHostname string, enableKrb5 bool) *nfsProvisioner {
+	if exists, err := remote.exists(exportDir); err != nil {
+		glog.Fatalf("Error checking exportDir %s on %s: %v", exportDir, remote.host, err)
+	} else if !exists {
+		glog.Fatalf("exportDir %s does not exist on %s!", exportDir, remote.host)
+	}
+
+	var identity types.UID
+	identityPath := path.Join(exportDir, identityFile)
+	if exists, err := remote.exists(identityPath); err != nil {
+		glog.Fatalf("Error checking identity file %s on %s: %v", identityPath, remote.host, err)
+	} else if !exists {
+		identity = uuid.NewUUID()
+		if err := remote.writeFile(identityPath, string(identity)); err != nil {
+			glog.Fatalf("Error writing identity file %s on %s! %v", identityPath, remote.host, err)
+		}
+	} else {
+		read, err := remote.readFile(identityPath)
+		if err != nil {
+			glog.Fatalf("Error reading identity file %s on %s! %v", identityPath, remote.host, err)
+		}
+		identity = types.UID(strings.TrimSpace(string(read)))
+	}
+
+	return &nfsProvisioner{
+		exportDir:      exportDir,
+		client:         client,
+		outOfCluster:   true,
+		exporter:       exporter,
+		quotaer:        newDummyQuotaer(),
+		serverHostname: serverHostname,
+		enableKrb5:     enableKrb5,
+		identity:       identity,
+		protocols:      server.DefaultProtocols(),
+		remote:         remote,
+	}
+}
+
+// removeAll removes the path backing a volume, locally or on the remote
+// server
+func (p *nfsProvisioner) removeAll(path string) error {
+	if p.remote != nil {
+		return p.remote.removeAll(path)
+	}
+	return os.RemoveAll(path)
+}
+
+// remoteExporter exports directories on a remote server by adding blocks to
+// the server's /etc/exports and running exportfs -r there
+type remoteExporter struct {
+	server *RemoteServer
+	ebc    exportBlockCreator
+	config string
+
+	// Map to track used exportIDs, used as fsids like kernelExporter's
+	exportIDs map[uint16]bool
+
+	mapMutex  *sync.Mutex
+	fileMutex *sync.Mutex
+}
+
+var _ exporter = &remoteExporter{}
+
+func newRemoteExporter(server *RemoteServer, rootSquash bool) exporter {
+	read, err := server.readFile(remoteExportsConfig)
+	if err != nil {
+		glog.Fatalf("Error reading config %s on %s: %v", remoteExportsConfig, server.host, err)
+	}
+	exportIDs, err := parseExistingIDs(read, regexp.MustCompile("fsid=([0-9]+)"))
+	if err != nil {
+		glog.Errorf("error while populating exportIDs map, there may be errors exporting later if exportIDs are reused: %v", err)
+	}
+	return &remoteExporter{
+		server:    server,
+		ebc:       &kernelExportBlockCreator{rootSquash},
+		config:    remoteExportsConfig,
+		exportIDs: exportIDs,
+		mapMutex:  &sync.Mutex{},
+		fileMutex: &sync.Mutex{},
+	}
+}
+
+func (e *remoteExporter) AddExportBlock(path string, opts exportOptions) (string, uint16, error) {
+	exportID := generateID(e.mapMutex, e.exportIDs)
+	exportIDStr := strconv.FormatUint(uint64(exportID), 10)
+
+	block := e.ebc.CreateExportBlock(exportIDStr, path, opts)
+
+	e.fileMutex.Lock()
+	err := e.server.appendFile(e.config, block)
+	e.fileMutex.Unlock()
+	if err != nil {
+		deleteID(e.mapMutex, e.exportIDs, exportID)
+		return "", 0, fmt.Errorf("error adding export block %s to config %s on %s: %v", block, e.config, e.server.host, err)
+	}
+	return block, exportID, nil
+}
+
+func (e *remoteExporter) RemoveExportBlock(block string, exportID uint16) error {
+	deleteID(e.mapMutex, e.exportIDs, exportID)
+
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+	read, err := e.server.readFile(e.config)
+	if err != nil {
+		return err
+	}
+	return e.server.writeFile(e.config, strings.Replace(string(read), block, "", -1))
+}
+
+// RestoreExportBlock adds a block created by an earlier AddExportBlock back to
+// the server's config if it is missing from there and reserves its exportID.
+// It returns whether the block was missing and so needs to be exported again.
+func (e *remoteExporter) RestoreExportBlock(block string, exportID uint16) (bool, error) {
+	e.mapMutex.Lock()
+	e.exportIDs[exportID] = true
+	e.mapMutex.Unlock()
+
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+	read, err := e.server.readFile(e.config)
+	if err != nil {
+		return false, fmt.Errorf("error reading config %s on %s: %v", e.config, e.server.host, err)
+	}
+	if strings.Contains(string(read), block) {
+		return false, nil
+	}
+
+	if err := e.server.appendFile(e.config, block); err != nil {
+		return false, fmt.Errorf("error adding export block %s to config %s on %s: %v", block, e.config, e.server.host, err)
+	}
+	return true, nil
+}
+
+// Export exports all directories listed in the server's /etc/exports
+func (e *remoteExporter) Export(_ string) error {
+	_, err := e.server.run("exportfs", "-r")
+	return err
+}
+
+func (e *remoteExporter) Unexport(volume *v1.PersistentVolume) error {
+	_, err := e.server.run("exportfs", "-r")
+	return err
+}
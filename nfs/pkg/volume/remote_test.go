@@ -0,0 +1,201 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/pkg/util/testing"
+)
+
+// fakeRemote is an NFS server reached over "SSH" that keeps its files and
+// directories in memory
+type fakeRemote struct {
+	files    map[string]string
+	dirs     map[string]string
+	groups   map[string]string
+	exportfs int
+}
+
+func (f *fakeRemote) run(s *RemoteServer, stdin string, command ...string) ([]byte, error) {
+	switch {
+	case command[0] == "cat":
+		content, ok := f.files[command[1]]
+		if !ok {
+			return nil, fmt.Errorf("cat on %s failed with error: exit status 1", s.host)
+		}
+		return []byte(content), nil
+	case command[0] == "sh" && command[2] == `cat > "$1"`:
+		f.files[command[4]] = stdin
+	case command[0] == "sh" && command[2] == `cat >> "$1"`:
+		f.files[command[4]] += stdin
+	case command[0] == "sh":
+		_, isFile := f.files[command[4]]
+		_, isDir := f.dirs[command[4]]
+		return []byte(fmt.Sprintf("%v\n", isFile || isDir)), nil
+	case command[0] == "stat":
+		return []byte("262144 4096\n"), nil
+	case command[0] == "mkdir":
+		if _, ok := f.dirs[command[3]]; ok {
+			return nil, fmt.Errorf("mkdir on %s failed with error: exit status 1", s.host)
+		}
+		f.dirs[command[3]] = command[2]
+	case command[0] == "chgrp":
+		f.groups[command[2]] = command[1]
+	case command[0] == "rm":
+		delete(f.dirs, command[2])
+	case command[0] == "exportfs":
+		f.exportfs++
+	default:
+		return nil, fmt.Errorf("unexpected command %v", command)
+	}
+	return nil, nil
+}
+
+func TestRemoteProvisionDelete(t *testing.T) {
+	defer func(run func(*RemoteServer, string, ...string) ([]byte, error)) { runRemoteCommand = run }(runRemoteCommand)
+	f := &fakeRemote{
+		files:  map[string]string{"/etc/exports": "/srv/other *(rw,fsid=1)\n"},
+		dirs:   map[string]string{"/srv/nfs": "0755"},
+		groups: map[string]string{},
+	}
+	runRemoteCommand = f.run
+
+	remote := &RemoteServer{host: "filer.example.com", user: "root", keyFile: "/etc/ssh-key/id_rsa", knownHostsFile: "/etc/ssh-key/known_hosts"}
+	p := NewNFSProvisioner("/srv/nfs", fake.NewSimpleClientset(), false, false, "", false, false, "", false, "", false, false, remote, server.DefaultProtocols())
+	identity, ok := f.files["/srv/nfs/"+identityFile]
+	if !ok {
+		t.Fatalf("expected identity file on the server but got none")
+	}
+
+	options := controller.VolumeOptions{
+		PVName:     "pvc-1",
+		Parameters: map[string]string{"gid": "1001"},
+		PVC:        newClaim(resource.MustParse("1Mi"), []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}, nil),
+	}
+	volume, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	evaluate(t, "provision", false, nil, "filer.example.com", volume.Spec.NFS.Server, "server")
+	evaluate(t, "provision", false, nil, "/srv/nfs/pvc-1", volume.Spec.NFS.Path, "path")
+	evaluate(t, "provision", false, nil, identity, volume.Annotations[annProvisionerID], "provisioner id")
+	evaluate(t, "provision", false, nil, "0071", f.dirs["/srv/nfs/pvc-1"], "permission bits")
+	evaluate(t, "provision", false, nil, "1001", f.groups["/srv/nfs/pvc-1"], "gid owner")
+	evaluate(t, "provision", false, nil, "2", volume.Annotations[annExportID], "export id")
+	evaluate(t, "provision", false, nil, true, strings.Contains(f.files["/etc/exports"], volume.Annotations[annExportBlock]), "export block in config")
+	evaluate(t, "provision", false, nil, 1, f.exportfs, "exportfs runs")
+
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning existing directory but got none")
+	}
+
+	if err := p.Delete(volume); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	_, ok = f.dirs["/srv/nfs/pvc-1"]
+	evaluate(t, "delete", false, nil, false, ok, "directory exists")
+	evaluate(t, "delete", false, nil, "/srv/other *(rw,fsid=1)\n", f.files["/etc/exports"], "config")
+	evaluate(t, "delete", false, nil, 2, f.exportfs, "exportfs runs")
+}
+
+func TestNewRemoteServer(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsRemoteTest")
+	defer os.RemoveAll(tmpDir)
+	key, knownHosts := path.Join(tmpDir, "id_rsa"), path.Join(tmpDir, "known_hosts")
+	for _, file := range []string{key, knownHosts} {
+		if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+			t.Fatalf("Error writing %s: %v", file, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		user        string
+		knownHosts  string
+		expectError bool
+	}{
+		{
+			name:       "user and known hosts",
+			user:       "root",
+			knownHosts: knownHosts,
+		},
+		{
+			name:        "no user",
+			knownHosts:  knownHosts,
+			expectError: true,
+		},
+		{
+			name:        "no known hosts",
+			user:        "root",
+			expectError: true,
+		},
+		{
+			name:        "missing known hosts",
+			user:        "root",
+			knownHosts:  path.Join(tmpDir, "missing"),
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := NewRemoteServer("filer", test.user, key, test.knownHosts)
+		if test.expectError && err == nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error but got none")
+		} else if !test.expectError && err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   *RemoteServer
+		expected []string
+	}{
+		{
+			name:     "root",
+			server:   &RemoteServer{host: "filer", user: "root", keyFile: "/key", knownHostsFile: "/known_hosts"},
+			expected: []string{"-i", "/key", "-o", "BatchMode=yes", "-l", "root", "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/known_hosts", "filer", "--", `'mkdir' '/srv/it'\''s'`},
+		},
+		{
+			name:     "other user",
+			server:   &RemoteServer{host: "filer", user: "nfs", keyFile: "/key", knownHostsFile: "/known_hosts"},
+			expected: []string{"-i", "/key", "-o", "BatchMode=yes", "-l", "nfs", "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/known_hosts", "filer", "--", `'mkdir' '/srv/it'\''s'`},
+		},
+	}
+	for _, test := range tests {
+		args := test.server.sshArgs([]string{"mkdir", "/srv/it's"})
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected args %v but got %v", test.expected, args)
+		}
+	}
+}
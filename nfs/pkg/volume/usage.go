@@ -0,0 +1,203 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+var (
+	// volumeUsedBytes is the disk space used by the files of each volume, by
+	// PV name and claim namespace/name
+	volumeUsedBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: "nfs_provisioner",
+			Name:      "volume_used_bytes",
+			Help:      "Disk space used by the files of a provisioned volume.",
+		},
+		[]string{"pv", "namespace", "claim"},
+	)
+
+	// volumeCapacityBytes is the capacity of each volume's PV, by PV name and
+	// claim namespace/name
+	volumeCapacityBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: "nfs_provisioner",
+			Name:      "volume_capacity_bytes",
+			Help:      "Capacity of a provisioned volume's PV.",
+		},
+		[]string{"pv", "namespace", "claim"},
+	)
+
+	registerUsageMetrics sync.Once
+)
+
+// VolumeUsage is how much space a provisioned volume uses, as of Time
+type VolumeUsage struct {
+	PV            string    `json:"pv"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Claim         string    `json:"claim,omitempty"`
+	CapacityBytes int64     `json:"capacityBytes"`
+	UsedBytes     int64     `json:"usedBytes"`
+	Time          time.Time `json:"time"`
+}
+
+// UsageReporter periodically measures how much space each volume the
+// provisioner provisioned uses by walking its directory, for chargeback. It
+// records the usage in the metrics nfs_provisioner_volume_used_bytes and
+// nfs_provisioner_volume_capacity_bytes and serves it as a JSON report.
+type UsageReporter struct {
+	provisioner *nfsProvisioner
+
+	mutex  sync.RWMutex
+	report []VolumeUsage
+}
+
+// NewUsageReporter creates a UsageReporter for the volumes of the given
+// provisioner, which must have been created by NewNFSProvisioner, and
+// registers its metrics with Prometheus' default registry.
+func NewUsageReporter(provisioner controller.Provisioner) (*UsageReporter, error) {
+	p, ok := provisioner.(*nfsProvisioner)
+	if !ok {
+		return nil, fmt.Errorf("provisioner %T is not an NFS provisioner", provisioner)
+	}
+	registerUsageMetrics.Do(func() {
+		prometheus.MustRegister(volumeUsedBytes)
+		prometheus.MustRegister(volumeCapacityBytes)
+	})
+	return &UsageReporter{provisioner: p, report: []VolumeUsage{}}, nil
+}
+
+// Run measures usage every period until stopCh is closed
+func (r *UsageReporter) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(r.update, period, stopCh)
+}
+
+// ServeHTTP serves the last usage report as a JSON array sorted by PV name
+func (r *UsageReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.RLock()
+	report := r.report
+	r.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		glog.Errorf("Error writing usage report: %v", err)
+	}
+}
+
+func (r *UsageReporter) update() {
+	volumes, err := r.provisioner.client.Core().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing PVs, not measuring usage: %v", err)
+		return
+	}
+
+	report := []VolumeUsage{}
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		if provisioned, err := r.provisioner.provisioned(volume); err != nil || !provisioned {
+			continue
+		}
+		used, err := r.provisioner.directoryUsage(path.Join(r.provisioner.exportDir, volume.Name))
+		if err != nil {
+			glog.Errorf("Error measuring usage of volume %q: %v", volume.Name, err)
+			continue
+		}
+		usage := VolumeUsage{PV: volume.Name, UsedBytes: used, Time: time.Now()}
+		if capacity, ok := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]; ok {
+			usage.CapacityBytes = capacity.Value()
+		}
+		if volume.Spec.ClaimRef != nil {
+			usage.Namespace = volume.Spec.ClaimRef.Namespace
+			usage.Claim = volume.Spec.ClaimRef.Name
+		}
+		report = append(report, usage)
+	}
+	sort.Sort(byPV(report))
+
+	// Reset so that deleted volumes' series disappear
+	volumeUsedBytes.Reset()
+	volumeCapacityBytes.Reset()
+	for _, usage := range report {
+		volumeUsedBytes.WithLabelValues(usage.PV, usage.Namespace, usage.Claim).Set(float64(usage.UsedBytes))
+		volumeCapacityBytes.WithLabelValues(usage.PV, usage.Namespace, usage.Claim).Set(float64(usage.CapacityBytes))
+	}
+
+	r.mutex.Lock()
+	r.report = report
+	r.mutex.Unlock()
+}
+
+// directoryUsage returns the disk space used by the files under dir, on the
+// remote server if there is one
+func (p *nfsProvisioner) directoryUsage(dir string) (int64, error) {
+	if p.remote != nil {
+		return p.remote.directoryUsage(dir)
+	}
+	return directoryUsage(dir)
+}
+
+// directoryUsage returns the disk space used by the files under dir, like
+// du, counting each hard linked file once
+func directoryUsage(dir string) (int64, error) {
+	var used int64
+	seen := map[uint64]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may be deleted while walking
+			if os.IsNotExist(err) && path != dir {
+				return nil
+			}
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			used += info.Size()
+			return nil
+		}
+		if stat.Nlink > 1 && !info.IsDir() {
+			if seen[stat.Ino] {
+				return nil
+			}
+			seen[stat.Ino] = true
+		}
+		used += stat.Blocks * 512
+		return nil
+	})
+	return used, err
+}
+
+type byPV []VolumeUsage
+
+func (u byPV) Len() int           { return len(u) }
+func (u byPV) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u byPV) Less(i, j int) bool { return u[i].PV < u[j].PV }
@@ -62,6 +62,19 @@ func getExistingIDs(config string, re *regexp.Regexp) (map[uint16]bool, error) {
 		return ids, err
 	}
 
+	return parseExistingIDs(read, re)
+}
+
+// parseExistingIDs populates a map with existing ids found in the given config
+// file content using the given regexp, like getExistingIDs
+func parseExistingIDs(read []byte, re *regexp.Regexp) (map[uint16]bool, error) {
+	ids := map[uint16]bool{}
+
+	digitsRe := "([0-9]+)"
+	if !strings.Contains(re.String(), digitsRe) {
+		return ids, fmt.Errorf("regexp %s doesn't contain digits submatch %s", re.String(), digitsRe)
+	}
+
 	allMatches := re.FindAllSubmatch(read, -1)
 	for _, match := range allMatches {
 		digits := match[1]
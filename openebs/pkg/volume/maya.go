@@ -0,0 +1,141 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// Labels of a volume spec that tell maya how to create the volume
+	sizeLabel         = "volumeprovisioner.mapi.openebs.io/storage-size"
+	replicaCountLabel = "volumeprovisioner.mapi.openebs.io/replica-count"
+	storagePoolLabel  = "volumeprovisioner.mapi.openebs.io/storage-pool"
+
+	// Annotations of a created volume that tell how to reach its iSCSI target
+	targetPortalsAnn = "vsm.openebs.io/targetportals"
+	iqnAnn           = "vsm.openebs.io/iqn"
+
+	mayaTimeout = 60 * time.Second
+)
+
+// mayaVolume is a volume as the maya API server's volumes API takes and
+// returns it
+type mayaVolume struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Metadata   mayaVolumeMetadata `json:"metadata"`
+}
+
+type mayaVolumeMetadata struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// mayaClient creates, reads and deletes volumes with the maya API server
+type mayaClient interface {
+	createVolume(volume *mayaVolume) error
+	readVolume(name string) (*mayaVolume, error)
+	deleteVolume(name string) error
+}
+
+type httpMayaClient struct {
+	address string
+	client  *http.Client
+}
+
+var _ mayaClient = &httpMayaClient{}
+
+// newMayaClient returns a mayaClient for the maya API server at address, e.g.
+// http://10.0.0.1:5656
+func newMayaClient(address string) mayaClient {
+	return &httpMayaClient{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{Timeout: mayaTimeout},
+	}
+}
+
+func (c *httpMayaClient) createVolume(volume *mayaVolume) error {
+	body, err := yaml.Marshal(volume)
+	if err != nil {
+		return fmt.Errorf("error encoding volume %q: %v", volume.Metadata.Name, err)
+	}
+	req, err := http.NewRequest("POST", c.address+"/latest/volumes/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+	_, err = c.do(req)
+	if err != nil {
+		return fmt.Errorf("error creating volume %q: %v", volume.Metadata.Name, err)
+	}
+	return nil
+}
+
+func (c *httpMayaClient) readVolume(name string) (*mayaVolume, error) {
+	req, err := http.NewRequest("GET", c.address+"/latest/volumes/info/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading volume %q: %v", name, err)
+	}
+	volume := &mayaVolume{}
+	if err := json.Unmarshal(body, volume); err != nil {
+		return nil, fmt.Errorf("error decoding volume %q: %v", name, err)
+	}
+	return volume, nil
+}
+
+func (c *httpMayaClient) deleteVolume(name string) error {
+	req, err := http.NewRequest("GET", c.address+"/latest/volumes/delete/"+name, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(req); err != nil {
+		return fmt.Errorf("error deleting volume %q: %v", name, err)
+	}
+	return nil
+}
+
+// do sends the request and returns the response's body, or an error with
+// the body if the response's status isn't 2xx
+func (c *httpMayaClient) do(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("maya API server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestMayaClient(t *testing.T) {
+	var created *mayaVolume
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/latest/volumes/":
+			body, _ := ioutil.ReadAll(r.Body)
+			created = &mayaVolume{}
+			if err := yaml.Unmarshal(body, created); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		case r.Method == "GET" && r.URL.Path == "/latest/volumes/info/pvc-1":
+			w.Write([]byte(`{"kind":"PersistentVolume","metadata":{"name":"pvc-1","annotations":{"vsm.openebs.io/iqn":"iqn.2016-09.com.openebs.jiva:pvc-1","vsm.openebs.io/targetportals":"10.0.0.2:3260"}}}`))
+		case r.Method == "GET" && r.URL.Path == "/latest/volumes/delete/pvc-1":
+			deleted = append(deleted, "pvc-1")
+		default:
+			http.Error(w, "volume not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	c := newMayaClient(server.URL + "/")
+
+	volume := &mayaVolume{Kind: "PersistentVolumeClaim", APIVersion: "v1", Metadata: mayaVolumeMetadata{Name: "pvc-1", Labels: map[string]string{sizeLabel: "1G"}}}
+	err := c.createVolume(volume)
+	evaluate(t, "create", false, err, volume, created, "created volume")
+
+	got, err := c.readVolume("pvc-1")
+	expected := &mayaVolume{Kind: "PersistentVolume", Metadata: mayaVolumeMetadata{Name: "pvc-1", Annotations: map[string]string{iqnAnn: "iqn.2016-09.com.openebs.jiva:pvc-1", targetPortalsAnn: "10.0.0.2:3260"}}}
+	evaluate(t, "read", false, err, expected, got, "read volume")
+
+	got, err = c.readVolume("pvc-2")
+	evaluate(t, "read missing volume", true, err, (*mayaVolume)(nil), got, "read volume")
+
+	err = c.deleteVolume("pvc-1")
+	evaluate(t, "delete", false, err, []string{"pvc-1"}, deleted, "deleted volumes")
+
+	err = c.deleteVolume("pvc-2")
+	evaluate(t, "delete missing volume", true, err, []string{"pvc-1"}, deleted, "deleted volumes")
+}
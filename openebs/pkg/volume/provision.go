@@ -0,0 +1,203 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// volumeAnn is set on provisioned PVs to the name of their maya volume
+	volumeAnn = "openebs.io/volume"
+
+	defaultFSType = "ext4"
+
+	gib = 1024 * 1024 * 1024
+)
+
+// openEBSParameters are the options parsed from a StorageClass
+type openEBSParameters struct {
+	// replicaCount is the number of replicas of the volume, 0 for maya's
+	// default
+	replicaCount int
+	// storagePool is the maya storage pool to create the volume's replicas
+	// in, empty for maya's default
+	storagePool string
+	fsType      string
+}
+
+type openEBSProvisioner struct {
+	// maya API server
+	mapi mayaClient
+}
+
+// NewOpenEBSProvisioner creates a Provisioner that provisions iSCSI volumes
+// with the OpenEBS maya API server at mapiAddress, e.g. http://10.0.0.1:5656.
+func NewOpenEBSProvisioner(mapiAddress string) controller.Provisioner {
+	return newOpenEBSProvisionerInternal(newMayaClient(mapiAddress))
+}
+
+func newOpenEBSProvisionerInternal(mapi mayaClient) *openEBSProvisioner {
+	return &openEBSProvisioner{
+		mapi: mapi,
+	}
+}
+
+var _ controller.Provisioner = &openEBSProvisioner{}
+
+// Provision creates a storage asset and returns a PV object representing it.
+func (p *openEBSProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	for _, mode := range options.PVC.Spec.AccessModes {
+		if mode != v1.ReadWriteOnce {
+			return nil, fmt.Errorf("invalid AccessModes %v: only AccessMode %v is supported", options.PVC.Spec.AccessModes, v1.ReadWriteOnce)
+		}
+	}
+	params, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// maya sizes volumes in whole G, round up
+	sizeGiB := (capacity.Value() + gib - 1) / gib
+	if sizeGiB < 1 {
+		sizeGiB = 1
+	}
+
+	labels := map[string]string{
+		sizeLabel: fmt.Sprintf("%dG", sizeGiB),
+	}
+	if params.replicaCount > 0 {
+		labels[replicaCountLabel] = strconv.Itoa(params.replicaCount)
+	}
+	if params.storagePool != "" {
+		labels[storagePoolLabel] = params.storagePool
+	}
+	spec := &mayaVolume{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Metadata: mayaVolumeMetadata{
+			Name:   options.PVName,
+			Labels: labels,
+		},
+	}
+	if err := p.mapi.createVolume(spec); err != nil {
+		return nil, err
+	}
+
+	portal, iqn, err := p.getTarget(options.PVName)
+	if err != nil {
+		// Without a PV nothing would delete the volume
+		if deleteErr := p.mapi.deleteVolume(options.PVName); deleteErr != nil {
+			glog.Errorf("Failed to delete volume %q after failing to get its target: %v", options.PVName, deleteErr)
+		}
+		return nil, err
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				volumeAnn: options.PVName,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", sizeGiB)),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				ISCSI: &v1.ISCSIVolumeSource{
+					TargetPortal: portal,
+					IQN:          iqn,
+					Lun:          0,
+					FSType:       params.fsType,
+					ReadOnly:     false,
+				},
+			},
+		},
+	}
+
+	glog.Infof("successfully created OpenEBS volume %q with target %s %s", options.PVName, portal, iqn)
+
+	return pv, nil
+}
+
+// getTarget returns the iSCSI target portal and IQN of the named volume
+func (p *openEBSProvisioner) getTarget(name string) (string, string, error) {
+	volume, err := p.mapi.readVolume(name)
+	if err != nil {
+		return "", "", err
+	}
+	// The first portal is the target's, others are for multipathing
+	portal := strings.TrimSpace(strings.Split(volume.Metadata.Annotations[targetPortalsAnn], ",")[0])
+	iqn := strings.TrimSpace(volume.Metadata.Annotations[iqnAnn])
+	if portal == "" || iqn == "" {
+		return "", "", fmt.Errorf("volume %q has no iSCSI target: annotations %s and %s must be set, got %v", name, targetPortalsAnn, iqnAnn, volume.Metadata.Annotations)
+	}
+	return portal, iqn, nil
+}
+
+// Delete removes the storage asset that was created by Provision represented
+// by the given PV.
+func (p *openEBSProvisioner) Delete(volume *v1.PersistentVolume) error {
+	name, ok := volume.Annotations[volumeAnn]
+	if !ok {
+		return errors.New("OpenEBS volume annotation not found on PV")
+	}
+
+	if err := p.mapi.deleteVolume(name); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseParameters(parameters map[string]string) (*openEBSParameters, error) {
+	params := &openEBSParameters{
+		fsType: defaultFSType,
+	}
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "replicacount":
+			count, err := strconv.Atoi(v)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid value %q for parameter replicaCount: must be a positive integer", v)
+			}
+			params.replicaCount = count
+		case "storagepool":
+			params.storagePool = v
+		case "fstype":
+			params.fsType = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	return params, nil
+}
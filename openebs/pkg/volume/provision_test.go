@@ -0,0 +1,243 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name             string
+		parameters       map[string]string
+		capacity         string
+		accessModes      []v1.PersistentVolumeAccessMode
+		annotations      map[string]string
+		createErr        error
+		readErr          error
+		expectedLabels   map[string]string
+		expectedSource   v1.PersistentVolumeSource
+		expectedCapacity string
+		expectDelete     bool
+		expectError      bool
+	}{
+		{
+			name:             "succeed with defaults, rounding up capacity",
+			capacity:         "1500Mi",
+			expectedLabels:   map[string]string{sizeLabel: "2G"},
+			expectedSource:   v1.PersistentVolumeSource{ISCSI: &v1.ISCSIVolumeSource{TargetPortal: "10.0.0.2:3260", IQN: "iqn.2016-09.com.openebs.jiva:pvc-1", FSType: "ext4"}},
+			expectedCapacity: "2Gi",
+		},
+		{
+			name:             "succeed with replica count, storage pool and fs type",
+			parameters:       map[string]string{"replicaCount": "3", "storagePool": "ssd", "fsType": "xfs"},
+			expectedLabels:   map[string]string{sizeLabel: "1G", replicaCountLabel: "3", storagePoolLabel: "ssd"},
+			expectedSource:   v1.PersistentVolumeSource{ISCSI: &v1.ISCSIVolumeSource{TargetPortal: "10.0.0.2:3260", IQN: "iqn.2016-09.com.openebs.jiva:pvc-1", FSType: "xfs"}},
+			expectedCapacity: "1Gi",
+		},
+		{
+			name:        "zero replica count",
+			parameters:  map[string]string{"replicaCount": "0"},
+			expectError: true,
+		},
+		{
+			name:        "bad replica count",
+			parameters:  map[string]string{"replicaCount": "three"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"foo": "bar"},
+			expectError: true,
+		},
+		{
+			name:        "read-write-many claim",
+			accessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			expectError: true,
+		},
+		{
+			name:        "create fails",
+			createErr:   errors.New("fake error"),
+			expectError: true,
+		},
+		{
+			name:         "read fails",
+			readErr:      errors.New("fake error"),
+			expectDelete: true,
+			expectError:  true,
+		},
+		{
+			name:         "volume has no target",
+			annotations:  map[string]string{targetPortalsAnn: "10.0.0.2:3260"},
+			expectDelete: true,
+			expectError:  true,
+		},
+	}
+	for _, test := range tests {
+		c := &testMayaClient{createErr: test.createErr, readErr: test.readErr, annotations: test.annotations}
+		p := newOpenEBSProvisionerInternal(c)
+
+		capacity := resource.MustParse("1Gi")
+		if test.capacity != "" {
+			capacity = resource.MustParse(test.capacity)
+		}
+		options := newOptions("pvc-1", capacity)
+		options.Parameters = test.parameters
+		if test.accessModes != nil {
+			options.PVC.Spec.AccessModes = test.accessModes
+		}
+		pv, err := p.Provision(options)
+
+		evaluate(t, test.name, false, nil, test.expectDelete, len(c.deleted) == 1, "cleanup delete")
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, pv == nil, "nil pv")
+			continue
+		}
+		evaluate(t, test.name, false, err, "pvc-1", c.created.Metadata.Name, "created volume name")
+		evaluate(t, test.name, false, err, test.expectedLabels, c.created.Metadata.Labels, "created volume labels")
+		evaluate(t, test.name, false, err, "pvc-1", pv.Name, "pv name")
+		evaluate(t, test.name, false, err, "pvc-1", pv.Annotations[volumeAnn], "volume annotation")
+		evaluate(t, test.name, false, err, test.expectedSource, pv.Spec.PersistentVolumeSource, "volume source")
+		pvCapacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		evaluate(t, test.name, false, err, test.expectedCapacity, pvCapacity.String(), "capacity")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		deleteErr    error
+		expectedName string
+		expectError  bool
+	}{
+		{
+			name:         "succeed",
+			annotations:  map[string]string{volumeAnn: "pvc-1"},
+			expectedName: "pvc-1",
+		},
+		{
+			name:        "no volume annotation",
+			expectError: true,
+		},
+		{
+			name:        "delete fails",
+			annotations: map[string]string{volumeAnn: "pvc-1"},
+			deleteErr:   errors.New("fake error"),
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		c := &testMayaClient{deleteErr: test.deleteErr}
+		p := newOpenEBSProvisionerInternal(c)
+
+		err := p.Delete(&v1.PersistentVolume{
+			ObjectMeta: v1.ObjectMeta{Name: "pvc-1", Annotations: test.annotations},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{ISCSI: &v1.ISCSIVolumeSource{TargetPortal: "10.0.0.2:3260", IQN: "iqn.2016-09.com.openebs.jiva:pvc-1"}},
+			},
+		})
+
+		if test.expectError {
+			evaluate(t, test.name, true, err, 0, len(c.deleted), "deleted volumes")
+			continue
+		}
+		evaluate(t, test.name, false, err, []string{test.expectedName}, c.deleted, "deleted volumes")
+	}
+}
+
+func newOptions(pvName string, capacity resource.Quantity) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{Name: "claim-1", Namespace: v1.NamespaceDefault},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): capacity,
+					},
+				},
+			},
+		},
+		Parameters: map[string]string{},
+	}
+}
+
+// testMayaClient is a mayaClient whose volumes get the target annotations,
+// unless others are given
+type testMayaClient struct {
+	createErr   error
+	readErr     error
+	deleteErr   error
+	annotations map[string]string
+
+	created *mayaVolume
+	deleted []string
+}
+
+var _ mayaClient = &testMayaClient{}
+
+func (c *testMayaClient) createVolume(volume *mayaVolume) error {
+	if c.createErr != nil {
+		return c.createErr
+	}
+	c.created = volume
+	return nil
+}
+
+func (c *testMayaClient) readVolume(name string) (*mayaVolume, error) {
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	annotations := c.annotations
+	if annotations == nil {
+		annotations = map[string]string{
+			targetPortalsAnn: "10.0.0.2:3260,10.0.0.3:3260",
+			iqnAnn:           "iqn.2016-09.com.openebs.jiva:" + name,
+		}
+	}
+	return &mayaVolume{Metadata: mayaVolumeMetadata{Name: name, Annotations: annotations}}, nil
+}
+
+func (c *testMayaClient) deleteVolume(name string) error {
+	if c.deleteErr != nil {
+		return c.deleteErr
+	}
+	c.deleted = append(c.deleted, name)
+	return nil
+}
+
+func evaluate(t *testing.T, name string, expectError bool, err error, expected interface{}, got interface{}, output string) {
+	if !expectError && err != nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("unexpected error getting %s: %v", output, err)
+	} else if expectError && err == nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected error but got %s: %v", output, got)
+	} else if !reflect.DeepEqual(expected, got) {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
+	}
+}
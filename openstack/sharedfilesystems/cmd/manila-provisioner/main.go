@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
+	"github.com/kubernetes-incubator/external-storage/openstack/sharedfilesystems/pkg/volume"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner          = flag.String("provisioner", "openstack.org/manila", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master               = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig           = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+	region               = flag.String("os-region", os.Getenv("OS_REGION_NAME"), "OpenStack region of the Manila endpoint to use. Defaults to the OS_REGION_NAME environment variable.")
+	identity             = flag.String("identity", "", "Identity the provisioner annotates the PVs it provisions with, to recognize them as its own, including after restarts. Provisioners of different Manila endpoints with the same provisioner name must have different identities. Defaults to the provisioner name.")
+	failedRetryThreshold = flag.Int("failed-retry-threshold", 10, "If the number of retries on provisioning failure need to be limited to a set number of attempts. Default 10")
+)
+
+const (
+	resyncPeriod              = 15 * time.Second
+	exponentialBackOffOnError = true
+	leasePeriod               = leaderelection.DefaultLeaseDuration
+	retryPeriod               = leaderelection.DefaultRetryPeriod
+	renewDeadline             = leaderelection.DefaultRenewDeadline
+	termLimit                 = leaderelection.DefaultTermLimit
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Authenticate to OpenStack with the OS_* environment variables
+	shareClient, err := volume.NewShareClient(*region)
+	if err != nil {
+		glog.Fatalf("Failed to create Manila client: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	if *identity == "" {
+		*identity = *provisioner
+	}
+	manilaProvisioner := volume.NewManilaProvisioner(clientset, shareClient, *identity)
+
+	// Start the provision controller which will dynamically provision Manila
+	// PVs
+	pc := controller.NewProvisionController(clientset, resyncPeriod, *provisioner, manilaProvisioner, serverVersion.GitVersion, exponentialBackOffOnError, *failedRetryThreshold, leasePeriod, renewDeadline, retryPeriod, termLimit)
+	pc.Run(wait.NeverStop)
+}
@@ -0,0 +1,392 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/types"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+const (
+	provisionerIDAnn = "manilaProvisionerIdentity"
+	manilaShareAnn   = "manilaShareID"
+
+	protocolNFS    = "NFS"
+	protocolCephFS = "CEPHFS"
+
+	// How long to wait for a share or an access rule to become usable and how
+	// often to check
+	shareReadyTimeout      = 2 * time.Minute
+	shareReadyPollInterval = 2 * time.Second
+
+	gib = 1024 * 1024 * 1024
+)
+
+// manilaParameters are the options parsed from a StorageClass
+type manilaParameters struct {
+	shareType    string
+	protocol     string
+	shareNetwork string
+	// zone is the availability zone to create shares in. If set it is also
+	// added to provisioned PVs as a failure-domain label.
+	zone string
+	// nfsShareClient is who NFS shares are exported to, as an address or CIDR
+	nfsShareClient string
+}
+
+type manilaProvisioner struct {
+	// Kubernetes Client. Use to create & delete CephFS secrets
+	client kubernetes.Interface
+	// Manila API
+	shares shareService
+	// Identity of this manilaProvisioner, stable across restarts. Used to
+	// identify "this" provisioner's PVs.
+	identity types.UID
+
+	shareReadyTimeout      time.Duration
+	shareReadyPollInterval time.Duration
+}
+
+// NewManilaProvisioner creates a Provisioner that provisions OpenStack Manila
+// shares using the Manila API client shareClient, e.g. from NewShareClient.
+// identity must be the same every time the provisioner runs, so that it
+// recognizes the PVs it provisioned, and differ between provisioners of
+// different Manila endpoints.
+func NewManilaProvisioner(client kubernetes.Interface, shareClient *gophercloud.ServiceClient, identity string) controller.Provisioner {
+	return newManilaProvisionerInternal(client, &manilaShareService{client: shareClient}, identity)
+}
+
+func newManilaProvisionerInternal(client kubernetes.Interface, shares shareService, identity string) *manilaProvisioner {
+	return &manilaProvisioner{
+		client:   client,
+		shares:   shares,
+		identity: types.UID(identity),
+
+		shareReadyTimeout:      shareReadyTimeout,
+		shareReadyPollInterval: shareReadyPollInterval,
+	}
+}
+
+var _ controller.Provisioner = &manilaProvisioner{}
+
+// Provision creates a storage asset and returns a PV object representing it.
+func (p *manilaProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	params, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// Manila sizes shares in GiB, round up
+	sizeGiB := int((capacity.Value() + gib - 1) / gib)
+	if sizeGiB < 1 {
+		sizeGiB = 1
+	}
+
+	share, err := p.shares.create(shares.CreateOpts{
+		ShareProto:       params.protocol,
+		Size:             sizeGiB,
+		Name:             options.PVName,
+		Description:      fmt.Sprintf("Created for claim %s/%s", options.PVC.Namespace, options.PVC.Name),
+		ShareType:        params.shareType,
+		ShareNetworkID:   params.shareNetwork,
+		AvailabilityZone: params.zone,
+		Metadata: map[string]string{
+			"kubernetes.io/created-for/pv/name":       options.PVName,
+			"kubernetes.io/created-for/pvc/namespace": options.PVC.Namespace,
+			"kubernetes.io/created-for/pvc/name":      options.PVC.Name,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share: %v", err)
+	}
+
+	source, err := p.setupShare(share.ID, params, options.PVC.Namespace)
+	if err != nil {
+		if deleteErr := p.shares.delete(share.ID); deleteErr != nil {
+			glog.Errorf("failed to delete share %s after failing to set it up: %v", share.ID, deleteErr)
+		}
+		return nil, err
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: string(p.identity),
+				manilaShareAnn:   share.ID,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+				v1.ReadOnlyMany,
+				v1.ReadWriteMany,
+			},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", sizeGiB)),
+			},
+			PersistentVolumeSource: *source,
+		},
+	}
+	if params.zone != "" {
+		pv.Labels = map[string]string{unversioned.LabelZoneFailureDomain: params.zone}
+	}
+
+	glog.Infof("successfully created Manila %s share %s for claim %s/%s", params.protocol, share.ID, options.PVC.Namespace, options.PVC.Name)
+
+	return pv, nil
+}
+
+// setupShare waits for the share to become available, grants access to it
+// and returns the volume source for mounting it. For CephFS the cephx key the
+// source refers to is saved in a secret in the claim's namespace.
+func (p *manilaProvisioner) setupShare(shareID string, params *manilaParameters, namespace string) (*v1.PersistentVolumeSource, error) {
+	if err := p.waitForShare(shareID); err != nil {
+		return nil, err
+	}
+
+	locations, err := p.shares.exportLocations(shareID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export locations of share %s: %v", shareID, err)
+	}
+	location, err := chooseExportLocation(locations)
+	if err != nil {
+		return nil, fmt.Errorf("share %s: %v", shareID, err)
+	}
+
+	switch params.protocol {
+	case protocolNFS:
+		if _, err := p.grantAndWait(shareID, shares.GrantAccessOpts{AccessType: "ip", AccessTo: params.nfsShareClient, AccessLevel: "rw"}); err != nil {
+			return nil, err
+		}
+		server, path, err := splitExportLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		return &v1.PersistentVolumeSource{
+			NFS: &v1.NFSVolumeSource{
+				Server: server,
+				Path:   path,
+			},
+		}, nil
+	case protocolCephFS:
+		user := "kubernetes-dynamic-user-" + shareID
+		rule, err := p.grantAndWait(shareID, shares.GrantAccessOpts{AccessType: "cephx", AccessTo: user, AccessLevel: "rw"})
+		if err != nil {
+			return nil, err
+		}
+		monitors, path, err := splitExportLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		secretName := "manila-" + shareID + "-secret"
+		secret := &v1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace: namespace,
+				Name:      secretName,
+			},
+			Data: map[string][]byte{
+				"key": []byte(rule.AccessKey),
+			},
+			Type: "Opaque",
+		}
+		if _, err := p.client.Core().Secrets(namespace).Create(secret); err != nil {
+			return nil, fmt.Errorf("failed to create secret %s/%s: %v", namespace, secretName, err)
+		}
+		return &v1.PersistentVolumeSource{
+			CephFS: &v1.CephFSVolumeSource{
+				Monitors: strings.Split(monitors, ","),
+				Path:     path,
+				User:     user,
+				SecretRef: &v1.LocalObjectReference{
+					Name: secretName,
+				},
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported protocol %q", params.protocol)
+}
+
+// waitForShare waits for the share's status to become available
+func (p *manilaProvisioner) waitForShare(shareID string) error {
+	err := wait.Poll(p.shareReadyPollInterval, p.shareReadyTimeout, func() (bool, error) {
+		share, err := p.shares.get(shareID)
+		if err != nil {
+			return false, err
+		}
+		switch share.Status {
+		case "available":
+			return true, nil
+		case "error":
+			return false, errors.New("share status is error")
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for share %s to become available: %v", shareID, err)
+	}
+	return nil
+}
+
+// grantAndWait grants access to the share and waits for the access rule to
+// become active and, for cephx rules, to have a key
+func (p *manilaProvisioner) grantAndWait(shareID string, opts shares.GrantAccessOpts) (*shares.AccessRight, error) {
+	rule, err := p.shares.grantAccess(shareID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to grant %s access to share %s: %v", opts.AccessType, shareID, err)
+	}
+
+	var activeRule *shares.AccessRight
+	err = wait.Poll(p.shareReadyPollInterval, p.shareReadyTimeout, func() (bool, error) {
+		rules, err := p.shares.accessRights(shareID)
+		if err != nil {
+			return false, err
+		}
+		for i := range rules {
+			if rules[i].ID != rule.ID {
+				continue
+			}
+			switch rules[i].State {
+			case "active":
+				if opts.AccessType == "cephx" && rules[i].AccessKey == "" {
+					return false, nil
+				}
+				activeRule = &rules[i]
+				return true, nil
+			case "error":
+				return false, errors.New("access rule state is error")
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for %s access rule of share %s to become active: %v", opts.AccessType, shareID, err)
+	}
+	return activeRule, nil
+}
+
+// chooseExportLocation returns the path of the export location clients should
+// use: a preferred one if any, else the first one not for admins only
+func chooseExportLocation(locations []shares.ExportLocation) (string, error) {
+	path := ""
+	for _, location := range locations {
+		if location.IsAdminOnly {
+			continue
+		}
+		if location.Preferred {
+			return location.Path, nil
+		}
+		if path == "" {
+			path = location.Path
+		}
+	}
+	if path == "" {
+		return "", errors.New("no export location usable by clients")
+	}
+	return path, nil
+}
+
+// splitExportLocation splits an export location like "server:/path", or for
+// CephFS "mon1:6789,mon2:6789:/path", into the server(s) and the path
+func splitExportLocation(location string) (string, string, error) {
+	i := strings.Index(location, ":/")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid export location %q", location)
+	}
+	return location[:i], location[i+1:], nil
+}
+
+// Delete removes the storage asset that was created by Provision represented
+// by the given PV.
+func (p *manilaProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != string(p.identity) {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	shareID, ok := volume.Annotations[manilaShareAnn]
+	if !ok {
+		return errors.New("manila share annotation not found on PV")
+	}
+
+	if err := p.shares.delete(shareID); err != nil {
+		return fmt.Errorf("failed to delete share %s: %v", shareID, err)
+	}
+
+	if cephFS := volume.Spec.PersistentVolumeSource.CephFS; cephFS != nil && cephFS.SecretRef != nil && volume.Spec.ClaimRef != nil {
+		namespace := volume.Spec.ClaimRef.Namespace
+		err := p.client.Core().Secrets(namespace).Delete(cephFS.SecretRef.Name, nil)
+		if err != nil {
+			glog.Warningf("failed to delete secret %s/%s of deleted share %s: %v", namespace, cephFS.SecretRef.Name, shareID, err)
+		}
+	}
+
+	return nil
+}
+
+func parseParameters(parameters map[string]string) (*manilaParameters, error) {
+	params := &manilaParameters{
+		shareType: "default",
+		protocol:  protocolNFS,
+	}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "type":
+			params.shareType = v
+		case "protocol":
+			params.protocol = strings.ToUpper(v)
+		case "sharenetwork":
+			params.shareNetwork = v
+		case "zone":
+			params.zone = v
+		case "nfsshareclient":
+			params.nfsShareClient = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	if params.protocol != protocolNFS && params.protocol != protocolCephFS {
+		return nil, fmt.Errorf("invalid protocol %q, must be %s or %s", params.protocol, protocolNFS, protocolCephFS)
+	}
+	// Not defaulted, so that no share is exported to more nodes than the
+	// admin chose to
+	if params.protocol == protocolNFS && params.nfsShareClient == "" {
+		return nil, fmt.Errorf("missing parameter nfsShareClient, the address or CIDR of the nodes that may mount %s shares", protocolNFS)
+	}
+	return params, nil
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name             string
+		parameters       map[string]string
+		capacity         string
+		shareStatus      string
+		locations        []shares.ExportLocation
+		ruleState        string
+		createErr        error
+		expectedSource   v1.PersistentVolumeSource
+		expectedCapacity string
+		expectedLabels   map[string]string
+		expectedSecret   string
+		expectDelete     bool
+		expectError      bool
+	}{
+		{
+			name:        "succeed with nfs",
+			parameters:  map[string]string{"zone": "nova", "nfsShareClient": "10.0.0.0/24"},
+			capacity:    "1Gi",
+			shareStatus: "available",
+			locations: []shares.ExportLocation{
+				{Path: "10.0.0.1:/admin", IsAdminOnly: true},
+				{Path: "10.0.0.1:/shares/share-1"},
+			},
+			ruleState:        "active",
+			expectedSource:   v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "10.0.0.1", Path: "/shares/share-1"}},
+			expectedCapacity: "1Gi",
+			expectedLabels:   map[string]string{unversioned.LabelZoneFailureDomain: "nova"},
+		},
+		{
+			name:        "succeed with cephfs, preferring the preferred location and rounding up capacity",
+			parameters:  map[string]string{"protocol": "cephfs"},
+			capacity:    "1500Mi",
+			shareStatus: "available",
+			locations: []shares.ExportLocation{
+				{Path: "10.0.0.1:6789:/volumes/other"},
+				{Path: "10.0.0.1:6789,10.0.0.2:6789:/volumes/_nogroup/share-1", Preferred: true},
+			},
+			ruleState: "active",
+			expectedSource: v1.PersistentVolumeSource{CephFS: &v1.CephFSVolumeSource{
+				Monitors:  []string{"10.0.0.1:6789", "10.0.0.2:6789"},
+				Path:      "/volumes/_nogroup/share-1",
+				User:      "kubernetes-dynamic-user-share-1",
+				SecretRef: &v1.LocalObjectReference{Name: "manila-share-1-secret"},
+			}},
+			expectedCapacity: "2Gi",
+			expectedSecret:   "manila-share-1-secret",
+		},
+		{
+			name:        "bad parameter",
+			parameters:  map[string]string{"foo": "bar"},
+			expectError: true,
+		},
+		{
+			name:        "nfs without nfsShareClient",
+			parameters:  map[string]string{"zone": "nova"},
+			expectError: true,
+		},
+		{
+			name:        "bad protocol",
+			parameters:  map[string]string{"protocol": "smb"},
+			expectError: true,
+		},
+		{
+			name:        "create fails",
+			parameters:  map[string]string{"nfsShareClient": "10.0.0.0/24"},
+			capacity:    "1Gi",
+			createErr:   errors.New("fake error"),
+			expectError: true,
+		},
+		{
+			name:         "share errors",
+			parameters:   map[string]string{"nfsShareClient": "10.0.0.0/24"},
+			capacity:     "1Gi",
+			shareStatus:  "error",
+			expectDelete: true,
+			expectError:  true,
+		},
+		{
+			name:         "no usable export location",
+			parameters:   map[string]string{"nfsShareClient": "10.0.0.0/24"},
+			capacity:     "1Gi",
+			shareStatus:  "available",
+			locations:    []shares.ExportLocation{{Path: "10.0.0.1:/admin", IsAdminOnly: true}},
+			expectDelete: true,
+			expectError:  true,
+		},
+		{
+			name:         "access rule errors",
+			parameters:   map[string]string{"nfsShareClient": "10.0.0.0/24"},
+			capacity:     "1Gi",
+			shareStatus:  "available",
+			locations:    []shares.ExportLocation{{Path: "10.0.0.1:/shares/share-1"}},
+			ruleState:    "error",
+			expectDelete: true,
+			expectError:  true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		s := &testShareService{status: test.shareStatus, locations: test.locations, ruleState: test.ruleState, createErr: test.createErr}
+		p := newTestManilaProvisioner(client, s)
+
+		capacity := resource.MustParse("1Gi")
+		if test.capacity != "" {
+			capacity = resource.MustParse(test.capacity)
+		}
+		options := newOptions("pvc-1", capacity)
+		options.Parameters = test.parameters
+		pv, err := p.Provision(options)
+
+		evaluate(t, test.name, false, nil, test.expectDelete, len(s.deleted) == 1, "cleanup delete")
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, pv == nil, "nil pv")
+			continue
+		}
+		evaluate(t, test.name, false, err, "pvc-1", pv.Name, "pv name")
+		evaluate(t, test.name, false, err, test.expectedSource, pv.Spec.PersistentVolumeSource, "volume source")
+		pvCapacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		evaluate(t, test.name, false, err, test.expectedCapacity, pvCapacity.String(), "capacity")
+		evaluate(t, test.name, false, err, test.expectedLabels, pv.Labels, "labels")
+		evaluate(t, test.name, false, err, "share-1", pv.Annotations[manilaShareAnn], "share annotation")
+		if test.expectedSecret != "" {
+			secret, err := client.Core().Secrets(v1.NamespaceDefault).Get(test.expectedSecret)
+			evaluate(t, test.name, false, err, "key-share-1", string(secret.Data["key"]), "secret key")
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	p := newTestManilaProvisioner(fake.NewSimpleClientset(), &testShareService{})
+
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		deleteErr     error
+		expectIgnored bool
+		expectError   bool
+	}{
+		{
+			name:        "succeed",
+			annotations: map[string]string{provisionerIDAnn: string(p.identity), manilaShareAnn: "share-1"},
+		},
+		{
+			name:          "another provisioner's pv",
+			annotations:   map[string]string{provisionerIDAnn: "foo", manilaShareAnn: "share-1"},
+			expectIgnored: true,
+			expectError:   true,
+		},
+		{
+			name:        "no share annotation",
+			annotations: map[string]string{provisionerIDAnn: string(p.identity)},
+			expectError: true,
+		},
+		{
+			name:        "delete fails",
+			annotations: map[string]string{provisionerIDAnn: string(p.identity), manilaShareAnn: "share-1"},
+			deleteErr:   errors.New("fake error"),
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p.shares = &testShareService{deleteErr: test.deleteErr}
+
+		err := p.Delete(&v1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "pvc-1", Annotations: test.annotations}})
+
+		_, ignored := err.(*controller.IgnoredError)
+		evaluate(t, test.name, test.expectError, err, test.expectIgnored, ignored, "ignored")
+	}
+}
+
+func TestSplitExportLocation(t *testing.T) {
+	tests := []struct {
+		location       string
+		expectedServer string
+		expectedPath   string
+		expectError    bool
+	}{
+		{location: "10.0.0.1:/shares/share-1", expectedServer: "10.0.0.1", expectedPath: "/shares/share-1"},
+		{location: "[fd00::1]:/shares/share-1", expectedServer: "[fd00::1]", expectedPath: "/shares/share-1"},
+		{location: "10.0.0.1:6789,10.0.0.2:6789:/volumes/share-1", expectedServer: "10.0.0.1:6789,10.0.0.2:6789", expectedPath: "/volumes/share-1"},
+		{location: "/shares/share-1", expectError: true},
+	}
+	for _, test := range tests {
+		server, path, err := splitExportLocation(test.location)
+		evaluate(t, test.location, test.expectError, err, test.expectedServer+" "+test.expectedPath, server+" "+path, "server and path")
+	}
+}
+
+func newTestManilaProvisioner(client *fake.Clientset, s shareService) *manilaProvisioner {
+	p := newManilaProvisionerInternal(client, s, "manila-test")
+	p.shareReadyTimeout = 100 * time.Millisecond
+	p.shareReadyPollInterval = time.Millisecond
+	return p
+}
+
+func newOptions(pvName string, capacity resource.Quantity) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{Name: "claim-1", Namespace: v1.NamespaceDefault},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): capacity,
+					},
+				},
+			},
+		},
+		Parameters: map[string]string{},
+	}
+}
+
+// testShareService is a shareService whose shares are all "share-1"
+type testShareService struct {
+	status    string
+	locations []shares.ExportLocation
+	ruleState string
+	createErr error
+	deleteErr error
+
+	rule    *shares.AccessRight
+	deleted []string
+}
+
+var _ shareService = &testShareService{}
+
+func (s *testShareService) create(opts shares.CreateOpts) (*shares.Share, error) {
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	return &shares.Share{ID: "share-1", Status: "creating", ShareProto: opts.ShareProto, Size: opts.Size}, nil
+}
+
+func (s *testShareService) get(id string) (*shares.Share, error) {
+	return &shares.Share{ID: id, Status: s.status}, nil
+}
+
+func (s *testShareService) delete(id string) error {
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+func (s *testShareService) exportLocations(id string) ([]shares.ExportLocation, error) {
+	return s.locations, nil
+}
+
+func (s *testShareService) grantAccess(id string, opts shares.GrantAccessOpts) (*shares.AccessRight, error) {
+	s.rule = &shares.AccessRight{ID: "rule-1", ShareID: id, AccessType: opts.AccessType, AccessTo: opts.AccessTo, AccessLevel: opts.AccessLevel, State: "new"}
+	return s.rule, nil
+}
+
+func (s *testShareService) accessRights(id string) ([]shares.AccessRight, error) {
+	if s.rule == nil {
+		return nil, nil
+	}
+	rule := *s.rule
+	rule.State = s.ruleState
+	if rule.AccessType == "cephx" {
+		rule.AccessKey = "key-" + id
+	}
+	return []shares.AccessRight{rule}, nil
+}
+
+func evaluate(t *testing.T, name string, expectError bool, err error, expected interface{}, got interface{}, output string) {
+	if !expectError && err != nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("unexpected error getting %s: %v", output, err)
+	} else if expectError && err == nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected error but got %s: %v", output, got)
+	} else if !reflect.DeepEqual(expected, got) {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
+	}
+}
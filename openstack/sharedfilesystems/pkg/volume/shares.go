@@ -0,0 +1,102 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+)
+
+// The Manila API microversion the provisioner speaks: 2.14 is needed for the
+// export location API and 2.21 for cephx access keys in access rules
+const manilaMicroversion = "2.21"
+
+// shareService is the part of the Manila API the provisioner uses
+type shareService interface {
+	create(opts shares.CreateOpts) (*shares.Share, error)
+	get(id string) (*shares.Share, error)
+	delete(id string) error
+	exportLocations(id string) ([]shares.ExportLocation, error)
+	grantAccess(id string, opts shares.GrantAccessOpts) (*shares.AccessRight, error)
+	accessRights(id string) ([]shares.AccessRight, error)
+}
+
+// NewShareClient returns a client of the Manila API in region, authenticated
+// with the OS_* environment variables understood by the OpenStack CLIs, e.g.
+// OS_AUTH_URL, OS_USERNAME, OS_PASSWORD and OS_TENANT_NAME.
+func NewShareClient(region string) (*gophercloud.ServiceClient, error) {
+	authOptions, err := openstack.AuthOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// Tokens expire, so let gophercloud authenticate again when they do
+	authOptions.AllowReauth = true
+	provider, err := openstack.AuthenticatedClient(authOptions)
+	if err != nil {
+		return nil, err
+	}
+	client, err := openstack.NewSharedFileSystemV2(provider, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	client.Microversion = manilaMicroversion
+	return client, nil
+}
+
+// manilaShareService is a shareService that calls the Manila API
+type manilaShareService struct {
+	client *gophercloud.ServiceClient
+}
+
+var _ shareService = &manilaShareService{}
+
+func (s *manilaShareService) create(opts shares.CreateOpts) (*shares.Share, error) {
+	return shares.Create(s.client, opts).Extract()
+}
+
+func (s *manilaShareService) get(id string) (*shares.Share, error) {
+	return shares.Get(s.client, id).Extract()
+}
+
+func (s *manilaShareService) delete(id string) error {
+	err := shares.Delete(s.client, id).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
+	}
+	return err
+}
+
+func (s *manilaShareService) exportLocations(id string) ([]shares.ExportLocation, error) {
+	return shares.GetExportLocations(s.client, id).Extract()
+}
+
+func (s *manilaShareService) grantAccess(id string, opts shares.GrantAccessOpts) (*shares.AccessRight, error) {
+	return shares.GrantAccess(s.client, id, opts).Extract()
+}
+
+// accessRights lists the share's access rules. gophercloud has no call for
+// the access_list action yet, so it is made directly.
+func (s *manilaShareService) accessRights(id string) ([]shares.AccessRight, error) {
+	var body struct {
+		AccessList []shares.AccessRight `json:"access_list"`
+	}
+	_, err := s.client.Post(s.client.ServiceURL("shares", id, "action"), map[string]interface{}{"access_list": nil}, &body, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	return body.AccessList, err
+}
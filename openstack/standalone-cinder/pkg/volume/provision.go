@@ -0,0 +1,333 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/wait"
+)
+
+const (
+	cinderVolumeAnn = "cinderVolumeID"
+
+	driverISCSI = "iscsi"
+	driverRBD   = "rbd"
+
+	// How long to wait for a volume to become available and how often to
+	// check
+	volumeReadyTimeout      = 2 * time.Minute
+	volumeReadyPollInterval = 2 * time.Second
+
+	gib = 1024 * 1024 * 1024
+)
+
+// cinderParameters are the options parsed from a StorageClass
+type cinderParameters struct {
+	volumeType string
+	// zone is the availability zone to create volumes in. If set it is also
+	// added to provisioned PVs as a failure-domain label.
+	zone   string
+	fsType string
+	// rbdSecretName is the name of the secret, in the claim's namespace, with
+	// the key of the cephx user Cinder returns for RBD volumes
+	rbdSecretName string
+}
+
+type cinderProvisioner struct {
+	// Cinder API
+	volumes volumeService
+	// The connector volumes are connected to. All nodes share it, since Cinder
+	// doesn't know them.
+	connector connector
+
+	volumeReadyTimeout      time.Duration
+	volumeReadyPollInterval time.Duration
+}
+
+// NewCinderProvisioner creates a Provisioner that provisions OpenStack Cinder
+// volumes using the Cinder API client volumeClient, e.g. from
+// NewVolumeClient, for nodes outside OpenStack. Volumes are connected to the
+// host named host and, for iSCSI backends, the initiator, which must be the
+// initiator name of every node that mounts them.
+func NewCinderProvisioner(volumeClient *gophercloud.ServiceClient, host, initiator string) controller.Provisioner {
+	return newCinderProvisionerInternal(&cinderVolumeService{client: volumeClient}, host, initiator)
+}
+
+func newCinderProvisionerInternal(volumes volumeService, host, initiator string) *cinderProvisioner {
+	return &cinderProvisioner{
+		volumes: volumes,
+		connector: connector{
+			Host:      host,
+			Initiator: initiator,
+			Platform:  "x86_64",
+			OSType:    "linux2",
+		},
+
+		volumeReadyTimeout:      volumeReadyTimeout,
+		volumeReadyPollInterval: volumeReadyPollInterval,
+	}
+}
+
+var _ controller.Provisioner = &cinderProvisioner{}
+
+// Provision creates a storage asset and returns a PV object representing it.
+func (p *cinderProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	params, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// Cinder sizes volumes in GiB, round up
+	sizeGiB := int((capacity.Value() + gib - 1) / gib)
+	if sizeGiB < 1 {
+		sizeGiB = 1
+	}
+
+	volume, err := p.volumes.create(createOpts{
+		Size:             sizeGiB,
+		Name:             options.PVName,
+		Description:      fmt.Sprintf("Created for claim %s/%s", options.PVC.Namespace, options.PVC.Name),
+		VolumeType:       params.volumeType,
+		AvailabilityZone: params.zone,
+		Metadata: map[string]string{
+			"kubernetes.io/created-for/pv/name":       options.PVName,
+			"kubernetes.io/created-for/pvc/namespace": options.PVC.Namespace,
+			"kubernetes.io/created-for/pvc/name":      options.PVC.Name,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %v", err)
+	}
+
+	source, err := p.connect(volume.ID, params)
+	if err != nil {
+		if deleteErr := p.volumes.delete(volume.ID); deleteErr != nil {
+			glog.Errorf("failed to delete volume %s after failing to connect it: %v", volume.ID, deleteErr)
+		}
+		return nil, err
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				cinderVolumeAnn: volume.ID,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+				v1.ReadOnlyMany,
+			},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", sizeGiB)),
+			},
+			PersistentVolumeSource: *source,
+		},
+	}
+	if params.zone != "" {
+		pv.Labels = map[string]string{unversioned.LabelZoneFailureDomain: params.zone}
+	}
+
+	glog.Infof("successfully created Cinder volume %s for claim %s/%s", volume.ID, options.PVC.Namespace, options.PVC.Name)
+
+	return pv, nil
+}
+
+// connect waits for the volume to become available, connects it to the
+// provisioner's connector and returns the volume source for mounting it. A
+// volume that fails to connect is left available, for deleting.
+func (p *cinderProvisioner) connect(volumeID string, params *cinderParameters) (*v1.PersistentVolumeSource, error) {
+	if err := p.waitForVolume(volumeID); err != nil {
+		return nil, err
+	}
+
+	if err := p.volumes.reserve(volumeID); err != nil {
+		return nil, fmt.Errorf("failed to reserve volume %s: %v", volumeID, err)
+	}
+	info, err := p.volumes.initializeConnection(volumeID, p.connector)
+	if err != nil {
+		p.unreserve(volumeID)
+		return nil, fmt.Errorf("failed to initialize connection of volume %s: %v", volumeID, err)
+	}
+	source, err := connectionSource(info, params)
+	if err == nil {
+		err = p.volumes.attach(volumeID, p.connector)
+	}
+	if err != nil {
+		if terminateErr := p.volumes.terminateConnection(volumeID, p.connector); terminateErr != nil {
+			glog.Errorf("failed to terminate connection of volume %s: %v", volumeID, terminateErr)
+		}
+		p.unreserve(volumeID)
+		return nil, fmt.Errorf("failed to connect volume %s: %v", volumeID, err)
+	}
+	return source, nil
+}
+
+func (p *cinderProvisioner) unreserve(volumeID string) {
+	if err := p.volumes.unreserve(volumeID); err != nil {
+		glog.Errorf("failed to unreserve volume %s: %v", volumeID, err)
+	}
+}
+
+// waitForVolume waits for the volume's status to become available
+func (p *cinderProvisioner) waitForVolume(volumeID string) error {
+	err := wait.Poll(p.volumeReadyPollInterval, p.volumeReadyTimeout, func() (bool, error) {
+		volume, err := p.volumes.get(volumeID)
+		if err != nil {
+			return false, err
+		}
+		switch volume.Status {
+		case "available":
+			return true, nil
+		case "error":
+			return false, errors.New("volume status is error")
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for volume %s to become available: %v", volumeID, err)
+	}
+	return nil
+}
+
+// connectionSource returns the volume source for the connection info Cinder
+// returned for a volume
+func connectionSource(info *connectionInfo, params *cinderParameters) (*v1.PersistentVolumeSource, error) {
+	data := info.Data
+	switch info.DriverVolumeType {
+	case driverISCSI:
+		if data.AuthMethod != "" {
+			return nil, fmt.Errorf("iSCSI auth method %q is not supported", data.AuthMethod)
+		}
+		if data.TargetPortal == "" || data.TargetIQN == "" {
+			return nil, errors.New("iSCSI connection info has no target")
+		}
+		return &v1.PersistentVolumeSource{
+			ISCSI: &v1.ISCSIVolumeSource{
+				TargetPortal: data.TargetPortal,
+				IQN:          data.TargetIQN,
+				Lun:          data.TargetLun,
+				FSType:       params.fsType,
+			},
+		}, nil
+	case driverRBD:
+		pool, image, err := splitRBDName(data.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(data.Hosts) == 0 || len(data.Hosts) != len(data.Ports) {
+			return nil, errors.New("RBD connection info has no monitors or mismatched hosts and ports")
+		}
+		monitors := make([]string, len(data.Hosts))
+		for i := range data.Hosts {
+			monitors[i] = data.Hosts[i] + ":" + data.Ports[i]
+		}
+		source := &v1.RBDVolumeSource{
+			CephMonitors: monitors,
+			RBDPool:      pool,
+			RBDImage:     image,
+			FSType:       params.fsType,
+		}
+		if data.AuthEnabled {
+			if params.rbdSecretName == "" {
+				return nil, errors.New("RBD volumes need cephx auth but rbdSecretName is not set")
+			}
+			source.RadosUser = data.AuthUsername
+			source.SecretRef = &v1.LocalObjectReference{Name: params.rbdSecretName}
+		}
+		return &v1.PersistentVolumeSource{RBD: source}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver volume type %q", info.DriverVolumeType)
+}
+
+// splitRBDName splits an RBD volume name like "pool/image" into the pool and
+// the image
+func splitRBDName(name string) (string, string, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid RBD volume name %q", name)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Delete removes the storage asset that was created by Provision represented
+// by the given PV.
+func (p *cinderProvisioner) Delete(volume *v1.PersistentVolume) error {
+	volumeID, ok := volume.Annotations[cinderVolumeAnn]
+	if !ok {
+		return errors.New("cinder volume annotation not found on PV")
+	}
+
+	cinderVolume, err := p.volumes.get(volumeID)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil
+		}
+		return fmt.Errorf("failed to get volume %s: %v", volumeID, err)
+	}
+	// A volume that is no longer in-use was disconnected by an earlier Delete
+	// that failed to delete it
+	if cinderVolume.Status == "in-use" {
+		if err := p.volumes.terminateConnection(volumeID, p.connector); err != nil {
+			return fmt.Errorf("failed to terminate connection of volume %s: %v", volumeID, err)
+		}
+		if err := p.volumes.detach(volumeID); err != nil {
+			return fmt.Errorf("failed to detach volume %s: %v", volumeID, err)
+		}
+	}
+	if err := p.volumes.delete(volumeID); err != nil {
+		return fmt.Errorf("failed to delete volume %s: %v", volumeID, err)
+	}
+
+	return nil
+}
+
+func parseParameters(parameters map[string]string) (*cinderParameters, error) {
+	params := &cinderParameters{}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "type":
+			params.volumeType = v
+		case "availability", "zone":
+			params.zone = v
+		case "fstype":
+			params.fsType = v
+		case "rbdsecretname":
+			params.rbdSecretName = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestProvision(t *testing.T) {
+	iscsiInfo := &connectionInfo{
+		DriverVolumeType: driverISCSI,
+		Data:             connectionData{TargetPortal: "10.0.0.1:3260", TargetIQN: "iqn.2010-10.org.openstack:volume-1", TargetLun: 1},
+	}
+	rbdInfo := &connectionInfo{
+		DriverVolumeType: driverRBD,
+		Data: connectionData{
+			Name:         "volumes/volume-1",
+			Hosts:        []string{"10.0.0.1", "10.0.0.2"},
+			Ports:        []string{"6789", "6789"},
+			AuthEnabled:  true,
+			AuthUsername: "cinder",
+		},
+	}
+
+	tests := []struct {
+		name             string
+		parameters       map[string]string
+		capacity         string
+		volumeStatus     string
+		info             *connectionInfo
+		createErr        error
+		expectedSource   v1.PersistentVolumeSource
+		expectedCapacity string
+		expectedLabels   map[string]string
+		expectedCalls    []string
+		expectError      bool
+	}{
+		{
+			name:         "succeed with iscsi",
+			parameters:   map[string]string{"zone": "nova", "fsType": "xfs"},
+			capacity:     "1Gi",
+			volumeStatus: "available",
+			info:         iscsiInfo,
+			expectedSource: v1.PersistentVolumeSource{ISCSI: &v1.ISCSIVolumeSource{
+				TargetPortal: "10.0.0.1:3260",
+				IQN:          "iqn.2010-10.org.openstack:volume-1",
+				Lun:          1,
+				FSType:       "xfs",
+			}},
+			expectedCapacity: "1Gi",
+			expectedLabels:   map[string]string{unversioned.LabelZoneFailureDomain: "nova"},
+			expectedCalls:    []string{"create", "reserve", "initializeConnection", "attach"},
+		},
+		{
+			name:         "succeed with rbd, rounding up capacity",
+			parameters:   map[string]string{"rbdSecretName": "ceph-secret"},
+			capacity:     "1500Mi",
+			volumeStatus: "available",
+			info:         rbdInfo,
+			expectedSource: v1.PersistentVolumeSource{RBD: &v1.RBDVolumeSource{
+				CephMonitors: []string{"10.0.0.1:6789", "10.0.0.2:6789"},
+				RBDPool:      "volumes",
+				RBDImage:     "volume-1",
+				RadosUser:    "cinder",
+				SecretRef:    &v1.LocalObjectReference{Name: "ceph-secret"},
+			}},
+			expectedCapacity: "2Gi",
+			expectedCalls:    []string{"create", "reserve", "initializeConnection", "attach"},
+		},
+		{
+			name:        "bad parameter",
+			parameters:  map[string]string{"foo": "bar"},
+			expectError: true,
+		},
+		{
+			name:          "create fails",
+			capacity:      "1Gi",
+			createErr:     errors.New("fake error"),
+			expectedCalls: []string{"create"},
+			expectError:   true,
+		},
+		{
+			name:          "volume errors",
+			capacity:      "1Gi",
+			volumeStatus:  "error",
+			expectedCalls: []string{"create", "delete"},
+			expectError:   true,
+		},
+		{
+			name:          "rbd without secret",
+			capacity:      "1Gi",
+			volumeStatus:  "available",
+			info:          rbdInfo,
+			expectedCalls: []string{"create", "reserve", "initializeConnection", "terminateConnection", "unreserve", "delete"},
+			expectError:   true,
+		},
+		{
+			name:         "unsupported driver",
+			capacity:     "1Gi",
+			volumeStatus: "available",
+			info: &connectionInfo{
+				DriverVolumeType: "fibre_channel",
+			},
+			expectedCalls: []string{"create", "reserve", "initializeConnection", "terminateConnection", "unreserve", "delete"},
+			expectError:   true,
+		},
+	}
+	for _, test := range tests {
+		s := &testVolumeService{status: test.volumeStatus, info: test.info, createErr: test.createErr}
+		p := newTestCinderProvisioner(s)
+
+		capacity := resource.MustParse("1Gi")
+		if test.capacity != "" {
+			capacity = resource.MustParse(test.capacity)
+		}
+		options := newOptions("pvc-1", capacity)
+		options.Parameters = test.parameters
+		pv, err := p.Provision(options)
+
+		evaluate(t, test.name, false, nil, test.expectedCalls, s.calls, "calls")
+		if test.expectError {
+			evaluate(t, test.name, true, err, true, pv == nil, "nil pv")
+			continue
+		}
+		evaluate(t, test.name, false, err, "pvc-1", pv.Name, "pv name")
+		evaluate(t, test.name, false, err, test.expectedSource, pv.Spec.PersistentVolumeSource, "volume source")
+		pvCapacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		evaluate(t, test.name, false, err, test.expectedCapacity, pvCapacity.String(), "capacity")
+		evaluate(t, test.name, false, err, test.expectedLabels, pv.Labels, "labels")
+		evaluate(t, test.name, false, err, "volume-1", pv.Annotations[cinderVolumeAnn], "volume annotation")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		volumeStatus  string
+		getErr        error
+		deleteErr     error
+		expectedCalls []string
+		expectError   bool
+	}{
+		{
+			name:          "succeed",
+			annotations:   map[string]string{cinderVolumeAnn: "volume-1"},
+			volumeStatus:  "in-use",
+			expectedCalls: []string{"terminateConnection", "detach", "delete"},
+		},
+		{
+			name:          "already disconnected",
+			annotations:   map[string]string{cinderVolumeAnn: "volume-1"},
+			volumeStatus:  "available",
+			expectedCalls: []string{"delete"},
+		},
+		{
+			name:        "already deleted",
+			annotations: map[string]string{cinderVolumeAnn: "volume-1"},
+			getErr:      gophercloud.ErrDefault404{},
+		},
+		{
+			name:        "no volume annotation",
+			expectError: true,
+		},
+		{
+			name:          "delete fails",
+			annotations:   map[string]string{cinderVolumeAnn: "volume-1"},
+			volumeStatus:  "available",
+			deleteErr:     errors.New("fake error"),
+			expectedCalls: []string{"delete"},
+			expectError:   true,
+		},
+	}
+	for _, test := range tests {
+		s := &testVolumeService{status: test.volumeStatus, getErr: test.getErr, deleteErr: test.deleteErr}
+		p := newTestCinderProvisioner(s)
+
+		err := p.Delete(&v1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "pvc-1", Annotations: test.annotations}})
+
+		evaluate(t, test.name, test.expectError, err, test.expectedCalls, s.calls, "calls")
+	}
+}
+
+func TestSplitRBDName(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedPool  string
+		expectedImage string
+		expectError   bool
+	}{
+		{name: "volumes/volume-1", expectedPool: "volumes", expectedImage: "volume-1"},
+		{name: "volume-1", expectError: true},
+		{name: "/volume-1", expectError: true},
+	}
+	for _, test := range tests {
+		pool, image, err := splitRBDName(test.name)
+		evaluate(t, test.name, test.expectError, err, test.expectedPool+" "+test.expectedImage, pool+" "+image, "pool and image")
+	}
+}
+
+func newTestCinderProvisioner(s volumeService) *cinderProvisioner {
+	p := newCinderProvisionerInternal(s, "kubernetes", "iqn.1994-05.com.redhat:kubernetes")
+	p.volumeReadyTimeout = 100 * time.Millisecond
+	p.volumeReadyPollInterval = time.Millisecond
+	return p
+}
+
+func newOptions(pvName string, capacity resource.Quantity) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{Name: "claim-1", Namespace: v1.NamespaceDefault},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): capacity,
+					},
+				},
+			},
+		},
+		Parameters: map[string]string{},
+	}
+}
+
+// testVolumeService is a volumeService whose volumes are all "volume-1". It
+// records the calls made to it, except get.
+type testVolumeService struct {
+	status    string
+	info      *connectionInfo
+	createErr error
+	getErr    error
+	deleteErr error
+
+	calls []string
+}
+
+var _ volumeService = &testVolumeService{}
+
+func (s *testVolumeService) create(opts createOpts) (*cinderVolume, error) {
+	s.calls = append(s.calls, "create")
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	return &cinderVolume{ID: "volume-1", Status: "creating", Size: opts.Size}, nil
+}
+
+func (s *testVolumeService) get(id string) (*cinderVolume, error) {
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	return &cinderVolume{ID: id, Status: s.status}, nil
+}
+
+func (s *testVolumeService) delete(id string) error {
+	s.calls = append(s.calls, "delete")
+	return s.deleteErr
+}
+
+func (s *testVolumeService) reserve(id string) error {
+	s.calls = append(s.calls, "reserve")
+	return nil
+}
+
+func (s *testVolumeService) unreserve(id string) error {
+	s.calls = append(s.calls, "unreserve")
+	return nil
+}
+
+func (s *testVolumeService) initializeConnection(id string, c connector) (*connectionInfo, error) {
+	s.calls = append(s.calls, "initializeConnection")
+	return s.info, nil
+}
+
+func (s *testVolumeService) terminateConnection(id string, c connector) error {
+	s.calls = append(s.calls, "terminateConnection")
+	return nil
+}
+
+func (s *testVolumeService) attach(id string, c connector) error {
+	s.calls = append(s.calls, "attach")
+	return nil
+}
+
+func (s *testVolumeService) detach(id string) error {
+	s.calls = append(s.calls, "detach")
+	return nil
+}
+
+func evaluate(t *testing.T, name string, expectError bool, err error, expected interface{}, got interface{}, output string) {
+	if !expectError && err != nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("unexpected error getting %s: %v", output, err)
+	} else if expectError && err == nil {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected error but got %s: %v", output, got)
+	} else if !reflect.DeepEqual(expected, got) {
+		t.Logf("test case: %s", name)
+		t.Errorf("expected %s %v but got %s %v", output, expected, output, got)
+	}
+}
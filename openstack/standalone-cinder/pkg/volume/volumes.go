@@ -0,0 +1,192 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+)
+
+// cinderVolume is the part of a Cinder volume the provisioner uses
+type cinderVolume struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Size   int    `json:"size"`
+}
+
+// createOpts are the options of a volume to create
+type createOpts struct {
+	Size             int               `json:"size"`
+	Name             string            `json:"name,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	VolumeType       string            `json:"volume_type,omitempty"`
+	AvailabilityZone string            `json:"availability_zone,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// connector describes the host a volume is attached to, like os-brick
+// describes a compute host to Cinder. Cinder backends use it, e.g. the
+// initiator to create iSCSI ACLs.
+type connector struct {
+	Host      string `json:"host"`
+	Initiator string `json:"initiator,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Multipath bool   `json:"multipath"`
+	Platform  string `json:"platform"`
+	OSType    string `json:"os_type"`
+}
+
+// connectionInfo is returned by the os-initialize_connection action and tells
+// how to connect to a volume. Which fields of Data are set depends on
+// DriverVolumeType.
+type connectionInfo struct {
+	DriverVolumeType string         `json:"driver_volume_type"`
+	Data             connectionData `json:"data"`
+}
+
+// connectionData are the fields of connectionInfo's data used for iSCSI and
+// RBD volumes
+type connectionData struct {
+	// iscsi
+	TargetPortal string `json:"target_portal"`
+	TargetIQN    string `json:"target_iqn"`
+	TargetLun    int32  `json:"target_lun"`
+	AuthMethod   string `json:"auth_method"`
+
+	// rbd
+	Name         string   `json:"name"`
+	Hosts        []string `json:"hosts"`
+	Ports        []string `json:"ports"`
+	AuthEnabled  bool     `json:"auth_enabled"`
+	AuthUsername string   `json:"auth_username"`
+}
+
+// volumeService is the part of the Cinder API the provisioner uses
+type volumeService interface {
+	create(opts createOpts) (*cinderVolume, error)
+	get(id string) (*cinderVolume, error)
+	delete(id string) error
+	reserve(id string) error
+	unreserve(id string) error
+	initializeConnection(id string, c connector) (*connectionInfo, error)
+	terminateConnection(id string, c connector) error
+	attach(id string, c connector) error
+	detach(id string) error
+}
+
+// NewVolumeClient returns a client of the Cinder v2 API in region,
+// authenticated with the OS_* environment variables understood by the
+// OpenStack CLIs, e.g. OS_AUTH_URL, OS_USERNAME, OS_PASSWORD and
+// OS_TENANT_NAME.
+func NewVolumeClient(region string) (*gophercloud.ServiceClient, error) {
+	authOptions, err := openstack.AuthOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// Tokens expire, so let gophercloud authenticate again when they do
+	authOptions.AllowReauth = true
+	provider, err := openstack.AuthenticatedClient(authOptions)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewBlockStorageV2(provider, gophercloud.EndpointOpts{Region: region})
+}
+
+// cinderVolumeService is a volumeService that calls the Cinder API. The
+// block storage packages of gophercloud aren't vendored, so the calls are
+// made directly.
+type cinderVolumeService struct {
+	client *gophercloud.ServiceClient
+}
+
+var _ volumeService = &cinderVolumeService{}
+
+func (s *cinderVolumeService) create(opts createOpts) (*cinderVolume, error) {
+	var body struct {
+		Volume cinderVolume `json:"volume"`
+	}
+	_, err := s.client.Post(s.client.ServiceURL("volumes"), map[string]interface{}{"volume": opts}, &body, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &body.Volume, nil
+}
+
+func (s *cinderVolumeService) get(id string) (*cinderVolume, error) {
+	var body struct {
+		Volume cinderVolume `json:"volume"`
+	}
+	_, err := s.client.Get(s.client.ServiceURL("volumes", id), &body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &body.Volume, nil
+}
+
+func (s *cinderVolumeService) delete(id string) error {
+	_, err := s.client.Delete(s.client.ServiceURL("volumes", id), nil)
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
+	}
+	return err
+}
+
+// action calls the named volume action, decoding its response, if any, into
+// result
+func (s *cinderVolumeService) action(id, name string, args interface{}, result interface{}) error {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	_, err := s.client.Post(s.client.ServiceURL("volumes", id, "action"), map[string]interface{}{name: args}, result, &gophercloud.RequestOpts{
+		OkCodes: []int{200, 202},
+	})
+	return err
+}
+
+func (s *cinderVolumeService) reserve(id string) error {
+	return s.action(id, "os-reserve", nil, nil)
+}
+
+func (s *cinderVolumeService) unreserve(id string) error {
+	return s.action(id, "os-unreserve", nil, nil)
+}
+
+func (s *cinderVolumeService) initializeConnection(id string, c connector) (*connectionInfo, error) {
+	var body struct {
+		ConnectionInfo connectionInfo `json:"connection_info"`
+	}
+	if err := s.action(id, "os-initialize_connection", map[string]interface{}{"connector": c}, &body); err != nil {
+		return nil, err
+	}
+	return &body.ConnectionInfo, nil
+}
+
+func (s *cinderVolumeService) terminateConnection(id string, c connector) error {
+	return s.action(id, "os-terminate_connection", map[string]interface{}{"connector": c}, nil)
+}
+
+// attach marks the volume in-use by the connector's host, so that it isn't
+// attached elsewhere through OpenStack
+func (s *cinderVolumeService) attach(id string, c connector) error {
+	return s.action(id, "os-attach", map[string]interface{}{"host_name": c.Host, "mountpoint": "/dev/" + id, "mode": "rw"}, nil)
+}
+
+func (s *cinderVolumeService) detach(id string) error {
+	return s.action(id, "os-detach", nil, nil)
+}